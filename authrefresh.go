@@ -0,0 +1,70 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/rakyll/hey/requester"
+)
+
+// runAuthCmd runs cmd with the shell and returns the Authorization header
+// value it prints, as is if it starts with a scheme such as "Bearer",
+// else as a bearer token.
+func runAuthCmd(cmd string) (string, error) {
+	c := exec.Command("sh", "-c", cmd)
+	c.Stderr = os.Stderr
+	out, err := c.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", cmd, err)
+	}
+	v := strings.TrimSpace(string(out))
+	if v == "" {
+		return "", fmt.Errorf("%s printed no token", cmd)
+	}
+	if !strings.Contains(v, " ") {
+		v = "Bearer " + v
+	}
+	return v, nil
+}
+
+// refreshAuth sets the Authorization header of w from cmd every interval,
+// until the returned function is called. If cmd fails, the failure is
+// reported and the previous header kept.
+func refreshAuth(w *requester.Work, cmd string, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				v, err := runAuthCmd(cmd)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Refreshing authorization failed: %v\n", err)
+					continue
+				}
+				w.SetAuthorization(v)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,361 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/rakyll/hey/requester"
+)
+
+// commands maps subcommand names to their entry points. A command line
+// that does not start with one of them is handled by runMain.
+var commands = map[string]func(args []string){
+	"run":      runMain,
+	"report":   reportMain,
+	"compare":  compareMain,
+	"convert":  convertMain,
+	"merge":    mergeMain,
+	"ssh":      sshMain,
+	"matrix":   matrixMain,
+	"server":   serverMain,
+	"web":      webMain,
+	"repl":     replMain,
+	"schema":   schemaMain,
+	"validate": validateMain,
+}
+
+var reportUsage = `Usage: hey report [options...] <results.ndjson>
+
+Regenerates the summary of a run out of its raw results, as saved with
+"hey run -o ndjson".
+
+Options:
+  -o  Output type. If none provided, a summary is printed.
+      "csv" dumps the response metrics in comma-separated values format,
+      with the time of every request phase (DNS, connect, TLS, request
+      write, time to first byte, response read) and a timestamp.
+      "html" renders the summary as a web page, with a chart of status
+      codes over time and a heatmap of latencies over time.
+      "png" draws the heatmap of latencies over time as an image.
+      "series" dumps status code counts per second of the run as CSV.
+  -percentiles  Comma-separated latency percentiles to report, e.g.
+      "50,90,99,99.9". Default is "10,25,50,75,90,95,99".
+  -time-unit  Unit to print latencies in, "s", "ms" or "us". Default is
+      "s".
+  -details  Also report the 95% confidence interval of each percentile,
+      with a warning if too few requests were slower than it to tell it
+      from noise, the outliers, the clock skew of the server by its Date
+      headers, the transports and connections opened, and the requests
+      actually in flight over the run. -o json always has them.
+`
+
+var compareUsage = `Usage: hey compare [options...] <base.ndjson> <new.ndjson>
+
+Compares the raw results of two runs, as saved with "hey run -o ndjson".
+Besides the deltas of the main statistics, the latencies of the runs are
+tested for a significant difference: the Mann-Whitney U test tells if the
+new run tends to be slower or faster, and the Kolmogorov-Smirnov test if
+the distributions differ at all, e.g. in shape.
+
+Options:
+  -alpha  Significance level of the tests. Default is 0.05.
+`
+
+var mergeUsage = `Usage: hey merge [options...] <results.ndjson>...
+
+Combines the raw results of runs made in parallel, e.g. on different
+machines, into a single report. Throughput is summed across runs, and
+latency percentiles are computed from mergeable sketches of each run.
+
+Options:
+  -o  Output type. If none provided, a summary is printed.
+      "csv" dumps the response metrics in comma-separated values format,
+      with the time of every request phase (DNS, connect, TLS, request
+      write, time to first byte, response read) and a timestamp.
+      "html" renders the summary as a web page, with a chart of status
+      codes over time and a heatmap of latencies over time.
+      "png" draws the heatmap of latencies over time as an image.
+      "series" dumps status code counts per second of the run as CSV.
+  -percentiles  Comma-separated latency percentiles to report, e.g.
+      "50,90,99,99.9". Default is "10,25,50,75,90,95,99".
+  -time-unit  Unit to print latencies in, "s", "ms" or "us". Default is
+      "s".
+  -details  Also report the 95% confidence interval of each percentile,
+      with a warning if too few requests were slower than it to tell it
+      from noise, the outliers, the clock skew of the server by its Date
+      headers, the transports and connections opened, and the requests
+      actually in flight over the run. -o json always has them.
+`
+
+var convertUsage = `Usage: hey convert -to <format> <results.ndjson>
+
+Converts raw results, as saved with "hey run -o ndjson", to another format.
+
+Options:
+  -to  Target format, one of:
+      "csv", a row of metrics per request.
+      "series", status code counts per second of the run as CSV.
+      "ndjson", the raw results, rewritten in the current record format.
+      "hdr", the latency percentile distribution in milliseconds, in the
+      HdrHistogram format read by HdrHistogram tools and plotters.
+      "json", the main statistics of the summary as a JSON document.
+      "png", the heatmap of latencies over time as an image.
+      "trace", every request as a span on the track of its worker, in the
+      Chrome trace event format that Perfetto and chrome://tracing open.
+      "jtl", a row per request in the CSV results format of JMeter, with
+      workers as the threads of a thread group.
+      "k6-summary", the summary in the end-of-test summary format of k6,
+      as "k6 run --summary-export" writes it.
+`
+
+var schemaUsage = `Usage: hey schema <records|summary>
+
+Prints the JSON Schema of an output, with the description of every field:
+"records" for the lines of "-o ndjson", "summary" for "-o json". The
+outputs carry the name and version of their schema, in the header line of
+the records and the "schema" field of the summary. The version changes
+when a field is removed or changes meaning.
+`
+
+// newCommandFlags returns a flag set for a subcommand with the given usage
+// text, and makes usageAndExit print it.
+func newCommandFlags(name, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	flag.Usage = fs.Usage
+	return fs
+}
+
+func reportMain(args []string) {
+	fs := newCommandFlags("report", reportUsage)
+	output := fs.String("o", "", "")
+	pctls := fs.String("percentiles", "", "")
+	unit := fs.String("time-unit", requester.TimeUnitSeconds, "")
+	details := fs.Bool("details", false, "")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usageAndExit("")
+	}
+	if *output == "ndjson" || *output == "trace" {
+		usageAndExit(fmt.Sprintf(`-o %s is not supported; use "hey convert".`, *output))
+	}
+	percentiles, err := parsePercentiles(*pctls)
+	if err != nil {
+		usageAndExit(err.Error())
+	}
+	checkTimeUnit(*unit)
+	rep := requester.ReportFromRecords(readRecords(fs.Arg(0)), percentiles)
+	rep.TimeUnit = *unit
+	rep.Details = *details
+	if err := requester.PrintReport(os.Stdout, rep, *output); err != nil {
+		errAndExit(err.Error())
+	}
+}
+
+func compareMain(args []string) {
+	fs := newCommandFlags("compare", compareUsage)
+	alpha := fs.Float64("alpha", 0.05, "")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		usageAndExit("")
+	}
+	if *alpha <= 0 || *alpha >= 1 {
+		usageAndExit("-alpha must be between 0 and 1.")
+	}
+	base, next := readReport(fs.Arg(0)), readReport(fs.Arg(1))
+	printComparison(os.Stdout, base, next)
+	printSignificance(os.Stdout, base, next, *alpha)
+}
+
+func mergeMain(args []string) {
+	fs := newCommandFlags("merge", mergeUsage)
+	output := fs.String("o", "", "")
+	pctls := fs.String("percentiles", "", "")
+	unit := fs.String("time-unit", requester.TimeUnitSeconds, "")
+	details := fs.Bool("details", false, "")
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		usageAndExit("")
+	}
+	if *output == "ndjson" || *output == "trace" {
+		usageAndExit(fmt.Sprintf("-o %s is not supported.", *output))
+	}
+	percentiles, err := parsePercentiles(*pctls)
+	if err != nil {
+		usageAndExit(err.Error())
+	}
+	checkTimeUnit(*unit)
+	var reps []requester.Report
+	for _, path := range fs.Args() {
+		reps = append(reps, readReport(path))
+	}
+	merged, err := requester.MergeReports(reps, percentiles)
+	if err != nil {
+		errAndExit(err.Error())
+	}
+	merged.TimeUnit = *unit
+	merged.Details = *details
+	if err := requester.PrintReport(os.Stdout, merged, *output); err != nil {
+		errAndExit(err.Error())
+	}
+}
+
+func convertMain(args []string) {
+	fs := newCommandFlags("convert", convertUsage)
+	to := fs.String("to", "", "")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usageAndExit("")
+	}
+	records := readRecords(fs.Arg(0))
+	switch *to {
+	case "csv", "series", "hdr", "json", "png":
+		rep := requester.ReportFromRecords(records, nil)
+		if err := requester.PrintReport(os.Stdout, rep, *to); err != nil {
+			errAndExit(err.Error())
+		}
+	case "ndjson":
+		enc := json.NewEncoder(os.Stdout)
+		enc.Encode(requester.NewRecordsHeader())
+		for _, rec := range records {
+			enc.Encode(rec)
+		}
+	case "trace":
+		if err := requester.WriteTrace(os.Stdout, records); err != nil {
+			errAndExit(err.Error())
+		}
+	case "jtl":
+		if err := requester.WriteJTL(os.Stdout, records); err != nil {
+			errAndExit(err.Error())
+		}
+	case "k6-summary":
+		rep := requester.ReportFromRecords(records, nil)
+		if err := requester.WriteK6Summary(os.Stdout, rep); err != nil {
+			errAndExit(err.Error())
+		}
+	default:
+		usageAndExit(fmt.Sprintf("unsupported format %q.", *to))
+	}
+}
+
+func schemaMain(args []string) {
+	fs := newCommandFlags("schema", schemaUsage)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usageAndExit("")
+	}
+	names := map[string]string{"records": requester.RecordsSchema, "summary": requester.SummarySchema}
+	name, ok := names[fs.Arg(0)]
+	if !ok {
+		usageAndExit(fmt.Sprintf("unknown schema %q.", fs.Arg(0)))
+	}
+	schema, err := requester.JSONSchema(name)
+	if err != nil {
+		errAndExit(err.Error())
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(schema)
+}
+
+func readRecords(path string) []requester.Record {
+	f, err := os.Open(path)
+	if err != nil {
+		errAndExit(err.Error())
+	}
+	defer f.Close()
+	records, err := requester.ReadRecords(f)
+	if err != nil {
+		errAndExit(fmt.Sprintf("%s: %v", path, err))
+	}
+	return records
+}
+
+func readReport(path string) requester.Report {
+	return requester.ReportFromRecords(readRecords(path), nil)
+}
+
+// printComparison writes the main statistics of two reports side by side,
+// along with the relative change from base to next.
+func printComparison(w io.Writer, base, next requester.Report) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tbase\tnew\tdelta")
+	row := func(name string, a, b float64) {
+		delta := "n/a"
+		if a != 0 {
+			delta = fmt.Sprintf("%+.1f%%", (b-a)/a*100)
+		}
+		fmt.Fprintf(tw, "%s\t%4.4f\t%4.4f\t%s\n", name, a, b, delta)
+	}
+	row("Requests", float64(base.NumRes), float64(next.NumRes))
+	row("Requests/sec", base.Rps, next.Rps)
+	row("Average", base.Average, next.Average)
+	row("Fastest", base.Fastest, next.Fastest)
+	row("Slowest", base.Slowest, next.Slowest)
+	for i := range base.LatencyDistribution {
+		if i >= len(next.LatencyDistribution) {
+			break
+		}
+		a, b := base.LatencyDistribution[i], next.LatencyDistribution[i]
+		if a.Percentage == 0 || a.Percentage != b.Percentage {
+			continue
+		}
+		row(fmt.Sprintf("p%v", a.Percentage), a.Latency, b.Latency)
+	}
+	row("Errors", float64(errorCount(base)), float64(errorCount(next)))
+	tw.Flush()
+}
+
+// printSignificance writes whether the latencies of two reports differ
+// significantly at the level alpha.
+func printSignificance(w io.Writer, base, next requester.Report, alpha float64) {
+	fmt.Fprintf(w, "\nLatency significance (%d vs %d responses, alpha %v):\n", len(base.Lats), len(next.Lats), alpha)
+	if len(base.Lats) == 0 || len(next.Lats) == 0 {
+		fmt.Fprintln(w, "  not tested, a run has no successful responses")
+		return
+	}
+	verdict := func(p float64, differ string) string {
+		if p < alpha {
+			return "significant, " + differ
+		}
+		return "not significant"
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	greater, p := requester.MannWhitneyU(base.Lats, next.Lats)
+	direction := "new is faster"
+	if greater > 0.5 {
+		direction = "new is slower"
+	}
+	fmt.Fprintf(tw, "  Mann-Whitney U\tp=%.4f\tP(new slower)=%.3f\t%s\n", p, greater, verdict(p, direction))
+	d, p := requester.KolmogorovSmirnov(base.Lats, next.Lats)
+	fmt.Fprintf(tw, "  Kolmogorov-Smirnov\tp=%.4f\tD=%.3f\t%s\n", p, d, verdict(p, "distributions differ"))
+	tw.Flush()
+}
+
+func errorCount(rep requester.Report) int {
+	var n int
+	for _, c := range rep.ErrorDist {
+		n += c
+	}
+	return n
+}
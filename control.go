@@ -0,0 +1,51 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+
+	"github.com/rakyll/hey/requester"
+)
+
+// serveControl listens on the Unix socket at path for the commands of
+// hey repl, one per line, and runs them on w. Closing the returned
+// listener stops accepting commands and removes the socket.
+func serveControl(path string, w *requester.Work, rec *serverRun, percentiles []float64) (io.Closer, error) {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				s := bufio.NewScanner(conn)
+				for s.Scan() {
+					if replCommand(conn, s.Text(), w, rec, percentiles) {
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+	return l, nil
+}
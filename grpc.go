@@ -0,0 +1,365 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// grpcService is the full name of the Control service of
+// api/control.proto, which hey server serves over HTTP/2, with or without
+// TLS, on the address of its HTTP API.
+const grpcService = "hey.v1.Control"
+
+// Status codes of gRPC.
+const (
+	grpcOK              = 0
+	grpcInvalidArgument = 3
+	grpcNotFound        = 5
+	grpcAlreadyExists   = 6
+	grpcUnimplemented   = 12
+	grpcInternal        = 13
+)
+
+// defaultStatsInterval is the interval of StreamStats if none is given.
+const defaultStatsInterval = time.Second
+
+// grpcError is an error with a gRPC status code.
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func (e *grpcError) Error() string { return e.msg }
+
+func grpcErrorf(code int, format string, args ...interface{}) error {
+	return &grpcError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// isGRPC reports whether r is a gRPC call.
+func isGRPC(r *http.Request) bool {
+	return r.ProtoMajor == 2 && r.Method == http.MethodPost &&
+		strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// serveGRPC serves a call of the Control service. Every method takes a
+// single request message; StreamStats streams its responses.
+func (s *server) serveGRPC(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+	err := s.callGRPC(w, r)
+	code, msg := grpcOK, ""
+	if err != nil {
+		code, msg = grpcInternal, err.Error()
+		var gerr *grpcError
+		if errors.As(err, &gerr) {
+			code = gerr.code
+		}
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set("Grpc-Message", url.PathEscape(msg))
+	}
+}
+
+func (s *server) callGRPC(w http.ResponseWriter, r *http.Request) error {
+	service, method, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if service != grpcService {
+		return grpcErrorf(grpcUnimplemented, "unknown service %s", service)
+	}
+	req, err := readGRPCMessage(r.Body)
+	if err != nil {
+		return err
+	}
+	switch method {
+	case "StartRun":
+		var c runConfig
+		msg := protoReader(req)
+		for len(msg) > 0 {
+			num, _, _, data, err := msg.next()
+			if err != nil {
+				return grpcErrorf(grpcInvalidArgument, "%v", err)
+			}
+			if num == 1 {
+				if c, err = decodeRunConfig(data); err != nil {
+					return grpcErrorf(grpcInvalidArgument, "%v", err)
+				}
+			}
+		}
+		run, err := s.start(c)
+		if errors.Is(err, errRunActive) {
+			return grpcErrorf(grpcAlreadyExists, "%v", err)
+		}
+		if err != nil {
+			return grpcErrorf(grpcInvalidArgument, "%v", err)
+		}
+		return writeGRPCMessage(w, encodeRun(run.snapshot()))
+	case "StopRun":
+		run, _, err := s.grpcRun(req)
+		if err != nil {
+			return err
+		}
+		run.work.Stop()
+		return writeGRPCMessage(w, encodeRun(run.snapshot()))
+	case "StreamStats":
+		run, interval, err := s.grpcRun(req)
+		if err != nil {
+			return err
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			snapshot := run.snapshot()
+			if err := writeGRPCMessage(w, encodeRun(snapshot)); err != nil {
+				return err
+			}
+			if snapshot.State == "done" {
+				return nil
+			}
+			select {
+			case <-r.Context().Done():
+				return r.Context().Err()
+			case <-ticker.C:
+			}
+		}
+	}
+	return grpcErrorf(grpcUnimplemented, "unknown method %s", method)
+}
+
+// grpcRun returns the run of the id of a StopRunRequest or
+// StreamStatsRequest, and the interval of the latter.
+func (s *server) grpcRun(req []byte) (*serverRun, time.Duration, error) {
+	var id string
+	interval := defaultStatsInterval
+	msg := protoReader(req)
+	for len(msg) > 0 {
+		num, _, v, data, err := msg.next()
+		if err != nil {
+			return nil, 0, grpcErrorf(grpcInvalidArgument, "%v", err)
+		}
+		switch num {
+		case 1:
+			id = string(data)
+		case 2:
+			if ms := int32(v); ms > 0 {
+				interval = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	run := s.run(id)
+	if run == nil {
+		return nil, 0, grpcErrorf(grpcNotFound, "no run %q", id)
+	}
+	return run, interval, nil
+}
+
+// readGRPCMessage reads the single length-prefixed message of a call.
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "reading message: %v", err)
+	}
+	if prefix[0] != 0 {
+		return nil, grpcErrorf(grpcUnimplemented, "compressed messages are not supported")
+	}
+	msg := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "reading message: %v", err)
+	}
+	return msg, nil
+}
+
+// writeGRPCMessage writes msg length-prefixed and flushes it.
+func writeGRPCMessage(w http.ResponseWriter, msg []byte) error {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	if _, err := w.Write(append(frame, msg...)); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// decodeRunConfig reads a RunConfig message.
+func decodeRunConfig(b []byte) (runConfig, error) {
+	var c runConfig
+	msg := protoReader(b)
+	for len(msg) > 0 {
+		num, wire, v, data, err := msg.next()
+		if err != nil {
+			return c, err
+		}
+		switch num {
+		case 1:
+			c.URL = string(data)
+		case 2:
+			c.Method = string(data)
+		case 3:
+			var key, value string
+			entry := protoReader(data)
+			for len(entry) > 0 {
+				num, _, _, data, err := entry.next()
+				if err != nil {
+					return c, err
+				}
+				switch num {
+				case 1:
+					key = string(data)
+				case 2:
+					value = string(data)
+				}
+			}
+			if c.Headers == nil {
+				c.Headers = make(map[string]string)
+			}
+			c.Headers[key] = value
+		case 4:
+			c.Body = string(data)
+		case 5:
+			c.N = int(int32(v))
+		case 6:
+			c.C = int(int32(v))
+		case 7:
+			c.Q = math.Float64frombits(v)
+		case 8:
+			c.Z = string(data)
+		case 9:
+			c.T = int(int32(v))
+		case 10:
+			if wire == wireFixed64 {
+				c.Percentiles = append(c.Percentiles, math.Float64frombits(v))
+				continue
+			}
+			if len(data)%8 != 0 {
+				return c, errors.New("malformed packed percentiles")
+			}
+			for ; len(data) > 0; data = data[8:] {
+				c.Percentiles = append(c.Percentiles, math.Float64frombits(binary.LittleEndian.Uint64(data)))
+			}
+		}
+	}
+	return c, nil
+}
+
+// encodeRun encodes a snapshot of a run as a Run message.
+func encodeRun(run *serverRun) []byte {
+	var b []byte
+	b = appendProtoString(b, 1, run.ID)
+	b = appendProtoMessage(b, 2, encodeRunConfig(&run.Config))
+	state := uint64(1)
+	if run.State == "done" {
+		state = 2
+	}
+	b = appendProtoVarint(b, 3, state)
+	b = appendProtoVarint(b, 4, uint64(run.Started.UnixNano()))
+	if run.Finished != nil {
+		b = appendProtoVarint(b, 5, uint64(run.Finished.UnixNano()))
+	}
+
+	var stats []byte
+	stats = appendProtoVarint(stats, 1, uint64(run.Stats.Requests))
+	stats = appendProtoVarint(stats, 2, uint64(run.Stats.Errors))
+	codes := make([]int, 0, len(run.Stats.StatusCodes))
+	for code := range run.Stats.StatusCodes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		var entry []byte
+		entry = appendProtoVarint(entry, 1, uint64(int32(code)))
+		entry = appendProtoVarint(entry, 2, uint64(run.Stats.StatusCodes[code]))
+		stats = appendProtoMessage(stats, 3, entry)
+	}
+	stats = appendProtoDouble(stats, 4, run.Stats.Rps)
+	stats = appendProtoDouble(stats, 5, run.Stats.Average)
+	stats = appendProtoDouble(stats, 6, run.Stats.Elapsed)
+	return appendProtoMessage(b, 6, stats)
+}
+
+// encodeRunConfig encodes c as a RunConfig message.
+func encodeRunConfig(c *runConfig) []byte {
+	var b []byte
+	b = appendProtoString(b, 1, c.URL)
+	b = appendProtoString(b, 2, c.Method)
+	keys := make([]string, 0, len(c.Headers))
+	for k := range c.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var entry []byte
+		entry = appendProtoString(entry, 1, k)
+		entry = appendProtoString(entry, 2, c.Headers[k])
+		b = appendProtoMessage(b, 3, entry)
+	}
+	b = appendProtoString(b, 4, c.Body)
+	b = appendProtoVarint(b, 5, uint64(int32(c.N)))
+	b = appendProtoVarint(b, 6, uint64(int32(c.C)))
+	b = appendProtoDouble(b, 7, c.Q)
+	b = appendProtoString(b, 8, c.Z)
+	b = appendProtoVarint(b, 9, uint64(int32(c.T)))
+	if len(c.Percentiles) > 0 {
+		var packed []byte
+		for _, p := range c.Percentiles {
+			packed = binary.LittleEndian.AppendUint64(packed, math.Float64bits(p))
+		}
+		b = appendProtoMessage(b, 10, packed)
+	}
+	return b
+}
+
+// The appendProto functions append a field of a proto3 message, unless
+// it has the default value.
+
+func appendProtoVarint(b []byte, num uint64, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	return binary.AppendUvarint(appendProtoTag(b, num, wireVarint), v)
+}
+
+func appendProtoDouble(b []byte, num uint64, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	return binary.LittleEndian.AppendUint64(appendProtoTag(b, num, wireFixed64), math.Float64bits(v))
+}
+
+func appendProtoString(b []byte, num uint64, s string) []byte {
+	if s == "" {
+		return b
+	}
+	return appendProtoMessage(b, num, []byte(s))
+}
+
+// appendProtoMessage appends a length-delimited field, always, as empty
+// messages are not the same as absent ones.
+func appendProtoMessage(b []byte, num uint64, data []byte) []byte {
+	b = binary.AppendUvarint(appendProtoTag(b, num, wireBytes), uint64(len(data)))
+	return append(b, data...)
+}
@@ -15,7 +15,30 @@
 package main
 
 import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/rakyll/hey/requester"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 func TestParseValidHeaderFlag(t *testing.T) {
@@ -64,3 +87,1019 @@ func TestParseAuthMetaCharacters(t *testing.T) {
 		t.Errorf("Auth header with a plus sign in the user name errored: %v", err)
 	}
 }
+
+func TestDetectContentType(t *testing.T) {
+	tests := []struct {
+		path string
+		body string
+		want string
+	}{
+		{"body.json", "", "application/json"},
+		{"payload", `{"a": 1}`, "application/json"},
+		{"payload", `<?xml version="1.0"?><a/>`, "application/xml"},
+		{"payload", "user=foo&pass=bar", "application/x-www-form-urlencoded"},
+		{"payload", "\x08\x96\x01\xff", "application/octet-stream"},
+		{"payload", "\x89PNG\r\n\x1a\n\x00\x00", "image/png"},
+		{"payload", "\x1f\x8b\x08\x00\x00", "application/x-gzip"},
+		{"payload", "%PDF-1.4\n\xff\xfe", "application/pdf"},
+		{"msg.pb", "anything", "application/x-protobuf"},
+		{"payload", "just some text", ""},
+	}
+	for _, tt := range tests {
+		if got := detectContentType(tt.path, []byte(tt.body)); got != tt.want {
+			t.Errorf("detectContentType(%q, %q) = %q; want %q", tt.path, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestParseForm(t *testing.T) {
+	form, err := parseForm([]string{"user=jane doe", "tag=a", "tag=b&c", "empty="})
+	if err != nil {
+		t.Fatalf("parseForm errored: %v", err)
+	}
+	if got, want := form.Encode(), "empty=&tag=a&tag=b%26c&user=jane+doe"; got != want {
+		t.Errorf("got %v; want %v", got, want)
+	}
+	if _, err := parseForm([]string{"novalue"}); err == nil {
+		t.Errorf("parseForm with a missing '=' did not error")
+	}
+}
+
+func TestPrintSignificance(t *testing.T) {
+	var base, slower, same []requester.Record
+	for i := 0; i < 100; i++ {
+		d := 0.01 + float64(i%10)/1000
+		base = append(base, requester.Record{Offset: float64(i), Duration: d, Status: 200})
+		slower = append(slower, requester.Record{Offset: float64(i), Duration: d + 0.005, Status: 200})
+		same = append(same, requester.Record{Offset: float64(i), Duration: d, Status: 200})
+	}
+	report := func(records []requester.Record) requester.Report {
+		return requester.ReportFromRecords(records, nil)
+	}
+	var out strings.Builder
+	printSignificance(&out, report(base), report(slower), 0.05)
+	if !strings.Contains(out.String(), "significant, new is slower") || !strings.Contains(out.String(), "significant, distributions differ") {
+		t.Errorf("Expected the slower run to differ significantly, found:\n%s", out.String())
+	}
+	out.Reset()
+	printSignificance(&out, report(base), report(same), 0.05)
+	if strings.Count(out.String(), "not significant") != 2 {
+		t.Errorf("Expected identical runs not to differ significantly, found:\n%s", out.String())
+	}
+}
+
+func TestParseRangeLength(t *testing.T) {
+	n, err := parseRangeLength("bytes=100-199")
+	if err != nil {
+		t.Fatalf("parseRangeLength errored: %v", err)
+	}
+	if n != 100 {
+		t.Errorf("got %v; want 100", n)
+	}
+	for _, in := range []string{"bytes=10-", "bytes=20-10", "items=0-1"} {
+		if _, err := parseRangeLength(in); err == nil {
+			t.Errorf("parseRangeLength(%q) did not error", in)
+		}
+	}
+}
+
+func TestParsePercent(t *testing.T) {
+	if got, err := parsePercent("2.5%"); err != nil || got != 0.025 {
+		t.Errorf("parsePercent(2.5%%) = %v, %v; want 0.025", got, err)
+	}
+	for _, in := range []string{"abc", "101%", "-1%"} {
+		if _, err := parsePercent(in); err == nil {
+			t.Errorf("parsePercent(%q) did not error", in)
+		}
+	}
+}
+
+func TestParseSweep(t *testing.T) {
+	for in, want := range map[string]string{"page=1..3": "page [1 2 3]", "sort=asc,desc": "sort [asc desc]"} {
+		name, values, err := parseSweep(in)
+		if got := fmt.Sprint(name, " ", values); err != nil || got != want {
+			t.Errorf("parseSweep(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"page", "=1..3", "page=3..1", "page=a..b"} {
+		if _, _, err := parseSweep(in); err == nil {
+			t.Errorf("parseSweep(%q) did not error", in)
+		}
+	}
+}
+
+func TestParseBytes(t *testing.T) {
+	for in, want := range map[string]int64{"10GB": 10e9, "512 MiB": 512 << 20, "1.5kb": 1500, "100": 100} {
+		if got, err := parseBytes(in); err != nil || got != want {
+			t.Errorf("parseBytes(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "GB", "10XB", "-1GB"} {
+		if _, err := parseBytes(in); err == nil {
+			t.Errorf("parseBytes(%q) did not error", in)
+		}
+	}
+}
+
+func TestParsePercentiles(t *testing.T) {
+	pctls, err := parsePercentiles("99.9, 50,90")
+	if err != nil {
+		t.Fatalf("parsePercentiles errored: %v", err)
+	}
+	if got, want := fmt.Sprint(pctls), "[50 90 99.9]"; got != want {
+		t.Errorf("got %v; want %v", got, want)
+	}
+	if pctls, _ := parsePercentiles(""); pctls != nil {
+		t.Errorf("Expected no percentiles, found %v", pctls)
+	}
+	if _, err := parsePercentiles("50,100"); err == nil {
+		t.Errorf("parsePercentiles with 100 did not error")
+	}
+}
+
+func TestParseStartAt(t *testing.T) {
+	now := time.Date(2024, 6, 1, 13, 59, 0, 0, time.UTC)
+	if got, err := parseStartAt("2024-06-01T14:00:00Z", 0, now); err != nil || !got.Equal(now.Add(time.Minute)) {
+		t.Errorf("Unexpected start time %v (%v)", got, err)
+	}
+	if got, err := parseStartAt("", 10*time.Second, now); err != nil || !got.Equal(now.Add(10*time.Second)) {
+		t.Errorf("Unexpected start time %v (%v) 10s from now", got, err)
+	}
+	if got, err := parseStartAt("", 0, now); err != nil || !got.IsZero() {
+		t.Errorf("Expected no start time, found %v (%v)", got, err)
+	}
+	for _, tt := range []struct {
+		at    string
+		after time.Duration
+	}{{"14:00", 0}, {"2024-06-01T14:00:00Z", time.Second}, {"", -time.Second}} {
+		if _, err := parseStartAt(tt.at, tt.after, now); err == nil {
+			t.Errorf("Expected an error for -start-at %q and -start-after %v", tt.at, tt.after)
+		}
+	}
+}
+
+func TestReadUserAgents(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "uas.txt")
+	os.WriteFile(name, []byte("# desktop\nMozilla/5.0 (X11)\n\n  curl/8.0  \n"), 0644)
+	uas, err := readUserAgents(name, false)
+	if err != nil {
+		t.Fatalf("readUserAgents errored: %v", err)
+	}
+	if got, want := fmt.Sprintf("%q", uas), `["Mozilla/5.0 (X11)" "curl/8.0"]`; got != want {
+		t.Errorf("got %v; want %v", got, want)
+	}
+	uas, _ = readUserAgents(name, true)
+	if uas[1] != "curl/8.0 "+heyUA {
+		t.Errorf("Expected the hey suffix, found %q", uas[1])
+	}
+}
+
+func TestReadCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	data := "# accounts\nalice:pa:ss\n\neyJhbGciOi.token\n"
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	auths, err := readCredentials(path)
+	if err != nil {
+		t.Fatalf("readCredentials errored: %v", err)
+	}
+	want := []string{"Basic YWxpY2U6cGE6c3M=", "Bearer eyJhbGciOi.token"}
+	if strings.Join(auths, "|") != strings.Join(want, "|") {
+		t.Errorf("Unexpected credentials %q; want %q", auths, want)
+	}
+	if err := os.WriteFile(path, []byte("# none\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readCredentials(path); err == nil {
+		t.Error("Expected an error for a file without credentials")
+	}
+}
+
+func TestResolveSecrets(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" || r.URL.Path != "/v1/secret/data/api" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"data":{"data":{"token":"v4ult"},"metadata":{"version":1}}}`)
+	}))
+	defer vault.Close()
+	t.Setenv("VAULT_ADDR", vault.URL)
+	t.Setenv("VAULT_TOKEN", "root")
+	t.Setenv("HEY_TEST_PASSWORD", "pa:ss")
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "token"), []byte("f1le\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	headers := filepath.Join(dir, "headers.txt")
+	data := "Authorization: Bearer ${vault:secret/data/api#token}\nX-Key: ${file:" + filepath.Join(dir, "token") + "}\n"
+	os.WriteFile(headers, []byte(data), 0600)
+	header, err := readHeaders(headers)
+	if err != nil {
+		t.Fatalf("readHeaders errored: %v", err)
+	}
+	if got := header.Get("Authorization"); got != "Bearer v4ult" {
+		t.Errorf("Unexpected Authorization header %q", got)
+	}
+	if got := header.Get("X-Key"); got != "f1le" {
+		t.Errorf("Unexpected X-Key header %q", got)
+	}
+
+	creds := filepath.Join(dir, "credentials")
+	os.WriteFile(creds, []byte("alice:${env:HEY_TEST_PASSWORD}\n${vault:secret/data/api#token}\n"), 0600)
+	auths, err := readCredentials(creds)
+	if err != nil {
+		t.Fatalf("readCredentials errored: %v", err)
+	}
+	want := []string{"Basic YWxpY2U6cGE6c3M=", "Bearer v4ult"}
+	if strings.Join(auths, "|") != strings.Join(want, "|") {
+		t.Errorf("Unexpected credentials %q; want %q", auths, want)
+	}
+
+	for _, ref := range []string{"${env:HEY_TEST_UNSET}", "${vault:secret/data/api#missing}", "${vault:secret/data/other#token}", "${vault:secret/data/api}"} {
+		os.WriteFile(headers, []byte("X-Key: "+ref+"\n"), 0600)
+		if _, err := readHeaders(headers); err == nil {
+			t.Errorf("Expected an error for %s", ref)
+		}
+	}
+}
+
+func TestReadHeaders(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "headers.txt")
+	os.WriteFile(name, []byte("# auth\nAuthorization: Bearer s3cret\n\nX-Tag: a\nX-Tag: b\n"), 0644)
+	header, err := readHeaders(name)
+	if err != nil {
+		t.Fatalf("readHeaders errored: %v", err)
+	}
+	if got := header.Get("Authorization"); got != "Bearer s3cret" {
+		t.Errorf("Unexpected Authorization header %q", got)
+	}
+	if got := header.Values("X-Tag"); len(got) != 2 {
+		t.Errorf("Expected both X-Tag values, found %q", got)
+	}
+	os.WriteFile(name, []byte("X-Ok: 1\nnot a header s3cret\n"), 0644)
+	_, err = readHeaders(name)
+	if err == nil || !strings.Contains(err.Error(), ":2:") || strings.Contains(err.Error(), "s3cret") {
+		t.Errorf("Expected an error naming line 2 without its contents, found %v", err)
+	}
+}
+
+func TestReadBodies(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "b.json"), []byte(`{"b":2}`), 0644)
+	os.WriteFile(filepath.Join(dir, "a.xml"), []byte("<a/>"), 0644)
+	os.WriteFile(filepath.Join(dir, ".hidden"), []byte("x"), 0644)
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	bodies, err := readBodies(dir, true)
+	if err != nil {
+		t.Fatalf("readBodies errored: %v", err)
+	}
+	if len(bodies) != 2 || bodies[0].Name != "a.xml" || bodies[1].Name != "b.json" {
+		t.Fatalf("Unexpected bodies %+v", bodies)
+	}
+	if bodies[1].ContentType != "application/json" {
+		t.Errorf("Expected a detected JSON content type, found %q", bodies[1].ContentType)
+	}
+	if bodies, _ = readBodies(dir, false); bodies[1].ContentType != "" {
+		t.Errorf("Expected no content type, found %q", bodies[1].ContentType)
+	}
+	if _, err := readBodies(filepath.Join(dir, "sub"), true); err == nil {
+		t.Errorf("readBodies of an empty directory did not error")
+	}
+}
+
+func TestParseSizeRange(t *testing.T) {
+	min, max, err := parseSizeRange("1KB..64KB")
+	if err != nil || min != 1000 || max != 64000 {
+		t.Errorf("got %v..%v, %v; want 1000..64000", min, max, err)
+	}
+	if min, max, _ = parseSizeRange("512B"); min != 512 || max != 512 {
+		t.Errorf("got %v..%v; want 512..512", min, max)
+	}
+	for _, s := range []string{"64KB..1KB", "1KB..", "big..64KB"} {
+		if _, _, err := parseSizeRange(s); err == nil {
+			t.Errorf("parseSizeRange(%q) did not error", s)
+		}
+	}
+}
+
+func TestParseStatusCodes(t *testing.T) {
+	codes, err := parseStatusCodes("429, 401")
+	if err != nil || fmt.Sprint(codes) != "[429 401]" {
+		t.Errorf("got %v, %v; want [429 401]", codes, err)
+	}
+	for _, s := range []string{"", "429,", "42", "abc"} {
+		if _, err := parseStatusCodes(s); err == nil {
+			t.Errorf("parseStatusCodes(%q) did not error", s)
+		}
+	}
+}
+
+func TestRunAuthCmd(t *testing.T) {
+	for cmd, want := range map[string]string{
+		"echo abc":           "Bearer abc",
+		"printf 'Token xyz'": "Token xyz",
+	} {
+		if got, err := runAuthCmd(cmd); err != nil || got != want {
+			t.Errorf("runAuthCmd(%q) = %q, %v; want %q", cmd, got, err, want)
+		}
+	}
+	for _, cmd := range []string{"true", "exit 1"} {
+		if _, err := runAuthCmd(cmd); err == nil {
+			t.Errorf("runAuthCmd(%q) did not error", cmd)
+		}
+	}
+}
+
+func TestRefreshAuth(t *testing.T) {
+	var mu sync.Mutex
+	auths := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		auths[r.Header.Get("Authorization")] = true
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	count := filepath.Join(t.TempDir(), "count")
+	cmd := fmt.Sprintf("echo x >> %s; wc -l < %s", count, count)
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &requester.Work{Request: req, N: 40, C: 1, QPS: 100, Writer: io.Discard}
+	w.Init()
+	stop := refreshAuth(w, cmd, 50*time.Millisecond)
+	w.Run()
+	stop()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(auths) < 3 {
+		t.Errorf("Expected the token to be refreshed several times, found %v", auths)
+	}
+}
+
+func TestProbeMetrics(t *testing.T) {
+	m := newProbeMetrics()
+	now := time.Unix(1700000000, 0)
+	m.Write(requester.Record{Duration: 0.02, Status: 200, Time: now})
+	m.Write(requester.Record{Duration: 0.3, Status: 200, Time: now})
+	m.Write(requester.Record{Duration: 1, Error: "timeout", Time: now.Add(time.Second)})
+	s := httptest.NewServer(m)
+	defer s.Close()
+	res, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	for _, want := range []string{
+		"hey_probe_success 0\n",
+		"hey_probe_last_timestamp_seconds 1700000001.000\n",
+		`hey_probe_requests_total{code="200"} 2` + "\n",
+		`hey_probe_requests_total{code="error"} 1` + "\n",
+		`hey_probe_latency_seconds_bucket{le="0.025"} 1` + "\n",
+		`hey_probe_latency_seconds_bucket{le="0.5"} 2` + "\n",
+		`hey_probe_latency_seconds_bucket{le="+Inf"} 2` + "\n",
+		"hey_probe_latency_seconds_count 2\n",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Metrics lack %q:\n%s", want, body)
+		}
+	}
+	m.Write(requester.Record{Duration: 0.01, Status: 204, Time: now.Add(2 * time.Second)})
+	var b strings.Builder
+	m.writeTo(&b)
+	if !strings.Contains(b.String(), "hey_probe_success 1\n") {
+		t.Errorf("Expected the probe to succeed after a 204:\n%s", b.String())
+	}
+}
+
+func TestReadProtoBody(t *testing.T) {
+	varint := func(num, v uint64) []byte {
+		return binary.AppendUvarint(binary.AppendUvarint(nil, num<<3), v)
+	}
+	message := func(num uint64, parts ...[]byte) []byte {
+		var data []byte
+		for _, p := range parts {
+			data = append(data, p...)
+		}
+		return append(binary.AppendUvarint(binary.AppendUvarint(nil, num<<3|2), uint64(len(data))), data...)
+	}
+	str := func(num uint64, s string) []byte { return message(num, []byte(s)) }
+	field := func(name string, num, label, typ uint64, typeName string) []byte {
+		f := message(2, str(1, name), varint(3, num), varint(4, label), varint(5, typ))
+		if typeName != "" {
+			f = message(2, str(1, name), varint(3, num), varint(4, label), varint(5, typ), str(6, typeName))
+		}
+		return f
+	}
+	// package test; syntax = "proto3";
+	// message Req {
+	//   enum Kind { UNKNOWN = 0; FAST = 2; }
+	//   string name = 1; int32 count = 2; repeated int32 ids = 3; Kind kind = 4;
+	//   Inner inner = 5; sint64 delta = 6; double ratio = 7;
+	// }
+	// message Inner { bool ok = 1; }
+	kind := message(4, str(1, "Kind"), message(2, str(1, "UNKNOWN"), varint(2, 0)), message(2, str(1, "FAST"), varint(2, 2)))
+	req := message(4, str(1, "Req"),
+		field("name", 1, 1, 9, ""),
+		field("count", 2, 1, 5, ""),
+		field("ids", 3, 3, 5, ""),
+		field("kind", 4, 1, 14, ".test.Req.Kind"),
+		field("inner", 5, 1, 11, ".test.Inner"),
+		field("delta", 6, 1, 18, ""),
+		field("ratio", 7, 1, 1, ""),
+		kind)
+	inner := message(4, str(1, "Inner"), field("ok", 1, 1, 8, ""))
+	set := message(1, str(1, "test.proto"), str(2, "test"), req, inner, str(12, "proto3"))
+
+	dir := t.TempDir()
+	desc := filepath.Join(dir, "set.pb")
+	if err := os.WriteFile(desc, set, 0600); err != nil {
+		t.Fatal(err)
+	}
+	text := filepath.Join(dir, "req.textproto")
+	write := func(s string) {
+		if err := os.WriteFile(text, []byte(s), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`# A request.
+name: "a\x62" 'c'
+count: -1
+ids: [1, 2]
+ids: 300
+kind: FAST
+inner < ok: true >
+delta: -2; ratio: 0.5
+`)
+	body, err := readProtoBody(text, "test.Req", desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x0a, 3, 'a', 'b', 'c',
+		0x10, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01,
+		0x20, 2,
+		0x2a, 2, 0x08, 1,
+		0x30, 3,
+		0x39, 0, 0, 0, 0, 0, 0, 0xe0, 0x3f,
+		0x1a, 4, 1, 2, 0xac, 0x02,
+	}
+	if fmt.Sprint(body) != fmt.Sprint(want) {
+		t.Errorf("Unexpected body\n got: %x\nwant: %x", body, want)
+	}
+
+	for _, s := range []string{
+		`nope: 1`,
+		`name: 1`,
+		`count: "1"`,
+		`kind: SLOW`,
+		`name: "a" name: "b"`,
+		`inner { ok: true`,
+		`count: [1]`,
+	} {
+		write(s)
+		if _, err := readProtoBody(text, "test.Req", desc); err == nil {
+			t.Errorf("Expected %q to fail", s)
+		}
+	}
+	if _, err := readProtoBody(text, "test.Missing", desc); err == nil {
+		t.Error("Expected an unknown message type to fail")
+	}
+}
+
+func TestSOAPEnvelope(t *testing.T) {
+	const head, tail = `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body>`, `</soap:Body></soap:Envelope>`
+	for body, want := range map[string]string{
+		`<GetQuote><Symbol>ACME</Symbol></GetQuote>`:                            head + `<GetQuote><Symbol>ACME</Symbol></GetQuote>` + tail,
+		`<?xml version="1.0"?>` + "\n" + `<GetQuote/>`:                          `<?xml version="1.0"?>` + head + "\n" + `<GetQuote/>` + tail,
+		`<s:Envelope xmlns:s="urn:x"><s:Body><GetQuote/></s:Body></s:Envelope>`: `<s:Envelope xmlns:s="urn:x"><s:Body><GetQuote/></s:Body></s:Envelope>`,
+	} {
+		if got := string(soapEnvelope([]byte(body))); got != want {
+			t.Errorf("soapEnvelope(%q) = %q; want %q", body, got, want)
+		}
+	}
+}
+
+func TestSSHRun(t *testing.T) {
+	dir := t.TempDir()
+	script := "#!/bin/sh\n" +
+		"echo \"$@\" > " + filepath.Join(dir, "args") + "\n" +
+		`echo '{"offset":0.1,"duration":0.2,"status":200}'` + "\n" +
+		`echo '{"offset":0.2,"duration":0.1,"status":200}'` + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "ssh"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	r := &sshRun{host: "user@host", hey: "hey", saveDir: dir}
+	r.run([]string{"-n", "2", "-H", "X-Name: it's", "http://example.com/"})
+	if r.err != nil {
+		t.Fatalf("run errored: %v", r.err)
+	}
+	if len(r.records) != 2 || r.n != 2 {
+		t.Errorf("Expected 2 records, found %v (%v counted)", len(r.records), r.n)
+	}
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	want := `-o BatchMode=yes user@host 'hey' 'run' '-o' 'ndjson' '-n' '2' '-H' 'X-Name: it'\''s' 'http://example.com/'` + "\n"
+	if string(args) != want {
+		t.Errorf("Unexpected ssh arguments %q; want %q", args, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "user@host.ndjson")); err != nil {
+		t.Errorf("Expected raw results to be saved: %v", err)
+	}
+
+	exitingScript(t, dir, "ssh", 3)
+	r = &sshRun{host: "user@host", hey: "hey"}
+	r.run([]string{"http://example.com/"})
+	if r.err != nil || r.code != exitAssertion || len(r.records) != 1 {
+		t.Errorf("Expected a run with a failed assertion to keep its records, found %v, code %v, err %v", len(r.records), r.code, r.err)
+	}
+	exitingScript(t, dir, "ssh", 255)
+	if r.run(nil); r.err == nil {
+		t.Error("Expected a run that ssh failed to fail")
+	}
+}
+
+// exitingScript writes a script named name to dir that prints a record
+// and exits with code, and returns its path.
+func exitingScript(t *testing.T, dir, name string, code int) string {
+	script := "#!/bin/sh\n" +
+		`echo '{"offset":0.1,"duration":0.2,"status":200}'` + "\n" +
+		fmt.Sprintf("exit %d\n", code)
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMatrixRun(t *testing.T) {
+	dir := t.TempDir()
+	script := "#!/bin/sh\n" +
+		"echo \"$@\" > " + filepath.Join(dir, "args") + "\n" +
+		`echo '{"offset":0.1,"duration":0.2,"status":200}'` + "\n" +
+		`echo '{"offset":0.2,"duration":0.1,"error":"timeout"}'` + "\n"
+	hey := filepath.Join(dir, "hey")
+	if err := os.WriteFile(hey, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &matrixRun{hey: hey, c: "10", q: "100"}
+	r.run([]string{"-n", "2", "http://example.com/"})
+	if r.err != nil {
+		t.Fatalf("run errored: %v", r.err)
+	}
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	if want := "run -o ndjson -c 10 -q 100 -n 2 http://example.com/\n"; string(args) != want {
+		t.Errorf("Unexpected hey arguments %q; want %q", args, want)
+	}
+	failed := &matrixRun{hey: filepath.Join(dir, "missing"), c: "50"}
+	failed.run(nil)
+	if failed.err == nil {
+		t.Error("Expected a run of a missing binary to fail")
+	}
+	saturated := &matrixRun{hey: exitingScript(t, dir, "saturated", 5), c: "100", q: "1000"}
+	saturated.run(nil)
+	if saturated.err != nil || saturated.code != exitSaturated || saturated.rep.NumRes != 1 {
+		t.Errorf("Expected a saturated run to keep its result, found %v records, code %v, err %v", saturated.rep.NumRes, saturated.code, saturated.err)
+	}
+	usage := &matrixRun{hey: exitingScript(t, dir, "usage", 1), c: "100"}
+	if usage.run(nil); usage.err == nil {
+		t.Error("Expected a run that exited with 1 to fail")
+	}
+
+	var out strings.Builder
+	if err := writeMatrixCSV(&out, []*matrixRun{r, failed, saturated}); err != nil {
+		t.Fatalf("writeMatrixCSV errored: %v", err)
+	}
+	want := "c,q,requests,rps,average,p50,p90,p99,errors,error rate,outcome\n" +
+		"10,100,2,10.00,0.2000,0.2000,0.2000,0.2000,1,50.00%,ok\n" +
+		"50,0,failed,,,,,,,,\n" +
+		"100,1000,1,5.00,0.2000,0.2000,0.2000,0.2000,0,0.00%,saturated\n"
+	if out.String() != want {
+		t.Errorf("Unexpected matrix CSV:\n%s\nwant:\n%s", out.String(), want)
+	}
+	out.Reset()
+	if err := printMatrix(&out, []*matrixRun{r}); err != nil || !strings.Contains(out.String(), "error rate") {
+		t.Errorf("Unexpected matrix table %q (%v)", out.String(), err)
+	}
+}
+
+func TestMatrixKeepConns(t *testing.T) {
+	var opened atomic.Int64
+	target := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	target.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			opened.Add(1)
+		}
+	}
+	target.Start()
+	defer target.Close()
+
+	conns := &requester.Conns{}
+	for i, c := range []string{"4", "2"} {
+		before := opened.Load()
+		r := &matrixRun{c: c, conns: conns}
+		r.run([]string{"-n", "20", target.URL})
+		if r.err != nil || r.code != 0 || r.rep.NumRes != 20 {
+			t.Fatalf("-c %s: expected 20 results, found %v, code %v, err %v", c, r.rep.NumRes, r.code, r.err)
+		}
+		if n := opened.Load() - before; i == 0 && n == 0 || i == 1 && n != 0 {
+			t.Errorf("-c %s: unexpected %d connections opened", c, n)
+		}
+	}
+}
+
+func TestParseMatrixValues(t *testing.T) {
+	positive := func(s string) error {
+		if n, err := strconv.Atoi(s); err != nil || n <= 0 {
+			return fmt.Errorf("%q is not a positive number", s)
+		}
+		return nil
+	}
+	if got, err := parseMatrixValues("10, 50,100", "-c", positive); err != nil || strings.Join(got, " ") != "10 50 100" {
+		t.Errorf("Unexpected values %q (%v); want 10 50 100", got, err)
+	}
+	if got, err := parseMatrixValues("", "-c", positive); err != nil || len(got) != 1 || got[0] != "" {
+		t.Errorf("Expected a single default value, found %q (%v)", got, err)
+	}
+	if _, err := parseMatrixValues("10,0", "-c", positive); err == nil {
+		t.Error("Expected an error for a zero value")
+	}
+}
+
+func TestServer(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	api := httptest.NewServer(newServer())
+	defer api.Close()
+
+	body := fmt.Sprintf(`{"url": %q, "n": 20, "c": 2}`, target.URL)
+	resp, err := http.Post(api.URL+"/runs", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var run serverRun
+	json.NewDecoder(resp.Body).Decode(&run)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || run.ID != "1" || run.Config.Method != "GET" {
+		t.Fatalf("Unexpected response %v: %+v", resp.Status, &run)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); run.State != "done"; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Run did not finish: %+v", &run)
+		}
+		time.Sleep(10 * time.Millisecond)
+		resp, err := http.Get(api.URL + "/runs/1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(resp.Body).Decode(&run)
+		resp.Body.Close()
+	}
+	if run.Stats.Requests != 20 || run.Stats.StatusCodes[200] != 20 {
+		t.Errorf("Unexpected stats %+v", run.Stats)
+	}
+
+	resp, err = http.Get(api.URL + "/runs/1/report?o=csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if lines := strings.Count(string(report), "\n"); resp.StatusCode != http.StatusOK || lines != 21 {
+		t.Errorf("Expected a CSV report of 20 requests, found %v: %q", resp.Status, report)
+	}
+	if resp, _ := http.Get(api.URL + "/runs/2"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown run, found %v", resp.Status)
+	}
+}
+
+func TestServerRetention(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	s := newServer()
+	s.keep = 2
+	for i := 0; i < 3; i++ {
+		run, err := s.start(runConfig{URL: target.URL, N: 1, C: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for run.snapshot().State != "done" {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if s.run("1") != nil || s.run("2") == nil || s.run("3") == nil {
+		t.Errorf("Expected runs 2 and 3 to be kept, found %v runs", len(s.runs))
+	}
+
+	api := httptest.NewServer(s)
+	defer api.Close()
+	req, _ := http.NewRequest("DELETE", api.URL+"/runs/2", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent || s.run("2") != nil {
+		t.Errorf("Expected run 2 to be deleted, found %v", resp.Status)
+	}
+}
+
+func TestServerToken(t *testing.T) {
+	s := newServer()
+	s.token = "secret"
+	api := httptest.NewServer(s)
+	defer api.Close()
+	for token, want := range map[string]int{"": http.StatusUnauthorized, "wrong": http.StatusUnauthorized, "secret": http.StatusOK} {
+		req, _ := http.NewRequest("GET", api.URL+"/runs", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("GET /runs with token %q = %v; want %v", token, resp.StatusCode, want)
+		}
+	}
+	for addr, want := range map[string]bool{"localhost:8080": true, "127.0.0.1:80": true, "[::1]:80": true, ":8080": false, "0.0.0.0:80": false, "example.com:80": false} {
+		if got := isLoopback(addr); got != want {
+			t.Errorf("isLoopback(%q) = %v; want %v", addr, got, want)
+		}
+	}
+}
+
+// callGRPC makes a call of the Control service with the request message
+// req, and returns the response messages and the grpc-status.
+func callGRPC(t *testing.T, client *http.Client, addr, method string, req []byte) ([][]byte, string) {
+	frame := make([]byte, 5)
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(req)))
+	hreq, _ := http.NewRequest("POST", addr+"/hey.v1.Control/"+method, bytes.NewReader(append(frame, req...)))
+	hreq.Header.Set("Content-Type", "application/grpc")
+	resp, err := client.Do(hreq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var msgs [][]byte
+	for {
+		if _, err := io.ReadFull(resp.Body, frame); err != nil {
+			break
+		}
+		msg := make([]byte, binary.BigEndian.Uint32(frame[1:]))
+		if _, err := io.ReadFull(resp.Body, msg); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, resp.Trailer.Get("Grpc-Status")
+}
+
+// runFields returns the id and state of a Run message, and the requests
+// of its stats.
+func runFields(t *testing.T, msg []byte) (id string, state, requests uint64) {
+	r := protoReader(msg)
+	for len(r) > 0 {
+		num, _, v, data, err := r.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch num {
+		case 1:
+			id = string(data)
+		case 3:
+			state = v
+		case 6:
+			stats := protoReader(data)
+			for len(stats) > 0 {
+				if num, _, v, _, _ := stats.next(); num == 1 {
+					requests = v
+				}
+			}
+		}
+	}
+	return id, state, requests
+}
+
+func TestServerGRPC(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	api := httptest.NewServer(h2c.NewHandler(newServer(), &http2.Server{}))
+	defer api.Close()
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+
+	config := encodeRunConfig(&runConfig{URL: target.URL, N: 20, C: 2, Headers: map[string]string{"X-Test": "1"}, Percentiles: []float64{50, 99}})
+	if c, err := decodeRunConfig(config); err != nil || c.URL != target.URL || c.N != 20 || c.Headers["X-Test"] != "1" || len(c.Percentiles) != 2 {
+		t.Errorf("Unexpected decoded config %+v (%v)", c, err)
+	}
+	msgs, status := callGRPC(t, client, api.URL, "StartRun", appendProtoMessage(nil, 1, config))
+	if status != "0" || len(msgs) != 1 {
+		t.Fatalf("StartRun returned status %q and %d messages", status, len(msgs))
+	}
+	if id, _, _ := runFields(t, msgs[0]); id != "1" {
+		t.Errorf("Expected run 1 to be started, found %q", id)
+	}
+
+	msgs, status = callGRPC(t, client, api.URL, "StreamStats", appendProtoVarint(appendProtoString(nil, 1, "1"), 2, 10))
+	if status != "0" || len(msgs) == 0 {
+		t.Fatalf("StreamStats returned status %q and %d messages", status, len(msgs))
+	}
+	if _, state, requests := runFields(t, msgs[len(msgs)-1]); state != 2 || requests != 20 {
+		t.Errorf("Expected the last stats of a done run of 20 requests, found state %v, %v requests", state, requests)
+	}
+
+	if _, status = callGRPC(t, client, api.URL, "StopRun", appendProtoString(nil, 1, "2")); status != "5" {
+		t.Errorf("Expected NOT_FOUND stopping an unknown run, found status %q", status)
+	}
+	if _, status = callGRPC(t, client, api.URL, "Fly", nil); status != "12" {
+		t.Errorf("Expected UNIMPLEMENTED for an unknown method, found status %q", status)
+	}
+}
+
+func TestWebHandler(t *testing.T) {
+	web := httptest.NewServer(newWebHandler())
+	defer web.Close()
+
+	for path, want := range map[string]string{
+		"/":     `<form id="form">`,
+		"/runs": "[]",
+	} {
+		resp, err := http.Get(web.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), want) {
+			t.Errorf("GET %s = %v %q; want %q", path, resp.Status, body, want)
+		}
+	}
+
+	config := `{"url": "http://localhost:1/", "n": 1, "c": 1}`
+	for _, tt := range []struct {
+		contentType string
+		header      string
+		value       string
+		want        int
+	}{
+		{"text/plain", "", "", http.StatusUnsupportedMediaType},
+		{"application/json", "Origin", "http://evil.example", http.StatusForbidden},
+		{"application/json", "Sec-Fetch-Site", "cross-site", http.StatusForbidden},
+		{"application/json", "Origin", web.URL, http.StatusCreated},
+	} {
+		req, _ := http.NewRequest("POST", web.URL+"/runs", strings.NewReader(config))
+		req.Header.Set("Content-Type", tt.contentType)
+		if tt.header != "" {
+			req.Header.Set(tt.header, tt.value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("POST /runs as %s with %s %q = %v; want %v", tt.contentType, tt.header, tt.value, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestRepl(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	req, _ := http.NewRequest("GET", target.URL, nil)
+	rec := &serverRun{Started: time.Now(), Stats: runStats{StatusCodes: make(map[int]int64)}}
+	w := &requester.Work{
+		Request: req,
+		N:       math.MaxInt32,
+		C:       1,
+		QPS:     100,
+		Sinks:   []requester.Sink{rec},
+		Writer:  io.Discard,
+	}
+	done := make(chan struct{})
+	go func() {
+		w.Run()
+		close(done)
+	}()
+	for {
+		if c, _ := w.Load(); c == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	in := strings.NewReader("set c 3\nset q 0\nset c zero\nstatus\nreport\nfly\nstop\n")
+	var out strings.Builder
+	repl(in, &out, w, rec, nil, done)
+	<-done
+	got := out.String()
+	for _, want := range []string{"ok\nok\nerror: ", " requests/sec, 3 workers\n", "Summary:", `unknown command "fly"`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Output %q does not contain %q", got, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if args := os.Getenv("HEY_VALIDATE_ARGS"); args != "" {
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+		os.Exit(run(strings.Split(args, "\n"), runValidate))
+	}
+	var hits atomic.Int64
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer target.Close()
+	dir := t.TempDir()
+	errorLog := filepath.Join(dir, "errors.log")
+
+	tests := []struct {
+		args []string
+		code int
+		want string
+	}{
+		{[]string{"-n", "100", "-c", "10", "-q", "5", "-d", "x{{seq}}", "-template", "-assert", "total_p99<1s", "-error-log", errorLog, target.URL},
+			0, "Load:       100 requests, 10 workers, 5 req/s each\n  Body:       template of 8 bytes\n  Assertions: 1\n"},
+		{[]string{"-z", "3s", target.URL, target.URL + "/b"}, 0, "Load: for 3s, 50 workers\n  URLs: 2, in turn\n"},
+		{[]string{"-n", "5", "-c", "10", target.URL}, 1, "-n cannot be less than -c."},
+		{[]string{"-d", "{{bogus}}", "-template", target.URL}, 1, `function "bogus" not defined`},
+		{[]string{"-assert", "p99<1s", target.URL}, 1, "could not parse the provided assertion"},
+		{[]string{"-D", filepath.Join(dir, "missing"), target.URL}, 1, "missing"},
+	}
+	for _, tt := range tests {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestValidate$")
+		cmd.Env = append(os.Environ(), "HEY_VALIDATE_ARGS="+strings.Join(tt.args, "\n"))
+		out, err := cmd.CombinedOutput()
+		code := 0
+		if exit, ok := err.(*exec.ExitError); ok {
+			code = exit.ExitCode()
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code || !strings.Contains(string(out), tt.want) {
+			t.Errorf("Validating %q exited with %d and printed %q; want %d and %q", tt.args, code, out, tt.code, tt.want)
+		}
+	}
+	if n := hits.Load(); n != 0 {
+		t.Errorf("Expected no requests to be sent, found %d", n)
+	}
+	if _, err := os.Stat(errorLog); !os.IsNotExist(err) {
+		t.Errorf("Expected the error log not to be created: %v", err)
+	}
+}
+
+func TestServeControl(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	req, _ := http.NewRequest("GET", target.URL, nil)
+	rec := &serverRun{Started: time.Now(), Stats: runStats{StatusCodes: make(map[int]int64)}}
+	w := &requester.Work{
+		Request: req,
+		N:       math.MaxInt32,
+		C:       1,
+		QPS:     100,
+		Sinks:   []requester.Sink{rec},
+		Writer:  io.Discard,
+	}
+	path := filepath.Join(t.TempDir(), "hey.sock")
+	l, err := serveControl(path, w, rec, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	done := make(chan struct{})
+	go func() {
+		w.Run()
+		close(done)
+	}()
+	for {
+		if c, _ := w.Load(); c == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(conn, "set c 2\nset q 50\nstatus\nstop\n")
+	out, _ := io.ReadAll(conn)
+	conn.Close()
+	<-done
+	if want := "ok\nok\n"; !strings.HasPrefix(string(out), want) || !strings.Contains(string(out), "2 workers at 50 QPS each") {
+		t.Errorf("Unexpected output %q", out)
+	}
+}
@@ -0,0 +1,325 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rakyll/hey/requester"
+)
+
+var matrixUsage = `Usage: hey matrix [options...] -- [run options...] <url>
+
+Runs a load test once for every combination of concurrency levels and rate
+limits, one after the other, and tabulates the results, e.g. to plot the
+throughput and latency curves of a service as load grows. Each run is
+"hey run" with the run options, e.g. -n or -z, and its -c and -q.
+
+Runs that complete but exit with an outcome other than success, e.g. a
+saturated client at high rates, keep their results, with the outcome in
+the table. The exit code is 1 if a run failed, or else the lowest exit
+code of the runs.
+
+Options:
+  -c  Comma-separated numbers of workers, e.g. "10,50,100". Default is
+      the default of "hey run".
+  -q  Comma-separated rate limits per worker, e.g. "100,500". 0 is no
+      limit. Default is no limit.
+  -cooldown  Pause between runs, for the target to settle, e.g.
+      -cooldown 30s. Default is 10s. No requests are sent during it.
+      Unless -keep-conns is given, every run is a process of its own, and
+      its connections are closed at its end, so that every run starts from
+      cold connections and its results are not skewed by the one before.
+  -keep-conns  Keeps connections open through the cool-downs, so that
+      every run starts on the connections of the one before, as clients
+      that stay connected would, and only dials those it lacks. Runs are
+      then made by this process, on a shared transport, which cannot be
+      combined with -transport, -h2, -client-certs, -auth-type ntlm,
+      -tls-keylog or -conn-records.
+  -o  Output type. If none provided, a table is printed.
+      "csv" writes the table as comma-separated values.
+`
+
+// matrixPercentiles are the latency percentiles tabulated for every run.
+var matrixPercentiles = []float64{50, 90, 99}
+
+func matrixMain(args []string) {
+	fs := newCommandFlags("matrix", matrixUsage)
+	cs := fs.String("c", "", "")
+	qs := fs.String("q", "", "")
+	cooldown := fs.Duration("cooldown", 10*time.Second, "")
+	keepConns := fs.Bool("keep-conns", false, "")
+	output := fs.String("o", "", "")
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		usageAndExit("")
+	}
+	if *output != "" && *output != "csv" {
+		usageAndExit(fmt.Sprintf("unsupported output %q.", *output))
+	}
+	if *cooldown < 0 {
+		usageAndExit("-cooldown cannot be negative.")
+	}
+	for _, arg := range fs.Args() {
+		for _, name := range []string{"-c", "-q", "-o"} {
+			if arg == name || strings.HasPrefix(arg, name+"=") {
+				usageAndExit(name + " must be given before --, every run sends raw results.")
+			}
+		}
+	}
+	workers, err := parseMatrixValues(*cs, "-c", func(s string) error {
+		if n, err := strconv.Atoi(s); err != nil || n <= 0 {
+			return fmt.Errorf("%q is not a positive number", s)
+		}
+		return nil
+	})
+	if err != nil {
+		usageAndExit(err.Error())
+	}
+	rates, err := parseMatrixValues(*qs, "-q", func(s string) error {
+		if q, err := strconv.ParseFloat(s, 64); err != nil || q < 0 {
+			return fmt.Errorf("%q is not a rate limit", s)
+		}
+		return nil
+	})
+	if err != nil {
+		usageAndExit(err.Error())
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		errAndExit(err.Error())
+	}
+
+	var conns *requester.Conns
+	if *keepConns {
+		conns = &requester.Conns{}
+	}
+	var runs []*matrixRun
+	for _, c := range workers {
+		for _, q := range rates {
+			runs = append(runs, &matrixRun{hey: exe, c: c, q: q, conns: conns})
+		}
+	}
+	for i, r := range runs {
+		if i > 0 {
+			time.Sleep(*cooldown)
+		}
+		fmt.Fprintf(os.Stderr, "Run %d/%d: %s\n", i+1, len(runs), r.name())
+		r.run(fs.Args())
+		if r.err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", r.name(), r.err)
+		} else if r.code != 0 {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", r.name(), outcomes[r.code])
+		}
+		if r.code == exitAborted {
+			// Interrupted: tabulate the runs so far.
+			runs = runs[:i+1]
+			break
+		}
+	}
+	if *output == "csv" {
+		err = writeMatrixCSV(os.Stdout, runs)
+	} else {
+		err = printMatrix(os.Stdout, runs)
+	}
+	if err != nil {
+		errAndExit(err.Error())
+	}
+	code := 0
+	for _, r := range runs {
+		if r.err != nil {
+			os.Exit(exitUsage)
+		}
+		if r.code != 0 && (code == 0 || r.code < code) {
+			code = r.code
+		}
+	}
+	os.Exit(code)
+}
+
+// parseMatrixValues parses the comma-separated values of the named flag,
+// checking each with valid. An empty list is a single empty value, which
+// leaves the flag to its default.
+func parseMatrixValues(s, name string, valid func(string) error) ([]string, error) {
+	if s == "" {
+		return []string{""}, nil
+	}
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if err := valid(v); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// matrixRun is a run of the matrix, with c workers and a rate limit of q,
+// either empty for the defaults of "hey run".
+type matrixRun struct {
+	hey   string           // path of hey
+	conns *requester.Conns // if set, the run is made in process, keeping its connections in conns
+	c, q  string
+
+	rep  requester.Report
+	code int   // exit code of a completed run, see outcomes
+	err  error // set if the run failed
+}
+
+func (r *matrixRun) name() string {
+	var parts []string
+	if r.c != "" {
+		parts = append(parts, "-c "+r.c)
+	}
+	if r.q != "" {
+		parts = append(parts, "-q "+r.q)
+	}
+	if len(parts) == 0 {
+		return "defaults"
+	}
+	return strings.Join(parts, " ")
+}
+
+func (r *matrixRun) run(args []string) {
+	runArgs := []string{"-o", "ndjson"}
+	if r.c != "" {
+		runArgs = append(runArgs, "-c", r.c)
+	}
+	if r.q != "" {
+		runArgs = append(runArgs, "-q", r.q)
+	}
+	runArgs = append(runArgs, args...)
+	if r.conns != nil {
+		r.runInProcess(runArgs)
+		return
+	}
+	cmd := exec.Command(r.hey, append([]string{"run"}, runArgs...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		r.err = err
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		r.err = err
+		return
+	}
+	records, readErr := requester.ReadRecords(stdout)
+	err = cmd.Wait()
+	code, completed := runOutcome(err)
+	if !completed {
+		r.err = fmt.Errorf("%v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+		return
+	}
+	r.code = code
+	if readErr != nil {
+		r.err = readErr
+		return
+	}
+	r.rep = requester.ReportFromRecords(records, matrixPercentiles)
+}
+
+// runInProcess makes the run with runArgs in this process, keeping its
+// connections in r.conns. Problems with the run options exit the process,
+// as they would fail every run.
+func (r *matrixRun) runInProcess(runArgs []string) {
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	var out bytes.Buffer
+	code := runWith(runArgs, runBatch, &out, r.conns)
+	if _, completed := outcomes[code]; code != 0 && !completed {
+		r.err = fmt.Errorf("exit status %d", code)
+		return
+	}
+	r.code = code
+	records, err := requester.ReadRecords(&out)
+	if err != nil {
+		r.err = err
+		return
+	}
+	r.rep = requester.ReportFromRecords(records, matrixPercentiles)
+}
+
+// matrixHeader is the header of matrix tables, matrixRow their rows.
+var matrixHeader = []string{"c", "q", "requests", "rps", "average", "p50", "p90", "p99", "errors", "error rate", "outcome"}
+
+func matrixRow(r *matrixRun) []string {
+	c, q := r.c, r.q
+	if c == "" {
+		c = "default"
+	}
+	if q == "" {
+		q = "0"
+	}
+	if r.err != nil {
+		row := make([]string, len(matrixHeader))
+		row[0], row[1], row[2] = c, q, "failed"
+		return row
+	}
+	row := []string{c, q, strconv.FormatInt(r.rep.NumRes, 10), fmt.Sprintf("%.2f", r.rep.Rps), fmt.Sprintf("%4.4f", r.rep.Average)}
+	for _, p := range matrixPercentiles {
+		lat := ""
+		for _, d := range r.rep.LatencyDistribution {
+			if d.Percentage == p {
+				lat = fmt.Sprintf("%4.4f", d.Latency)
+			}
+		}
+		row = append(row, lat)
+	}
+	errs := errorCount(r.rep)
+	rate := 0.0
+	if r.rep.NumRes > 0 {
+		rate = float64(errs) / float64(r.rep.NumRes) * 100
+	}
+	outcome := "ok"
+	if r.code != 0 {
+		outcome = outcomes[r.code]
+	}
+	return append(row, strconv.Itoa(errs), fmt.Sprintf("%.2f%%", rate), outcome)
+}
+
+// printMatrix writes the results of runs as a table, latencies in
+// seconds.
+func printMatrix(w io.Writer, runs []*matrixRun) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(matrixHeader, "\t"))
+	for _, r := range runs {
+		fmt.Fprintln(tw, strings.Join(matrixRow(r), "\t"))
+	}
+	return tw.Flush()
+}
+
+// writeMatrixCSV writes the results of runs as comma-separated values,
+// latencies in seconds.
+func writeMatrixCSV(w io.Writer, runs []*matrixRun) error {
+	cw := csv.NewWriter(w)
+	cw.Write(matrixHeader)
+	for _, r := range runs {
+		cw.Write(matrixRow(r))
+	}
+	cw.Flush()
+	return cw.Error()
+}
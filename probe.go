@@ -0,0 +1,140 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/rakyll/hey/requester"
+)
+
+// probeBuckets are the upper bounds of the buckets of the latency
+// histogram of probes, in seconds, as the default buckets of Prometheus
+// client libraries.
+var probeBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// probeMetrics collects the metrics of a probe run, served in the
+// Prometheus text format.
+type probeMetrics struct {
+	mu       sync.Mutex
+	requests map[string]int64 // by status code, or "error"
+	buckets  []int64          // cumulative counts of probeBuckets
+	count    int64            // requests that got a response
+	sum      float64          // their latencies
+
+	// Last request.
+	last        bool
+	success     bool
+	lastLatency float64
+	lastTime    float64 // Unix time
+}
+
+func newProbeMetrics() *probeMetrics {
+	return &probeMetrics{requests: make(map[string]int64), buckets: make([]int64, len(probeBuckets))}
+}
+
+// Write implements requester.Sink to collect the results of the probe.
+func (m *probeMetrics) Write(rec requester.Record) error {
+	if rec.Connection != nil || rec.Mark != "" {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.last = true
+	m.lastTime = float64(rec.Time.UnixNano()) / 1e9
+	m.lastLatency = rec.Duration
+	if rec.Error != "" {
+		m.requests["error"]++
+		m.success = false
+		return nil
+	}
+	m.requests[strconv.Itoa(rec.Status)]++
+	m.success = rec.Status < 400
+	m.count++
+	m.sum += rec.Duration
+	for i, le := range probeBuckets {
+		if rec.Duration <= le {
+			m.buckets[i]++
+		}
+	}
+	return nil
+}
+
+func (m *probeMetrics) Close() error {
+	return nil
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (m *probeMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.writeTo(w)
+}
+
+func (m *probeMetrics) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	if m.last {
+		success := 0
+		if m.success {
+			success = 1
+		}
+		metric("hey_probe_success", "gauge", "Whether the last request succeeded, without an error or a status of 400 or above.")
+		fmt.Fprintf(w, "hey_probe_success %d\n", success)
+		metric("hey_probe_duration_seconds", "gauge", "Latency of the last request.")
+		fmt.Fprintf(w, "hey_probe_duration_seconds %g\n", m.lastLatency)
+		metric("hey_probe_last_timestamp_seconds", "gauge", "Time the last request was sent at.")
+		fmt.Fprintf(w, "hey_probe_last_timestamp_seconds %.3f\n", m.lastTime)
+	}
+
+	metric("hey_probe_requests_total", "counter", "Requests completed, by status code, or error if none was received.")
+	codes := make([]string, 0, len(m.requests))
+	for code := range m.requests {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "hey_probe_requests_total{code=%q} %d\n", code, m.requests[code])
+	}
+
+	metric("hey_probe_latency_seconds", "histogram", "Latency of the requests that got a response.")
+	for i, le := range probeBuckets {
+		fmt.Fprintf(w, "hey_probe_latency_seconds_bucket{le=\"%g\"} %d\n", le, m.buckets[i])
+	}
+	fmt.Fprintf(w, "hey_probe_latency_seconds_bucket{le=\"+Inf\"} %d\n", m.count)
+	fmt.Fprintf(w, "hey_probe_latency_seconds_sum %g\n", m.sum)
+	fmt.Fprintf(w, "hey_probe_latency_seconds_count %d\n", m.count)
+}
+
+// serveProbe serves the metrics of m at /metrics on addr. Closing the
+// returned listener stops serving them.
+func serveProbe(addr string, m *probeMetrics) (io.Closer, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	go http.Serve(l, mux)
+	return l, nil
+}
@@ -0,0 +1,690 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Field types of FieldDescriptorProto.
+const (
+	protoDouble   = 1
+	protoFloat    = 2
+	protoInt64    = 3
+	protoUint64   = 4
+	protoInt32    = 5
+	protoFixed64  = 6
+	protoFixed32  = 7
+	protoBool     = 8
+	protoString   = 9
+	protoGroup    = 10
+	protoMessage  = 11
+	protoBytes    = 12
+	protoUint32   = 13
+	protoEnum     = 14
+	protoSfixed32 = 15
+	protoSfixed64 = 16
+	protoSint32   = 17
+	protoSint64   = 18
+)
+
+// Wire types of the protobuf binary format.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// protoField is a field of a message type, from its FieldDescriptorProto.
+type protoField struct {
+	name     string
+	number   uint64
+	typ      int
+	typeName string // of message and enum fields, e.g. "pkg.Message"
+	repeated bool
+	packed   bool
+}
+
+// protoSchema holds the message and enum types of a file descriptor set
+// by their full names, e.g. "pkg.Message" and "pkg.Message.Kind".
+type protoSchema struct {
+	messages map[string]map[string]*protoField // fields by name
+	enums    map[string]map[string]int32       // values by name
+}
+
+// readProtoBody encodes the message of type typeName in the text format
+// file textFile to the binary format, with the types of the file
+// descriptor set descFile, as written by protoc --descriptor_set_out.
+func readProtoBody(textFile, typeName, descFile string) ([]byte, error) {
+	desc, err := os.ReadFile(descFile)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := parseProtoSchema(desc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", descFile, err)
+	}
+	text, err := os.ReadFile(textFile)
+	if err != nil {
+		return nil, err
+	}
+	body, err := schema.encode(strings.TrimPrefix(typeName, "."), string(text))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", textFile, err)
+	}
+	return body, nil
+}
+
+// protoReader reads the fields of a message in the binary format.
+type protoReader []byte
+
+// next returns the next field, the value of varint and fixed fields as v,
+// and the contents of length-delimited ones as data.
+func (r *protoReader) next() (num uint64, wire int, v uint64, data []byte, err error) {
+	tag, n := binary.Uvarint(*r)
+	if n <= 0 {
+		return 0, 0, 0, nil, errors.New("malformed field tag")
+	}
+	*r = (*r)[n:]
+	num, wire = tag>>3, int(tag&7)
+	switch wire {
+	case wireVarint:
+		if v, n = binary.Uvarint(*r); n <= 0 {
+			return 0, 0, 0, nil, errors.New("malformed varint")
+		}
+	case wireFixed64:
+		if len(*r) < 8 {
+			return 0, 0, 0, nil, errors.New("truncated fixed64")
+		}
+		v, n = binary.LittleEndian.Uint64(*r), 8
+	case wireFixed32:
+		if len(*r) < 4 {
+			return 0, 0, 0, nil, errors.New("truncated fixed32")
+		}
+		v, n = uint64(binary.LittleEndian.Uint32(*r)), 4
+	case wireBytes:
+		l, m := binary.Uvarint(*r)
+		if m <= 0 || uint64(len(*r)-m) < l {
+			return 0, 0, 0, nil, errors.New("truncated length-delimited field")
+		}
+		data, n = (*r)[m:m+int(l)], m+int(l)
+	default:
+		return 0, 0, 0, nil, fmt.Errorf("unsupported wire type %d", wire)
+	}
+	*r = (*r)[n:]
+	return num, wire, v, data, nil
+}
+
+// parseProtoSchema reads the types of a FileDescriptorSet.
+func parseProtoSchema(set []byte) (*protoSchema, error) {
+	s := &protoSchema{messages: make(map[string]map[string]*protoField), enums: make(map[string]map[string]int32)}
+	r := protoReader(set)
+	for len(r) > 0 {
+		num, _, _, data, err := r.next()
+		if err != nil {
+			return nil, err
+		}
+		if num == 1 { // file
+			if err := s.addFile(data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(s.messages) == 0 {
+		return nil, errors.New("no message types in the file descriptor set")
+	}
+	return s, nil
+}
+
+// addFile adds the types of a FileDescriptorProto.
+func (s *protoSchema) addFile(file []byte) error {
+	var pkg, syntax string
+	var messages, enums [][]byte
+	r := protoReader(file)
+	for len(r) > 0 {
+		num, _, _, data, err := r.next()
+		if err != nil {
+			return err
+		}
+		switch num {
+		case 2:
+			pkg = string(data)
+		case 4:
+			messages = append(messages, data)
+		case 5:
+			enums = append(enums, data)
+		case 12:
+			syntax = string(data)
+		}
+	}
+	prefix := ""
+	if pkg != "" {
+		prefix = pkg + "."
+	}
+	for _, m := range messages {
+		if err := s.addMessage(prefix, m, syntax == "proto3"); err != nil {
+			return err
+		}
+	}
+	for _, e := range enums {
+		if err := s.addEnum(prefix, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addMessage adds the type of a DescriptorProto, and its nested types.
+func (s *protoSchema) addMessage(prefix string, msg []byte, proto3 bool) error {
+	var name string
+	var fields, nested, enums [][]byte
+	r := protoReader(msg)
+	for len(r) > 0 {
+		num, _, _, data, err := r.next()
+		if err != nil {
+			return err
+		}
+		switch num {
+		case 1:
+			name = string(data)
+		case 2:
+			fields = append(fields, data)
+		case 3:
+			nested = append(nested, data)
+		case 4:
+			enums = append(enums, data)
+		}
+	}
+	name = prefix + name
+	byName := make(map[string]*protoField, len(fields))
+	for _, data := range fields {
+		f, err := parseProtoField(data, proto3)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		byName[f.name] = f
+	}
+	s.messages[name] = byName
+	for _, m := range nested {
+		if err := s.addMessage(name+".", m, proto3); err != nil {
+			return err
+		}
+	}
+	for _, e := range enums {
+		if err := s.addEnum(name+".", e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseProtoField reads a FieldDescriptorProto. Repeated scalar fields
+// are packed by default in proto3 files.
+func parseProtoField(field []byte, proto3 bool) (*protoField, error) {
+	f := &protoField{}
+	packed, explicit := false, false
+	r := protoReader(field)
+	for len(r) > 0 {
+		num, _, v, data, err := r.next()
+		if err != nil {
+			return nil, err
+		}
+		switch num {
+		case 1:
+			f.name = string(data)
+		case 3:
+			f.number = v
+		case 4:
+			f.repeated = v == 3
+		case 5:
+			f.typ = int(v)
+		case 6:
+			f.typeName = strings.TrimPrefix(string(data), ".")
+		case 8: // options
+			o := protoReader(data)
+			for len(o) > 0 {
+				num, _, v, _, err := o.next()
+				if err != nil {
+					return nil, err
+				}
+				if num == 2 {
+					packed, explicit = v != 0, true
+				}
+			}
+		}
+	}
+	switch f.typ {
+	case protoString, protoBytes, protoMessage, protoGroup:
+	default:
+		f.packed = f.repeated && (packed || proto3 && !explicit)
+	}
+	return f, nil
+}
+
+// addEnum adds the values of an EnumDescriptorProto.
+func (s *protoSchema) addEnum(prefix string, enum []byte) error {
+	var name string
+	values := make(map[string]int32)
+	r := protoReader(enum)
+	for len(r) > 0 {
+		num, _, _, data, err := r.next()
+		if err != nil {
+			return err
+		}
+		switch num {
+		case 1:
+			name = string(data)
+		case 2:
+			var valueName string
+			var number int32
+			v := protoReader(data)
+			for len(v) > 0 {
+				num, _, n, data, err := v.next()
+				if err != nil {
+					return err
+				}
+				switch num {
+				case 1:
+					valueName = string(data)
+				case 2:
+					number = int32(n)
+				}
+			}
+			values[valueName] = number
+		}
+	}
+	s.enums[prefix+name] = values
+	return nil
+}
+
+// encode encodes the message of type typeName in the text format to the
+// binary format.
+func (s *protoSchema) encode(typeName, text string) ([]byte, error) {
+	if _, ok := s.messages[typeName]; !ok {
+		return nil, fmt.Errorf("unknown message type %q", typeName)
+	}
+	t := &textScanner{s: text, line: 1}
+	return s.encodeMessage(t, typeName, 0)
+}
+
+// encodeMessage encodes the fields of a message of type typeName read
+// from t up to end, the closing delimiter of the message or 0 for the end
+// of the input. Packed fields are written after the others.
+func (s *protoSchema) encodeMessage(t *textScanner, typeName string, end byte) ([]byte, error) {
+	fields := s.messages[typeName]
+	var out []byte
+	packed := make(map[*protoField][]byte)
+	var packedOrder []*protoField
+	seen := make(map[string]bool)
+	for {
+		t.skipSpace()
+		if c := t.peek(); c == end {
+			if end != 0 {
+				t.pos++
+			}
+			break
+		} else if c == 0 {
+			return nil, t.errorf("unexpected end of input in %s", typeName)
+		}
+		name := t.ident()
+		if name == "" {
+			return nil, t.errorf("expected a field name of %s, got %q", typeName, t.peek())
+		}
+		f := fields[name]
+		if f == nil {
+			return nil, t.errorf("unknown field %q of %s", name, typeName)
+		}
+		if f.typ == protoGroup {
+			return nil, t.errorf("field %q: groups are not supported", name)
+		}
+		if seen[name] && !f.repeated {
+			return nil, t.errorf("field %q is set more than once", name)
+		}
+		seen[name] = true
+		t.skipSpace()
+		if t.peek() == ':' {
+			t.pos++
+			t.skipSpace()
+		}
+		values := 1
+		list := t.peek() == '['
+		if list {
+			if !f.repeated {
+				return nil, t.errorf("field %q is not repeated", name)
+			}
+			t.pos++
+			t.skipSpace()
+			values = 0
+			if t.peek() == ']' {
+				t.pos++
+			} else {
+				values = -1
+			}
+		}
+		for i := 0; values < 0 || i < values; i++ {
+			if f.typ == protoMessage {
+				t.skipSpace()
+				open := t.peek()
+				if open != '{' && open != '<' {
+					return nil, t.errorf("field %q: expected a message", name)
+				}
+				t.pos++
+				closing := byte('}')
+				if open == '<' {
+					closing = '>'
+				}
+				msg, err := s.encodeMessage(t, f.typeName, closing)
+				if err != nil {
+					return nil, err
+				}
+				out = appendProtoTag(out, f.number, wireBytes)
+				out = binary.AppendUvarint(out, uint64(len(msg)))
+				out = append(out, msg...)
+			} else {
+				tok, quoted, err := t.scalar()
+				if err != nil {
+					return nil, err
+				}
+				wire, v, err := s.encodeScalar(f, tok, quoted)
+				if err != nil {
+					return nil, t.errorf("field %q: %v", name, err)
+				}
+				if f.packed {
+					if _, ok := packed[f]; !ok {
+						packedOrder = append(packedOrder, f)
+					}
+					packed[f] = append(packed[f], v...)
+				} else {
+					out = appendProtoTag(out, f.number, wire)
+					out = append(out, v...)
+				}
+			}
+			if !list {
+				break
+			}
+			t.skipSpace()
+			if c := t.peek(); c == ',' {
+				t.pos++
+			} else if c == ']' {
+				t.pos++
+				break
+			} else {
+				return nil, t.errorf("field %q: expected ',' or ']'", name)
+			}
+		}
+		t.skipSpace()
+		if c := t.peek(); c == ',' || c == ';' {
+			t.pos++
+		}
+	}
+	for _, f := range packedOrder {
+		out = appendProtoTag(out, f.number, wireBytes)
+		out = binary.AppendUvarint(out, uint64(len(packed[f])))
+		out = append(out, packed[f]...)
+	}
+	return out, nil
+}
+
+func appendProtoTag(b []byte, num uint64, wire int) []byte {
+	return binary.AppendUvarint(b, num<<3|uint64(wire))
+}
+
+// encodeScalar encodes the value of a scalar field given by tok, quoted
+// if it was a string literal, without its tag.
+func (s *protoSchema) encodeScalar(f *protoField, tok string, quoted bool) (wire int, v []byte, err error) {
+	if quoted != (f.typ == protoString || f.typ == protoBytes) {
+		return 0, nil, fmt.Errorf("invalid value %q", tok)
+	}
+	switch f.typ {
+	case protoString, protoBytes:
+		if f.typ == protoString && !utf8.ValidString(tok) {
+			return 0, nil, errors.New("invalid UTF-8 in string")
+		}
+		v = binary.AppendUvarint(nil, uint64(len(tok)))
+		return wireBytes, append(v, tok...), nil
+	case protoDouble, protoFloat:
+		x, err := parseTextFloat(tok)
+		if err != nil {
+			return 0, nil, err
+		}
+		if f.typ == protoFloat {
+			return wireFixed32, binary.LittleEndian.AppendUint32(nil, math.Float32bits(float32(x))), nil
+		}
+		return wireFixed64, binary.LittleEndian.AppendUint64(nil, math.Float64bits(x)), nil
+	case protoBool:
+		switch tok {
+		case "true", "True", "t", "1":
+			return wireVarint, []byte{1}, nil
+		case "false", "False", "f", "0":
+			return wireVarint, []byte{0}, nil
+		}
+		return 0, nil, fmt.Errorf("invalid bool %q", tok)
+	case protoEnum:
+		if n, ok := s.enums[f.typeName][tok]; ok {
+			return wireVarint, binary.AppendUvarint(nil, uint64(int64(n))), nil
+		}
+		n, err := strconv.ParseInt(tok, 0, 32)
+		if err != nil {
+			return 0, nil, fmt.Errorf("unknown value %q of %s", tok, f.typeName)
+		}
+		return wireVarint, binary.AppendUvarint(nil, uint64(n)), nil
+	case protoUint32, protoUint64, protoFixed32, protoFixed64:
+		bits := 64
+		if f.typ == protoUint32 || f.typ == protoFixed32 {
+			bits = 32
+		}
+		n, err := strconv.ParseUint(tok, 0, bits)
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid unsigned integer %q", tok)
+		}
+		switch f.typ {
+		case protoFixed32:
+			return wireFixed32, binary.LittleEndian.AppendUint32(nil, uint32(n)), nil
+		case protoFixed64:
+			return wireFixed64, binary.LittleEndian.AppendUint64(nil, n), nil
+		}
+		return wireVarint, binary.AppendUvarint(nil, n), nil
+	default: // signed integers
+		bits := 64
+		if f.typ == protoInt32 || f.typ == protoSint32 || f.typ == protoSfixed32 {
+			bits = 32
+		}
+		n, err := strconv.ParseInt(tok, 0, bits)
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid integer %q", tok)
+		}
+		switch f.typ {
+		case protoSint32, protoSint64:
+			return wireVarint, binary.AppendVarint(nil, n), nil
+		case protoSfixed32:
+			return wireFixed32, binary.LittleEndian.AppendUint32(nil, uint32(n)), nil
+		case protoSfixed64:
+			return wireFixed64, binary.LittleEndian.AppendUint64(nil, uint64(n)), nil
+		}
+		// Negative int32 values are sign-extended to 10 bytes, as int64.
+		return wireVarint, binary.AppendUvarint(nil, uint64(n)), nil
+	}
+}
+
+// parseTextFloat parses a floating point number of the text format,
+// which may have an "f" suffix and be "inf", "-inf" or "nan".
+func parseTextFloat(tok string) (float64, error) {
+	lower := strings.ToLower(tok)
+	if !strings.Contains(lower, "inf") {
+		lower = strings.TrimSuffix(lower, "f")
+	}
+	x, err := strconv.ParseFloat(lower, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", tok)
+	}
+	return x, nil
+}
+
+// textScanner reads tokens of the protobuf text format.
+type textScanner struct {
+	s    string
+	pos  int
+	line int
+}
+
+func (t *textScanner) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", t.line, fmt.Sprintf(format, args...))
+}
+
+// peek returns the next byte, or 0 at the end of the input.
+func (t *textScanner) peek() byte {
+	if t.pos >= len(t.s) {
+		return 0
+	}
+	return t.s[t.pos]
+}
+
+// skipSpace skips white space and comments.
+func (t *textScanner) skipSpace() {
+	for t.pos < len(t.s) {
+		switch c := t.s[t.pos]; {
+		case c == '\n':
+			t.line++
+			t.pos++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			t.pos++
+		case c == '#':
+			for t.pos < len(t.s) && t.s[t.pos] != '\n' {
+				t.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func isIdentByte(c byte, first bool) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || !first && '0' <= c && c <= '9'
+}
+
+// ident reads a field name, or returns "" if there is none.
+func (t *textScanner) ident() string {
+	start := t.pos
+	for t.pos < len(t.s) && isIdentByte(t.s[t.pos], t.pos == start) {
+		t.pos++
+	}
+	return t.s[start:t.pos]
+}
+
+// scalar reads a scalar value: a number, an identifier such as an enum
+// value, or string literals, which are concatenated.
+func (t *textScanner) scalar() (tok string, quoted bool, err error) {
+	t.skipSpace()
+	if c := t.peek(); c != '"' && c != '\'' {
+		start := t.pos
+		for t.pos < len(t.s) {
+			c := t.s[t.pos]
+			if !isIdentByte(c, false) && c != '.' && c != '-' && c != '+' {
+				break
+			}
+			t.pos++
+		}
+		if t.pos == start {
+			return "", false, t.errorf("expected a value, got %q", c)
+		}
+		return t.s[start:t.pos], false, nil
+	}
+	var sb strings.Builder
+	for c := t.peek(); c == '"' || c == '\''; c = t.peek() {
+		if err := t.quoted(&sb); err != nil {
+			return "", false, err
+		}
+		t.skipSpace()
+	}
+	return sb.String(), true, nil
+}
+
+// quoted reads a string literal into sb, with its escapes replaced.
+func (t *textScanner) quoted(sb *strings.Builder) error {
+	quote := t.s[t.pos]
+	t.pos++
+	for {
+		if t.pos >= len(t.s) || t.s[t.pos] == '\n' {
+			return t.errorf("unterminated string")
+		}
+		c := t.s[t.pos]
+		t.pos++
+		if c == quote {
+			return nil
+		}
+		if c != '\\' {
+			sb.WriteByte(c)
+			continue
+		}
+		if t.pos >= len(t.s) {
+			return t.errorf("unterminated string")
+		}
+		c = t.s[t.pos]
+		t.pos++
+		switch c {
+		case 'a':
+			sb.WriteByte('\a')
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'v':
+			sb.WriteByte('\v')
+		case '\\', '\'', '"', '?':
+			sb.WriteByte(c)
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			n := int(c - '0')
+			for i := 0; i < 2 && t.pos < len(t.s) && '0' <= t.s[t.pos] && t.s[t.pos] <= '7'; i++ {
+				n = n*8 + int(t.s[t.pos]-'0')
+				t.pos++
+			}
+			if n > 0xff {
+				return t.errorf("invalid octal escape")
+			}
+			sb.WriteByte(byte(n))
+		case 'x', 'u', 'U':
+			digits := map[byte]int{'x': 2, 'u': 4, 'U': 8}[c]
+			start := t.pos
+			for t.pos < len(t.s) && t.pos-start < digits && strings.IndexByte("0123456789abcdefABCDEF", t.s[t.pos]) >= 0 {
+				t.pos++
+			}
+			if t.pos == start || c != 'x' && t.pos-start != digits {
+				return t.errorf("invalid \\%c escape", c)
+			}
+			n, _ := strconv.ParseUint(t.s[start:t.pos], 16, 32)
+			if c == 'x' {
+				sb.WriteByte(byte(n))
+			} else {
+				sb.WriteRune(rune(n))
+			}
+		default:
+			return t.errorf("invalid escape \\%c", c)
+		}
+	}
+}
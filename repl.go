@@ -0,0 +1,150 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rakyll/hey/requester"
+)
+
+var replUsage = `Usage: hey repl [run options...] <url>...
+
+Runs a load test like "hey run", and reads commands from the standard
+input to steer it while it runs. Unless -n, -z or -iterations is given,
+the run goes on until stopped. The final report is printed once it ends.
+
+Commands:
+  set c <workers>  Changes the number of workers.
+  set q <qps>      Changes the rate limit per worker; 0 removes it.
+  status           Prints the number of requests so far, and the load.
+  report           Prints an interim summary of the results so far.
+  mark <text>      Marks the results at the current time, e.g. "mark
+                   deploy v2", to attribute changes to what happened.
+  stop             Stops the run. So do quit and the end of the input.
+  help             Lists the commands.
+
+Run "hey run -h" for the run options.
+`
+
+const replHelp = `Commands: set c <workers>, set q <qps>, status, report, mark <text>, stop.
+`
+
+func replMain(args []string) {
+	if code := run(args, runREPL); code != 0 {
+		os.Exit(code)
+	}
+}
+
+// repl reads commands from in to adjust w, which collects its results
+// with rec, until the run is done or the user stops it.
+func repl(in io.Reader, out io.Writer, w *requester.Work, rec *serverRun, percentiles []float64, done <-chan struct{}) {
+	lines := make(chan string)
+	go func() {
+		s := bufio.NewScanner(in)
+		for s.Scan() {
+			lines <- s.Text()
+		}
+		close(lines)
+	}()
+	for {
+		var line string
+		var ok bool
+		select {
+		case <-done:
+			return
+		case line, ok = <-lines:
+		}
+		if !ok {
+			w.Stop()
+			return
+		}
+		if replCommand(out, line, w, rec, percentiles) {
+			return
+		}
+	}
+}
+
+// replCommand runs the command in line on w, and reports whether it
+// stopped the run.
+func replCommand(out io.Writer, line string, w *requester.Work, rec *serverRun, percentiles []float64) (stop bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false
+	}
+	switch cmd := fields[0]; {
+	case cmd == "set" && len(fields) == 3 && fields[1] == "c":
+		c, err := strconv.Atoi(fields[2])
+		if err == nil {
+			err = w.SetConcurrency(c)
+		}
+		replResult(out, err)
+	case cmd == "set" && len(fields) == 3 && fields[1] == "q":
+		q, err := strconv.ParseFloat(fields[2], 64)
+		if err == nil {
+			err = w.SetQPS(q)
+		}
+		replResult(out, err)
+	case cmd == "status" && len(fields) == 1:
+		s := rec.snapshot().Stats
+		c, q := w.Load()
+		fmt.Fprintf(out, "%d requests, %d errors, %.2f requests/sec, %d workers", s.Requests, s.Errors, s.Rps, c)
+		if q > 0 {
+			fmt.Fprintf(out, " at %g QPS each", q)
+		}
+		fmt.Fprintln(out)
+	case cmd == "report" && len(fields) == 1:
+		rec.mu.Lock()
+		rep := requester.ReportFromRecords(rec.records, percentiles)
+		rec.mu.Unlock()
+		if err := requester.PrintReport(out, rep, ""); err != nil {
+			replResult(out, err)
+		}
+	case cmd == "mark" && len(fields) > 1:
+		replResult(out, w.Mark(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "mark"))))
+	case (cmd == "stop" || cmd == "quit") && len(fields) == 1:
+		w.Stop()
+		return true
+	case cmd == "help":
+		fmt.Fprint(out, replHelp)
+	default:
+		fmt.Fprintf(out, "unknown command %q. %s", line, replHelp)
+	}
+	return false
+}
+
+func replResult(out io.Writer, err error) {
+	if err != nil {
+		fmt.Fprintf(out, "error: %v\n", err)
+		return
+	}
+	fmt.Fprintln(out, "ok")
+}
+
+// runInteractive runs w while reading commands from the standard input.
+func runInteractive(w *requester.Work, rec *serverRun, percentiles []float64) {
+	done := make(chan struct{})
+	go func() {
+		w.Run()
+		close(done)
+	}()
+	repl(os.Stdin, os.Stdout, w, rec, percentiles, done)
+	<-done
+}
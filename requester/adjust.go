@@ -0,0 +1,155 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+)
+
+var errNotRunning = errors.New("requester: the run is not running")
+
+// workerPool tracks the workers of a run, so that their number and the
+// rate limit can be adjusted while it runs.
+type workerPool struct {
+	mu      sync.Mutex
+	clients []*http.Client
+	n       int   // requests per worker
+	seed    int64 // of the workers' random sources
+	qps     float64
+	started int             // workers started so far, for their IDs
+	quits   []chan struct{} // of the running workers
+	active  int             // workers that have not returned, quit or not
+	arrival int64           // Poisson arrival sources created so far
+	done    chan struct{}   // closed once all workers returned
+}
+
+// startWorker starts a worker. b.pool.mu must be held.
+func (b *Work) startWorker() {
+	p := &b.pool
+	p.started++
+	worker := p.started
+	quit := make(chan struct{})
+	p.quits = append(p.quits, quit)
+	p.active++
+	// Each worker has its own source, so that its choices do not
+	// depend on how it is scheduled against the others.
+	rnd := rand.New(rand.NewSource(p.seed + int64(worker-1)))
+	client := p.clients[(worker-1)%len(p.clients)]
+	go func() {
+		b.runWorker(client, p.n, worker, rnd, quit)
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for i, q := range p.quits {
+			if q == quit {
+				p.quits = append(p.quits[:i], p.quits[i+1:]...)
+				break
+			}
+		}
+		if p.active--; p.active == 0 {
+			close(p.done)
+		}
+	}()
+}
+
+// setRate replaces the rate limiter by one allowing rate requests per
+// second overall, or none if rate is 0. b.pool.mu must be held, unless
+// workers have not started yet.
+func (b *Work) setRate(rate float64) {
+	var l *limiter
+	if rate > 0 {
+		var rnd *rand.Rand
+		if b.Arrival == ArrivalPoisson {
+			// Seeded apart from the workers' sources.
+			b.pool.arrival++
+			rnd = rand.New(rand.NewSource(b.pool.seed - b.pool.arrival))
+		}
+		if b.PrecisePacing {
+			l = newPreciseLimiter(rate, b.Burst, rnd)
+		} else {
+			l = newLimiter(rate, b.Burst, rnd)
+		}
+	}
+	if old := b.limiter.Swap(l); old != nil {
+		old.stop()
+	}
+}
+
+// running reports whether workers are running. b.pool.mu must be held.
+func (b *Work) running() bool {
+	if b.pool.done == nil {
+		return false
+	}
+	select {
+	case <-b.pool.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// SetConcurrency changes the number of workers of a running run to c.
+// Workers started make as many requests as the initial ones, and workers
+// stopped return after their current request. With a rate limit, the
+// overall rate changes along, as QPS is per worker.
+func (b *Work) SetConcurrency(c int) error {
+	if c < 1 {
+		return errors.New("requester: concurrency cannot be smaller than 1")
+	}
+	b.pool.mu.Lock()
+	defer b.pool.mu.Unlock()
+	if !b.running() {
+		return errNotRunning
+	}
+	for len(b.pool.quits) < c {
+		b.startWorker()
+	}
+	for len(b.pool.quits) > c {
+		last := len(b.pool.quits) - 1
+		close(b.pool.quits[last])
+		b.pool.quits = b.pool.quits[:last]
+	}
+	if b.pool.qps > 0 {
+		b.setRate(b.pool.qps * float64(c))
+	}
+	return nil
+}
+
+// SetQPS changes the rate limit per worker of a running run to qps, or
+// removes it if qps is 0. Rate limited requests are then paced from the
+// time of the change.
+func (b *Work) SetQPS(qps float64) error {
+	if qps < 0 {
+		return errors.New("requester: QPS cannot be negative")
+	}
+	b.pool.mu.Lock()
+	defer b.pool.mu.Unlock()
+	if !b.running() {
+		return errNotRunning
+	}
+	b.pool.qps = qps
+	b.setRate(qps * float64(len(b.pool.quits)))
+	return nil
+}
+
+// Load returns the number of workers of a running run and their rate
+// limit per worker, 0 if there is none.
+func (b *Work) Load() (c int, qps float64) {
+	b.pool.mu.Lock()
+	defer b.pool.mu.Unlock()
+	return len(b.pool.quits), b.pool.qps
+}
@@ -0,0 +1,155 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var assertRegexp = regexp.MustCompile(`^\s*([a-z]+)_(p[\d.]+|avg|max)\s*(<=|<)\s*(\S+)\s*$`)
+
+// assertPhases are the phases assertions may be made on. The connection
+// phases are only measured for the requests that opened a connection.
+var assertPhases = map[string]struct {
+	lats func(rep *Report) []float64
+	conn bool
+}{
+	"total": {func(rep *Report) []float64 { return rep.Lats }, false},
+	"dns":   {func(rep *Report) []float64 { return rep.DnsLats }, true},
+	"dial":  {func(rep *Report) []float64 { return rep.DialLats }, true},
+	"tls":   {func(rep *Report) []float64 { return rep.TLSLats }, true},
+	"conn":  {func(rep *Report) []float64 { return rep.ConnLats }, true},
+	"req":   {func(rep *Report) []float64 { return rep.ReqLats }, false},
+	"ttfb":  {func(rep *Report) []float64 { return rep.DelayLats }, false},
+	"res":   {func(rep *Report) []float64 { return rep.ResLats }, false},
+}
+
+// Assertion is a limit on the latency of a phase of the requests, such
+// as "tls_p99<50ms": the 99th percentile of TLS handshakes must be under
+// 50ms.
+type Assertion struct {
+	Phase     string  // one of total, dns, dial, tls, conn, req, ttfb and res
+	Stat      string  // "p<percentile>", "avg" or "max"
+	Pct       float64 // percentile of a "p" Stat
+	Inclusive bool    // whether Limit itself passes, with "<="
+	Limit     time.Duration
+}
+
+// ParseAssertion parses an assertion of the form "ttfb_p95<100ms", a phase
+// and a statistic of its latency, "<" or "<=", and a duration.
+func ParseAssertion(s string) (Assertion, error) {
+	m := assertRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return Assertion{}, fmt.Errorf("could not parse the provided assertion; input = %v", s)
+	}
+	if _, ok := assertPhases[m[1]]; !ok {
+		return Assertion{}, fmt.Errorf("unknown phase %q; input = %v", m[1], s)
+	}
+	a := Assertion{Phase: m[1], Stat: m[2], Inclusive: m[3] == "<="}
+	if m[2][0] == 'p' {
+		pct, err := strconv.ParseFloat(m[2][1:], 64)
+		if err != nil || pct <= 0 || pct > 100 {
+			return Assertion{}, fmt.Errorf("percentile must be above 0 and at most 100; input = %v", s)
+		}
+		a.Pct = pct
+	}
+	limit, err := time.ParseDuration(m[4])
+	if err != nil {
+		return Assertion{}, err
+	}
+	a.Limit = limit
+	return a, nil
+}
+
+func (a Assertion) String() string {
+	op := "<"
+	if a.Inclusive {
+		op = "<="
+	}
+	return fmt.Sprintf("%s_%s%s%v", a.Phase, a.Stat, op, a.Limit)
+}
+
+// AssertionResult is the outcome of an assertion on a run.
+type AssertionResult struct {
+	Assertion string
+
+	// Value is the statistic the assertion was made on, in seconds, over
+	// Samples latencies. An assertion without samples fails, e.g. on TLS
+	// handshakes of a run over plain HTTP.
+	Value   float64
+	Samples int
+	Passed  bool
+}
+
+// Check evaluates a against the latencies of rep.
+func (a Assertion) Check(rep *Report) AssertionResult {
+	phase := assertPhases[a.Phase]
+	var lats []float64
+	for _, l := range phase.lats(rep) {
+		if phase.conn && l == 0 {
+			// The request reused a connection.
+			continue
+		}
+		lats = append(lats, l)
+	}
+	res := AssertionResult{Assertion: a.String(), Samples: len(lats)}
+	if len(lats) == 0 {
+		return res
+	}
+	sort.Float64s(lats)
+	switch a.Stat {
+	case "avg":
+		var sum float64
+		for _, l := range lats {
+			sum += l
+		}
+		res.Value = sum / float64(len(lats))
+	case "max":
+		res.Value = lats[len(lats)-1]
+	default:
+		res.Value = quantile(lats, a.Pct)
+	}
+	limit := a.Limit.Seconds()
+	res.Passed = res.Value < limit || a.Inclusive && res.Value == limit
+	return res
+}
+
+// checkAssertions evaluates assertions against rep, nil if there are none.
+func checkAssertions(assertions []Assertion, rep *Report) []AssertionResult {
+	var results []AssertionResult
+	for _, a := range assertions {
+		results = append(results, a.Check(rep))
+	}
+	return results
+}
+
+// FailedAssertions returns the results of Work.Assertions that failed,
+// once the run finished.
+func (b *Work) FailedAssertions() []AssertionResult {
+	if b.report == nil {
+		return nil
+	}
+	var failed []AssertionResult
+	for _, res := range b.report.asserted {
+		if !res.Passed {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
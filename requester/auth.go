@@ -0,0 +1,23 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+// SetAuthorization sets the Authorization header of the requests sent
+// from now on, replacing the one of Request, such as to swap in a
+// refreshed token during a long run. It is safe to call while the run
+// proceeds.
+func (b *Work) SetAuthorization(v string) {
+	b.auth.Store(&v)
+}
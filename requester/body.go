@@ -0,0 +1,61 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"math/rand"
+	"sync/atomic"
+)
+
+// Body is a request body of Work.Bodies.
+type Body struct {
+	// Name identifies the body in the results, such as its file name.
+	Name string
+	Data []byte
+
+	// ContentType, if set, is sent as the Content-Type header of
+	// requests with this body.
+	ContentType string
+}
+
+// compressibleText is repeated to fill compressible random bodies.
+const compressibleText = "The quick brown fox jumps over the lazy dog. "
+
+// randomBody returns a body of a random size between RandomBodyMin and
+// RandomBodyMax bytes, of random bytes or of repeated text if
+// RandomBodyText is set.
+func (b *Work) randomBody(rnd *rand.Rand) []byte {
+	data := make([]byte, b.RandomBodyMin+rnd.Int63n(b.RandomBodyMax-b.RandomBodyMin+1))
+	if b.RandomBodyText {
+		// Start at a random offset so that bodies differ.
+		off := rnd.Intn(len(compressibleText))
+		for i := range data {
+			data[i] = compressibleText[(off+i)%len(compressibleText)]
+		}
+	} else {
+		rnd.Read(data)
+	}
+	return data
+}
+
+// nextBody returns the body of the next request, the next of Bodies in
+// turn or a random one.
+func (b *Work) nextBody(rnd *rand.Rand) *Body {
+	if b.RandomBodies {
+		return &b.Bodies[rnd.Intn(len(b.Bodies))]
+	}
+	i := atomic.AddUint64(&b.bodyNext, 1) - 1
+	return &b.Bodies[i%uint64(len(b.Bodies))]
+}
@@ -0,0 +1,106 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// capture records a request and its response in full, to be written to
+// a file of Work.CaptureDir.
+type capture struct {
+	reqBody  bytes.Buffer
+	respBody bytes.Buffer
+}
+
+// newCapture makes the body of req be recorded as it is sent.
+func newCapture(req *http.Request) *capture {
+	c := &capture{}
+	if req.Body != nil {
+		req.Body = &teeBody{ReadCloser: req.Body, w: &c.reqBody}
+	}
+	return c
+}
+
+// captureResponse makes the body of resp be recorded as it is read.
+func (c *capture) captureResponse(resp *http.Response) {
+	resp.Body = &teeBody{ReadCloser: resp.Body, w: &c.respBody}
+}
+
+type teeBody struct {
+	io.ReadCloser
+	w io.Writer
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	t.w.Write(p[:n])
+	return n, err
+}
+
+// writeCapture writes the request, sent offset into the run, and its response
+// or error to the next file of CaptureDir, and returns the name of the
+// file, or "" if it could not be written.
+func (b *Work) writeCapture(c *capture, offset time.Duration, req *http.Request, resp *http.Response, err error) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s %s\n", req.Method, req.URL, req.Proto)
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	fmt.Fprintf(&buf, "Host: %s\n", host)
+	writeHeader(&buf, req.Header)
+	buf.WriteString("\n")
+	buf.Write(c.reqBody.Bytes())
+	fmt.Fprintf(&buf, "\n\n--- at %4.4f secs\n\n", offset.Seconds())
+	if err != nil {
+		fmt.Fprintf(&buf, "error: %v\n", err)
+	} else {
+		fmt.Fprintf(&buf, "%s %s\n", resp.Proto, resp.Status)
+		writeHeader(&buf, resp.Header)
+		buf.WriteString("\n")
+		buf.Write(c.respBody.Bytes())
+		if len(resp.Trailer) > 0 {
+			buf.WriteString("\n\n")
+			writeHeader(&buf, resp.Trailer)
+		}
+	}
+	name := fmt.Sprintf("%06d.http", atomic.AddInt64(&b.captured, 1))
+	if os.WriteFile(filepath.Join(b.CaptureDir, name), buf.Bytes(), 0644) != nil {
+		return ""
+	}
+	return name
+}
+
+func writeHeader(w io.Writer, h http.Header) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			fmt.Fprintf(w, "%s: %s\n", k, v)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
+// DefaultCertExpiryWarning is how close to expiry a server certificate
+// must be before the report warns about it.
+const DefaultCertExpiryWarning = 14 * 24 * time.Hour
+
+// CertificateInfo describes a certificate presented by the server.
+type CertificateInfo struct {
+	Subject   string
+	Issuer    string
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+func certificateInfos(certs []*x509.Certificate) []CertificateInfo {
+	infos := make([]CertificateInfo, len(certs))
+	for i, c := range certs {
+		infos[i] = CertificateInfo{
+			Subject:   c.Subject.String(),
+			Issuer:    c.Issuer.String(),
+			NotBefore: c.NotBefore,
+			NotAfter:  c.NotAfter,
+		}
+	}
+	return infos
+}
+
+// certExpiryWarning returns a warning for the certificate in the chain
+// that expires first, if it expires within d of now. It returns an empty
+// string otherwise.
+func certExpiryWarning(certs []CertificateInfo, d time.Duration, now time.Time) string {
+	if len(certs) == 0 {
+		return ""
+	}
+	first := certs[0]
+	for _, c := range certs[1:] {
+		if c.NotAfter.Before(first.NotAfter) {
+			first = c
+		}
+	}
+	left := first.NotAfter.Sub(now)
+	switch {
+	case left <= 0:
+		return fmt.Sprintf("certificate %q expired %v ago", first.Subject, (-left).Round(time.Second))
+	case left < d:
+		return fmt.Sprintf("certificate %q expires in %v", first.Subject, left.Round(time.Second))
+	}
+	return ""
+}
@@ -0,0 +1,35 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import "math/rand"
+
+// Phases in which a chaos abort closes the connection.
+const (
+	abortRequest  = "request"  // after the request headers are written
+	abortResponse = "response" // after the first response byte is read
+)
+
+// chaosAbort decides whether the next request should be aborted. It
+// returns the phase to abort in, or an empty string.
+func (b *Work) chaosAbort(rnd *rand.Rand) string {
+	if b.ChaosAbortRate <= 0 || rnd.Float64() >= b.ChaosAbortRate {
+		return ""
+	}
+	if rnd.Intn(2) == 0 {
+		return abortRequest
+	}
+	return abortResponse
+}
@@ -0,0 +1,127 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"math"
+	"time"
+)
+
+// minDriftSpan is how far apart the first and last Date headers must be
+// for the drift of the clock skew to be reported. Date headers are in
+// whole seconds, so the skew of each is only known to a second.
+const minDriftSpan = time.Minute
+
+// ClockSkewReport describes how far the clock of the server was from the
+// local clock, by the Date headers of its responses, to line requests up
+// with server logs. Skews are the server clock minus the local clock, in
+// seconds, positive if the server is ahead.
+type ClockSkewReport struct {
+	// Samples counts the responses with a Date header.
+	Samples int64
+
+	// Skew is the average skew, taking every Date header to be sent
+	// halfway through its second, and halfway between the request and
+	// the response.
+	Skew float64
+
+	// Low and High bound the skew, as the Date headers were sent while
+	// requests were in flight. If the skew drifted by more than the
+	// resolution of Date headers, Low is above High.
+	Low  float64
+	High float64
+
+	// Drift is how much the skew changed over the run, in seconds per
+	// hour, from a linear fit; 0 if the Date headers span less than a
+	// minute.
+	Drift float64
+}
+
+// Consistent reports whether a single skew fits all Date headers.
+func (c *ClockSkewReport) Consistent() bool {
+	return c.Low <= c.High
+}
+
+type clockStats struct {
+	n      int64
+	lo, hi float64
+	// Sums of the offsets and skews of the samples, for their average
+	// and a linear fit.
+	sx, sy, sxx, sxy float64
+	first, last      float64
+}
+
+func (c *clockStats) add(res *result) {
+	if res.date == 0 || res.err != nil {
+		return
+	}
+	date := float64(res.date)
+	sent := float64(res.sent.UnixNano()) / 1e9
+	received := sent + res.final.Seconds()
+	// The Date header was sent from date to date+1, and while the
+	// request was in flight.
+	lo, hi := date-received, date+1-sent
+	skew := (lo + hi) / 2
+	x := res.offset.Seconds()
+	if c.n == 0 {
+		c.lo, c.hi = lo, hi
+		c.first, c.last = x, x
+	}
+	c.n++
+	c.lo, c.hi = math.Max(c.lo, lo), math.Min(c.hi, hi)
+	c.first, c.last = math.Min(c.first, x), math.Max(c.last, x)
+	c.sx += x
+	c.sy += skew
+	c.sxx += x * x
+	c.sxy += x * skew
+}
+
+// snapshot returns the report of the skews, or nil if no response had a
+// Date header.
+func (c *clockStats) snapshot() *ClockSkewReport {
+	if c.n == 0 {
+		return nil
+	}
+	n := float64(c.n)
+	rep := &ClockSkewReport{Samples: c.n, Skew: c.sy / n, Low: c.lo, High: c.hi}
+	if c.last-c.first >= minDriftSpan.Seconds() {
+		if d := n*c.sxx - c.sx*c.sx; d > 0 {
+			rep.Drift = (n*c.sxy - c.sx*c.sy) / d * time.Hour.Seconds()
+		}
+	}
+	return rep
+}
+
+// mergeClockSkew adds the skews of b to a. Runs on different machines
+// have clocks of their own, so the merged skew is only meaningful if
+// their clocks were synchronized.
+func mergeClockSkew(a, b *ClockSkewReport) *ClockSkewReport {
+	if b == nil {
+		return a
+	}
+	if a == nil {
+		c := *b
+		return &c
+	}
+	n := float64(a.Samples + b.Samples)
+	weigh := func(x, y float64) float64 {
+		return (x*float64(a.Samples) + y*float64(b.Samples)) / n
+	}
+	a.Skew = weigh(a.Skew, b.Skew)
+	a.Drift = weigh(a.Drift, b.Drift)
+	a.Low, a.High = math.Max(a.Low, b.Low), math.Min(a.High, b.High)
+	a.Samples += b.Samples
+	return a
+}
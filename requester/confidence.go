@@ -0,0 +1,63 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import "math"
+
+// confidenceZ is the standard score of the 95% confidence intervals of
+// percentiles.
+const confidenceZ = 1.96
+
+// percentileRanks returns the 0-based ranks of the sorted samples that
+// bound the 95% confidence interval of the pct percentile of n samples.
+// The number of samples below the true percentile is binomial, which
+// bounds the interval without assuming how latencies are distributed.
+// tooFew is set if the upper bound is beyond the slowest sample, i.e.
+// too few samples are slower than the percentile to tell it from noise.
+func percentileRanks(n int, pct float64) (lo, hi int, tooFew bool) {
+	p := pct / 100
+	mean := float64(n) * p
+	spread := confidenceZ * math.Sqrt(mean*(1-p))
+	// The bounds are 1-based ranks.
+	lo = int(math.Floor(mean - spread))
+	hi = int(math.Ceil(mean + spread))
+	if hi > n {
+		hi, tooFew = n, true
+	}
+	return max(lo, 1) - 1, max(hi, 1) - 1, tooFew
+}
+
+// withConfidence sets the confidence interval of d, a percentile of n
+// samples whose value at a 0-based rank is returned by at.
+func withConfidence(d LatencyDistribution, n int, at func(rank int) float64) LatencyDistribution {
+	if n == 0 {
+		return d
+	}
+	lo, hi, tooFew := percentileRanks(n, d.Percentage)
+	d.Low, d.High, d.TooFewSamples = at(lo), at(hi), tooFew
+	return d
+}
+
+// tooFewSamples returns the percentiles of dist too few samples were
+// slower than to bound them.
+func tooFewSamples(dist []LatencyDistribution) []float64 {
+	var pcts []float64
+	for _, d := range dist {
+		if d.Percentage > 0 && d.TooFewSamples {
+			pcts = append(pcts, d.Percentage)
+		}
+	}
+	return pcts
+}
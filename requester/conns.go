@@ -0,0 +1,248 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reasons connections were closed for, see ConnRecord.
+const (
+	// ConnClosedByServer is for connections the server closed.
+	ConnClosedByServer = "server"
+	// ConnClosedByClient is for connections closed by the transport, e.g.
+	// after a single request with DisableKeepAlives or on a timeout.
+	ConnClosedByClient = "client"
+	// ConnFailed is for connections closed after reading or writing them
+	// failed.
+	ConnFailed = "error"
+	// ConnOpen is for connections still open at the end of the run.
+	ConnOpen = "open"
+)
+
+// ConnRecord is the raw record of a connection, written along with the
+// records of requests to the raw results once it is closed, or at the
+// end of the run if it is still open. The Record holding it gives the
+// time it was established. Durations are in seconds.
+type ConnRecord struct {
+	// ID identifies the connection, from 1, in the ConnID of the records
+	// of the requests it served.
+	ID   int64  `json:"id"`
+	Addr string `json:"addr"`
+
+	// Dial is the time taken to connect, and TLS that taken by the TLS
+	// handshake, if any.
+	Dial float64 `json:"dial"`
+	TLS  float64 `json:"tls,omitempty"`
+
+	// Lifetime is how long the connection was open.
+	Lifetime float64 `json:"lifetime"`
+
+	// Requests counts the requests the connection served, and
+	// BytesRead and BytesWritten the bytes it carried, including those
+	// of TLS records and HTTP framing.
+	Requests     int64 `json:"requests"`
+	BytesRead    int64 `json:"bytes_read"`
+	BytesWritten int64 `json:"bytes_written"`
+
+	// CloseReason is why the connection was closed, ConnClosedByServer,
+	// ConnClosedByClient, ConnFailed or ConnOpen, and Error the error
+	// reading or writing it with ConnFailed.
+	CloseReason string `json:"close_reason"`
+	Error       string `json:"error,omitempty"`
+}
+
+// connTracker follows the connections of a run, sending the record of
+// each to the results once it is closed.
+type connTracker struct {
+	results chan<- *result
+
+	mu     sync.Mutex
+	nextID int64
+	open   map[*trackedConn]bool
+	done   bool // whether the results no longer take records
+}
+
+func newConnTracker(results chan<- *result) *connTracker {
+	return &connTracker{results: results, open: make(map[*trackedConn]bool)}
+}
+
+// track returns c, which took dial to connect, wrapped to be followed.
+func (t *connTracker) track(c net.Conn, dial time.Duration) net.Conn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nextID++
+	tc := &trackedConn{
+		Conn:        c,
+		t:           t,
+		id:          t.nextID,
+		established: now(),
+		at:          time.Now(),
+		dial:        dial,
+	}
+	t.open[tc] = true
+	return tc
+}
+
+// use counts a request served by conn, whose TLS handshake took tls if
+// it is new, and returns the ID of the connection, or 0 if it is not
+// tracked.
+func (t *connTracker) use(conn net.Conn, isNew bool, tls time.Duration) int64 {
+	c := trackedConnOf(conn)
+	if c == nil {
+		return 0
+	}
+	atomic.AddInt64(&c.requests, 1)
+	if isNew {
+		c.mu.Lock()
+		c.tls = tls
+		c.mu.Unlock()
+	}
+	return c.id
+}
+
+// closed sends the record of c, closed at end for reason.
+func (t *connTracker) closed(c *trackedConn, end time.Duration, reason string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return
+	}
+	delete(t.open, c)
+	t.results <- c.result(end, reason, err)
+}
+
+// finish sends the records of the connections still open, after which
+// no more records are sent.
+func (t *connTracker) finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	end := now()
+	for c := range t.open {
+		t.results <- c.result(end, ConnOpen, nil)
+	}
+	t.open = nil
+	t.done = true
+}
+
+// trackedConn is a connection counting the bytes it carries, and the
+// first error reading or writing it, which tells why it was closed.
+type trackedConn struct {
+	net.Conn
+	t           *connTracker
+	id          int64
+	established time.Duration
+	at          time.Time // wall clock time it was established
+	dial        time.Duration
+
+	read     int64 // accessed atomically
+	written  int64 // accessed atomically
+	requests int64 // accessed atomically
+
+	mu     sync.Mutex
+	tls    time.Duration
+	err    error // first error reading or writing
+	closed bool
+}
+
+// trackedConnOf returns the tracked connection under c, e.g. a TLS
+// connection, or nil if there is none.
+func trackedConnOf(c net.Conn) *trackedConn {
+	for {
+		switch cc := c.(type) {
+		case *trackedConn:
+			return cc
+		case interface{ NetConn() net.Conn }:
+			c = cc.NetConn()
+		default:
+			return nil
+		}
+	}
+}
+
+func (c *trackedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(&c.read, int64(n))
+	if err != nil {
+		c.fail(err)
+	}
+	return n, err
+}
+
+func (c *trackedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(&c.written, int64(n))
+	if err != nil {
+		c.fail(err)
+	}
+	return n, err
+}
+
+func (c *trackedConn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Errors after Close come from closing it.
+	if !c.closed && c.err == nil {
+		c.err = err
+	}
+}
+
+func (c *trackedConn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return c.Conn.Close()
+	}
+	c.closed = true
+	err := c.err
+	c.mu.Unlock()
+
+	reason := ConnClosedByClient
+	switch {
+	case err == io.EOF:
+		reason, err = ConnClosedByServer, nil
+	case err != nil:
+		reason = ConnFailed
+	}
+	c.t.closed(c, now(), reason, err)
+	return c.Conn.Close()
+}
+
+// result returns the result holding the record of c, closed at end for
+// reason.
+func (c *trackedConn) result(end time.Duration, reason string, err error) *result {
+	c.mu.Lock()
+	tls := c.tls
+	c.mu.Unlock()
+	rec := &ConnRecord{
+		ID:           c.id,
+		Addr:         c.RemoteAddr().String(),
+		Dial:         c.dial.Seconds(),
+		TLS:          tls.Seconds(),
+		Lifetime:     (end - c.established).Seconds(),
+		Requests:     atomic.LoadInt64(&c.requests),
+		BytesRead:    atomic.LoadInt64(&c.read),
+		BytesWritten: atomic.LoadInt64(&c.written),
+		CloseReason:  reason,
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	return &result{offset: c.established, sent: c.at, conn: rec}
+}
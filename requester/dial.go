@@ -0,0 +1,209 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// IP families connections may be restricted to, see Work.IPFamily.
+const (
+	IPv4 = "IPv4"
+	IPv6 = "IPv6"
+)
+
+// dialContext returns the dial function of the transports, which races
+// IP families after FallbackDelay and dials only IPFamily if set, and
+// resolves hosts with Resolvers in turn if set. Failed dials are retried
+// in the DialGrace period. The connections dialed are tracked if the run
+// records them.
+func (b *Work) dialContext() func(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := &net.Dialer{FallbackDelay: b.FallbackDelay}
+	resolvers := b.resolverDialers()
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		d := d
+		if len(resolvers) > 0 {
+			i := int((atomic.AddUint64(&b.dnsNext, 1) - 1) % uint64(len(resolvers)))
+			d = resolvers[i]
+			if choice, ok := ctx.Value(resolverKey{}).(*resolverChoice); ok {
+				choice.set(b.Resolvers[i])
+			}
+		}
+		switch b.IPFamily {
+		case IPv4:
+			network = "tcp4"
+		case IPv6:
+			network = "tcp6"
+		}
+		s := now()
+		c, err := d.DialContext(ctx, network, addr)
+		if err != nil && b.DialGrace > 0 {
+			c, err = b.retryDial(ctx, d, network, addr, err)
+		}
+		if err != nil || b.conns == nil {
+			return c, err
+		}
+		return b.conns.track(c, now()-s), nil
+	}
+}
+
+// ipFamily returns the family of the IP address of a host:port address,
+// IPv4 or IPv6, or "" if it has none.
+func ipFamily(addr string) string {
+	ap, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return ""
+	}
+	if ap.Addr().Unmap().Is4() {
+		return IPv4
+	}
+	return IPv6
+}
+
+// dialTrace records the families of the addresses dialed for a new
+// connection. The dialer races families on separate goroutines, so it is
+// safe for concurrent use.
+type dialTrace struct {
+	mu       sync.Mutex
+	primary  string // family of the first address dialed
+	fallback string // other family dialed after it, if any
+}
+
+func (d *dialTrace) start(addr string) {
+	family := ipFamily(addr)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.primary == "" {
+		d.primary = family
+	} else if family != d.primary && family != "" {
+		d.fallback = family
+	}
+}
+
+func (d *dialTrace) fellBack() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.fallback
+}
+
+// DialReport describes the IP families of the connections opened, and how
+// often dials fell back from one family to the other, as they do from
+// IPv6 to IPv4 when IPv6 is slow or broken.
+type DialReport struct {
+	// Families counts the connections opened by IP family, IPv4 or IPv6.
+	Families map[string]int64
+
+	// Fallbacks counts the connections whose dial started the fallback to
+	// the other family, of which FallbackConnections were then connected
+	// over it. Dials of the other connections connected to the family
+	// tried first.
+	Fallbacks           int64
+	FallbackConnections int64
+
+	// ConnTime and FallbackConnTime are the average connection setup
+	// times, in seconds, of connections opened without and with a
+	// fallback.
+	ConnTime         float64
+	FallbackConnTime float64
+}
+
+// direct returns the number of connections opened without a fallback.
+func (r *DialReport) direct() int64 {
+	n := -r.Fallbacks
+	for _, count := range r.Families {
+		n += count
+	}
+	return n
+}
+
+type dialStats struct {
+	families            map[string]int64
+	fallbacks           int64
+	fallbackConnections int64
+	conns               time.Duration
+	fallbackConns       time.Duration
+}
+
+func (d *dialStats) add(res *result) {
+	if !res.newConn || res.family == "" {
+		return
+	}
+	if d.families == nil {
+		d.families = make(map[string]int64)
+	}
+	d.families[res.family]++
+	if res.fallback == "" {
+		d.conns += res.connDuration
+		return
+	}
+	d.fallbacks++
+	if res.fallback == res.family {
+		d.fallbackConnections++
+	}
+	d.fallbackConns += res.connDuration
+}
+
+// snapshot returns the report of the dials, or nil if they were all over
+// IPv4 without a fallback, where there is nothing to tell.
+func (d *dialStats) snapshot() *DialReport {
+	if d.families[IPv6] == 0 && d.fallbacks == 0 {
+		return nil
+	}
+	rep := &DialReport{
+		Families:            make(map[string]int64, len(d.families)),
+		Fallbacks:           d.fallbacks,
+		FallbackConnections: d.fallbackConnections,
+	}
+	for family, n := range d.families {
+		rep.Families[family] = n
+	}
+	if n := rep.direct(); n > 0 {
+		rep.ConnTime = d.conns.Seconds() / float64(n)
+	}
+	if d.fallbacks > 0 {
+		rep.FallbackConnTime = d.fallbackConns.Seconds() / float64(d.fallbacks)
+	}
+	return rep
+}
+
+// mergeDials adds the dials of b to a.
+func mergeDials(a, b *DialReport) *DialReport {
+	if b == nil {
+		return a
+	}
+	if a == nil {
+		a = &DialReport{Families: make(map[string]int64)}
+	}
+	// Weigh the average connection times by the connections they are of.
+	conns := a.ConnTime*float64(a.direct()) + b.ConnTime*float64(b.direct())
+	fallbackConns := a.FallbackConnTime*float64(a.Fallbacks) + b.FallbackConnTime*float64(b.Fallbacks)
+	for family, n := range b.Families {
+		a.Families[family] += n
+	}
+	a.Fallbacks += b.Fallbacks
+	a.FallbackConnections += b.FallbackConnections
+	if n := a.direct(); n > 0 {
+		a.ConnTime = conns / float64(n)
+	}
+	if a.Fallbacks > 0 {
+		a.FallbackConnTime = fallbackConns / float64(a.Fallbacks)
+	}
+	return a
+}
@@ -0,0 +1,122 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Bounds of the backoff between retries of failed dials, see
+// Work.DialGrace.
+const (
+	dialRetryMin = 10 * time.Millisecond
+	dialRetryMax = time.Second
+)
+
+// DialRetryReport describes the dials retried in the grace period of
+// Work.DialGrace.
+type DialRetryReport struct {
+	Grace time.Duration
+
+	// Retries counts the dials made again after failing. Recovered dials
+	// connected after one or more retries, Failed ones did not by the end
+	// of the grace period or of their request.
+	Retries   int64
+	Recovered int64
+	Failed    int64
+}
+
+// dialRetries counts the retries of failed dials.
+type dialRetries struct {
+	retries   int64
+	recovered int64
+	failed    int64
+}
+
+// retryDial retries the dial of addr that failed with err, with jittered
+// exponential backoff, as long as the grace period of the run and ctx
+// allow.
+func (b *Work) retryDial(ctx context.Context, d *net.Dialer, network, addr string, err error) (net.Conn, error) {
+	backoff := dialRetryMin
+	retried := false
+	for {
+		left := b.start + b.DialGrace - now()
+		if left <= 0 {
+			break
+		}
+		// Full jitter over the upper half of the backoff, so that the
+		// connections dropped together do not retry together.
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		if wait > left {
+			wait = left
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			if retried {
+				atomic.AddInt64(&b.dialRetries.failed, 1)
+			}
+			return nil, err
+		}
+		retried = true
+		atomic.AddInt64(&b.dialRetries.retries, 1)
+		var c net.Conn
+		if c, err = d.DialContext(ctx, network, addr); err == nil {
+			atomic.AddInt64(&b.dialRetries.recovered, 1)
+			return c, nil
+		}
+		if backoff *= 2; backoff > dialRetryMax {
+			backoff = dialRetryMax
+		}
+	}
+	if retried {
+		atomic.AddInt64(&b.dialRetries.failed, 1)
+	}
+	return nil, err
+}
+
+// snapshot returns the report of the retries, or nil if no dial was
+// retried.
+func (r *dialRetries) snapshot(grace time.Duration) *DialRetryReport {
+	retries := atomic.LoadInt64(&r.retries)
+	if retries == 0 {
+		return nil
+	}
+	return &DialRetryReport{
+		Grace:     grace,
+		Retries:   retries,
+		Recovered: atomic.LoadInt64(&r.recovered),
+		Failed:    atomic.LoadInt64(&r.failed),
+	}
+}
+
+// mergeDialRetries adds the retries of b to a.
+func mergeDialRetries(a, b *DialRetryReport) *DialRetryReport {
+	if b == nil {
+		return a
+	}
+	if a == nil {
+		a = &DialRetryReport{}
+	}
+	a.Grace = max(a.Grace, b.Grace)
+	a.Retries += b.Retries
+	a.Recovered += b.Recovered
+	a.Failed += b.Failed
+	return a
+}
@@ -0,0 +1,175 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SystemResolver names the resolver of the system in DNS reports.
+const SystemResolver = "system"
+
+// LookupReport describes the DNS lookups made through a resolver as
+// connections were opened.
+type LookupReport struct {
+	// Lookups is the number of lookups, of which Failed failed.
+	Lookups int64
+	Failed  int64
+
+	// Average, Fastest and Slowest lookup times of the lookups that
+	// succeeded, in seconds.
+	Average float64
+	Fastest float64
+	Slowest float64
+
+	Distribution []LatencyDistribution
+}
+
+// dnsPercentiles are reported in the lookup distributions, with the
+// tail as DNS is suspected of tail latency.
+var dnsPercentiles = []float64{50, 90, 99, 99.9}
+
+// resolverKey is the context key of the *resolverChoice of a request.
+type resolverKey struct{}
+
+// resolverChoice records the resolver the connection of a request was
+// dialed with. Dials may outlive the request that started them, so it is
+// safe for concurrent use.
+type resolverChoice struct {
+	mu   sync.Mutex
+	name string
+}
+
+func (r *resolverChoice) set(name string) {
+	r.mu.Lock()
+	r.name = name
+	r.mu.Unlock()
+}
+
+func (r *resolverChoice) get() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.name
+}
+
+// resolverDialers returns a dialer for each of Resolvers, which resolves
+// hosts with it.
+func (b *Work) resolverDialers() []*net.Dialer {
+	var dialers []*net.Dialer
+	for _, server := range b.Resolvers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		server := server
+		dialers = append(dialers, &net.Dialer{
+			FallbackDelay: b.FallbackDelay,
+			Resolver: &net.Resolver{
+				PreferGo: true,
+				Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, network, server)
+				},
+			},
+		})
+	}
+	return dialers
+}
+
+// resolverStats collects the lookups made through a resolver.
+type resolverStats struct {
+	failed int64
+	total  time.Duration
+	lats   []float64
+	n      int64 // lookups that succeeded
+}
+
+type dnsStats map[string]*resolverStats
+
+func (d dnsStats) add(res *result) {
+	if res.resolver == "" || res.dnsDuration == 0 && !res.dnsFailed {
+		return
+	}
+	s := d[res.resolver]
+	if s == nil {
+		s = &resolverStats{}
+		d[res.resolver] = s
+	}
+	if res.dnsFailed {
+		s.failed++
+		return
+	}
+	s.n++
+	s.total += res.dnsDuration
+	if len(s.lats) < maxRes {
+		s.lats = append(s.lats, res.dnsDuration.Seconds())
+	}
+}
+
+// snapshot returns the reports of the lookups by resolver, or nil if no
+// lookups were made through chosen resolvers or with Work.FreshDNS.
+func (d dnsStats) snapshot() map[string]*LookupReport {
+	if len(d) == 0 {
+		return nil
+	}
+	reps := make(map[string]*LookupReport, len(d))
+	for resolver, s := range d {
+		rep := &LookupReport{Lookups: s.n + s.failed, Failed: s.failed}
+		if len(s.lats) > 0 {
+			lats := append([]float64(nil), s.lats...)
+			sort.Float64s(lats)
+			rep.Average = s.total.Seconds() / float64(s.n)
+			rep.Fastest, rep.Slowest = lats[0], lats[len(lats)-1]
+			for _, pct := range dnsPercentiles {
+				rep.Distribution = append(rep.Distribution, LatencyDistribution{Percentage: pct, Latency: quantile(lats, pct)})
+			}
+		}
+		reps[resolver] = rep
+	}
+	return reps
+}
+
+// mergeDNS combines the lookups of parallel runs by resolver.
+// Distributions cannot be merged and are dropped.
+func mergeDNS(a, b map[string]*LookupReport) map[string]*LookupReport {
+	if len(b) == 0 {
+		return a
+	}
+	if a == nil {
+		a = make(map[string]*LookupReport, len(b))
+	}
+	for resolver, l := range b {
+		m := a[resolver]
+		if m == nil {
+			a[resolver] = &LookupReport{Lookups: l.Lookups, Failed: l.Failed, Average: l.Average, Fastest: l.Fastest, Slowest: l.Slowest}
+			continue
+		}
+		ok, lOK := m.Lookups-m.Failed, l.Lookups-l.Failed
+		if ok == 0 || lOK > 0 && l.Fastest < m.Fastest {
+			m.Fastest = l.Fastest
+		}
+		m.Slowest = max(m.Slowest, l.Slowest)
+		if ok+lOK > 0 {
+			m.Average = (m.Average*float64(ok) + l.Average*float64(lOK)) / float64(ok+lOK)
+		}
+		m.Lookups += l.Lookups
+		m.Failed += l.Failed
+		m.Distribution = nil
+	}
+	return a
+}
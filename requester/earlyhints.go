@@ -0,0 +1,132 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EarlyHintsReport describes the 103 Early Hints interim responses
+// received ahead of final responses.
+type EarlyHintsReport struct {
+	// Hinted counts the responses preceded by Early Hints, out of Total.
+	Hinted int64
+	Total  int64
+
+	// AverageHint is the time from sending a hinted request to its first
+	// 103 response, and AverageFinal to its final response headers, in
+	// seconds. Their difference is how much earlier hinted resources can
+	// be fetched.
+	AverageHint  float64
+	AverageFinal float64
+
+	// Resources counts the Link header entries of the Early Hints, and
+	// AverageResources is the number per hinted response.
+	Resources        int64
+	AverageResources float64
+
+	HintDistribution  []LatencyDistribution
+	FinalDistribution []LatencyDistribution
+}
+
+// earlyHintPercentiles are reported in the hint and final distributions.
+var earlyHintPercentiles = []float64{50, 90, 99}
+
+// hintedResources counts the resources in the Link headers of an Early
+// Hints response, each of which is enclosed in angle brackets.
+func hintedResources(h textproto.MIMEHeader) int {
+	var n int
+	for _, v := range h["Link"] {
+		n += strings.Count(v, "<")
+	}
+	return n
+}
+
+type earlyHintStats struct {
+	total     int64
+	hinted    int64
+	resources int64
+	hint      time.Duration
+	final     time.Duration
+	hints     []float64
+	finals    []float64
+}
+
+func (e *earlyHintStats) add(res *result) {
+	if res.err != nil {
+		return
+	}
+	e.total++
+	if res.earlyHint == 0 {
+		return
+	}
+	e.hinted++
+	e.resources += int64(res.hintLinks)
+	e.hint += res.earlyHint
+	e.final += res.final
+	if len(e.hints) < maxRes {
+		e.hints = append(e.hints, res.earlyHint.Seconds())
+		e.finals = append(e.finals, res.final.Seconds())
+	}
+}
+
+func (e *earlyHintStats) snapshot() *EarlyHintsReport {
+	if e.hinted == 0 {
+		return nil
+	}
+	rep := &EarlyHintsReport{
+		Hinted:           e.hinted,
+		Total:            e.total,
+		AverageHint:      (e.hint / time.Duration(e.hinted)).Seconds(),
+		AverageFinal:     (e.final / time.Duration(e.hinted)).Seconds(),
+		Resources:        e.resources,
+		AverageResources: float64(e.resources) / float64(e.hinted),
+	}
+	hints := append([]float64(nil), e.hints...)
+	finals := append([]float64(nil), e.finals...)
+	sort.Float64s(hints)
+	sort.Float64s(finals)
+	for _, pct := range earlyHintPercentiles {
+		rep.HintDistribution = append(rep.HintDistribution, LatencyDistribution{Percentage: pct, Latency: quantile(hints, pct)})
+		rep.FinalDistribution = append(rep.FinalDistribution, LatencyDistribution{Percentage: pct, Latency: quantile(finals, pct)})
+	}
+	return rep
+}
+
+// mergeEarlyHints combines the Early Hints of parallel runs. Distributions
+// cannot be merged and are dropped.
+func mergeEarlyHints(a, b *EarlyHintsReport) *EarlyHintsReport {
+	if b == nil {
+		return a
+	}
+	if a == nil {
+		a = &EarlyHintsReport{}
+	}
+	m := &EarlyHintsReport{
+		Hinted:    a.Hinted + b.Hinted,
+		Total:     a.Total + b.Total,
+		Resources: a.Resources + b.Resources,
+	}
+	if m.Hinted > 0 {
+		n := float64(m.Hinted)
+		m.AverageHint = (a.AverageHint*float64(a.Hinted) + b.AverageHint*float64(b.Hinted)) / n
+		m.AverageFinal = (a.AverageFinal*float64(a.Hinted) + b.AverageFinal*float64(b.Hinted)) / n
+		m.AverageResources = float64(m.Resources) / n
+	}
+	return m
+}
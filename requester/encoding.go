@@ -0,0 +1,91 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// encodedBody describes a response body read with a client-controlled
+// Accept-Encoding.
+type encodedBody struct {
+	compressed   int64         // bytes received on the wire
+	decompressed int64         // bytes after decoding, 0 if not decoded
+	decoded      bool          // whether the Content-Encoding was supported
+	duration     time.Duration // time spent decompressing
+}
+
+// decoders are the supported content codings, by name.
+var decoders = map[string]func(io.Reader) (io.Reader, error){
+	"gzip":    func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	"x-gzip":  func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	"deflate": func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) },
+	"br":      func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+}
+
+// readEncodedBody reads the whole response body and decompresses it if all
+// of its content codings are supported (gzip, deflate or br). Stacked
+// codings, e.g. "gzip, br", are decoded in the reverse of the order they
+// were applied in. Bodies with other codings are counted as received but
+// are not decoded.
+func readEncodedBody(resp *http.Response) (encodedBody, error) {
+	var eb encodedBody
+	raw, err := ioutil.ReadAll(resp.Body)
+	eb.compressed = int64(len(raw))
+	if err != nil {
+		return eb, err
+	}
+
+	var codings []func(io.Reader) (io.Reader, error)
+	for _, v := range resp.Header.Values("Content-Encoding") {
+		for _, coding := range strings.Split(v, ",") {
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding == "" || coding == "identity" {
+				continue
+			}
+			newReader, ok := decoders[coding]
+			if !ok {
+				return eb, nil
+			}
+			codings = append(codings, newReader)
+		}
+	}
+	if len(codings) == 0 {
+		eb.decompressed = eb.compressed
+		eb.decoded = true
+		return eb, nil
+	}
+
+	s := now()
+	var zr io.Reader = bytes.NewReader(raw)
+	for i := len(codings) - 1; i >= 0 && err == nil; i-- {
+		zr, err = codings[i](zr)
+	}
+	if err == nil {
+		eb.decompressed, err = io.Copy(ioutil.Discard, zr)
+	}
+	eb.duration = now() - s
+	eb.decoded = err == nil
+	return eb, err
+}
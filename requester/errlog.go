@@ -0,0 +1,104 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultErrorLogLimit is the number of error responses logged if
+// Work.ErrorLogLimit is not set.
+const DefaultErrorLogLimit = 100
+
+// maxErrorBody is the number of body bytes logged per error response.
+const maxErrorBody = 4096
+
+// errorLog writes the status, headers and beginning of the body of the
+// first error responses of a run.
+type errorLog struct {
+	w     io.Writer
+	limit int64
+	n     int64 // responses claimed so far
+
+	mu sync.Mutex
+}
+
+// claim reports whether another error response should be logged.
+func (l *errorLog) claim() bool {
+	return atomic.AddInt64(&l.n, 1) <= l.limit
+}
+
+// captureBody wraps the body of resp so that its first maxErrorBody bytes
+// are kept as they are read. With Work.AcceptEncoding set, these are the
+// bytes as received, before decoding.
+func captureBody(resp *http.Response) *capturingBody {
+	c := &capturingBody{ReadCloser: resp.Body}
+	resp.Body = c
+	return c
+}
+
+type capturingBody struct {
+	io.ReadCloser
+	buf  bytes.Buffer
+	read int64
+}
+
+func (c *capturingBody) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if room := maxErrorBody - c.buf.Len(); room > 0 {
+		c.buf.Write(p[:min(n, room)])
+	}
+	c.read += int64(n)
+	return n, err
+}
+
+// write logs an error response to req received offset into the run.
+func (l *errorLog) write(offset time.Duration, req *http.Request, resp *http.Response, body *capturingBody) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s %s at %4.4f secs\n", req.Method, req.URL, offset.Seconds())
+	fmt.Fprintf(&b, "%s %s\n", resp.Proto, resp.Status)
+	keys := make([]string, 0, len(resp.Header))
+	for k := range resp.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range resp.Header[k] {
+			fmt.Fprintf(&b, "%s: %s\n", k, v)
+		}
+	}
+	b.WriteString("\n")
+	shown := body.buf.Bytes()
+	b.Write(shown)
+	if len(shown) > 0 && shown[len(shown)-1] != '\n' {
+		b.WriteString("\n")
+	}
+	if body.read > int64(len(shown)) {
+		fmt.Fprintf(&b, "[truncated, %d of %d bytes shown]\n", len(shown), body.read)
+	}
+	b.WriteString("\n")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.w, b.String())
+}
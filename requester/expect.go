@@ -0,0 +1,75 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+// ExpectReport summarizes how requests matched the status codes of
+// Work.ExpectStatus, which count as successes.
+type ExpectReport struct {
+	Codes      []int
+	Expected   int
+	Unexpected int
+
+	// UnexpectedDist counts the unexpected outcomes by status code, 0
+	// for requests that failed without a response.
+	UnexpectedDist map[int]int
+}
+
+func newExpectReport(codes []int) *ExpectReport {
+	return &ExpectReport{Codes: codes, UnexpectedDist: make(map[int]int)}
+}
+
+func (e *ExpectReport) add(res *result) {
+	if e == nil {
+		return
+	}
+	if res.err == nil && res.aborted == "" {
+		for _, code := range e.Codes {
+			if res.statusCode == code {
+				e.Expected++
+				return
+			}
+		}
+	}
+	e.Unexpected++
+	code := res.statusCode
+	if res.err != nil || res.aborted != "" {
+		code = 0
+	}
+	e.UnexpectedDist[code]++
+}
+
+// SuccessRate returns the fraction of requests with an expected outcome.
+func (e *ExpectReport) SuccessRate() float64 {
+	if total := e.Expected + e.Unexpected; total > 0 {
+		return float64(e.Expected) / float64(total)
+	}
+	return 0
+}
+
+// mergeExpect adds the outcomes of b to a.
+func mergeExpect(a, b *ExpectReport) *ExpectReport {
+	if b == nil {
+		return a
+	}
+	if a == nil {
+		a = newExpectReport(b.Codes)
+	}
+	a.Expected += b.Expected
+	a.Unexpected += b.Unexpected
+	for code, n := range b.UnexpectedDist {
+		a.UnexpectedDist[code] += n
+	}
+	return a
+}
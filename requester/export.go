@@ -0,0 +1,164 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// hdrTicksPerHalfDistance is how many percentiles are listed in the
+// HdrHistogram format between a percentile and the one halfway to 100,
+// as HdrHistogram's outputPercentileDistribution does by default.
+const hdrTicksPerHalfDistance = 5
+
+// writeHDR writes the latencies of rep as an HdrHistogram percentile
+// distribution, in milliseconds, which HdrHistogram tools and plotters
+// read.
+func writeHDR(w io.Writer, rep Report) error {
+	lats := append([]float64(nil), rep.Lats...)
+	sort.Float64s(lats)
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%12s %14s %10s %14s\n\n", "Value", "Percentile", "TotalCount", "1/(1-Percentile)")
+	n := len(lats)
+	var sum, sumSq float64
+	for _, l := range lats {
+		sum += l * 1000
+		sumSq += l * 1000 * l * 1000
+	}
+	if n > 0 {
+		for p := 0.0; p < 100; p = nextHDRPercentile(p) {
+			count := int(math.Ceil(p / 100 * float64(n)))
+			if count == 0 {
+				count = 1
+			}
+			fmt.Fprintf(bw, "%12.3f %2.12f %10d %14.2f\n", lats[count-1]*1000, p/100, count, 100/(100-p))
+			if count == n {
+				break
+			}
+		}
+		fmt.Fprintf(bw, "%12.3f %2.12f %10d\n", lats[n-1]*1000, 1.0, n)
+	}
+	var mean, stddev, max float64
+	if n > 0 {
+		mean = sum / float64(n)
+		stddev = math.Sqrt(math.Max(sumSq/float64(n)-mean*mean, 0))
+		max = lats[n-1] * 1000
+	}
+	fmt.Fprintf(bw, "#[Mean    = %12.3f, StdDeviation   = %12.3f]\n", mean, stddev)
+	fmt.Fprintf(bw, "#[Max     = %12.3f, Total count    = %12d]\n", max, n)
+	return bw.Flush()
+}
+
+// nextHDRPercentile returns the percentile listed after p, halving the
+// step each time the distance to 100 halves.
+func nextHDRPercentile(p float64) float64 {
+	halfDistance := math.Pow(2, math.Floor(math.Log2(100/(100-p)))+1)
+	return p + 100/(halfDistance*hdrTicksPerHalfDistance)
+}
+
+// Summary is the main statistics of a report, as a JSON document for
+// other tools to consume. Latencies are in seconds.
+type Summary struct {
+	Schema      *SchemaInfo        `json:"schema"`
+	Requests    int64              `json:"requests"`
+	Errors      int                `json:"errors"`
+	Duration    float64            `json:"duration"`
+	Rps         float64            `json:"rps"`
+	Average     float64            `json:"average"`
+	Fastest     float64            `json:"fastest"`
+	Slowest     float64            `json:"slowest"`
+	Percentiles map[string]float64 `json:"percentiles"`
+	StatusCodes map[int]int        `json:"status_codes"`
+	ErrorDist   map[string]int     `json:"errors_by_type,omitempty"`
+	SizeTotal   int64              `json:"size_total"`
+
+	// Intervals are the 95% confidence intervals of Percentiles, and
+	// TooFewSamples the percentiles too few requests were slower than to
+	// bound them.
+	Intervals     map[string][2]float64 `json:"percentile_intervals,omitempty"`
+	TooFewSamples []string              `json:"too_few_samples,omitempty"`
+
+	Histogram []SummaryBucket `json:"histogram,omitempty"`
+
+	// Records are the raw results of the requests, with
+	// Work.JSONRecords.
+	Records []Record `json:"records,omitempty"`
+}
+
+// SummaryBucket is a bucket of the latency histogram, counting the
+// requests up to Mark seconds since the previous bucket.
+type SummaryBucket struct {
+	Mark      float64 `json:"mark"`
+	Count     int     `json:"count"`
+	Frequency float64 `json:"frequency"`
+}
+
+// SummaryOf returns the summary of rep.
+func SummaryOf(rep Report) Summary {
+	s := Summary{
+		Schema:      schemaInfo(SummarySchema),
+		Requests:    rep.NumRes,
+		Duration:    rep.Total.Seconds(),
+		Rps:         rep.Rps,
+		Average:     rep.Average,
+		Fastest:     rep.Fastest,
+		Slowest:     rep.Slowest,
+		Percentiles: make(map[string]float64, len(rep.LatencyDistribution)),
+		StatusCodes: rep.StatusCodeDist,
+		ErrorDist:   rep.ErrorDist,
+		SizeTotal:   rep.SizeTotal,
+		Records:     rep.Records,
+	}
+	// The average is NaN without successful requests, which JSON cannot
+	// encode.
+	if math.IsNaN(s.Average) {
+		s.Average = 0
+	}
+	for _, b := range rep.Histogram {
+		s.Histogram = append(s.Histogram, SummaryBucket{Mark: b.Mark, Count: b.Count, Frequency: b.Frequency})
+	}
+	for _, n := range rep.ErrorDist {
+		s.Errors += n
+	}
+	for _, l := range rep.LatencyDistribution {
+		if l.Percentage == 0 {
+			continue
+		}
+		name := "p" + strconv.FormatFloat(l.Percentage, 'f', -1, 64)
+		s.Percentiles[name] = l.Latency
+		if l.High > 0 {
+			if s.Intervals == nil {
+				s.Intervals = make(map[string][2]float64)
+			}
+			s.Intervals[name] = [2]float64{l.Low, l.High}
+		}
+		if l.TooFewSamples {
+			s.TooFewSamples = append(s.TooFewSamples, name)
+		}
+	}
+	return s
+}
+
+func writeSummary(w io.Writer, rep Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(SummaryOf(rep))
+}
@@ -0,0 +1,43 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import "regexp"
+
+// errorPatterns rewrite the parts of error strings that vary between
+// otherwise identical errors, in order, so they can be grouped.
+var errorPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// Quoted request URLs: keep the scheme and host, drop path and query.
+	{regexp.MustCompile(`"([a-z]+://[^/"\s]+)[^"\s]*"`), `"$1"`},
+	// IPv4 and bracketed IPv6 addresses, with an optional port.
+	{regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}(:\d+)?\b`), "<addr>"},
+	{regexp.MustCompile(`\[[0-9a-fA-F:.]+(%\w+)?\](:\d+)?`), "<addr>"},
+	// UUIDs and long hex or numeric strings, such as request IDs.
+	{regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`), "<id>"},
+	{regexp.MustCompile(`\b\d{6,}\b`), "<n>"},
+	{regexp.MustCompile(`\b[0-9a-fA-F]{16,}\b`), "<id>"},
+}
+
+// fingerprintError normalizes an error message so that errors differing
+// only in addresses, ports or request IDs are reported together.
+func fingerprintError(msg string) string {
+	for _, p := range errorPatterns {
+		msg = p.re.ReplaceAllString(msg, p.repl)
+	}
+	return msg
+}
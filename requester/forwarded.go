@@ -0,0 +1,34 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import "net/netip"
+
+// nthAddr returns the n-th address of prefix p, wrapping around once all
+// of its addresses were used.
+func nthAddr(p netip.Prefix, n uint64) netip.Addr {
+	p = p.Masked()
+	if hostBits := p.Addr().BitLen() - p.Bits(); hostBits < 64 {
+		n %= 1 << hostBits
+	}
+	b := p.Addr().AsSlice()
+	for i := len(b) - 1; i >= 0 && n > 0; i-- {
+		sum := uint64(b[i]) + n&0xff
+		b[i] = byte(sum)
+		n = n>>8 + sum>>8
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
@@ -0,0 +1,217 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxGoldenPaths caps the distinct paths divergences are counted by.
+// Further paths are counted as otherGoldenPath.
+const maxGoldenPaths = 10
+
+const otherGoldenPath = "(other)"
+
+// CompileIgnorePaths compiles JSON paths to ignore when comparing
+// responses to a golden body, such as "$.timestamp" or "$.items[*].id".
+// "*" matches any object key, "[*]" any array index. Ignoring a path
+// ignores everything below it.
+func CompileIgnorePaths(paths []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, len(paths))
+	for i, p := range paths {
+		if !strings.HasPrefix(p, "$") {
+			return nil, fmt.Errorf("JSON path %q does not start with $", p)
+		}
+		expr := regexp.QuoteMeta(p)
+		expr = strings.ReplaceAll(expr, `\[\*\]`, `\[\d+\]`)
+		expr = strings.ReplaceAll(expr, `\.\*`, `\.[^.\[]+`)
+		re, err := regexp.Compile("^" + expr + `($|[.\[])`)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = re
+	}
+	return res, nil
+}
+
+// diffGolden compares the JSON body to the Golden one, and returns the
+// first divergence found as "path: detail", or "" if they match.
+func (b *Work) diffGolden(body []byte) string {
+	var got interface{}
+	if err := json.Unmarshal(body, &got); err != nil {
+		return "$: response is not JSON"
+	}
+	return diffJSON("$", b.Golden, got, b.GoldenIgnore)
+}
+
+func diffJSON(path string, want, got interface{}, ignore []*regexp.Regexp) string {
+	if ignored(path, ignore) {
+		return ""
+	}
+	switch w := want.(type) {
+	case map[string]interface{}:
+		g, ok := got.(map[string]interface{})
+		if !ok {
+			return path + ": " + jsonKind(got) + " instead of object"
+		}
+		keys := make([]string, 0, len(w)+len(g))
+		for k := range w {
+			keys = append(keys, k)
+		}
+		for k := range g {
+			if _, ok := w[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p := path + "." + k
+			wv, inWant := w[k]
+			gv, inGot := g[k]
+			switch {
+			case !inGot && !ignored(p, ignore):
+				return p + ": missing"
+			case !inWant && !ignored(p, ignore):
+				return p + ": unexpected"
+			case inGot && inWant:
+				if d := diffJSON(p, wv, gv, ignore); d != "" {
+					return d
+				}
+			}
+		}
+		return ""
+	case []interface{}:
+		g, ok := got.([]interface{})
+		if !ok {
+			return path + ": " + jsonKind(got) + " instead of array"
+		}
+		for i := 0; i < len(w) && i < len(g); i++ {
+			if d := diffJSON(path+"["+strconv.Itoa(i)+"]", w[i], g[i], ignore); d != "" {
+				return d
+			}
+		}
+		if len(w) != len(g) {
+			return fmt.Sprintf("%s: %d elements instead of %d", path, len(g), len(w))
+		}
+		return ""
+	default:
+		if !reflect.DeepEqual(want, got) {
+			return fmt.Sprintf("%s: %s instead of %s", path, jsonValue(got), jsonValue(want))
+		}
+		return ""
+	}
+}
+
+func ignored(path string, ignore []*regexp.Regexp) bool {
+	for _, re := range ignore {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonKind(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
+
+// jsonValue formats a scalar JSON value, abbreviated.
+func jsonValue(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		return jsonKind(v)
+	}
+	s, _ := json.Marshal(v)
+	if len(s) > 40 {
+		return string(s[:37]) + "..."
+	}
+	return string(s)
+}
+
+// GoldenReport summarizes how responses compared to the golden body.
+type GoldenReport struct {
+	Compared int
+	Diverged int
+
+	// Paths counts divergent responses by the path of their first
+	// divergence, with an example of it.
+	Paths map[string]GoldenDivergence
+}
+
+// GoldenDivergence counts responses diverging at a path.
+type GoldenDivergence struct {
+	Count   int
+	Example string
+}
+
+func (g *GoldenReport) add(res *result) {
+	if g == nil || !res.compared {
+		return
+	}
+	g.Compared++
+	if res.diff != "" {
+		path, _, _ := strings.Cut(res.diff, ": ")
+		g.count(path, GoldenDivergence{Count: 1, Example: res.diff})
+	}
+}
+
+func (g *GoldenReport) count(path string, d GoldenDivergence) {
+	if g.Paths == nil {
+		g.Paths = make(map[string]GoldenDivergence)
+	}
+	if _, ok := g.Paths[path]; !ok && len(g.Paths) >= maxGoldenPaths {
+		path = otherGoldenPath
+	}
+	sum := g.Paths[path]
+	sum.Count += d.Count
+	if sum.Example == "" {
+		sum.Example = d.Example
+	}
+	g.Paths[path] = sum
+	g.Diverged += d.Count
+}
+
+// mergeGolden adds the comparisons of b to a.
+func mergeGolden(a, b *GoldenReport) *GoldenReport {
+	if b == nil {
+		return a
+	}
+	if a == nil {
+		a = &GoldenReport{}
+	}
+	a.Compared += b.Compared
+	for path, d := range b.Paths {
+		a.count(path, d)
+	}
+	return a
+}
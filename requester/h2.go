@@ -0,0 +1,261 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// h2PingTimeout is how long a PING frame is waited for to be
+// acknowledged before it counts as failed.
+const h2PingTimeout = 10 * time.Second
+
+// h2Pool is the connection pool of an HTTP/2 transport. It replaces the
+// one http2.ConfigureTransport sets up, which keeps its connections to
+// itself, so that the connections can be pinged and their use tracked.
+type h2Pool struct {
+	t2        *http2.Transport
+	singleUse bool // whether connections take a single request
+	stats     *h2Stats
+
+	mu    sync.Mutex
+	conns map[string][]*h2Conn // by host:port
+}
+
+// h2Conn is an HTTP/2 connection of an h2Pool.
+type h2Conn struct {
+	cc       *http2.ClientConn
+	opened   time.Duration
+	active   int  // requests in flight
+	requests int  // requests taken
+	used     bool // whether it took a request
+	pinging  bool // whether a PING is awaiting its ack
+}
+
+// h2Use is the connection a request was given, to release once its
+// response body is closed.
+type h2Use struct {
+	conn *h2Conn
+}
+
+type h2UseKey struct{}
+
+// configureH2 enables HTTP/2 on tr, as http2.ConfigureTransport does,
+// with connections pooled by the returned h2Pool, whose lifecycle is
+// collected by stats.
+func configureH2(tr *http.Transport, stats *h2Stats) *h2Pool {
+	p := &h2Pool{singleUse: tr.DisableKeepAlives, stats: stats, conns: make(map[string][]*h2Conn)}
+	p.t2 = &http2.Transport{
+		TLSClientConfig:    tr.TLSClientConfig,
+		DisableCompression: tr.DisableCompression,
+		ConnPool:           p,
+	}
+	tr.RegisterProtocol("https", h2NoDial{p})
+	tr.TLSClientConfig.NextProtos = []string{"h2", "http/1.1"}
+	tr.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{
+		"h2": p.upgrade,
+	}
+	return p
+}
+
+// upgrade adds the connection negotiated to HTTP/2 by tr to the pool,
+// unless the pool already has one to the host to use instead.
+func (p *h2Pool) upgrade(authority string, c *tls.Conn) http.RoundTripper {
+	addr := authority
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.singleUse {
+		for _, conn := range p.conns[addr] {
+			if conn.cc.CanTakeNewRequest() {
+				// Another worker dialed the host at the same time.
+				go c.Close()
+				return p
+			}
+		}
+	}
+	cc, err := p.t2.NewClientConn(newH2TapConn(c, p.stats))
+	if err != nil {
+		go c.Close()
+		return h2Error{err}
+	}
+	p.stats.open()
+	p.conns[addr] = append(p.conns[addr], &h2Conn{cc: cc, opened: now()})
+	return p
+}
+
+// GetClientConn returns a connection to addr that can take req.
+func (p *h2Pool) GetClientConn(req *http.Request, addr string) (*http2.ClientConn, error) {
+	use, _ := req.Context().Value(h2UseKey{}).(*h2Use)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conn := range p.conns[addr] {
+		if p.singleUse && conn.used || !conn.cc.CanTakeNewRequest() {
+			continue
+		}
+		conn.used = true
+		conn.requests++
+		if use != nil {
+			// A retried request releases the connection it was given.
+			if use.conn != nil {
+				use.conn.active--
+			}
+			use.conn = conn
+			conn.active++
+		}
+		return conn.cc, nil
+	}
+	return nil, http2.ErrNoCachedConn
+}
+
+// MarkDead removes a connection that was closed from the pool.
+func (p *h2Pool) MarkDead(cc *http2.ClientConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for addr, conns := range p.conns {
+		for i, conn := range conns {
+			if conn.cc != cc {
+				continue
+			}
+			p.stats.close(now()-conn.opened, conn.requests)
+			if conns = append(conns[:i], conns[i+1:]...); len(conns) == 0 {
+				delete(p.conns, addr)
+			} else {
+				p.conns[addr] = conns
+			}
+			return
+		}
+	}
+}
+
+func (p *h2Pool) release(use *h2Use) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if use.conn == nil {
+		return
+	}
+	use.conn.active--
+	if p.singleUse && use.conn.active == 0 {
+		go use.conn.cc.Close()
+	}
+	use.conn = nil
+}
+
+// RoundTrip sends req over a pooled connection, keeping track of it
+// until its response body is closed.
+func (p *h2Pool) RoundTrip(req *http.Request) (*http.Response, error) {
+	use := &h2Use{}
+	req = req.WithContext(context.WithValue(req.Context(), h2UseKey{}, use))
+	resp, err := p.t2.RoundTripOpt(req, http2.RoundTripOpt{OnlyCachedConn: true})
+	if err != nil {
+		p.release(use)
+		return nil, err
+	}
+	resp.Body = &h2Body{ReadCloser: resp.Body, release: func() { p.release(use) }}
+	return resp, nil
+}
+
+// h2NoDial sends requests over pooled connections only, leaving the
+// dialing of new ones to the HTTP/1 transport, which negotiates HTTP/2.
+type h2NoDial struct {
+	p *h2Pool
+}
+
+func (rt h2NoDial) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := rt.p.RoundTrip(req)
+	if err == http2.ErrNoCachedConn {
+		return nil, http.ErrSkipAltProtocol
+	}
+	return resp, err
+}
+
+type h2Error struct {
+	err error
+}
+
+func (rt h2Error) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, rt.err
+}
+
+// h2Body releases the connection of a response once it is closed.
+type h2Body struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *h2Body) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// idle returns the connections without requests in flight or a PING
+// awaiting its ack, marking them as pinging.
+func (p *h2Pool) idle() []*h2Conn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var idle []*h2Conn
+	for _, conns := range p.conns {
+		for _, conn := range conns {
+			if conn.active == 0 && !conn.pinging {
+				conn.pinging = true
+				idle = append(idle, conn)
+			}
+		}
+	}
+	return idle
+}
+
+// ping sends a PING frame on conn and records its round trip time.
+func (p *h2Pool) ping(conn *h2Conn, stats *pingStats) {
+	ctx, cancel := context.WithTimeout(context.Background(), h2PingTimeout)
+	defer cancel()
+	s := now()
+	err := conn.cc.Ping(ctx)
+	stats.add(now()-s, err)
+	p.mu.Lock()
+	conn.pinging = false
+	p.mu.Unlock()
+}
+
+// pingH2 pings the idle HTTP/2 connections of pools every PingInterval,
+// until the returned function is called.
+func (b *Work) pingH2(pools []*h2Pool) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(b.PingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for _, p := range pools {
+					for _, conn := range p.idle() {
+						go p.ping(conn, b.report.pings)
+					}
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
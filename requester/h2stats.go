@@ -0,0 +1,230 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// H2Report describes the lifecycle of HTTP/2 connections: the GOAWAY
+// frames servers sent to retire them, the streams they refused or reset,
+// and how long the connections lasted.
+type H2Report struct {
+	// Opened and Closed count the connections opened and closed during
+	// the run. Connections still open at its end are not closed.
+	Opened int64
+	Closed int64
+
+	// GoAways counts the GOAWAY frames received, and GoAwayCodes them by
+	// error code, e.g. NO_ERROR for graceful shutdowns.
+	GoAways     int64
+	GoAwayCodes map[string]int64
+
+	// RefusedStreams counts the streams reset by servers with
+	// REFUSED_STREAM, which the transport retries on another connection,
+	// and ResetStreams the streams reset with other error codes, by code.
+	RefusedStreams int64
+	ResetStreams   map[string]int64
+
+	// AverageLifetime, ShortestLifetime and LongestLifetime describe how
+	// long the closed connections were open, in seconds, and
+	// AverageRequests how many requests they took on average.
+	AverageLifetime  float64
+	ShortestLifetime float64
+	LongestLifetime  float64
+	AverageRequests  float64
+}
+
+// h2Stats collects the lifecycle of HTTP/2 connections, concurrently
+// with the run.
+type h2Stats struct {
+	mu       sync.Mutex
+	opened   int64
+	closed   int64
+	goAways  map[string]int64
+	refused  int64
+	resets   map[string]int64
+	lifetime time.Duration
+	shortest time.Duration
+	longest  time.Duration
+	requests int64
+}
+
+func (s *h2Stats) open() {
+	s.mu.Lock()
+	s.opened++
+	s.mu.Unlock()
+}
+
+func (s *h2Stats) close(lifetime time.Duration, requests int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed == 0 || lifetime < s.shortest {
+		s.shortest = lifetime
+	}
+	s.longest = max(s.longest, lifetime)
+	s.closed++
+	s.lifetime += lifetime
+	s.requests += int64(requests)
+}
+
+// frame counts the GOAWAY and RST_STREAM frames received.
+func (s *h2Stats) frame(typ http2.FrameType, payload []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch typ {
+	case http2.FrameGoAway:
+		if s.goAways == nil {
+			s.goAways = make(map[string]int64)
+		}
+		s.goAways[http2.ErrCode(binary.BigEndian.Uint32(payload[4:8])).String()]++
+	case http2.FrameRSTStream:
+		code := http2.ErrCode(binary.BigEndian.Uint32(payload))
+		if code == http2.ErrCodeRefusedStream {
+			s.refused++
+			return
+		}
+		if s.resets == nil {
+			s.resets = make(map[string]int64)
+		}
+		s.resets[code.String()]++
+	}
+}
+
+func (s *h2Stats) snapshot() *H2Report {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.opened == 0 {
+		return nil
+	}
+	rep := &H2Report{
+		Opened:           s.opened,
+		Closed:           s.closed,
+		GoAwayCodes:      make(map[string]int64, len(s.goAways)),
+		RefusedStreams:   s.refused,
+		ResetStreams:     make(map[string]int64, len(s.resets)),
+		ShortestLifetime: s.shortest.Seconds(),
+		LongestLifetime:  s.longest.Seconds(),
+	}
+	for code, n := range s.goAways {
+		rep.GoAways += n
+		rep.GoAwayCodes[code] = n
+	}
+	for code, n := range s.resets {
+		rep.ResetStreams[code] = n
+	}
+	if s.closed > 0 {
+		rep.AverageLifetime = s.lifetime.Seconds() / float64(s.closed)
+		rep.AverageRequests = float64(s.requests) / float64(s.closed)
+	}
+	return rep
+}
+
+// mergeH2 adds the HTTP/2 connections of b to a.
+func mergeH2(a, b *H2Report) *H2Report {
+	if b == nil {
+		return a
+	}
+	if a == nil {
+		a = &H2Report{GoAwayCodes: make(map[string]int64), ResetStreams: make(map[string]int64)}
+	}
+	closed := a.Closed + b.Closed
+	if closed > 0 {
+		a.AverageLifetime = (a.AverageLifetime*float64(a.Closed) + b.AverageLifetime*float64(b.Closed)) / float64(closed)
+		a.AverageRequests = (a.AverageRequests*float64(a.Closed) + b.AverageRequests*float64(b.Closed)) / float64(closed)
+	}
+	if a.Closed == 0 || b.Closed > 0 && b.ShortestLifetime < a.ShortestLifetime {
+		a.ShortestLifetime = b.ShortestLifetime
+	}
+	a.LongestLifetime = max(a.LongestLifetime, b.LongestLifetime)
+	a.Opened += b.Opened
+	a.Closed = closed
+	a.GoAways += b.GoAways
+	for code, n := range b.GoAwayCodes {
+		a.GoAwayCodes[code] += n
+	}
+	a.RefusedStreams += b.RefusedStreams
+	for code, n := range b.ResetStreams {
+		a.ResetStreams[code] += n
+	}
+	return a
+}
+
+// h2FrameHeaderLen is the length of the header of HTTP/2 frames.
+const h2FrameHeaderLen = 9
+
+// h2TapConn is an HTTP/2 connection that passes the frames it reads to
+// stats, for the frames the transport handles out of sight.
+type h2TapConn struct {
+	*tls.Conn
+	stats *h2Stats
+
+	hdr     [h2FrameHeaderLen]byte
+	hdrLen  int             // bytes of hdr read
+	typ     http2.FrameType // type of the frame being read
+	left    int             // payload bytes of the frame left to read
+	payload []byte          // payload read, of GOAWAY and RST_STREAM frames
+}
+
+func newH2TapConn(c *tls.Conn, stats *h2Stats) net.Conn {
+	return &h2TapConn{Conn: c, stats: stats}
+}
+
+func (c *h2TapConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.tap(p[:n])
+	return n, err
+}
+
+// tap follows the frames in the bytes read, keeping the first 8 bytes
+// of the payloads of GOAWAY and RST_STREAM frames, which hold their
+// error codes.
+func (c *h2TapConn) tap(p []byte) {
+	for len(p) > 0 {
+		if c.hdrLen < h2FrameHeaderLen {
+			n := copy(c.hdr[c.hdrLen:], p)
+			c.hdrLen += n
+			p = p[n:]
+			if c.hdrLen < h2FrameHeaderLen {
+				return
+			}
+			c.left = int(c.hdr[0])<<16 | int(c.hdr[1])<<8 | int(c.hdr[2])
+			c.typ = http2.FrameType(c.hdr[3])
+			c.payload = c.payload[:0]
+		}
+		n := min(c.left, len(p))
+		if c.typ == http2.FrameGoAway || c.typ == http2.FrameRSTStream {
+			c.payload = append(c.payload, p[:min(n, 8-len(c.payload))]...)
+		}
+		c.left -= n
+		p = p[n:]
+		if c.left > 0 {
+			return
+		}
+		c.hdrLen = 0
+		if c.typ == http2.FrameGoAway && len(c.payload) == 8 || c.typ == http2.FrameRSTStream && len(c.payload) >= 4 {
+			c.stats.frame(c.typ, c.payload)
+		}
+	}
+}
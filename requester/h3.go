@@ -0,0 +1,63 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// newH3Transport returns an HTTP/3 transport connecting with cfg, whose
+// QUIC handshakes are reported.
+func (b *Work) newH3Transport(cfg *tls.Config) http.RoundTripper {
+	return &http3.Transport{
+		TLSClientConfig:    cfg,
+		DisableCompression: b.DisableCompression || b.AcceptEncoding != "",
+		Dial:               b.dialQUIC,
+	}
+}
+
+// dialQUIC sets up a QUIC connection and times its handshake. The
+// handshake is reported to the trace of the request as both the dial and
+// the TLS handshake, as QUIC does them at once. It completes before the
+// request is sent: like over TCP, early data is not sent.
+func (b *Work) dialQUIC(ctx context.Context, addr string, cfg *tls.Config, qcfg *quic.Config) (*quic.Conn, error) {
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.ConnectStart != nil {
+		trace.ConnectStart("udp", addr)
+	}
+	if trace != nil && trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+	s := now()
+	conn, err := quic.DialAddr(ctx, addr, cfg, qcfg)
+	b.report.quic.add(now()-s, err)
+	var state tls.ConnectionState
+	if err == nil {
+		state = conn.ConnectionState().TLS
+	}
+	if trace != nil && trace.ConnectDone != nil {
+		trace.ConnectDone("udp", addr, err)
+	}
+	if trace != nil && trace.TLSHandshakeDone != nil {
+		trace.TLSHandshakeDone(state, err)
+	}
+	return conn, err
+}
@@ -0,0 +1,170 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// HeaderAssertion is a header every response must have, such as
+// "X-Cache: HIT", with a value equal to Value or, if Regexp is set,
+// matching it in full. Of headers with several values, one must match.
+type HeaderAssertion struct {
+	Name   string
+	Value  string
+	Regexp *regexp.Regexp
+}
+
+// ParseHeaderAssertion parses a header assertion of the form
+// "Name: value". If regex is set, the value is a regular expression the
+// whole value of the header must match.
+func ParseHeaderAssertion(s string, regex bool) (HeaderAssertion, error) {
+	name, value, ok := strings.Cut(s, ":")
+	name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+	if !ok || name == "" {
+		return HeaderAssertion{}, fmt.Errorf("could not parse the provided header assertion; input = %v", s)
+	}
+	a := HeaderAssertion{Name: http.CanonicalHeaderKey(name), Value: value}
+	if regex {
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return HeaderAssertion{}, err
+		}
+		a.Regexp = re
+	}
+	return a, nil
+}
+
+func (a HeaderAssertion) String() string {
+	if a.Regexp != nil {
+		return a.Name + " ~ " + a.Value
+	}
+	return a.Name + ": " + a.Value
+}
+
+// check reports whether h satisfies a, and otherwise the values of the
+// header, "(missing)" if it has none.
+func (a HeaderAssertion) check(h http.Header) (ok bool, got string) {
+	values := h.Values(a.Name)
+	for _, v := range values {
+		if a.Regexp != nil && a.Regexp.MatchString(v) || a.Regexp == nil && v == a.Value {
+			return true, ""
+		}
+	}
+	if len(values) == 0 {
+		return false, "(missing)"
+	}
+	return false, strings.Join(values, ", ")
+}
+
+// headerFailure is an assertion of Work.HeaderAssertions a response
+// failed, by index, with the values it had.
+type headerFailure struct {
+	assertion int
+	got       string
+}
+
+// checkHeaders checks the headers of a response against assertions.
+func checkHeaders(assertions []HeaderAssertion, h http.Header) []headerFailure {
+	var failures []headerFailure
+	for i, a := range assertions {
+		if ok, got := a.check(h); !ok {
+			failures = append(failures, headerFailure{assertion: i, got: got})
+		}
+	}
+	return failures
+}
+
+// HeaderAssertionReport summarizes how the responses of a run satisfied
+// a header assertion.
+type HeaderAssertionReport struct {
+	Assertion string
+
+	// Checked is the number of responses checked, of which Failed did
+	// not satisfy the assertion, Example the header values of the first.
+	Checked int64
+	Failed  int64
+	Example string
+}
+
+type headerAssertStats []HeaderAssertionReport
+
+func newHeaderAssertStats(assertions []HeaderAssertion) headerAssertStats {
+	s := make(headerAssertStats, len(assertions))
+	for i, a := range assertions {
+		s[i].Assertion = a.String()
+	}
+	return s
+}
+
+func (s headerAssertStats) add(res *result) {
+	if !res.headersChecked {
+		return
+	}
+	for i := range s {
+		s[i].Checked++
+	}
+	for _, f := range res.headerFailures {
+		if s[f.assertion].Failed == 0 {
+			s[f.assertion].Example = f.got
+		}
+		s[f.assertion].Failed++
+	}
+}
+
+// snapshot returns the reports of the assertions, nil if there are none.
+func (s headerAssertStats) snapshot() []HeaderAssertionReport {
+	if len(s) == 0 {
+		return nil
+	}
+	return append([]HeaderAssertionReport(nil), s...)
+}
+
+// FailedHeaderAssertions returns the reports of Work.HeaderAssertions
+// that some response failed, once the run finished.
+func (b *Work) FailedHeaderAssertions() []HeaderAssertionReport {
+	if b.report == nil {
+		return nil
+	}
+	var failed []HeaderAssertionReport
+	for _, rep := range b.report.headers {
+		if rep.Failed > 0 {
+			failed = append(failed, rep)
+		}
+	}
+	return failed
+}
+
+// mergeHeaderAssertions adds the reports of b to a, by assertion.
+func mergeHeaderAssertions(a, b []HeaderAssertionReport) []HeaderAssertionReport {
+	for _, rep := range b {
+		i := 0
+		for i < len(a) && a[i].Assertion != rep.Assertion {
+			i++
+		}
+		if i == len(a) {
+			a = append(a, HeaderAssertionReport{Assertion: rep.Assertion})
+		}
+		if a[i].Failed == 0 {
+			a[i].Example = rep.Example
+		}
+		a[i].Checked += rep.Checked
+		a[i].Failed += rep.Failed
+	}
+	return a
+}
@@ -0,0 +1,164 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"strings"
+)
+
+// Dimensions of latency heatmaps: the run is split into heatmapColumns
+// intervals, and latencies into heatmapRows log-spaced buckets, which
+// keep fast and slow modes apart.
+const (
+	heatmapColumns = 60
+	heatmapRows    = 20
+)
+
+// heatmap counts responses by when they were sent and how long they took.
+type heatmap struct {
+	start    float64   // offset of the first column, in seconds
+	interval float64   // width of the columns, in seconds
+	edges    []float64 // latency bucket edges, heatmapRows+1 from fastest
+	counts   [][]int   // by column, then by row from fastest
+	max      int
+}
+
+// newHeatmap returns the heatmap of the responses with latencies lats
+// sent at offsets, or nil if there are none.
+func newHeatmap(lats, offsets []float64) *heatmap {
+	if len(lats) == 0 || len(offsets) != len(lats) {
+		return nil
+	}
+	first, last := offsets[0], offsets[0]
+	fastest, slowest := lats[0], lats[0]
+	for i, l := range lats {
+		first, last = math.Min(first, offsets[i]), math.Max(last, offsets[i])
+		fastest, slowest = math.Min(fastest, l), math.Max(slowest, l)
+	}
+	lo := math.Max(fastest, 1e-6)
+	hi := math.Max(slowest, lo*1.01)
+	h := &heatmap{
+		start:    first,
+		interval: (last - first) / heatmapColumns,
+		edges:    make([]float64, heatmapRows+1),
+		counts:   make([][]int, heatmapColumns),
+	}
+	for i := range h.edges {
+		h.edges[i] = lo * math.Pow(hi/lo, float64(i)/heatmapRows)
+	}
+	for i := range h.counts {
+		h.counts[i] = make([]int, heatmapRows)
+	}
+	for i, l := range lats {
+		col := heatmapColumns - 1
+		if h.interval > 0 {
+			col = min(int((offsets[i]-first)/h.interval), heatmapColumns-1)
+		}
+		row := 0
+		if l > lo {
+			row = min(int(math.Log(l/lo)/math.Log(hi/lo)*heatmapRows), heatmapRows-1)
+		}
+		h.counts[col][row]++
+		if n := h.counts[col][row]; n > h.max {
+			h.max = n
+		}
+	}
+	return h
+}
+
+// color returns the color of a cell of n responses, from light to dark
+// on a log scale so that sparse outliers remain visible.
+func (h *heatmap) color(n int) color.RGBA {
+	if n == 0 {
+		return color.RGBA{255, 255, 255, 255}
+	}
+	t := math.Log1p(float64(n)) / math.Log1p(float64(h.max))
+	mix := func(from, to uint8) uint8 {
+		return uint8(float64(from) + t*(float64(to)-float64(from)))
+	}
+	return color.RGBA{mix(222, 8), mix(235, 48), mix(247, 107), 255}
+}
+
+// heatmapChart renders the latencies of rep over time as an SVG heatmap,
+// slower responses higher up, and its marks as vertical lines.
+func heatmapChart(rep Report) htmltemplate.HTML {
+	h := newHeatmap(rep.Lats, rep.Offsets)
+	if h == nil {
+		return ""
+	}
+	u := timeUnitOf(rep.TimeUnit)
+	const width, height, margin = 800, 300, 70
+	cw := float64(width-margin) / heatmapColumns
+	ch := float64(height-20) / heatmapRows
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg width="%d" height="%d" viewBox="0 0 %d %d" font-size="11">`, width, height, width, height)
+	for col, counts := range h.counts {
+		for row, n := range counts {
+			if n == 0 {
+				continue
+			}
+			c := h.color(n)
+			from := float64(col) * h.interval
+			fmt.Fprintf(&sb, `<rect x="%.2f" y="%.2f" width="%.2f" height="%.2f" fill="#%02x%02x%02x"><title>%.2fs-%.2fs: %d responses in %s-%s</title></rect>`,
+				margin+float64(col)*cw, float64(heatmapRows-1-row)*ch, cw, ch, c.R, c.G, c.B,
+				from, from+h.interval, n, u.value(h.edges[row]), u.format(h.edges[row+1]))
+		}
+	}
+	for _, row := range []int{0, heatmapRows / 2, heatmapRows} {
+		fmt.Fprintf(&sb, `<text x="0" y="%.2f">%s</text>`, math.Max(float64(heatmapRows-row)*ch, 11), u.format(h.edges[row]))
+	}
+	if span := h.interval * heatmapColumns; span > 0 {
+		for _, m := range rep.Marks {
+			markLine(&sb, margin+m.Offset/span*(width-margin), 0, height-20, m)
+		}
+	}
+	fmt.Fprintf(&sb, `<text x="%d" y="%d">0s</text>`, margin, height-4)
+	fmt.Fprintf(&sb, `<text x="%d" y="%d" text-anchor="end">%.2fs</text>`, width, height-4, h.interval*heatmapColumns)
+	sb.WriteString(`</svg>`)
+	return htmltemplate.HTML(sb.String())
+}
+
+// heatmapCell is the size in pixels of the cells of PNG heatmaps.
+const heatmapCell = 12
+
+// writeHeatmapPNG writes the latencies of rep over time as a PNG heatmap,
+// slower responses higher up.
+func writeHeatmapPNG(w io.Writer, rep Report) error {
+	h := newHeatmap(rep.Lats, rep.Offsets)
+	if h == nil {
+		return errors.New("no responses to draw a heatmap of")
+	}
+	img := image.NewRGBA(image.Rect(0, 0, heatmapColumns*heatmapCell, heatmapRows*heatmapCell))
+	for col, counts := range h.counts {
+		for row, n := range counts {
+			c := h.color(n)
+			y0 := (heatmapRows - 1 - row) * heatmapCell
+			for y := y0; y < y0+heatmapCell; y++ {
+				for x := col * heatmapCell; x < (col+1)*heatmapCell; x++ {
+					img.SetRGBA(x, y, c)
+				}
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
@@ -0,0 +1,141 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import "time"
+
+// InFlight describes the number of requests in flight during a run,
+// averaged over each second of it, as opposed to the configured
+// concurrency. A drop means workers were not sending, e.g. because they
+// were held up by slow responses or by rate limiting.
+type InFlight struct {
+	Concurrency int // configured number of workers; 0 if unknown
+	Average     float64
+
+	// Limit is the configured cap on requests in flight, 0 if none, and
+	// Capped counts requests that had to wait for it.
+	Limit  int
+	Capped int64
+
+	// Lowest is the lowest one-second average, at LowestOffset seconds
+	// into the run. The last second of the run is not considered, as
+	// workers finish there.
+	Lowest       float64
+	LowestOffset float64
+
+	// Series holds the average number of requests in flight during each
+	// second of the run.
+	Series []float64
+}
+
+// inflightStats accumulates the time requests spent in flight in each
+// interval of a run.
+type inflightStats struct {
+	busy   []time.Duration
+	limit  int // MaxInFlight, 0 if none
+	capped int64
+}
+
+func (s *inflightStats) add(res *result, start time.Duration) {
+	if res.capped {
+		s.capped++
+	}
+	from := max(res.offset-start, 0)
+	to := from + res.duration
+	for i := int(from / seriesInterval); time.Duration(i)*seriesInterval < to; i++ {
+		for len(s.busy) <= i {
+			s.busy = append(s.busy, 0)
+		}
+		lo := max(from, time.Duration(i)*seriesInterval)
+		hi := time.Duration(i+1) * seriesInterval
+		if to < hi {
+			hi = to
+		}
+		s.busy[i] += hi - lo
+	}
+}
+
+func (s *inflightStats) snapshot(total time.Duration, concurrency int) *InFlight {
+	if len(s.busy) == 0 || total <= 0 {
+		return nil
+	}
+	in := &InFlight{
+		Concurrency: concurrency,
+		Limit:       s.limit,
+		Capped:      s.capped,
+		Series:      make([]float64, len(s.busy)),
+	}
+	var busy time.Duration
+	for i, b := range s.busy {
+		busy += b
+		// The last interval may be cut short by the end of the run.
+		width := total - time.Duration(i)*seriesInterval
+		if width <= 0 || width > seriesInterval {
+			width = seriesInterval
+		}
+		in.Series[i] = b.Seconds() / width.Seconds()
+	}
+	in.Average = busy.Seconds() / total.Seconds()
+	in.setLowest()
+	return in
+}
+
+// setLowest finds the lowest value of the series but the last.
+func (in *InFlight) setLowest() {
+	if len(in.Series) == 0 {
+		return
+	}
+	in.Lowest, in.LowestOffset = in.Series[0], 0
+	for i, v := range in.Series[:max(len(in.Series)-1, 1)] {
+		if v < in.Lowest {
+			in.Lowest, in.LowestOffset = v, float64(i)
+		}
+	}
+}
+
+// mergeInFlight adds up the in-flight requests of parallel runs.
+func mergeInFlight(a, b *InFlight) *InFlight {
+	if b == nil {
+		return a
+	}
+	if a == nil {
+		a = &InFlight{}
+	}
+	m := &InFlight{
+		Concurrency: a.Concurrency + b.Concurrency,
+		Limit:       a.Limit + b.Limit,
+		Capped:      a.Capped + b.Capped,
+		Average:     a.Average + b.Average,
+		Series:      append([]float64(nil), a.Series...),
+	}
+	for i, v := range b.Series {
+		if i < len(m.Series) {
+			m.Series[i] += v
+		} else {
+			m.Series = append(m.Series, v)
+		}
+	}
+	m.setLowest()
+	return m
+}
+
+// inflightAt returns the average number of requests in flight during the
+// i-th second of the run, or 0 if unknown.
+func inflightAt(in *InFlight, i int) float64 {
+	if in == nil || i >= len(in.Series) {
+		return 0
+	}
+	return in.Series[i]
+}
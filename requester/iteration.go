@@ -0,0 +1,111 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import "fmt"
+
+// iterationBuckets is the most groups of iterations reported.
+const iterationBuckets = 10
+
+// IterationReport describes a run where each worker made the same number
+// of iterations, as with Work.Iterations.
+type IterationReport struct {
+	PerWorker int // iterations each worker was to make
+	Workers   int
+	Finished  int // workers that made all their iterations
+
+	// Buckets group consecutive iterations of all workers, e.g. to see if
+	// the first iterations of each were slower.
+	Buckets []IterationBucket
+}
+
+// IterationBucket holds statistics for iterations From to To, inclusive.
+type IterationBucket struct {
+	Iterations string
+	From       int
+	To         int
+	Count      int
+	Errors     int
+	Average    float64 // latency of successful requests, in seconds
+}
+
+type iterationStats struct {
+	perWorker int
+	count     []int // by iteration, 0-based
+	errors    []int
+	total     []float64
+	done      map[int]int // iterations made by worker
+}
+
+func (s *iterationStats) add(res *result) {
+	if res.iteration <= 0 {
+		return
+	}
+	if s.done == nil {
+		s.done = make(map[int]int)
+	}
+	i := res.iteration - 1
+	for len(s.count) <= i {
+		s.count = append(s.count, 0)
+		s.errors = append(s.errors, 0)
+		s.total = append(s.total, 0)
+	}
+	s.count[i]++
+	s.done[res.worker]++
+	if res.err != nil || res.aborted != "" {
+		s.errors[i]++
+		return
+	}
+	s.total[i] += res.duration.Seconds()
+}
+
+func (s *iterationStats) snapshot() *IterationReport {
+	if len(s.done) == 0 {
+		return nil
+	}
+	perWorker := s.perWorker
+	if perWorker == 0 {
+		// Unknown when reading records; take the most any worker made.
+		for _, n := range s.done {
+			perWorker = max(perWorker, n)
+		}
+	}
+	rep := &IterationReport{PerWorker: perWorker, Workers: len(s.done)}
+	for _, n := range s.done {
+		if n >= perWorker {
+			rep.Finished++
+		}
+	}
+	size := (len(s.count) + iterationBuckets - 1) / iterationBuckets
+	for from := 0; from < len(s.count); from += size {
+		to := min(from+size, len(s.count))
+		b := IterationBucket{From: from + 1, To: to}
+		var total float64
+		for i := from; i < to; i++ {
+			b.Count += s.count[i]
+			b.Errors += s.errors[i]
+			total += s.total[i]
+		}
+		if ok := b.Count - b.Errors; ok > 0 {
+			b.Average = total / float64(ok)
+		}
+		b.Iterations = fmt.Sprint(b.From)
+		if b.To > b.From {
+			b.Iterations = fmt.Sprintf("%d-%d", b.From, b.To)
+		}
+		rep.Buckets = append(rep.Buckets, b)
+	}
+	return rep
+}
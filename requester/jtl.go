@@ -0,0 +1,85 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// jtlHeader are the columns of JMeter's CSV results (JTL) with its
+// default save configuration.
+var jtlHeader = []string{
+	"timeStamp", "elapsed", "label", "responseCode", "responseMessage",
+	"threadName", "dataType", "success", "failureMessage", "bytes",
+	"sentBytes", "grpThreads", "allThreads", "URL", "Latency", "IdleTime",
+	"Connect",
+}
+
+// WriteJTL writes records as JMeter CSV results (JTL), for JMeter and the
+// tools that read its results. Workers are the threads of a single thread
+// group, and times are in milliseconds: Latency is the time to the first
+// byte of the response, Connect that of DNS+dialup. As in JMeter,
+// requests fail with an error or a status of 400 or above. Marks and
+// records of connections are skipped.
+func WriteJTL(w io.Writer, records []Record) error {
+	ms := func(secs float64) string {
+		return strconv.FormatInt(int64(math.Round(secs*1000)), 10)
+	}
+	threads := 1
+	for _, rec := range records {
+		threads = max(threads, rec.Worker)
+	}
+	cw := csv.NewWriter(w)
+	cw.Write(jtlHeader)
+	for _, rec := range records {
+		if rec.Mark != "" || rec.Connection != nil {
+			continue
+		}
+		label := rec.URL
+		if label == "" {
+			label = "hey"
+		}
+		code, message := strconv.Itoa(rec.Status), http.StatusText(rec.Status)
+		if rec.Error != "" {
+			code, message = "Non HTTP response code: "+rec.Error, "Non HTTP response message: "+rec.Error
+		}
+		success := rec.Error == "" && rec.Status > 0 && rec.Status < 400
+		cw.Write([]string{
+			strconv.FormatInt(rec.Time.UnixMilli(), 10),
+			ms(rec.Duration),
+			label,
+			code,
+			message,
+			"hey 1-" + strconv.Itoa(max(rec.Worker, 1)),
+			"text",
+			strconv.FormatBool(success),
+			rec.Error,
+			strconv.FormatInt(max(rec.Size, 0), 10),
+			"0",
+			strconv.Itoa(threads),
+			strconv.Itoa(threads),
+			rec.URL,
+			ms(rec.Duration - rec.ResRead),
+			"0",
+			ms(rec.Conn),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
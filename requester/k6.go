@@ -0,0 +1,144 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// k6TrendStats are the statistics of trend metrics in k6 summaries, the
+// default of its summaryTrendStats option.
+var k6TrendStats = []string{"avg", "min", "med", "max", "p(90)", "p(95)"}
+
+// k6Summary is the end-of-test summary of k6, as passed to handleSummary
+// and written by "k6 run --summary-export".
+type k6Summary struct {
+	RootGroup k6Group             `json:"root_group"`
+	Options   k6Options           `json:"options"`
+	State     k6State             `json:"state"`
+	Metrics   map[string]k6Metric `json:"metrics"`
+}
+
+type k6Group struct {
+	Name   string        `json:"name"`
+	Path   string        `json:"path"`
+	ID     string        `json:"id"`
+	Groups []interface{} `json:"groups"`
+	Checks []interface{} `json:"checks"`
+}
+
+type k6Options struct {
+	SummaryTrendStats []string `json:"summaryTrendStats"`
+	SummaryTimeUnit   string   `json:"summaryTimeUnit"`
+	NoColor           bool     `json:"noColor"`
+}
+
+type k6State struct {
+	IsStdOutTTY       bool    `json:"isStdOutTTY"`
+	IsStdErrTTY       bool    `json:"isStdErrTTY"`
+	TestRunDurationMs float64 `json:"testRunDurationMs"`
+}
+
+// k6Metric is a metric of a k6 summary. Values of time trends are in
+// milliseconds.
+type k6Metric struct {
+	Type     string             `json:"type"`
+	Contains string             `json:"contains"`
+	Values   map[string]float64 `json:"values"`
+}
+
+// k6Trend returns the trend metric of the latencies lats, in seconds.
+func k6Trend(lats []float64) k6Metric {
+	sorted := append([]float64(nil), lats...)
+	sort.Float64s(sorted)
+	var sum float64
+	for _, l := range sorted {
+		sum += l
+	}
+	n := len(sorted)
+	const ms = 1000
+	return k6Metric{Type: "trend", Contains: "time", Values: map[string]float64{
+		"avg":   sum / float64(n) * ms,
+		"min":   sorted[0] * ms,
+		"med":   quantile(sorted, 50) * ms,
+		"max":   sorted[n-1] * ms,
+		"p(90)": quantile(sorted, 90) * ms,
+		"p(95)": quantile(sorted, 95) * ms,
+	}}
+}
+
+// WriteK6Summary writes rep as a k6 end-of-test summary, for tools that
+// read k6 results. The phases of requests map to the http_req_* metrics
+// of k6: DNS+dialup to blocked, the dial to connecting, the response wait
+// to waiting. As in k6, requests fail with an error or a status of 400 or
+// above.
+func WriteK6Summary(w io.Writer, rep Report) error {
+	secs := rep.Total.Seconds()
+	rate := func(n float64) float64 {
+		if secs == 0 {
+			return 0
+		}
+		return n / secs
+	}
+	var ok int64
+	for code, n := range rep.StatusCodeDist {
+		if code < 400 {
+			ok += int64(n)
+		}
+	}
+	failed := rep.NumRes - ok
+	var failedRate float64
+	if rep.NumRes > 0 {
+		failedRate = float64(failed) / float64(rep.NumRes)
+	}
+	s := k6Summary{
+		RootGroup: k6Group{ID: "d41d8cd98f00b204e9800998ecf8427e", Groups: []interface{}{}, Checks: []interface{}{}},
+		Options:   k6Options{SummaryTrendStats: k6TrendStats},
+		State:     k6State{TestRunDurationMs: secs * 1000},
+		Metrics: map[string]k6Metric{
+			"http_reqs": {Type: "counter", Contains: "default", Values: map[string]float64{
+				"count": float64(rep.NumRes),
+				"rate":  rate(float64(rep.NumRes)),
+			}},
+			"http_req_failed": {Type: "rate", Contains: "default", Values: map[string]float64{
+				"rate":   failedRate,
+				"passes": float64(failed),
+				"fails":  float64(ok),
+			}},
+			"data_received": {Type: "counter", Contains: "data", Values: map[string]float64{
+				"count": float64(rep.SizeTotal),
+				"rate":  rate(float64(rep.SizeTotal)),
+			}},
+		},
+	}
+	if len(rep.Lats) > 0 {
+		for name, lats := range map[string][]float64{
+			"http_req_duration":        rep.Lats,
+			"http_req_blocked":         rep.ConnLats,
+			"http_req_connecting":      rep.DialLats,
+			"http_req_tls_handshaking": rep.TLSLats,
+			"http_req_sending":         rep.ReqLats,
+			"http_req_waiting":         rep.DelayLats,
+			"http_req_receiving":       rep.ResLats,
+		} {
+			s.Metrics[name] = k6Trend(lats)
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
@@ -0,0 +1,154 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// KeepAliveReport describes the keep-alive probes sent on pooled
+// connections at the end of think time pauses. Comparing how long the
+// connections found alive and closed had been idle brackets the idle
+// timeout of the server.
+type KeepAliveReport struct {
+	Method string
+
+	// Probes is the number of probes sent. Alive ones went out on the
+	// pooled connection, Closed ones found that the server had closed it,
+	// either as the transport dropped it from the pool or as the probe
+	// failed on it. Failed probes got an error on a new connection.
+	Probes int64
+	Alive  int64
+	Closed int64
+	Failed int64
+
+	// LongestAlive is the longest idle time, in seconds, after which a
+	// connection was found alive, ShortestClosed the shortest after which
+	// one was found closed.
+	LongestAlive   float64
+	ShortestClosed float64
+}
+
+// keepAliveStats collects the outcomes of keep-alive probes as workers
+// send them.
+type keepAliveStats struct {
+	method string
+
+	mu             sync.Mutex
+	probes         int64
+	alive          int64
+	closed         int64
+	failed         int64
+	longestAlive   time.Duration
+	shortestClosed time.Duration
+}
+
+// probeKeepAlive sends a keep-alive probe with the transport of c, on
+// the connection left idle by the previous request of the worker, and
+// records whether the server kept it open.
+func (b *Work) probeKeepAlive(c *http.Client, idle time.Duration) {
+	k := b.report.keepalive
+	req, err := http.NewRequestWithContext(b.drainCtx, k.method, b.Request.URL.String(), nil)
+	if err != nil {
+		k.add(idle, false, true)
+		return
+	}
+	req.Header = b.Request.Header.Clone()
+	req.Host = b.Request.Host
+	// The transport retries idempotent requests on reused connections
+	// that turn out to be closed, so a second connection means the first
+	// one was.
+	var conns int
+	var reused bool
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if conns++; conns == 1 {
+				reused = info.Reused
+			}
+		},
+	}))
+	resp, err := c.Transport.RoundTrip(req)
+	if err == nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	k.add(idle, reused && conns == 1 && err == nil, err != nil && !reused)
+}
+
+// add records a probe sent after the connection was idle for idle, which
+// found it alive, failed on a new connection or otherwise found it closed.
+func (k *keepAliveStats) add(idle time.Duration, alive, failed bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.probes++
+	switch {
+	case alive:
+		k.alive++
+		k.longestAlive = max(k.longestAlive, idle)
+	case failed:
+		k.failed++
+	default:
+		k.closed++
+		if k.closed == 1 || idle < k.shortestClosed {
+			k.shortestClosed = idle
+		}
+	}
+}
+
+func (k *keepAliveStats) snapshot() *KeepAliveReport {
+	if k == nil {
+		return nil
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.probes == 0 {
+		return nil
+	}
+	return &KeepAliveReport{
+		Method:         k.method,
+		Probes:         k.probes,
+		Alive:          k.alive,
+		Closed:         k.closed,
+		Failed:         k.failed,
+		LongestAlive:   k.longestAlive.Seconds(),
+		ShortestClosed: k.shortestClosed.Seconds(),
+	}
+}
+
+// mergeKeepAlive adds the probes of b to a.
+func mergeKeepAlive(a, b *KeepAliveReport) *KeepAliveReport {
+	if b == nil {
+		return a
+	}
+	if a == nil {
+		c := *b
+		return &c
+	}
+	if b.Alive > 0 {
+		a.LongestAlive = max(a.LongestAlive, b.LongestAlive)
+	}
+	if b.Closed > 0 && (a.Closed == 0 || b.ShortestClosed < a.ShortestClosed) {
+		a.ShortestClosed = b.ShortestClosed
+	}
+	a.Probes += b.Probes
+	a.Alive += b.Alive
+	a.Closed += b.Closed
+	a.Failed += b.Failed
+	return a
+}
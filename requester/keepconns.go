@@ -0,0 +1,60 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// Conns keeps the connections of runs open for the runs after them, which
+// then start on connections warmed up by the runs before rather than
+// dialing new ones, e.g. the runs of hey matrix with -keep-conns. The
+// runs share a single transport, made by the first of them.
+type Conns struct {
+	mu   sync.Mutex
+	rt   http.RoundTripper
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// transport returns the transport kept for b, which is made by b if it is
+// the first run. New connections are dialed, and their dials reported, by
+// the run asking for them.
+func (c *Conns) transport(b *Work) http.RoundTripper {
+	dial := b.dialContext()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dial = dial
+	if c.rt == nil {
+		c.rt, _ = b.newTransport(0, 1, b.Request.Host)
+	}
+	return c.rt
+}
+
+// keep sets up tr to be kept by c: as later runs may have more
+// workers than the first, it keeps as many idle connections as it can.
+func (c *Conns) keep(tr *http.Transport) {
+	tr.MaxIdleConnsPerHost = maxIdleConn
+	tr.DialContext = c.dialContext
+}
+
+func (c *Conns) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	c.mu.Lock()
+	dial := c.dial
+	c.mu.Unlock()
+	return dial(ctx, network, addr)
+}
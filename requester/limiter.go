@@ -0,0 +1,159 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"math/rand"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// spinThreshold is how long before a send time a precise limiter stops
+// sleeping and busy-waits instead, as sleeps can overshoot by about that
+// much.
+const spinThreshold = time.Millisecond
+
+// limiter is a rate limiter shared by all workers, implemented as a
+// virtual scheduling (GCRA) token bucket. Requests are given send times on
+// an absolute schedule, so unlike per-worker tickers, sleep overshoot does
+// not accumulate and the achieved rate stays close to the target.
+//
+// Requests are evenly spaced, or with a random source, spaced by
+// exponentially distributed gaps, i.e. arrive as a Poisson process.
+type limiter struct {
+	interval time.Duration // average gap between requests
+	tau      time.Duration // how far ahead of schedule bursts may go
+	rnd      *rand.Rand    // draws gaps for Poisson arrivals; nil if uniform
+
+	mu    sync.Mutex
+	tat   time.Duration // theoretical arrival time of the next request
+	start time.Duration
+	due   time.Duration // time the next request is due on the schedule
+
+	// With precise pacing, a timing goroutine hands out the due times of
+	// requests at their send times.
+	tokens chan time.Duration
+	done   chan struct{}
+}
+
+// newLimiter returns a limiter allowing rate requests per second, and up
+// to burst requests back to back. If rnd is not nil, requests arrive as a
+// Poisson process with gaps drawn from it.
+func newLimiter(rate float64, burst int, rnd *rand.Rand) *limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	l := &limiter{
+		interval: time.Duration(float64(time.Second) / rate),
+		rnd:      rnd,
+		start:    now(),
+	}
+	l.tau = time.Duration(burst-1) * l.interval
+	// As with a ticker, the first request is due one gap in.
+	g := l.gap()
+	l.tat, l.due = l.start+g, l.start+g
+	return l
+}
+
+// gap returns the time between a request and the next one.
+func (l *limiter) gap() time.Duration {
+	if l.rnd == nil {
+		return l.interval
+	}
+	return time.Duration(l.rnd.ExpFloat64() * float64(l.interval))
+}
+
+// next schedules the request after the one sent at t. It returns the time
+// the request sent at t was due at.
+func (l *limiter) next(t time.Duration) time.Duration {
+	due := max(l.due-l.tau, l.start)
+	g := l.gap()
+	l.tat = max(l.tat, t) + g
+	l.due += g
+	return due
+}
+
+// newPreciseLimiter is like newLimiter, but busy-waits rather than sleeps
+// for the last moments before each send time, on a dedicated goroutine.
+// This allows sub-millisecond gaps between requests, at the cost of a CPU
+// core. stop must be called to release it.
+func newPreciseLimiter(rate float64, burst int, rnd *rand.Rand) *limiter {
+	l := newLimiter(rate, burst, rnd)
+	l.tokens = make(chan time.Duration)
+	l.done = make(chan struct{})
+	go l.run()
+	return l
+}
+
+func (l *limiter) run() {
+	for {
+		t := now()
+		send := max(t, l.tat-l.tau)
+		if d := send - t - spinThreshold; d > 0 {
+			time.Sleep(d)
+		}
+		for now() < send {
+			runtime.Gosched()
+		}
+		select {
+		case l.tokens <- max(l.due-l.tau, l.start):
+		case <-l.done:
+			return
+		}
+		// The request went out when a worker took the token, which may be
+		// later than send if all workers were busy.
+		l.next(now())
+	}
+}
+
+// stop releases the timing goroutine of a precise limiter.
+func (l *limiter) stop() {
+	if l.done != nil {
+		close(l.done)
+	}
+}
+
+// wait blocks until the next request may be sent. It returns the time the
+// request was due at, had the run kept to the target rate from its start,
+// and false if the limiter was stopped or stop closed while waiting.
+func (l *limiter) wait(stop <-chan struct{}) (time.Duration, bool) {
+	if l.tokens != nil {
+		select {
+		case due := <-l.tokens:
+			return due, true
+		case <-l.done:
+			return 0, false
+		case <-stop:
+			return 0, false
+		}
+	}
+	l.mu.Lock()
+	t := now()
+	send := max(t, l.tat-l.tau)
+	due := l.next(t)
+	l.mu.Unlock()
+
+	if d := send - t; d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-stop:
+			return 0, false
+		}
+	}
+	return due, true
+}
@@ -0,0 +1,64 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Mark is an annotation of the results at a point of the run, e.g. of a
+// deploy made while it ran, so that changes in latency can be attributed
+// to it.
+type Mark struct {
+	Offset float64   // seconds since the start of the run
+	Time   time.Time // wall clock time
+	Text   string
+}
+
+// Mark annotates the results with text at the current time, e.g.
+// "deploy v2". It may be called while the run proceeds, and fails before
+// Init and once the run is done.
+func (b *Work) Mark(text string) error {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return errors.New("requester: empty mark")
+	}
+	b.markMu.Lock()
+	defer b.markMu.Unlock()
+	if b.results == nil || b.finished {
+		return errors.New("requester: the run is not running")
+	}
+	b.results <- &result{offset: now(), sent: time.Now(), mark: text}
+	return nil
+}
+
+// markLine draws m on an SVG chart as a vertical line at x, from y0 to
+// y1, with its text as the tooltip.
+func markLine(sb *strings.Builder, x, y0, y1 float64, m Mark) {
+	fmt.Fprintf(sb, `<line x1="%.2f" y1="%.2f" x2="%.2f" y2="%.2f" stroke="#333333" stroke-dasharray="4 2"><title>%.2fs: %s</title></line>`,
+		x, y0, x, y1, m.Offset, html.EscapeString(m.Text))
+}
+
+// mergeMarks adds the marks of b to a, in the order they were made.
+func mergeMarks(a, b []Mark) []Mark {
+	a = append(a, b...)
+	sort.SliceStable(a, func(i, j int) bool { return a[i].Offset < a[j].Offset })
+	return a
+}
@@ -0,0 +1,171 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import "math"
+
+// MergeReports combines the reports of runs made in parallel, e.g. from
+// different machines, into a single one. Counts and distributions are
+// summed, throughput is the sum of the runs' throughputs and the total
+// duration is the longest of them. Latency percentiles and the histogram
+// are computed from the merged sketches, with the given percentiles
+// (DefaultPercentiles if empty).
+func MergeReports(reps []Report, percentiles []float64) (Report, error) {
+	m := Report{
+		ErrorDist:      make(map[string]int),
+		StatusCodeDist: make(map[int]int),
+		ProtoDist:      make(map[string]int),
+		ConnProtoDist:  make(map[string]int),
+		AbortDist:      make(map[string]int),
+		Sketch:         NewSketch(DefaultSketchAccuracy),
+		Fastest:        math.Inf(1),
+	}
+	for _, rep := range reps {
+		if err := m.Sketch.Merge(rep.Sketch); err != nil {
+			return Report{}, err
+		}
+		n := float64(len(rep.Lats))
+		m.AvgTotal += rep.AvgTotal
+		m.AvgConn += rep.AvgConn * n
+		m.AvgDNS += rep.AvgDNS * n
+		m.AvgReq += rep.AvgReq * n
+		m.AvgRes += rep.AvgRes * n
+		m.AvgDelay += rep.AvgDelay * n
+		m.Rps += rep.Rps
+		m.NumRes += rep.NumRes
+		m.SizeTotal += rep.SizeTotal
+		m.MaxBytes += rep.MaxBytes
+		m.Received += rep.Received
+		if rep.Total > m.Total {
+			m.Total = rep.Total
+		}
+		if len(rep.Lats) > 0 {
+			m.Fastest = math.Min(m.Fastest, rep.Fastest)
+			m.Slowest = math.Max(m.Slowest, rep.Slowest)
+		}
+		m.Lats = append(m.Lats, rep.Lats...)
+		m.ConnLats = append(m.ConnLats, rep.ConnLats...)
+		m.DnsLats = append(m.DnsLats, rep.DnsLats...)
+		m.DialLats = append(m.DialLats, rep.DialLats...)
+		m.TLSLats = append(m.TLSLats, rep.TLSLats...)
+		m.ReqLats = append(m.ReqLats, rep.ReqLats...)
+		m.ResLats = append(m.ResLats, rep.ResLats...)
+		m.DelayLats = append(m.DelayLats, rep.DelayLats...)
+		m.Offsets = append(m.Offsets, rep.Offsets...)
+		m.StatusCodes = append(m.StatusCodes, rep.StatusCodes...)
+		m.Timestamps = append(m.Timestamps, rep.Timestamps...)
+		for k, v := range rep.ErrorDist {
+			m.ErrorDist[k] += v
+		}
+		for k, v := range rep.StatusCodeDist {
+			m.StatusCodeDist[k] += v
+		}
+		for k, v := range rep.ProtoDist {
+			m.ProtoDist[k] += v
+		}
+		for k, v := range rep.ConnProtoDist {
+			m.ConnProtoDist[k] += v
+		}
+		for k, v := range rep.AbortDist {
+			m.AbortDist[k] += v
+		}
+		m.StatusSeries = mergeSeries(m.StatusSeries, rep.StatusSeries)
+		m.InFlight = mergeInFlight(m.InFlight, rep.InFlight)
+		m.Pacing = mergePacing(m.Pacing, rep.Pacing)
+		m.EarlyHints = mergeEarlyHints(m.EarlyHints, rep.EarlyHints)
+		m.Redirects = mergeRedirects(m.Redirects, rep.Redirects)
+		m.Dials = mergeDials(m.Dials, rep.Dials)
+		m.DialRetries = mergeDialRetries(m.DialRetries, rep.DialRetries)
+		m.Resumption = mergeResumption(m.Resumption, rep.Resumption)
+		m.ClockSkew = mergeClockSkew(m.ClockSkew, rep.ClockSkew)
+		m.Outages = mergeOutages(m.Outages, rep.Outages)
+		m.Marks = mergeMarks(m.Marks, rep.Marks)
+		m.SinkDropped += rep.SinkDropped
+		m.DNS = mergeDNS(m.DNS, rep.DNS)
+		m.Pings = mergePings(m.Pings, rep.Pings)
+		m.KeepAlive = mergeKeepAlive(m.KeepAlive, rep.KeepAlive)
+		m.H2 = mergeH2(m.H2, rep.H2)
+		m.QUIC = mergeQUIC(m.QUIC, rep.QUIC)
+		m.Outliers = mergeOutliers(m.Outliers, rep.Outliers)
+		m.TrailerDist = mergeTrailers(m.TrailerDist, rep.TrailerDist)
+		m.ParamDist = mergeParams(m.ParamDist, rep.ParamDist)
+		m.Golden = mergeGolden(m.Golden, rep.Golden)
+		m.Expect = mergeExpect(m.Expect, rep.Expect)
+		m.HeaderAssertions = mergeHeaderAssertions(m.HeaderAssertions, rep.HeaderAssertions)
+		m.SecurityHeaders = mergeSecurityHeaders(m.SecurityHeaders, rep.SecurityHeaders)
+		m.TargetDist = mergeTargets(m.TargetDist, rep.TargetDist)
+		m.Connections += rep.Connections
+		m.Transports += rep.Transports
+		if m.Transport == "" || m.Transport == rep.Transport {
+			m.Transport = rep.Transport
+		} else if rep.Transport != "" {
+			m.Transport = "mixed"
+		}
+	}
+	if len(m.Lats) == 0 {
+		m.Fastest = 0
+		return m, nil
+	}
+
+	n := float64(len(m.Lats))
+	m.Average = m.AvgTotal / float64(m.Sketch.Count)
+	m.AvgConn /= n
+	m.AvgDNS /= n
+	m.AvgReq /= n
+	m.AvgRes /= n
+	m.AvgDelay /= n
+	m.SizeReq = m.SizeTotal / int64(len(m.Lats))
+
+	pctls := percentiles
+	if len(pctls) == 0 {
+		pctls = DefaultPercentiles
+	}
+	count := int(m.Sketch.Count)
+	for _, p := range pctls {
+		d := LatencyDistribution{Percentage: p, Latency: m.Sketch.Quantile(p / 100)}
+		m.LatencyDistribution = append(m.LatencyDistribution, withConfidence(d, count, func(rank int) float64 {
+			return m.Sketch.Quantile(float64(rank) / float64(max(count-1, 1)))
+		}))
+	}
+	m.Histogram = sketchHistogram(m.Sketch, m.Fastest, m.Slowest)
+	return m, nil
+}
+
+// sketchHistogram spreads the values of s over the same buckets as
+// report.histogram would.
+func sketchHistogram(s *Sketch, fastest, slowest float64) []Bucket {
+	const bc = 10
+	res := make([]Bucket, bc+1)
+	bs := (slowest - fastest) / bc
+	for i := 0; i < bc; i++ {
+		res[i].Mark = fastest + bs*float64(i)
+	}
+	res[bc].Mark = slowest
+	add := func(v float64, n int64) {
+		i := 0
+		for i < bc && v > res[i].Mark {
+			i++
+		}
+		res[i].Count += int(n)
+	}
+	add(0, s.Zeros)
+	for idx, n := range s.Bins {
+		add(s.value(idx), n)
+	}
+	for i := range res {
+		res[i].Frequency = float64(res[i].Count) / float64(s.Count)
+	}
+	return res
+}
@@ -0,0 +1,76 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+)
+
+// Kerberos holds the Kerberos tickets of a credentials cache, to
+// authenticate requests with HTTP Negotiate (SPNEGO), as enterprise SSO
+// gateways and Windows-integrated authentication of IIS do.
+type Kerberos struct {
+	client *client.Client
+}
+
+// LoadKerberos loads the credentials cache at ccache, as written by
+// kinit, with the Kerberos configuration at conf, which is needed to find
+// the KDC of service tickets not in the cache. If conf is empty, only
+// cached service tickets can be used.
+func LoadKerberos(ccache, conf string) (*Kerberos, error) {
+	cfg := config.New()
+	if conf != "" {
+		var err error
+		if cfg, err = config.Load(conf); err != nil {
+			return nil, err
+		}
+	}
+	cc, err := credentials.LoadCCache(ccache)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errors.New("no Kerberos tickets, run kinit first")
+		}
+		return nil, err
+	}
+	cl, err := client.NewFromCCache(cc, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Kerberos{client: cl}, nil
+}
+
+// negotiateTransport authenticates every request with a Kerberos service
+// ticket for HTTP/<host>, sent with the request rather than after a 401,
+// so that requests take no extra round trip. Service tickets are fetched
+// from the KDC once, and cached.
+type negotiateTransport struct {
+	tr  http.RoundTripper
+	krb *Kerberos
+}
+
+func (t *negotiateTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	if err := spnego.SetSPNEGOHeader(t.krb.client, r, "HTTP/"+r.URL.Hostname()); err != nil {
+		return nil, err
+	}
+	return t.tr.RoundTrip(r)
+}
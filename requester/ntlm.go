@@ -0,0 +1,297 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/bits"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// NTLMCredentials are the credentials of NTLM authentication, as used by
+// Windows-integrated authentication of IIS.
+type NTLMCredentials struct {
+	Domain   string
+	User     string
+	Password string
+}
+
+// ntlmTransport authenticates requests with NTLMv2 when the server asks
+// for it. NTLM authenticates connections rather than requests, so the
+// handshake is only made on connections the server has not authenticated
+// yet, and relies on its three requests going over the same connection.
+type ntlmTransport struct {
+	tr    http.RoundTripper
+	creds *NTLMCredentials
+}
+
+func (t *ntlmTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	send := func(auth string) (*http.Response, error) {
+		r := req.Clone(req.Context())
+		if body != nil {
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		if auth != "" {
+			r.Header.Set("Authorization", "NTLM "+auth)
+		}
+		return t.tr.RoundTrip(r)
+	}
+	resp, err := send("")
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || ntlmChallengeOf(resp) == nil {
+		return resp, err
+	}
+	discard(resp)
+	resp, err = send(base64.StdEncoding.EncodeToString(ntlmNegotiate()))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := ntlmChallengeOf(resp)
+	if len(challenge) == 0 {
+		return resp, nil
+	}
+	discard(resp)
+	auth, err := ntlmAuthenticate(challenge, t.creds, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return send(base64.StdEncoding.EncodeToString(auth))
+}
+
+// discard reads the rest of the body of resp and closes it, so that its
+// connection is reused.
+func discard(resp *http.Response) {
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// ntlmChallengeOf returns the NTLM challenge of resp, empty if it asks
+// for NTLM without one, or nil if it does not ask for NTLM.
+func ntlmChallengeOf(resp *http.Response) []byte {
+	for _, v := range resp.Header.Values("WWW-Authenticate") {
+		scheme, param, _ := strings.Cut(strings.TrimSpace(v), " ")
+		if !strings.EqualFold(scheme, "NTLM") {
+			continue
+		}
+		challenge, err := base64.StdEncoding.DecodeString(strings.TrimSpace(param))
+		if err != nil || challenge == nil {
+			return []byte{}
+		}
+		return challenge
+	}
+	return nil
+}
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// NTLM negotiate flags.
+const (
+	ntlmUnicode          = 0x00000001
+	ntlmRequestTarget    = 0x00000004
+	ntlmNTLM             = 0x00000200
+	ntlmAlwaysSign       = 0x00008000
+	ntlmExtendedSecurity = 0x00080000
+	ntlmTargetInfo       = 0x00800000
+	ntlm128              = 0x20000000
+	ntlm56               = 0x80000000
+
+	ntlmFlags = ntlmUnicode | ntlmRequestTarget | ntlmNTLM | ntlmAlwaysSign |
+		ntlmExtendedSecurity | ntlmTargetInfo | ntlm128 | ntlm56
+)
+
+// ntlmAvTimestamp is the ID of the server time in the target info of
+// challenges.
+const ntlmAvTimestamp = 7
+
+// ntlmNegotiate returns an NTLM negotiate message, without a domain or
+// workstation.
+func ntlmNegotiate() []byte {
+	msg := make([]byte, 32)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 1)
+	binary.LittleEndian.PutUint32(msg[12:], ntlmFlags)
+	return msg
+}
+
+// ntlmAuthenticate returns the NTLMv2 authenticate message answering the
+// NTLM challenge message challenge with creds, at now if the challenge
+// does not give the time of the server.
+func ntlmAuthenticate(challenge []byte, creds *NTLMCredentials, now time.Time) ([]byte, error) {
+	if len(challenge) < 48 || !bytes.Equal(challenge[:8], ntlmSignature) || binary.LittleEndian.Uint32(challenge[8:]) != 2 {
+		return nil, errors.New("requester: malformed NTLM challenge")
+	}
+	flags := binary.LittleEndian.Uint32(challenge[20:]) & ntlmFlags
+	serverChallenge := challenge[24:32]
+	infoLen := int(binary.LittleEndian.Uint16(challenge[40:]))
+	infoOff := int(binary.LittleEndian.Uint32(challenge[44:]))
+	if infoOff+infoLen > len(challenge) {
+		return nil, errors.New("requester: malformed NTLM challenge")
+	}
+	targetInfo := challenge[infoOff : infoOff+infoLen]
+
+	clientChallenge := make([]byte, 8)
+	if _, err := rand.Read(clientChallenge); err != nil {
+		return nil, err
+	}
+	timestamp, fromServer := ntlmTimestamp(targetInfo)
+	if !fromServer {
+		timestamp = ntlmFiletime(now)
+	}
+	key := ntowfv2(creds)
+
+	temp := make([]byte, 0, 28+len(targetInfo)+4)
+	temp = append(temp, 1, 1, 0, 0, 0, 0, 0, 0)
+	temp = binary.LittleEndian.AppendUint64(temp, timestamp)
+	temp = append(temp, clientChallenge...)
+	temp = append(temp, 0, 0, 0, 0)
+	temp = append(temp, targetInfo...)
+	temp = append(temp, 0, 0, 0, 0)
+	ntResponse := append(hmacMD5(key, serverChallenge, temp), temp...)
+	// With the time of the server, the LMv2 response is to be empty.
+	lmResponse := make([]byte, 24)
+	if !fromServer {
+		lmResponse = append(hmacMD5(key, serverChallenge, clientChallenge), clientChallenge...)
+	}
+
+	fields := [][]byte{lmResponse, ntResponse, utf16le(creds.Domain), utf16le(creds.User), nil, nil}
+	const header = 64
+	msg := make([]byte, header)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 3)
+	off := header
+	for i, f := range fields {
+		binary.LittleEndian.PutUint16(msg[12+8*i:], uint16(len(f)))
+		binary.LittleEndian.PutUint16(msg[14+8*i:], uint16(len(f)))
+		binary.LittleEndian.PutUint32(msg[16+8*i:], uint32(off))
+		off += len(f)
+	}
+	binary.LittleEndian.PutUint32(msg[60:], flags)
+	for _, f := range fields {
+		msg = append(msg, f...)
+	}
+	return msg, nil
+}
+
+// ntlmTimestamp returns the time of the server in the target info of a
+// challenge, if it has one.
+func ntlmTimestamp(info []byte) (uint64, bool) {
+	for len(info) >= 4 {
+		id := binary.LittleEndian.Uint16(info)
+		n := int(binary.LittleEndian.Uint16(info[2:]))
+		if id == 0 || 4+n > len(info) {
+			break
+		}
+		if id == ntlmAvTimestamp && n == 8 {
+			return binary.LittleEndian.Uint64(info[4:]), true
+		}
+		info = info[4+n:]
+	}
+	return 0, false
+}
+
+// ntlmFiletime returns t as a Windows FILETIME, in 100ns since 1601.
+func ntlmFiletime(t time.Time) uint64 {
+	const epochDelta = 116444736000000000
+	return uint64(t.UnixNano()/100) + epochDelta
+}
+
+// ntowfv2 returns the NTLMv2 key of creds.
+func ntowfv2(creds *NTLMCredentials) []byte {
+	return hmacMD5(md4(utf16le(creds.Password)), utf16le(strings.ToUpper(creds.User)+creds.Domain))
+}
+
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	h := hmac.New(md5.New, key)
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+func utf16le(s string) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, u)
+	}
+	return b
+}
+
+// Shifts of the three rounds of MD4, and the order of the words of the
+// third.
+var (
+	md4Shifts = [3][4]int{{3, 7, 11, 19}, {3, 5, 9, 13}, {3, 9, 11, 15}}
+	md4Order3 = [16]int{0, 8, 4, 12, 2, 10, 6, 14, 1, 9, 5, 13, 3, 11, 7, 15}
+)
+
+// md4 returns the MD4 digest of msg, as of RFC 1320, which NTLM hashes
+// passwords with.
+func md4(msg []byte) []byte {
+	n := len(msg)
+	msg = append(append([]byte(nil), msg...), 0x80)
+	for len(msg)%64 != 56 {
+		msg = append(msg, 0)
+	}
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(n)*8)
+
+	s := [4]uint32{0x67452301, 0xefcdab89, 0x98badcfe, 0x10325476}
+	var x [16]uint32
+	for ; len(msg) > 0; msg = msg[64:] {
+		for i := range x {
+			x[i] = binary.LittleEndian.Uint32(msg[4*i:])
+		}
+		a, b, c, d := s[0], s[1], s[2], s[3]
+		// Each step updates a, and the words rotate so that the next
+		// updates d, then c and b.
+		for i := 0; i < 16; i++ {
+			f := b&c | ^b&d
+			a, b, c, d = d, bits.RotateLeft32(a+f+x[i], md4Shifts[0][i%4]), b, c
+		}
+		for i := 0; i < 16; i++ {
+			g := b&c | b&d | c&d
+			a, b, c, d = d, bits.RotateLeft32(a+g+x[i/4+i%4*4]+0x5a827999, md4Shifts[1][i%4]), b, c
+		}
+		for i := 0; i < 16; i++ {
+			h := b ^ c ^ d
+			a, b, c, d = d, bits.RotateLeft32(a+h+x[md4Order3[i]]+0x6ed9eba1, md4Shifts[2][i%4]), b, c
+		}
+		s[0] += a
+		s[1] += b
+		s[2] += c
+		s[3] += d
+	}
+	digest := make([]byte, 0, 16)
+	for _, v := range s {
+		digest = binary.LittleEndian.AppendUint32(digest, v)
+	}
+	return digest
+}
@@ -0,0 +1,275 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// OCSP certificate statuses.
+const (
+	OCSPGood    = "good"
+	OCSPRevoked = "revoked"
+	OCSPUnknown = "unknown"
+)
+
+// OCSPInfo describes the OCSP response the server stapled to the first
+// TLS connection, if any, as checked at the end of the run.
+type OCSPInfo struct {
+	Stapled bool
+
+	// Status is the status of the server certificate, OCSPGood,
+	// OCSPRevoked or OCSPUnknown, RevokedAt when it was revoked.
+	Status    string
+	RevokedAt time.Time
+
+	// ProducedAt is when the response was signed, and it is valid from
+	// ThisUpdate to NextUpdate, if set.
+	ProducedAt time.Time
+	ThisUpdate time.Time
+	NextUpdate time.Time
+
+	// Verified is whether the response is about the server certificate
+	// and signed by its issuer, or a responder the issuer delegated to.
+	// Error tells why the response could not be verified.
+	Verified bool
+	Error    string
+
+	// CheckedAt is when the response was verified, and VerifyTime how
+	// long the verification took, in seconds.
+	CheckedAt  time.Time
+	VerifyTime float64
+}
+
+// Valid reports whether a response was stapled, verified, says the
+// certificate is good and was current when it was checked.
+func (o *OCSPInfo) Valid() bool {
+	return o.Verified && o.Status == OCSPGood && !o.CheckedAt.Before(o.ThisUpdate) &&
+		(o.NextUpdate.IsZero() || o.CheckedAt.Before(o.NextUpdate))
+}
+
+// OCSP returns the OCSP response stapled to the first TLS connection of
+// the run, once it finished, or nil if no TLS connection was made.
+func (b *Work) OCSP() *OCSPInfo {
+	return b.ocsp
+}
+
+// checkOCSP checks the stapled OCSP response staple against the
+// certificate chain presented with it, at now.
+func checkOCSP(staple []byte, certs []*x509.Certificate, now time.Time) *OCSPInfo {
+	info := &OCSPInfo{Stapled: len(staple) > 0, CheckedAt: now}
+	if !info.Stapled {
+		return info
+	}
+	s := time.Now()
+	err := parseOCSP(info, staple, certs)
+	info.VerifyTime = time.Since(s).Seconds()
+	if err != nil {
+		info.Error = err.Error()
+	} else {
+		info.Verified = true
+	}
+	return info
+}
+
+// The ASN.1 structures of OCSP responses, see RFC 6960.
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseBytes struct {
+	Type     asn1.ObjectIdentifier
+	Response []byte
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Version     int `asn1:"optional,default:0,explicit,tag:0"`
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []ocspSingleResponse
+	Extensions  []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspSingleResponse struct {
+	CertID     ocspCertID
+	Good       asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked    ocspRevokedInfo  `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate time.Time        `asn1:"generalized"`
+	NextUpdate time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	Extensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspCertID struct {
+	HashAlgorithm  pkix.AlgorithmIdentifier
+	IssuerNameHash []byte
+	IssuerKeyHash  []byte
+	SerialNumber   *big.Int
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+var (
+	oidOCSPBasic = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+
+	ocspHashes = map[string]crypto.Hash{
+		"1.3.14.3.2.26":          crypto.SHA1,
+		"2.16.840.1.101.3.4.2.1": crypto.SHA256,
+		"2.16.840.1.101.3.4.2.2": crypto.SHA384,
+		"2.16.840.1.101.3.4.2.3": crypto.SHA512,
+	}
+
+	ocspSignatureAlgorithms = map[string]x509.SignatureAlgorithm{
+		"1.2.840.113549.1.1.5":  x509.SHA1WithRSA,
+		"1.2.840.113549.1.1.11": x509.SHA256WithRSA,
+		"1.2.840.113549.1.1.12": x509.SHA384WithRSA,
+		"1.2.840.113549.1.1.13": x509.SHA512WithRSA,
+		"1.2.840.10045.4.1":     x509.ECDSAWithSHA1,
+		"1.2.840.10045.4.3.2":   x509.ECDSAWithSHA256,
+		"1.2.840.10045.4.3.3":   x509.ECDSAWithSHA384,
+		"1.2.840.10045.4.3.4":   x509.ECDSAWithSHA512,
+		"1.3.101.112":           x509.PureEd25519,
+	}
+)
+
+// parseOCSP fills info from the OCSP response der, returning why it
+// cannot be verified for the leaf of certs, if it cannot.
+func parseOCSP(info *OCSPInfo, der []byte, certs []*x509.Certificate) error {
+	var resp ocspResponse
+	if _, err := asn1.Unmarshal(der, &resp); err != nil {
+		return fmt.Errorf("malformed OCSP response: %v", err)
+	}
+	if resp.Status != 0 {
+		return fmt.Errorf("OCSP response status %d", resp.Status)
+	}
+	if !resp.Response.Type.Equal(oidOCSPBasic) {
+		return fmt.Errorf("unsupported OCSP response type %v", resp.Response.Type)
+	}
+	var basic ocspBasicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return fmt.Errorf("malformed OCSP response: %v", err)
+	}
+	var data ocspResponseData
+	if _, err := asn1.Unmarshal(basic.TBSResponseData.FullBytes, &data); err != nil {
+		return fmt.Errorf("malformed OCSP response data: %v", err)
+	}
+	if len(data.Responses) != 1 {
+		return fmt.Errorf("OCSP response has %d statuses, want 1", len(data.Responses))
+	}
+	single := data.Responses[0]
+	info.ProducedAt = data.ProducedAt
+	info.ThisUpdate = single.ThisUpdate
+	info.NextUpdate = single.NextUpdate
+	switch {
+	case bool(single.Good):
+		info.Status = OCSPGood
+	case bool(single.Unknown):
+		info.Status = OCSPUnknown
+	default:
+		info.Status = OCSPRevoked
+		info.RevokedAt = single.Revoked.RevocationTime
+	}
+
+	if len(certs) < 2 {
+		return errors.New("the issuer certificate was not presented")
+	}
+	leaf, issuer := certs[0], certs[1]
+	if err := checkOCSPCertID(single.CertID, leaf, issuer); err != nil {
+		return err
+	}
+	algo, ok := ocspSignatureAlgorithms[basic.SignatureAlgorithm.Algorithm.String()]
+	if !ok {
+		return fmt.Errorf("unsupported OCSP signature algorithm %v", basic.SignatureAlgorithm.Algorithm)
+	}
+	signer := issuer
+	if len(basic.Certificates) > 0 {
+		// A responder the issuer delegated signing OCSP responses to.
+		responder, err := x509.ParseCertificate(basic.Certificates[0].FullBytes)
+		if err != nil {
+			return fmt.Errorf("malformed OCSP responder certificate: %v", err)
+		}
+		if !bytes.Equal(responder.Raw, issuer.Raw) {
+			if err := responder.CheckSignatureFrom(issuer); err != nil {
+				return fmt.Errorf("OCSP responder certificate not issued by the issuer: %v", err)
+			}
+			if !hasExtKeyUsage(responder, x509.ExtKeyUsageOCSPSigning) {
+				return errors.New("OCSP responder certificate is not authorized to sign OCSP responses")
+			}
+		}
+		signer = responder
+	}
+	if err := signer.CheckSignature(algo, basic.TBSResponseData.FullBytes, basic.Signature.RightAlign()); err != nil {
+		return fmt.Errorf("bad OCSP response signature: %v", err)
+	}
+	return nil
+}
+
+// checkOCSPCertID checks that id identifies leaf, as issued by issuer.
+func checkOCSPCertID(id ocspCertID, leaf, issuer *x509.Certificate) error {
+	if id.SerialNumber == nil || id.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+		return errors.New("OCSP response is not about the server certificate")
+	}
+	hash, ok := ocspHashes[id.HashAlgorithm.Algorithm.String()]
+	if !ok || !hash.Available() {
+		return fmt.Errorf("unsupported OCSP certificate ID hash %v", id.HashAlgorithm.Algorithm)
+	}
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return fmt.Errorf("malformed issuer public key: %v", err)
+	}
+	h := hash.New()
+	h.Write(issuer.RawSubject)
+	nameHash := h.Sum(nil)
+	h.Reset()
+	h.Write(spki.PublicKey.RightAlign())
+	if !bytes.Equal(nameHash, id.IssuerNameHash) || !bytes.Equal(h.Sum(nil), id.IssuerKeyHash) {
+		return errors.New("OCSP response is not about a certificate of the issuer")
+	}
+	return nil
+}
+
+func hasExtKeyUsage(c *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	for _, u := range c.ExtKeyUsage {
+		if u == usage {
+			return true
+		}
+	}
+	return false
+}
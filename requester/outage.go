@@ -0,0 +1,185 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultDownProbeInterval is how often a target found down with
+// Work.PauseOnDown is probed, if Work.DownProbeInterval is not set.
+const DefaultDownProbeInterval = time.Second
+
+// Outage is a window in which the target was down, every request failing
+// with an error or a 5xx status, and sending was paused.
+type Outage struct {
+	// Start is when the first of the failed requests was sent, and End
+	// when the probe that found the target up again completed, in seconds
+	// since the start of the run. End is 0 if the target was still down
+	// at the end of the run.
+	Start float64
+	End   float64
+
+	// Probes counts the requests sent to find out whether the target was
+	// up again.
+	Probes int
+}
+
+// Duration returns how long the outage lasted, 0 if it had not ended.
+func (o Outage) Duration() float64 {
+	if o.End == 0 {
+		return 0
+	}
+	return o.End - o.Start
+}
+
+// failed reports whether res counts towards an outage.
+func failed(res *result) bool {
+	return res.err != nil || res.statusCode >= 500
+}
+
+// downDetector pauses sending once every request failed for a while,
+// with Work.PauseOnDown, and lets a single worker probe the target until
+// it is up again.
+type downDetector struct {
+	after    time.Duration // how long requests must fail for
+	interval time.Duration // between probes
+
+	mu        sync.Mutex
+	failing   time.Duration // when the first failed request was sent, if failing
+	isFailing bool
+	down      bool
+	probing   bool
+	nextProbe time.Duration
+}
+
+// observe updates the detector with res, completed at t.
+func (d *downDetector) observe(res *result, t time.Duration) {
+	if res.aborted != "" || res.drained {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !failed(res) {
+		d.isFailing, d.down = false, false
+		return
+	}
+	if !d.isFailing || res.offset < d.failing {
+		d.failing, d.isFailing = res.offset, true
+	}
+	if !d.down && t-d.failing >= d.after {
+		d.down = true
+		d.nextProbe = t + d.interval
+	}
+}
+
+// wait blocks while the target is down, until it is time for the caller
+// to send a probe or the target is up again. It reports whether the
+// request is a probe, and false for ok if stop or quit was closed.
+func (d *downDetector) wait(stop, quit <-chan struct{}) (probe, ok bool) {
+	for {
+		d.mu.Lock()
+		if !d.down {
+			d.mu.Unlock()
+			return false, true
+		}
+		t := now()
+		if !d.probing && t >= d.nextProbe {
+			d.probing = true
+			d.mu.Unlock()
+			return true, true
+		}
+		wait := d.interval / 10
+		if !d.probing && d.nextProbe-t < wait {
+			wait = d.nextProbe - t
+		}
+		d.mu.Unlock()
+		select {
+		case <-time.After(wait):
+		case <-stop:
+			return false, false
+		case <-quit:
+			return false, false
+		}
+	}
+}
+
+// probed is called once a probe completed, at t.
+func (d *downDetector) probed(t time.Duration) {
+	d.mu.Lock()
+	d.probing = false
+	d.nextProbe = t + d.interval
+	d.mu.Unlock()
+}
+
+// outageStats finds the outages of a run by its probes: an outage starts
+// with the failures before its first probe, and ends with a probe that
+// succeeded.
+type outageStats struct {
+	failing bool
+	since   float64 // when the first of the current failures was sent
+	current *Outage
+	outages []Outage
+}
+
+func (o *outageStats) add(res *result, start time.Duration) {
+	if res.aborted != "" || res.drained {
+		return
+	}
+	sent := (res.offset - start).Seconds()
+	if !res.probe {
+		if failed(res) {
+			if !o.failing || sent < o.since {
+				o.failing, o.since = true, sent
+			}
+		} else if o.current == nil {
+			o.failing = false
+		}
+		return
+	}
+	if o.current == nil {
+		o.current = &Outage{Start: sent}
+		if o.failing {
+			o.current.Start = o.since
+		}
+	}
+	o.current.Probes++
+	if !failed(res) {
+		o.current.End = sent + res.duration.Seconds()
+		o.outages = append(o.outages, *o.current)
+		o.current, o.failing = nil, false
+	}
+}
+
+// snapshot returns the outages of the run, nil if there were none.
+func (o *outageStats) snapshot() []Outage {
+	outages := append([]Outage(nil), o.outages...)
+	if o.current != nil {
+		outages = append(outages, *o.current)
+	}
+	if len(outages) == 0 {
+		return nil
+	}
+	return outages
+}
+
+// mergeOutages adds the outages of b to a, in the order they started.
+func mergeOutages(a, b []Outage) []Outage {
+	a = append(a, b...)
+	sort.SliceStable(a, func(i, j int) bool { return a[i].Start < a[j].Start })
+	return a
+}
@@ -0,0 +1,40 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+// saturatedLate is the fraction of rate limited requests sent behind
+// schedule above which the client is deemed saturated.
+const saturatedLate = 0.01
+
+// Unreachable reports whether, once the run finished, requests were made
+// but none of them got a response, e.g. as connections were refused or
+// the host did not resolve.
+func (b *Work) Unreachable() bool {
+	if b.report == nil {
+		return false
+	}
+	return b.report.numRes > 0 && b.report.responded == 0
+}
+
+// Saturated reports whether, once the run finished, more than 1% of
+// the rate limited requests were sent over an interval behind schedule,
+// i.e. the client could not generate the load asked of it.
+func (b *Work) Saturated() bool {
+	if b.report == nil || b.report.pacing == nil || b.report.pacing.count == 0 {
+		return false
+	}
+	p := b.report.pacing
+	return float64(p.late)/float64(p.count) > saturatedLate
+}
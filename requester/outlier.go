@@ -0,0 +1,221 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// DefaultOutlierK is how many median absolute deviations above the median
+// latency responses are flagged as outliers by default.
+const DefaultOutlierK = 5
+
+const (
+	// minOutlierSamples is the number of responses below which outliers
+	// are not looked for.
+	minOutlierSamples = 10
+	// maxOutliers is the number of outliers listed in reports.
+	maxOutliers = 10
+)
+
+// Phases of requests, which outliers are attributed to by where most of
+// their time went.
+const (
+	PhaseConn  = "DNS+dialup"
+	PhaseReq   = "req write"
+	PhaseDelay = "resp wait"
+	PhaseRes   = "resp read"
+)
+
+// OutlierReport describes the responses much slower than the rest, and
+// what they had in common, to tell e.g. connection setups from server
+// stalls.
+type OutlierReport struct {
+	// Outliers took longer than Threshold, the Median latency plus K
+	// times the median absolute deviation MAD of the latencies, in
+	// seconds. The MAD is taken to be at least 1% of the median, so that
+	// runs of equal latencies do not flag every slower response. Merged
+	// reports average them over runs.
+	K         float64
+	Median    float64
+	MAD       float64
+	Threshold float64
+
+	// Count is the number of outliers among Responses, of which NewConns
+	// opened a new connection and Redirected followed redirects, while
+	// AllNewConns and AllRedirected count those among all responses.
+	Count         int
+	Responses     int
+	NewConns      int
+	AllNewConns   int
+	Redirected    int
+	AllRedirected int
+
+	// StatusCodes counts the outliers by status code, and Phases by the
+	// phase most of their time went to, PhaseConn, PhaseReq, PhaseDelay or
+	// PhaseRes.
+	StatusCodes map[int]int
+	Phases      map[string]int
+
+	// Slowest are the slowest outliers, slowest first.
+	Slowest []Outlier
+}
+
+// Outlier is a response flagged as an outlier.
+type Outlier struct {
+	// Offset is when the request was sent, in seconds since the start of
+	// the run, and Time the wall clock time.
+	Offset    float64
+	Time      time.Time
+	Latency   float64
+	Status    int
+	NewConn   bool
+	Redirects int
+	Phase     string
+}
+
+// outlierSignal holds what a response may have in common with outliers,
+// besides its status code and phases.
+type outlierSignal struct {
+	newConn   bool
+	redirects int
+}
+
+// outlierStats collects the signals of the responses sampled in the
+// latency distribution, alongside their latencies.
+type outlierStats struct {
+	k       float64
+	signals []outlierSignal
+}
+
+func (o *outlierStats) add(res *result) {
+	o.signals = append(o.signals, outlierSignal{newConn: res.newConn, redirects: len(res.redirects)})
+}
+
+// snapshot returns the outliers among the latencies sampled in rep, or
+// nil if there are too few to tell.
+func (o *outlierStats) snapshot(rep *Report) *OutlierReport {
+	n := len(rep.Lats)
+	if n < minOutlierSamples || len(o.signals) != n {
+		return nil
+	}
+	k := o.k
+	if k == 0 {
+		k = DefaultOutlierK
+	}
+	sorted := append([]float64(nil), rep.Lats...)
+	sort.Float64s(sorted)
+	median := sorted[n/2]
+	devs := make([]float64, n)
+	for i, l := range sorted {
+		devs[i] = math.Abs(l - median)
+	}
+	sort.Float64s(devs)
+	mad := math.Max(devs[n/2], median/100)
+	out := &OutlierReport{
+		K:           k,
+		Median:      median,
+		MAD:         mad,
+		Threshold:   median + k*mad,
+		Responses:   n,
+		StatusCodes: make(map[int]int),
+		Phases:      make(map[string]int),
+	}
+	for i, l := range rep.Lats {
+		sig := o.signals[i]
+		if sig.newConn {
+			out.AllNewConns++
+		}
+		if sig.redirects > 0 {
+			out.AllRedirected++
+		}
+		if l <= out.Threshold {
+			continue
+		}
+		ol := Outlier{
+			Offset:    rep.Offsets[i],
+			Time:      rep.Timestamps[i],
+			Latency:   l,
+			Status:    rep.StatusCodes[i],
+			NewConn:   sig.newConn,
+			Redirects: sig.redirects,
+			Phase:     slowestPhase(rep.ConnLats[i], rep.ReqLats[i], rep.DelayLats[i], rep.ResLats[i]),
+		}
+		out.Count++
+		if ol.NewConn {
+			out.NewConns++
+		}
+		if ol.Redirects > 0 {
+			out.Redirected++
+		}
+		out.StatusCodes[ol.Status]++
+		out.Phases[ol.Phase]++
+		out.Slowest = append(out.Slowest, ol)
+	}
+	out.Slowest = sortOutliers(out.Slowest)
+	return out
+}
+
+// slowestPhase returns the phase of a request that took the longest.
+func slowestPhase(conn, req, delay, res float64) string {
+	phase, longest := PhaseConn, conn
+	for _, p := range []struct {
+		name string
+		d    float64
+	}{{PhaseReq, req}, {PhaseDelay, delay}, {PhaseRes, res}} {
+		if p.d > longest {
+			phase, longest = p.name, p.d
+		}
+	}
+	return phase
+}
+
+// sortOutliers sorts outliers slowest first, keeping maxOutliers of them.
+func sortOutliers(outliers []Outlier) []Outlier {
+	sort.SliceStable(outliers, func(i, j int) bool { return outliers[i].Latency > outliers[j].Latency })
+	return outliers[:min(len(outliers), maxOutliers)]
+}
+
+// mergeOutliers adds the outliers of b to a.
+func mergeOutliers(a, b *OutlierReport) *OutlierReport {
+	if b == nil {
+		return a
+	}
+	if a == nil {
+		a = &OutlierReport{K: b.K, StatusCodes: make(map[int]int), Phases: make(map[string]int)}
+	}
+	weigh := func(x, y float64) float64 {
+		return (x*float64(a.Responses) + y*float64(b.Responses)) / float64(a.Responses+b.Responses)
+	}
+	a.Median = weigh(a.Median, b.Median)
+	a.MAD = weigh(a.MAD, b.MAD)
+	a.Threshold = weigh(a.Threshold, b.Threshold)
+	a.Count += b.Count
+	a.Responses += b.Responses
+	a.NewConns += b.NewConns
+	a.AllNewConns += b.AllNewConns
+	a.Redirected += b.Redirected
+	a.AllRedirected += b.AllRedirected
+	for code, n := range b.StatusCodes {
+		a.StatusCodes[code] += n
+	}
+	for phase, n := range b.Phases {
+		a.Phases[phase] += n
+	}
+	a.Slowest = sortOutliers(append(a.Slowest, b.Slowest...))
+	return a
+}
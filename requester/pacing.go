@@ -0,0 +1,127 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"sort"
+	"time"
+)
+
+// PacingReport describes how closely requests followed the schedule set
+// by the QPS rate limit. The k-th request of the run is meant to be sent k
+// rate limit intervals after it starts; the lag of a request is how much
+// later than that it was actually sent.
+type PacingReport struct {
+	// TargetRate is the rate limit across all workers, in requests per
+	// second; 0 if unknown. ActualRate is the rate requests were sent at.
+	TargetRate float64
+	ActualRate float64
+
+	// Late counts requests sent more than one interval behind schedule,
+	// i.e. after the rate limiter had skipped a slot for them.
+	Late int64
+
+	// AverageLag and MaxLag are in seconds. MaxLag is how far behind its
+	// schedule the run fell.
+	AverageLag float64
+	MaxLag     float64
+
+	LagDistribution []LatencyDistribution
+}
+
+// pacingPercentiles are reported in the lag distribution.
+var pacingPercentiles = []float64{50, 90, 99}
+
+type pacingStats struct {
+	targetRate float64
+	interval   time.Duration // between requests at the target rate
+	count      int64
+	late       int64
+	total      time.Duration
+	max        time.Duration
+	lags       []float64
+}
+
+func newPacingStats(qps float64, workers int) *pacingStats {
+	return &pacingStats{
+		targetRate: qps * float64(workers),
+		interval:   time.Duration(float64(time.Second) / (qps * float64(workers))),
+	}
+}
+
+func (p *pacingStats) add(res *result) {
+	if !res.paced {
+		return
+	}
+	p.count++
+	p.total += res.lag
+	if res.lag > p.max {
+		p.max = res.lag
+	}
+	if p.interval > 0 && res.lag > p.interval {
+		p.late++
+	}
+	if len(p.lags) < maxRes {
+		p.lags = append(p.lags, res.lag.Seconds())
+	}
+}
+
+func (p *pacingStats) snapshot(total time.Duration) *PacingReport {
+	if p.count == 0 {
+		return nil
+	}
+	rep := &PacingReport{
+		TargetRate: p.targetRate,
+		Late:       p.late,
+		AverageLag: (p.total / time.Duration(p.count)).Seconds(),
+		MaxLag:     p.max.Seconds(),
+	}
+	if total > 0 {
+		rep.ActualRate = float64(p.count) / total.Seconds()
+	}
+	lags := append([]float64(nil), p.lags...)
+	sort.Float64s(lags)
+	for _, pct := range pacingPercentiles {
+		rep.LagDistribution = append(rep.LagDistribution, LatencyDistribution{Percentage: pct, Latency: quantile(lags, pct)})
+	}
+	return rep
+}
+
+// mergePacing combines the pacing of parallel runs. Lag distributions
+// cannot be merged and are dropped.
+func mergePacing(a, b *PacingReport) *PacingReport {
+	if b == nil {
+		return a
+	}
+	if a == nil {
+		return &PacingReport{
+			TargetRate: b.TargetRate,
+			ActualRate: b.ActualRate,
+			Late:       b.Late,
+			AverageLag: b.AverageLag,
+			MaxLag:     b.MaxLag,
+		}
+	}
+	m := &PacingReport{
+		TargetRate: a.TargetRate + b.TargetRate,
+		ActualRate: a.ActualRate + b.ActualRate,
+		Late:       a.Late + b.Late,
+		MaxLag:     max(a.MaxLag, b.MaxLag),
+	}
+	if m.ActualRate > 0 {
+		m.AverageLag = (a.AverageLag*a.ActualRate + b.AverageLag*b.ActualRate) / m.ActualRate
+	}
+	return m
+}
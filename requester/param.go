@@ -0,0 +1,154 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// maxParamValues caps the distinct values tracked per query parameter,
+// so that fuzzed values do not grow the report without bound. Further
+// values are counted as otherParamValue.
+const maxParamValues = 10000
+
+const otherParamValue = "(other)"
+
+// slowestParams is the number of values reported per query parameter.
+const slowestParams = 10
+
+// fuzzChars are the characters of fuzzed query parameter values.
+const fuzzChars = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// setParams sets the swept and fuzzed query parameters of req, and
+// returns them.
+func (b *Work) setParams(req *http.Request, rnd *rand.Rand) url.Values {
+	params := make(url.Values, 2)
+	if b.SweepParam != "" && len(b.SweepValues) > 0 {
+		i := atomic.AddUint64(&b.swept, 1) - 1
+		params.Set(b.SweepParam, b.SweepValues[i%uint64(len(b.SweepValues))])
+	}
+	if b.FuzzParam != "" {
+		v := make([]byte, 8)
+		for i := range v {
+			v[i] = fuzzChars[rnd.Intn(len(fuzzChars))]
+		}
+		params.Set(b.FuzzParam, string(v))
+	}
+	// The URL is shared with the original request.
+	u := *req.URL
+	q := u.Query()
+	for name, values := range params {
+		q[name] = values
+	}
+	u.RawQuery = q.Encode()
+	req.URL = &u
+	return params
+}
+
+// ParamValue summarizes the requests made with a value of a swept or
+// fuzzed query parameter. Latencies are of successful requests.
+type ParamValue struct {
+	Count   int
+	Errors  int
+	Total   float64 // sum of latencies
+	Slowest float64
+}
+
+// Average returns the average latency of the successful requests.
+func (v ParamValue) Average() float64 {
+	if n := v.Count - v.Errors; n > 0 {
+		return v.Total / float64(n)
+	}
+	return 0
+}
+
+// paramStats tracks requests by query parameter name and value.
+type paramStats map[string]map[string]ParamValue
+
+func (p paramStats) add(res *result) {
+	for name, values := range res.params {
+		v := ParamValue{Count: 1}
+		if res.err != nil || res.aborted != "" {
+			v.Errors = 1
+		} else {
+			v.Total, v.Slowest = res.duration.Seconds(), res.duration.Seconds()
+		}
+		p.count(name, strings.Join(values, ","), v)
+	}
+}
+
+func (p paramStats) count(name, value string, v ParamValue) {
+	dist := p[name]
+	if dist == nil {
+		dist = make(map[string]ParamValue)
+		p[name] = dist
+	}
+	if _, ok := dist[value]; !ok && len(dist) >= maxParamValues {
+		value = otherParamValue
+	}
+	sum := dist[value]
+	sum.Count += v.Count
+	sum.Errors += v.Errors
+	sum.Total += v.Total
+	sum.Slowest = max(sum.Slowest, v.Slowest)
+	dist[value] = sum
+}
+
+// mergeParams adds the query parameter values tracked in b to a.
+func mergeParams(a, b map[string]map[string]ParamValue) map[string]map[string]ParamValue {
+	if len(b) == 0 {
+		return a
+	}
+	p := paramStats(a)
+	if p == nil {
+		p = make(paramStats)
+	}
+	for name, dist := range b {
+		for value, v := range dist {
+			p.count(name, value, v)
+		}
+	}
+	return p
+}
+
+// namedParamValue is a ParamValue with its value, as listed in reports.
+type namedParamValue struct {
+	Value string
+	ParamValue
+}
+
+// slowestParamValues returns up to slowestParams values of dist with the
+// highest average latency, slowest first.
+func slowestParamValues(dist map[string]ParamValue) []namedParamValue {
+	values := make([]namedParamValue, 0, len(dist))
+	for value, v := range dist {
+		values = append(values, namedParamValue{value, v})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if ai, aj := values[i].Average(), values[j].Average(); ai != aj {
+			return ai > aj
+		}
+		return values[i].Value < values[j].Value
+	})
+	if len(values) > slowestParams {
+		values = values[:slowestParams]
+	}
+	return values
+}
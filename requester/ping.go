@@ -0,0 +1,111 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// PingReport describes the round trip times of the HTTP/2 PING frames
+// sent on idle connections. Servers acknowledge them without processing
+// a request, so they measure the network RTT that request latencies
+// include on top of server time.
+type PingReport struct {
+	// Count is the number of pings acknowledged, and Failed that of the
+	// pings that were not, e.g. as their connection closed.
+	Count  int64
+	Failed int64
+
+	// Average, Fastest and Slowest round trip times, in seconds.
+	Average float64
+	Fastest float64
+	Slowest float64
+
+	Distribution []LatencyDistribution
+}
+
+// pingPercentiles are reported in the ping distribution.
+var pingPercentiles = []float64{50, 90, 99}
+
+// pingStats collects the round trip times of pings as they are
+// acknowledged, concurrently with the run.
+type pingStats struct {
+	mu     sync.Mutex
+	failed int64
+	total  time.Duration
+	rtts   []float64
+}
+
+func (p *pingStats) add(rtt time.Duration, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		p.failed++
+		return
+	}
+	p.total += rtt
+	if len(p.rtts) < maxRes {
+		p.rtts = append(p.rtts, rtt.Seconds())
+	}
+}
+
+func (p *pingStats) snapshot() *PingReport {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.rtts) == 0 && p.failed == 0 {
+		return nil
+	}
+	rep := &PingReport{Count: int64(len(p.rtts)), Failed: p.failed}
+	if len(p.rtts) == 0 {
+		return rep
+	}
+	rtts := append([]float64(nil), p.rtts...)
+	sort.Float64s(rtts)
+	rep.Average = p.total.Seconds() / float64(len(rtts))
+	rep.Fastest, rep.Slowest = rtts[0], rtts[len(rtts)-1]
+	for _, pct := range pingPercentiles {
+		rep.Distribution = append(rep.Distribution, LatencyDistribution{Percentage: pct, Latency: quantile(rtts, pct)})
+	}
+	return rep
+}
+
+// mergePings combines the pings of parallel runs. Distributions cannot
+// be merged and are dropped.
+func mergePings(a, b *PingReport) *PingReport {
+	if b == nil {
+		return a
+	}
+	if a == nil {
+		return &PingReport{Count: b.Count, Failed: b.Failed, Average: b.Average, Fastest: b.Fastest, Slowest: b.Slowest}
+	}
+	m := &PingReport{
+		Count:   a.Count + b.Count,
+		Failed:  a.Failed + b.Failed,
+		Fastest: a.Fastest,
+		Slowest: max(a.Slowest, b.Slowest),
+	}
+	if a.Count == 0 || b.Count > 0 && b.Fastest < a.Fastest {
+		m.Fastest = b.Fastest
+	}
+	if m.Count > 0 {
+		m.Average = (a.Average*float64(a.Count) + b.Average*float64(b.Count)) / float64(m.Count)
+	}
+	return m
+}
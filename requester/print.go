@@ -13,7 +13,8 @@
 // limitations under the License.
 
 /*
-Hey supports two output formats: summary and CSV
+Hey supports nine output formats: summary, CSV, HTML, NDJSON, series,
+HdrHistogram, JSON, PNG and trace
 
 The summary output presents a number of statistics about the requests in a
 human-readable format, including:
@@ -31,6 +32,41 @@ The comma-separated CSV format is proceeded by a header, and consists of the fol
 6. Response-read:	Time taken to read full response (in seconds)
 7. status-code:		HTTP status code of the response (e.g. 200)
 8. offset:			The time since the start of the benchmark when the request was started. (in seconds)
+9. Connect:		Time taken to open the TCP connection, part of DNS+dialup (in seconds)
+10. TLS-handshake:	Time taken by the TLS handshake, part of DNS+dialup (in seconds)
+11. timestamp:		Wall clock time the request was started (RFC 3339, UTC)
+
+The HTML format presents the same statistics as the summary as a standalone
+web page, with a heatmap of latencies over time.
+
+The NDJSON format streams the raw result of every request as a JSON object
+per line, see Record. It can be read back with ReadRecords to regenerate
+any of the other formats. With Work.RecordConns, the records of
+connections, see ConnRecord, are written among them as connections close.
+
+The series format is a CSV time series of response status codes, with a
+row per second of the run by the time requests were sent. Its columns are
+the offset of the interval (in seconds), the number of responses of each
+status code seen in the run, the number of errors, and the average number
+of requests in flight during the interval.
+
+The HdrHistogram format is the percentile distribution of latencies, in
+milliseconds, as HdrHistogram's outputPercentileDistribution writes it,
+for HdrHistogram tools and plotters to read.
+
+The JSON format is the main statistics of the summary as a JSON document,
+see Summary.
+
+The PNG format is the heatmap of latencies over time as an image, with
+the run split into intervals from left to right and latencies into
+log-spaced buckets from bottom to top, each cell darker the more
+responses it holds. It shows bimodal latencies and periodic spikes, e.g.
+of garbage collections, that percentiles smooth over.
+
+The trace format streams the raw results as a Chrome trace event file,
+which Perfetto and chrome://tracing open, each request a span on the
+track of the worker that sent it, with its phases nested in it. Like
+NDJSON, it is written as requests complete; see WriteTrace.
 */
 package requester
 
@@ -38,26 +74,81 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	htmltemplate "html/template"
+	"io"
 	"strings"
 	"text/template"
+	"time"
 )
 
-func newTemplate(output string) *template.Template {
+// executor is implemented by both text and HTML templates.
+type executor interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// newExecutor returns the template of output, printing latencies in the
+// named time unit, see Work.TimeUnit.
+func newExecutor(output, unit string) executor {
+	funcs := unitFuncMap(timeUnitOf(unit))
+	if output == "html" {
+		return htmltemplate.Must(htmltemplate.New("tmpl").Funcs(htmltemplate.FuncMap(tmplFuncMap)).Funcs(htmltemplate.FuncMap(funcs)).Parse(htmlTmpl))
+	}
+	return template.Must(template.New("tmpl").Funcs(tmplFuncMap).Funcs(funcs).Parse(templateOf(output)))
+}
+
+// templateOf returns the text of the template of output.
+func templateOf(output string) string {
 	outputTmpl := output
 	switch outputTmpl {
 	case "":
 		outputTmpl = defaultTmpl
 	case "csv":
 		outputTmpl = csvTmpl
+	case "series":
+		outputTmpl = seriesTmpl
 	}
-	return template.Must(template.New("tmpl").Funcs(tmplFuncMap).Parse(outputTmpl))
+	return outputTmpl
 }
 
 var tmplFuncMap = template.FuncMap{
 	"formatNumber":    formatNumber,
 	"formatNumberInt": formatNumberInt,
-	"histogram":       histogram,
 	"jsonify":         jsonify,
+	"barWidth":        barWidth,
+	"seriesCodes":     seriesCodes,
+	"seriesChart":     seriesChart,
+	"heatmapChart":    heatmapChart,
+	"tooFewSamples":   tooFewSamples,
+	"inflightAt":      inflightAt,
+	"formatTime":      formatTime,
+	"slowestParams":   slowestParamValues,
+}
+
+// unitFuncMap returns the template functions that print latencies, in
+// seconds, in the unit u: latency with the unit, latencyValue without,
+// and histogram.
+func unitFuncMap(u timeUnit) template.FuncMap {
+	return template.FuncMap{
+		"latency":      u.format,
+		"latencyValue": u.value,
+		"histogram": func(buckets []Bucket) string {
+			return histogram(buckets, u)
+		},
+	}
+}
+
+// barWidth returns the width in percent of the histogram bar for b.
+func barWidth(buckets []Bucket, b Bucket) int {
+	max := 0
+	for _, b := range buckets {
+		if b.Count > max {
+			max = b.Count
+		}
+	}
+	if max == 0 {
+		return 0
+	}
+	return b.Count * 100 / max
 }
 
 func jsonify(v interface{}) string {
@@ -73,7 +164,16 @@ func formatNumberInt(duration int) string {
 	return fmt.Sprintf("%d", duration)
 }
 
-func histogram(buckets []Bucket) string {
+// formatTime formats t with nanoseconds in its time zone, UTC unless
+// Work.LocalTime is set, or as an empty string if it is unknown.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func histogram(buckets []Bucket, u timeUnit) string {
 	max := 0
 	for _, b := range buckets {
 		if v := b.Count; v > max {
@@ -87,7 +187,7 @@ func histogram(buckets []Bucket) string {
 		if max > 0 {
 			barLen = (buckets[i].Count*40 + max/2) / max
 		}
-		res.WriteString(fmt.Sprintf("  %4.3f [%v]\t|%v\n", buckets[i].Mark, buckets[i].Count, strings.Repeat(barChar, barLen)))
+		res.WriteString(fmt.Sprintf("  %4.3f [%v]\t|%v\n", buckets[i].Mark*u.scale, buckets[i].Count, strings.Repeat(barChar, barLen)))
 	}
 	return res.String()
 }
@@ -96,33 +196,473 @@ var (
 	defaultTmpl = `
 Summary:
   Total:	{{ formatNumber .Total.Seconds }} secs
-  Slowest:	{{ formatNumber .Slowest }} secs
-  Fastest:	{{ formatNumber .Fastest }} secs
-  Average:	{{ formatNumber .Average }} secs
+  Slowest:	{{ latency .Slowest }}
+  Fastest:	{{ latency .Fastest }}
+  Average:	{{ latency .Average }}
   Requests/sec:	{{ formatNumber .Rps }}
   {{ if gt .SizeTotal 0 }}
   Total data:	{{ .SizeTotal }} bytes
   Size/request:	{{ .SizeReq }} bytes{{ end }}
-
+{{ if gt .CompressedTotal 0 }}
+Compression:
+  Received:	{{ .CompressedTotal }} bytes
+  Decompressed:	{{ .DecompressedTotal }} bytes
+  Decompress:	{{ latency .AvgDecompress }} (average){{ if gt .UndecodedRes 0 }}
+  Not decoded:	{{ .UndecodedRes }} responses{{ end }}
+{{ end }}{{ if gt .MaxBytes 0 }}
+Data budget:
+  Received:	{{ .Received }} of {{ .MaxBytes }} bytes{{ if ge .Received .MaxBytes }} (reached, run stopped){{ end }}
+{{ end }}
 Response time histogram:
 {{ histogram .Histogram }}
 
-Latency distribution:{{ range .LatencyDistribution }}
-  {{ .Percentage }}%% in {{ formatNumber .Latency }} secs{{ end }}
+Latency distribution:{{ range .LatencyDistribution }}{{ if .Percentage }}
+  {{ .Percentage }}% in {{ latency .Latency }}{{ if and $.Details .High }} (95% CI {{ latencyValue .Low }}-{{ latencyValue .High }}{{ if .TooFewSamples }}+{{ end }}){{ end }}{{ end }}{{ end }}{{ if .Details }}{{ with tooFewSamples .LatencyDistribution }}
+
+  WARNING: too few requests ({{ $.NumRes }}) to tell {{ range $i, $p := . }}{{ if $i }}, {{ end }}p{{ $p }}{{ end }} from noise{{ end }}{{ end }}
 
 Details (average, fastest, slowest):
-  DNS+dialup:	{{ formatNumber .AvgConn }} secs, {{ formatNumber .ConnMax }} secs, {{ formatNumber .ConnMin }} secs
-  DNS-lookup:	{{ formatNumber .AvgDNS }} secs, {{ formatNumber .DnsMax }} secs, {{ formatNumber .DnsMin }} secs
-  req write:	{{ formatNumber .AvgReq }} secs, {{ formatNumber .ReqMax }} secs, {{ formatNumber .ReqMin }} secs
-  resp wait:	{{ formatNumber .AvgDelay }} secs, {{ formatNumber .DelayMax }} secs, {{ formatNumber .DelayMin }} secs
-  resp read:	{{ formatNumber .AvgRes }} secs, {{ formatNumber .ResMax }} secs, {{ formatNumber .ResMin }} secs
+  DNS+dialup:	{{ latency .AvgConn }}, {{ latency .ConnMax }}, {{ latency .ConnMin }}
+  DNS-lookup:	{{ latency .AvgDNS }}, {{ latency .DnsMax }}, {{ latency .DnsMin }}
+  req write:	{{ latency .AvgReq }}, {{ latency .ReqMax }}, {{ latency .ReqMin }}
+  resp wait:	{{ latency .AvgDelay }}, {{ latency .DelayMax }}, {{ latency .DelayMin }}
+  resp read:	{{ latency .AvgRes }}, {{ latency .ResMax }}, {{ latency .ResMin }}
 
 Status code distribution:{{ range $code, $num := .StatusCodeDist }}
   [{{ $code }}]	{{ $num }} responses{{ end }}
 
-{{ if gt (len .ErrorDist) 0 }}Error distribution:{{ range $err, $num := .ErrorDist }}
+{{ with and .Details .Outliers }}{{ if .Count }}Outliers ({{ .Count }}/{{ .Responses }} responses over {{ latency .Threshold }}, median + {{ .K }} x MAD):
+  Median:	{{ latency .Median }}, MAD {{ latency .MAD }}
+  New connections:	{{ .NewConns }} outliers, {{ .AllNewConns }} of all responses
+  Redirected:	{{ .Redirected }} outliers, {{ .AllRedirected }} of all responses
+  Status codes:{{ range $code, $num := .StatusCodes }}	[{{ $code }}] {{ $num }}{{ end }}
+  Slowest phase:{{ range $phase, $num := .Phases }}	[{{ $phase }}] {{ $num }}{{ end }}
+  Slowest:{{ range .Slowest }}
+    {{ latency .Latency }} at {{ printf "%.2f" .Offset }}s ({{ formatTime .Time }})	[{{ .Status }}] {{ .Phase }}{{ if .NewConn }}, new connection{{ end }}{{ if .Redirects }}, {{ .Redirects }} redirects{{ end }}{{ end }}
+
+{{ end }}{{ end }}{{ if gt (len .TargetDist) 1 }}Per target:{{ range $target, $t := .TargetDist }}
+  {{ $target }}
+    Requests:	{{ $t.Requests }} ({{ formatNumber $t.Rps }} req/s){{ if $t.Errors }}, {{ $t.Errors }} errors{{ end }}
+    Latency:	{{ latency $t.Average }} average, p50 {{ latencyValue ($t.Percentile 50) }}, p99 {{ latencyValue ($t.Percentile 99) }}, slowest {{ latencyValue $t.Slowest }}
+    Connections:	{{ $t.NewConns }} new{{ if $t.NewConns }}, {{ latency $t.AvgConn }} average setup, {{ latency $t.AvgDNS }} DNS{{ end }}
+    Status codes:{{ range $code, $num := $t.StatusCodes }}	[{{ $code }}] {{ $num }}{{ end }}{{ end }}
+
+{{ end }}{{ with .EarlyHints }}Early Hints ({{ .Hinted }}/{{ .Total }} responses hinted):
+  Time to 103:	{{ latency .AverageHint }} average{{ range .HintDistribution }}, p{{ .Percentage }} {{ latencyValue .Latency }}{{ end }}
+  Time to final:	{{ latency .AverageFinal }} average{{ range .FinalDistribution }}, p{{ .Percentage }} {{ latencyValue .Latency }}{{ end }}
+  Resources:	{{ .Resources }} hinted, {{ printf "%.2f" .AverageResources }} per response
+
+{{ end }}{{ with .Redirects }}Redirects ({{ .Redirected }}/{{ .Total }} responses redirected):
+  Overhead:	{{ latency .AverageOverhead }} average, {{ latency .Overhead }} total
+  Chain lengths:{{ range $n, $num := .ChainLengths }}	[{{ $n }}] {{ $num }}{{ end }}{{ range $url, $h := .Hops }}
+  [{{ $url }}]	{{ $h.Count }} redirects, {{ latency $h.Average }} average{{ end }}
+
+{{ end }}{{ with .Dials }}Dials:
+  Families:{{ range $family, $num := .Families }}	[{{ $family }}] {{ $num }}{{ end }}
+  Fallbacks:	{{ .Fallbacks }} started, {{ .FallbackConnections }} connected over the fallback family
+  Conn time:	{{ latency .ConnTime }} average without fallback, {{ latency .FallbackConnTime }} with
+
+{{ end }}{{ with .DialRetries }}Dial retries (in the first {{ .Grace }} of the run):
+  Retries:	{{ .Retries }}
+  Recovered:	{{ .Recovered }} dials connected after retrying
+  Failed:	{{ .Failed }} dials did not
+
+{{ end }}{{ with .DNS }}DNS lookups by resolver:{{ range $resolver, $l := . }}
+  [{{ $resolver }}]	{{ $l.Lookups }} lookups{{ if $l.Failed }}, {{ $l.Failed }} failed{{ end }}{{ if lt $l.Failed $l.Lookups }}, {{ latency $l.Average }} average, fastest {{ latencyValue $l.Fastest }}, slowest {{ latencyValue $l.Slowest }}{{ range $l.Distribution }}
+    {{ .Percentage }}% in {{ latency .Latency }}{{ end }}{{ end }}{{ end }}
+
+{{ end }}{{ with .Resumption }}TLS resumption ({{ .Resumed }}/{{ .Handshakes }} handshakes resumed):
+  Full:	{{ latency .FullHandshake }} handshake, {{ latency .FullConn }} connection setup average
+  Resumed:	{{ latency .ResumedHandshake }} handshake, {{ latency .ResumedConn }} connection setup average{{ if .Saved }}
+  Saved:	{{ latency .Saved }} per resumed connection{{ end }}
+  Early data:	not sent, resumed handshakes still take a round trip
+
+{{ end }}{{ with and .Details .ClockSkew }}Clock skew ({{ .Samples }} Date headers, server minus local clock):
+  Skew:	{{ printf "%+.4f" .Skew }} secs{{ if .Consistent }}, between {{ printf "%+.4f" .Low }} and {{ printf "%+.4f" .High }}{{ else }}, no single skew fits all Date headers{{ end }}{{ if .Drift }}
+  Drift:	{{ printf "%+.4f" .Drift }} secs per hour{{ end }}
+
+{{ end }}{{ with .Outages }}Outages (sending paused while the target was down):{{ range . }}
+  [{{ formatNumber .Start }} secs]	{{ if .End }}down for {{ formatNumber .Duration }} secs, up at {{ formatNumber .End }} secs{{ else }}still down at the end of the run{{ end }}, {{ .Probes }} probes{{ end }}
+
+{{ end }}{{ with .Marks }}Marks:{{ range . }}
+  [{{ formatNumber .Offset }} secs]	{{ .Text }} ({{ formatTime .Time }}){{ end }}
+
+{{ end }}{{ if gt .SinkDropped 0 }}Sinks:
+  Dropped:	{{ .SinkDropped }} records, the sinks could not keep up
+
+{{ end }}{{ with .Pings }}HTTP/2 PING RTT ({{ .Count }} pings{{ if .Failed }}, {{ .Failed }} failed{{ end }}):
+  Average:	{{ latency .Average }}
+  Fastest:	{{ latency .Fastest }}
+  Slowest:	{{ latency .Slowest }}{{ range .Distribution }}
+  {{ .Percentage }}% in {{ latency .Latency }}{{ end }}
+
+{{ end }}{{ with .KeepAlive }}Keep-alive probes ({{ .Probes }} {{ .Method }} requests{{ if .Failed }}, {{ .Failed }} failed{{ end }}):
+  Alive:	{{ .Alive }}{{ if .Alive }}, idle for up to {{ formatNumber .LongestAlive }} secs{{ end }}
+  Closed:	{{ .Closed }}{{ if .Closed }}, idle for {{ formatNumber .ShortestClosed }} secs or more{{ end }}
+
+{{ end }}{{ with .H2 }}HTTP/2 connections ({{ .Opened }} opened, {{ .Closed }} closed):
+  GOAWAY:	{{ .GoAways }} received{{ range $code, $num := .GoAwayCodes }}	[{{ $code }}] {{ $num }}{{ end }}
+  Refused streams:	{{ .RefusedStreams }}
+  Reset streams:{{ range $code, $num := .ResetStreams }}	[{{ $code }}] {{ $num }}{{ else }}	0{{ end }}{{ if .Closed }}
+  Lifetime:	{{ formatNumber .AverageLifetime }} secs average, shortest {{ formatNumber .ShortestLifetime }}, longest {{ formatNumber .LongestLifetime }}
+  Requests:	{{ printf "%.2f" .AverageRequests }} per closed connection{{ end }}
+
+{{ end }}{{ with .QUIC }}QUIC handshakes ({{ .Handshakes }} connections{{ if .Failed }}, {{ .Failed }} failed{{ end }}):{{ if .Handshakes }}
+  Average:	{{ latency .Average }}
+  Fastest:	{{ latency .Fastest }}
+  Slowest:	{{ latency .Slowest }}{{ end }}
+
+{{ end }}{{ if and .Details .Transport }}Transport:
+  Strategy:	{{ .Transport }} ({{ .Transports }} transports)
+  Connections:	{{ .Connections }} opened
+
+{{ end }}{{ with and .Details .InFlight }}In-flight requests:{{ if .Concurrency }}
+  Configured:	{{ .Concurrency }}{{ end }}{{ if .Limit }}
+  Limit:	{{ .Limit }} ({{ .Capped }} requests waited for it){{ end }}
+  Average:	{{ printf "%.2f" .Average }}
+  Lowest:	{{ printf "%.2f" .Lowest }} (at {{ .LowestOffset }}s)
+
+{{ end }}{{ with .Iterations }}Iterations ({{ .PerWorker }} per worker, {{ .Finished }}/{{ .Workers }} workers finished):{{ range .Buckets }}
+  [{{ .Iterations }}]	{{ latency .Average }} average, {{ .Count }} requests, {{ .Errors }} errors{{ end }}
+
+{{ end }}{{ with .Pacing }}Pacing:{{ if .TargetRate }}
+  Target rate:	{{ formatNumber .TargetRate }} req/s{{ end }}
+  Actual rate:	{{ formatNumber .ActualRate }} req/s
+  Lag:	{{ latency .AverageLag }} average, {{ latency .MaxLag }} behind schedule at most{{ range .LagDistribution }}
+  {{ .Percentage }}% in {{ latency .Latency }}{{ end }}{{ if .TargetRate }}
+  Late:	{{ .Late }} requests sent after a skipped slot{{ end }}
+
+{{ end }}{{ with .Assertions }}Assertions:{{ range . }}
+  {{ .Assertion }}	{{ if .Passed }}passed{{ else }}FAILED{{ end }}, {{ if .Samples }}{{ latency .Value }} over {{ .Samples }} samples{{ else }}no samples{{ end }}{{ end }}
+
+{{ end }}{{ with .SLO }}SLO ({{ .SLO }}):
+  Good/bad:	{{ .Good }}/{{ .Bad }} requests
+  Compliance:	{{ formatNumber .Compliance }}
+  Burn rate:	{{ formatNumber .BurnRate }}x{{ if gt .Exhaustion 0 }}
+  Budget lasts:	{{ .Exhaustion }} at this burn rate{{ end }}{{ if gt .BudgetRequests 0.0 }}
+  Budget:	{{ printf "%.0f" .BudgetRequests }} bad requests at {{ .TrafficRate }} req/s{{ end }}
+
+{{ end }}{{ if gt (len .RangeDist) 0 }}Range requests (range, average, partial/total):{{ range .RangeDist }}{{ if gt .Count 0 }}
+  {{ .Range }}	{{ latency .Average }}, {{ .Partial }}/{{ .Count }} partial{{ end }}{{ end }}
+
+{{ end }}{{ if gt (len .Certificates) 0 }}TLS certificates:{{ range .Certificates }}
+  {{ .Subject }}
+    issuer:	{{ .Issuer }}
+    expires:	{{ .NotAfter.UTC.Format "2006-01-02T15:04:05Z07:00" }}{{ end }}{{ if .CertExpiryWarning }}
+
+  WARNING: {{ .CertExpiryWarning }}{{ end }}
+
+{{ end }}{{ with .OCSP }}OCSP stapling:{{ if .Stapled }}
+  Status:	{{ .Status }}{{ if eq .Status "revoked" }} at {{ .RevokedAt.UTC.Format "2006-01-02T15:04:05Z07:00" }}{{ end }}, {{ if .Verified }}verified{{ else }}not verified: {{ .Error }}{{ end }}
+  Produced:	{{ .ProducedAt.UTC.Format "2006-01-02T15:04:05Z07:00" }}
+  Valid:	from {{ .ThisUpdate.UTC.Format "2006-01-02T15:04:05Z07:00" }}{{ if not .NextUpdate.IsZero }} to {{ .NextUpdate.UTC.Format "2006-01-02T15:04:05Z07:00" }}{{ end }}
+  Checked:	{{ .CheckedAt.UTC.Format "2006-01-02T15:04:05Z07:00" }} in {{ latency .VerifyTime }}{{ if not .Valid }}
+
+  WARNING: the stapled OCSP response is not valid{{ end }}{{ else }}
+  WARNING: no OCSP response was stapled{{ end }}
+
+{{ end }}{{ if gt (len .ProtoDist) 0 }}Protocol distribution:{{ range $proto, $num := .ProtoDist }}
+  [{{ $proto }}]	{{ $num }} responses, {{ index $.ConnProtoDist $proto }} connections{{ end }}
+
+{{ end }}{{ if gt (len .TrailerDist) 0 }}Response trailers:{{ range $name, $values := .TrailerDist }}{{ range $value, $num := $values }}
+  [{{ $name }}: {{ $value }}]	{{ $num }} responses{{ end }}{{ end }}
+
+{{ end }}{{ with .Expect }}Expected outcomes ({{ range $i, $code := .Codes }}{{ if $i }}, {{ end }}{{ $code }}{{ end }}):
+  Successes:	{{ .Expected }} requests
+  Success rate:	{{ formatNumber .SuccessRate }}
+  Failures:	{{ .Unexpected }} requests{{ range $code, $num := .UnexpectedDist }}
+  [{{ if $code }}{{ $code }}{{ else }}error{{ end }}]	{{ $num }} requests{{ end }}
+
+{{ end }}{{ with .HeaderAssertions }}Header assertions:{{ range . }}
+  {{ .Assertion }}	{{ if .Failed }}FAILED by {{ .Failed }}/{{ .Checked }} responses, e.g. {{ .Example }}{{ else }}passed by {{ .Checked }} responses{{ end }}{{ end }}
+
+{{ end }}{{ with .SecurityHeaders }}Security headers (responses with the header, values and backends that differ):{{ range . }}
+  {{ .Name }}	{{ .Present }}/{{ .Responses }} responses{{ if .Consistent }}{{ if .Present }}, consistent{{ else }}, missing{{ end }}{{ else }}, INCONSISTENT{{ range $v, $n := .Values }}
+    [{{ $n }}]	{{ $v }}{{ end }}{{ range .Divergent }}
+    backend {{ .Backend }}:{{ range $v, $n := .Values }}	[{{ $n }}] {{ $v }}{{ end }}{{ end }}{{ end }}{{ end }}
+
+{{ end }}{{ with .Golden }}Golden response:
+  Compared:	{{ .Compared }} responses
+  Diverged:	{{ .Diverged }} responses{{ range $path, $d := .Paths }}
+  [{{ $path }}]	{{ $d.Count }} responses, e.g. {{ $d.Example }}{{ end }}
+
+{{ end }}{{ range $name, $dist := .ParamDist }}Query parameter {{ $name }} ({{ len $dist }} values, slowest first):{{ range slowestParams $dist }}
+  [{{ .Value }}]	{{ latency .Average }} average, {{ latency .Slowest }} slowest, {{ .Count }} requests{{ if .Errors }}, {{ .Errors }} errors{{ end }}{{ end }}
+
+{{ end }}{{ with .Drain }}{{ if or .Completed .Cancelled .Failed }}Drain phase:
+  Completed:	{{ .Completed }} requests{{ if .Completed }} ({{ latency .Average }} average, {{ latency .Slowest }} slowest){{ end }}
+  Cancelled:	{{ .Cancelled }} requests
+  Failed:	{{ .Failed }} requests
+
+{{ end }}{{ end }}{{ if gt (len .AbortDist) 0 }}Chaos aborts:{{ range $phase, $num := .AbortDist }}
+  [{{ $num }}]	during {{ $phase }}{{ end }}
+
+{{ end }}{{ if gt (len .ErrorDist) 0 }}Error distribution:{{ range $err, $num := .ErrorDist }}
   [{{ $num }}]	{{ $err }}{{ end }}{{ end }}
 `
-	csvTmpl = `{{ $connLats := .ConnLats }}{{ $dnsLats := .DnsLats }}{{ $dnsLats := .DnsLats }}{{ $reqLats := .ReqLats }}{{ $delayLats := .DelayLats }}{{ $resLats := .ResLats }}{{ $statusCodeLats := .StatusCodes }}{{ $offsets := .Offsets}}response-time,DNS+dialup,DNS,Request-write,Response-delay,Response-read,status-code,offset{{ range $i, $v := .Lats }}
-{{ formatNumber $v }},{{ formatNumber (index $connLats $i) }},{{ formatNumber (index $dnsLats $i) }},{{ formatNumber (index $reqLats $i) }},{{ formatNumber (index $delayLats $i) }},{{ formatNumber (index $resLats $i) }},{{ formatNumberInt (index $statusCodeLats $i) }},{{ formatNumber (index $offsets $i) }}{{ end }}`
+	csvTmpl = `{{ $connLats := .ConnLats }}{{ $dnsLats := .DnsLats }}{{ $dnsLats := .DnsLats }}{{ $reqLats := .ReqLats }}{{ $delayLats := .DelayLats }}{{ $resLats := .ResLats }}{{ $statusCodeLats := .StatusCodes }}{{ $offsets := .Offsets}}{{ $dialLats := .DialLats }}{{ $tlsLats := .TLSLats }}{{ $timestamps := .Timestamps }}response-time,DNS+dialup,DNS,Request-write,Response-delay,Response-read,status-code,offset,Connect,TLS-handshake,timestamp{{ range $i, $v := .Lats }}
+{{ formatNumber $v }},{{ formatNumber (index $connLats $i) }},{{ formatNumber (index $dnsLats $i) }},{{ formatNumber (index $reqLats $i) }},{{ formatNumber (index $delayLats $i) }},{{ formatNumber (index $resLats $i) }},{{ formatNumberInt (index $statusCodeLats $i) }},{{ formatNumber (index $offsets $i) }},{{ formatNumber (index $dialLats $i) }},{{ formatNumber (index $tlsLats $i) }},{{ formatTime (index $timestamps $i) }}{{ end }}`
+	seriesTmpl = `{{ $codes := seriesCodes .StatusSeries }}offset,{{ range $codes }}{{ . }},{{ end }}errors,in-flight{{ range $i, $b := .StatusSeries }}
+{{ .Offset }},{{ range $codes }}{{ index $b.StatusCodes . }},{{ end }}{{ .Errors }},{{ printf "%.2f" (inflightAt $.InFlight $i) }}{{ end }}`
+	htmlTmpl = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>hey report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { text-align: left; padding: 0.2em 1em 0.2em 0; }
+.bar { background: #4a90d9; height: 1em; }
+</style>
+</head>
+<body>
+<h1>hey report</h1>
+
+<h2>Summary</h2>
+<table>
+<tr><th>Total</th><td>{{ formatNumber .Total.Seconds }} secs</td></tr>
+<tr><th>Slowest</th><td>{{ latency .Slowest }}</td></tr>
+<tr><th>Fastest</th><td>{{ latency .Fastest }}</td></tr>
+<tr><th>Average</th><td>{{ latency .Average }}</td></tr>
+<tr><th>Requests/sec</th><td>{{ formatNumber .Rps }}</td></tr>
+<tr><th>Responses</th><td>{{ .NumRes }}</td></tr>{{ if gt .SizeTotal 0 }}
+<tr><th>Total data</th><td>{{ .SizeTotal }} bytes</td></tr>
+<tr><th>Size/request</th><td>{{ .SizeReq }} bytes</td></tr>{{ end }}{{ if gt .MaxBytes 0 }}
+<tr><th>Data budget</th><td>{{ .Received }} of {{ .MaxBytes }} bytes received</td></tr>{{ end }}
+</table>
+
+<h2>Response time histogram</h2>
+<table>{{ $buckets := .Histogram }}{{ range $buckets }}
+<tr><td>{{ latency .Mark }}</td><td>{{ .Count }}</td><td style="width: 40em"><div class="bar" style="width: {{ barWidth $buckets . }}%"></div></td></tr>{{ end }}
+</table>
+
+<h2>Latency distribution</h2>
+<table>
+<tr><th></th><th>Latency</th>{{ if .Details }}<th>95% confidence interval</th>{{ end }}</tr>{{ range .LatencyDistribution }}{{ if .Percentage }}
+<tr><th>{{ .Percentage }}%</th><td>{{ latency .Latency }}</td>{{ if $.Details }}<td>{{ if .High }}{{ latencyValue .Low }}-{{ latency .High }}{{ if .TooFewSamples }}, too few samples{{ end }}{{ end }}</td>{{ end }}</tr>{{ end }}{{ end }}
+</table>
+
+<h2>Details (average, fastest, slowest)</h2>
+<table>
+<tr><th>DNS+dialup</th><td>{{ latency .AvgConn }}</td><td>{{ latency .ConnMax }}</td><td>{{ latency .ConnMin }}</td></tr>
+<tr><th>DNS-lookup</th><td>{{ latency .AvgDNS }}</td><td>{{ latency .DnsMax }}</td><td>{{ latency .DnsMin }}</td></tr>
+<tr><th>req write</th><td>{{ latency .AvgReq }}</td><td>{{ latency .ReqMax }}</td><td>{{ latency .ReqMin }}</td></tr>
+<tr><th>resp wait</th><td>{{ latency .AvgDelay }}</td><td>{{ latency .DelayMax }}</td><td>{{ latency .DelayMin }}</td></tr>
+<tr><th>resp read</th><td>{{ latency .AvgRes }}</td><td>{{ latency .ResMax }}</td><td>{{ latency .ResMin }}</td></tr>
+</table>
+
+<h2>Status code distribution</h2>
+<table>{{ range $code, $num := .StatusCodeDist }}
+<tr><th>{{ $code }}</th><td>{{ $num }} responses</td></tr>{{ end }}
+</table>
+{{ with and .Details .Outliers }}{{ if .Count }}
+<h2>Outliers</h2>
+<p>{{ .Count }}/{{ .Responses }} responses took over {{ latency .Threshold }}, the median of {{ latency .Median }} plus {{ .K }} times the median absolute deviation of {{ latency .MAD }}.</p>
+<table>
+<tr><th>New connections</th><td>{{ .NewConns }} outliers, {{ .AllNewConns }} of all responses</td></tr>
+<tr><th>Redirected</th><td>{{ .Redirected }} outliers, {{ .AllRedirected }} of all responses</td></tr>
+<tr><th>Status codes</th><td>{{ range $code, $num := .StatusCodes }}[{{ $code }}] {{ $num }} {{ end }}</td></tr>
+<tr><th>Slowest phase</th><td>{{ range $phase, $num := .Phases }}[{{ $phase }}] {{ $num }} {{ end }}</td></tr>
+</table>
+<table>
+<tr><th>Latency</th><th>Offset</th><th>Time</th><th>Status</th><th>Slowest phase</th><th>New connection</th><th>Redirects</th></tr>{{ range .Slowest }}
+<tr><td>{{ latency .Latency }}</td><td>{{ printf "%.2f" .Offset }}s</td><td>{{ formatTime .Time }}</td><td>{{ .Status }}</td><td>{{ .Phase }}</td><td>{{ .NewConn }}</td><td>{{ .Redirects }}</td></tr>{{ end }}
+</table>
+{{ end }}{{ end }}{{ if gt (len .TargetDist) 1 }}
+<h2>Per target</h2>
+<table>
+<tr><th>Target</th><th>Requests</th><th>Requests/sec</th><th>Errors</th><th>Average</th><th>p50</th><th>p99</th><th>Slowest</th><th>New connections</th><th>Setup</th><th>DNS</th><th>Status codes</th></tr>{{ range $target, $t := .TargetDist }}
+<tr><th>{{ $target }}</th><td>{{ $t.Requests }}</td><td>{{ formatNumber $t.Rps }}</td><td>{{ $t.Errors }}</td><td>{{ latency $t.Average }}</td><td>{{ latency ($t.Percentile 50) }}</td><td>{{ latency ($t.Percentile 99) }}</td><td>{{ latency $t.Slowest }}</td><td>{{ $t.NewConns }}</td><td>{{ latency $t.AvgConn }}</td><td>{{ latency $t.AvgDNS }}</td><td>{{ range $code, $num := $t.StatusCodes }}[{{ $code }}] {{ $num }} {{ end }}</td></tr>{{ end }}
+</table>
+{{ end }}{{ if .TrailerDist }}
+<h2>Response trailers</h2>
+<table>{{ range $name, $values := .TrailerDist }}{{ range $value, $num := $values }}
+<tr><th>{{ $name }}: {{ $value }}</th><td>{{ $num }} responses</td></tr>{{ end }}{{ end }}
+</table>
+{{ end }}{{ with .Assertions }}
+<h2>Assertions</h2>
+<table>
+<tr><th>Assertion</th><th>Result</th><th>Value</th><th>Samples</th></tr>{{ range . }}
+<tr><td>{{ .Assertion }}</td><td>{{ if .Passed }}passed{{ else }}failed{{ end }}</td><td>{{ if .Samples }}{{ latency .Value }}{{ end }}</td><td>{{ .Samples }}</td></tr>{{ end }}
+</table>
+{{ end }}{{ with .Expect }}
+<h2>Expected outcomes</h2>
+<p>{{ .Expected }} requests had one of the expected status codes {{ range $i, $code := .Codes }}{{ if $i }}, {{ end }}{{ $code }}{{ end }}, {{ .Unexpected }} did not.</p>
+<table>{{ range $code, $num := .UnexpectedDist }}
+<tr><th>{{ if $code }}{{ $code }}{{ else }}error{{ end }}</th><td>{{ $num }} requests</td></tr>{{ end }}
+</table>
+{{ end }}{{ with .HeaderAssertions }}
+<h2>Header assertions</h2>
+<table>
+<tr><th>Assertion</th><th>Responses</th><th>Failed</th><th>Example</th></tr>{{ range . }}
+<tr><td>{{ .Assertion }}</td><td>{{ .Checked }}</td><td>{{ .Failed }}</td><td>{{ .Example }}</td></tr>{{ end }}
+</table>
+{{ end }}{{ with .SecurityHeaders }}
+<h2>Security headers</h2>
+<p>Headers that only some responses had, or with different values, are inconsistent, along with the backends whose responses differed from most.</p>
+<table>
+<tr><th>Header</th><th>Responses with it</th><th>Values</th><th>Differing backends</th></tr>{{ range . }}
+<tr><td>{{ .Name }}</td><td>{{ .Present }}/{{ .Responses }}</td><td>{{ range $v, $n := .Values }}[{{ $n }}] {{ $v }}<br>{{ end }}</td><td>{{ range .Divergent }}{{ .Backend }}:{{ range $v, $n := .Values }} [{{ $n }}] {{ $v }}{{ end }}<br>{{ end }}</td></tr>{{ end }}
+</table>
+{{ end }}{{ with .Golden }}
+<h2>Golden response</h2>
+<p>{{ .Diverged }}/{{ .Compared }} compared responses diverged.</p>
+<table>{{ range $path, $d := .Paths }}
+<tr><th>{{ $path }}</th><td>{{ $d.Count }} responses</td><td>{{ $d.Example }}</td></tr>{{ end }}
+</table>
+{{ end }}{{ range $name, $dist := .ParamDist }}
+<h2>Query parameter {{ $name }}</h2>
+<p>{{ len $dist }} values, slowest first.</p>
+<table>
+<tr><th>Value</th><th>Average</th><th>Slowest</th><th>Requests</th><th>Errors</th></tr>{{ range slowestParams $dist }}
+<tr><th>{{ .Value }}</th><td>{{ latency .Average }}</td><td>{{ latency .Slowest }}</td><td>{{ .Count }}</td><td>{{ .Errors }}</td></tr>{{ end }}
+</table>
+{{ end }}{{ if .StatusSeries }}
+<h2>Status codes over time</h2>
+{{ seriesChart .StatusSeries .Marks }}
+{{ end }}{{ if .Lats }}
+<h2>Latency over time</h2>
+<p>Responses by when they were sent and how long they took, darker cells holding more.</p>
+{{ heatmapChart . }}
+{{ end }}{{ with .EarlyHints }}
+<h2>Early Hints</h2>
+<p>{{ .Hinted }}/{{ .Total }} responses hinted.</p>
+<table>
+<tr><th></th><th>Average</th>{{ range .HintDistribution }}<th>p{{ .Percentage }}</th>{{ end }}</tr>
+<tr><th>Time to 103</th><td>{{ latency .AverageHint }}</td>{{ range .HintDistribution }}<td>{{ latency .Latency }}</td>{{ end }}</tr>
+<tr><th>Time to final</th><td>{{ latency .AverageFinal }}</td>{{ range .FinalDistribution }}<td>{{ latency .Latency }}</td>{{ end }}</tr>
+<tr><th>Resources</th><td colspan="4">{{ .Resources }} hinted, {{ printf "%.2f" .AverageResources }} per response</td></tr>
+</table>
+{{ end }}{{ with .Redirects }}
+<h2>Redirects</h2>
+<p>{{ .Redirected }}/{{ .Total }} responses redirected, with {{ latency .AverageOverhead }} overhead on average and {{ latency .Overhead }} in total.</p>
+<table>
+<tr><th>Chain length</th><th>Responses</th></tr>{{ range $n, $num := .ChainLengths }}
+<tr><th>{{ $n }}</th><td>{{ $num }}</td></tr>{{ end }}
+</table>
+<table>
+<tr><th>Redirected from</th><th>Redirects</th><th>Average</th></tr>{{ range $url, $h := .Hops }}
+<tr><th>{{ $url }}</th><td>{{ $h.Count }}</td><td>{{ latency $h.Average }}</td></tr>{{ end }}
+</table>
+{{ end }}{{ with .Dials }}
+<h2>Dials</h2>
+<table>
+<tr><th>Families</th><td>{{ range $family, $num := .Families }}[{{ $family }}] {{ $num }} {{ end }}</td></tr>
+<tr><th>Fallbacks</th><td>{{ .Fallbacks }} started, {{ .FallbackConnections }} connected over the fallback family</td></tr>
+<tr><th>Conn time</th><td>{{ latency .ConnTime }} average without fallback, {{ latency .FallbackConnTime }} with</td></tr>
+</table>
+{{ end }}{{ with .DialRetries }}
+<h2>Dial retries</h2>
+<p>Failed dials were retried in the first {{ .Grace }} of the run.</p>
+<table>
+<tr><th>Retries</th><td>{{ .Retries }}</td></tr>
+<tr><th>Recovered</th><td>{{ .Recovered }} dials connected after retrying</td></tr>
+<tr><th>Failed</th><td>{{ .Failed }} dials did not</td></tr>
+</table>
+{{ end }}{{ with .DNS }}
+<h2>DNS lookups</h2>
+<table>
+<tr><th>Resolver</th><th>Lookups</th><th>Failed</th><th>Average</th><th>Fastest</th><th>Slowest</th><th>Distribution</th></tr>{{ range $resolver, $l := . }}
+<tr><th>{{ $resolver }}</th><td>{{ $l.Lookups }}</td><td>{{ $l.Failed }}</td><td>{{ latency $l.Average }}</td><td>{{ latency $l.Fastest }}</td><td>{{ latency $l.Slowest }}</td><td>{{ range $l.Distribution }}p{{ .Percentage }} {{ latencyValue .Latency }} {{ end }}</td></tr>{{ end }}
+</table>
+{{ end }}{{ with .Resumption }}
+<h2>TLS resumption</h2>
+<p>{{ .Resumed }}/{{ .Handshakes }} handshakes resumed{{ if .Saved }}, saving {{ latency .Saved }} per resumed connection{{ end }}. Early data is not sent, so resumed handshakes still take a round trip.</p>
+<table>
+<tr><th></th><th>Handshake</th><th>Connection setup</th></tr>
+<tr><th>Full</th><td>{{ latency .FullHandshake }}</td><td>{{ latency .FullConn }}</td></tr>
+<tr><th>Resumed</th><td>{{ latency .ResumedHandshake }}</td><td>{{ latency .ResumedConn }}</td></tr>
+</table>
+{{ end }}{{ with and .Details .ClockSkew }}
+<h2>Clock skew</h2>
+<p>The server clock minus the local clock, by {{ .Samples }} Date headers.</p>
+<table>
+<tr><th>Skew</th><td>{{ printf "%+.4f" .Skew }} secs{{ if .Consistent }}, between {{ printf "%+.4f" .Low }} and {{ printf "%+.4f" .High }}{{ else }}, no single skew fits all Date headers{{ end }}</td></tr>{{ if .Drift }}
+<tr><th>Drift</th><td>{{ printf "%+.4f" .Drift }} secs per hour</td></tr>{{ end }}
+</table>
+{{ end }}{{ with .Outages }}
+<h2>Outages</h2>
+<p>Sending was paused while the target was down.</p>
+<table>
+<tr><th>Down at</th><th>Up at</th><th>Duration</th><th>Probes</th></tr>{{ range . }}
+<tr><td>{{ formatNumber .Start }}</td><td>{{ if .End }}{{ formatNumber .End }}{{ else }}still down{{ end }}</td><td>{{ if .End }}{{ formatNumber .Duration }}{{ end }}</td><td>{{ .Probes }}</td></tr>{{ end }}
+</table>
+{{ end }}{{ with .Marks }}
+<h2>Marks</h2>
+<p>Annotations made while the run proceeded, also drawn on the charts over time.</p>
+<table>
+<tr><th>Offset</th><th>Time</th><th>Mark</th></tr>{{ range . }}
+<tr><td>{{ formatNumber .Offset }} secs</td><td>{{ formatTime .Time }}</td><td>{{ .Text }}</td></tr>{{ end }}
+</table>
+{{ end }}{{ with .Pings }}
+<h2>HTTP/2 PING RTT</h2>
+<p>{{ .Count }} pings acknowledged{{ if .Failed }}, {{ .Failed }} failed{{ end }}.</p>
+<table>
+<tr><th>Average</th><th>Fastest</th><th>Slowest</th>{{ range .Distribution }}<th>p{{ .Percentage }}</th>{{ end }}</tr>
+<tr><td>{{ latency .Average }}</td><td>{{ latency .Fastest }}</td><td>{{ latency .Slowest }}</td>{{ range .Distribution }}<td>{{ latency .Latency }}</td>{{ end }}</tr>
+</table>
+{{ end }}{{ with .KeepAlive }}
+<h2>Keep-alive probes</h2>
+<p>{{ .Probes }} {{ .Method }} requests{{ if .Failed }}, {{ .Failed }} failed{{ end }}.</p>
+<table>
+<tr><th>Alive</th><td>{{ .Alive }}{{ if .Alive }}, idle for up to {{ formatNumber .LongestAlive }} secs{{ end }}</td></tr>
+<tr><th>Closed</th><td>{{ .Closed }}{{ if .Closed }}, idle for {{ formatNumber .ShortestClosed }} secs or more{{ end }}</td></tr>
+</table>
+{{ end }}{{ with .H2 }}
+<h2>HTTP/2 connections</h2>
+<p>{{ .Opened }} opened, {{ .Closed }} closed.</p>
+<table>
+<tr><th>GOAWAY</th><td>{{ .GoAways }} received{{ range $code, $num := .GoAwayCodes }}, [{{ $code }}] {{ $num }}{{ end }}</td></tr>
+<tr><th>Refused streams</th><td>{{ .RefusedStreams }}</td></tr>
+<tr><th>Reset streams</th><td>{{ range $code, $num := .ResetStreams }}[{{ $code }}] {{ $num }} {{ else }}0{{ end }}</td></tr>{{ if .Closed }}
+<tr><th>Lifetime</th><td>{{ formatNumber .AverageLifetime }} secs average, shortest {{ formatNumber .ShortestLifetime }}, longest {{ formatNumber .LongestLifetime }}</td></tr>
+<tr><th>Requests</th><td>{{ printf "%.2f" .AverageRequests }} per closed connection</td></tr>{{ end }}
+</table>
+{{ end }}{{ with .QUIC }}
+<h2>QUIC handshakes</h2>
+<p>{{ .Handshakes }} connections{{ if .Failed }}, {{ .Failed }} failed{{ end }}.</p>{{ if .Handshakes }}
+<table>
+<tr><th>Average</th><th>Fastest</th><th>Slowest</th></tr>
+<tr><td>{{ latency .Average }}</td><td>{{ latency .Fastest }}</td><td>{{ latency .Slowest }}</td></tr>
+</table>{{ end }}
+{{ end }}{{ if and .Details .Transport }}
+<h2>Transport</h2>
+<table>
+<tr><th>Strategy</th><td>{{ .Transport }} ({{ .Transports }} transports)</td></tr>
+<tr><th>Connections</th><td>{{ .Connections }} opened</td></tr>
+</table>
+{{ end }}{{ with and .Details .InFlight }}
+<h2>In-flight requests</h2>
+<table>{{ if .Concurrency }}
+<tr><th>Configured</th><td>{{ .Concurrency }}</td></tr>{{ end }}{{ if .Limit }}
+<tr><th>Limit</th><td>{{ .Limit }} ({{ .Capped }} requests waited for it)</td></tr>{{ end }}
+<tr><th>Average</th><td>{{ printf "%.2f" .Average }}</td></tr>
+<tr><th>Lowest</th><td>{{ printf "%.2f" .Lowest }} (at {{ .LowestOffset }}s)</td></tr>
+</table>
+{{ end }}{{ with .Iterations }}
+<h2>Iterations</h2>
+<p>{{ .PerWorker }} per worker, {{ .Finished }}/{{ .Workers }} workers finished.</p>
+<table>
+<tr><th>Iterations</th><th>Average</th><th>Requests</th><th>Errors</th></tr>{{ range .Buckets }}
+<tr><td>{{ .Iterations }}</td><td>{{ latency .Average }}</td><td>{{ .Count }}</td><td>{{ .Errors }}</td></tr>{{ end }}
+</table>
+{{ end }}{{ with .Pacing }}
+<h2>Pacing</h2>
+<table>{{ if .TargetRate }}
+<tr><th>Target rate</th><td>{{ formatNumber .TargetRate }} req/s</td></tr>{{ end }}
+<tr><th>Actual rate</th><td>{{ formatNumber .ActualRate }} req/s</td></tr>
+<tr><th>Average lag</th><td>{{ latency .AverageLag }}</td></tr>
+<tr><th>Max lag</th><td>{{ latency .MaxLag }}</td></tr>{{ range .LagDistribution }}
+<tr><th>{{ .Percentage }}% lag</th><td>{{ latency .Latency }}</td></tr>{{ end }}{{ if .TargetRate }}
+<tr><th>Late</th><td>{{ .Late }} requests</td></tr>{{ end }}
+</table>
+{{ end }}{{ if gt (len .ErrorDist) 0 }}
+<h2>Error distribution</h2>
+<table>{{ range $err, $num := .ErrorDist }}
+<tr><th>{{ $num }}</th><td>{{ $err }}</td></tr>{{ end }}
+</table>
+{{ end }}
+</body>
+</html>`
 )
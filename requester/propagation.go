@@ -0,0 +1,53 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// Trace context propagation formats.
+const (
+	// PropagationW3C sends a W3C Trace Context traceparent header, and a
+	// tracestate header with Work.TraceState.
+	PropagationW3C = "w3c"
+	// PropagationB3 sends Zipkin's multi-header B3 format.
+	PropagationB3 = "b3"
+)
+
+// propagate starts a new sampled trace for req, sending its context in
+// the Propagation format, and returns the trace ID.
+//
+// IDs are not drawn from Seed, so that they stay unique across runs.
+func (b *Work) propagate(req *http.Request) string {
+	var ids [24]byte
+	crand.Read(ids[:])
+	traceID := hex.EncodeToString(ids[:16])
+	spanID := hex.EncodeToString(ids[16:])
+	switch b.Propagation {
+	case PropagationW3C:
+		req.Header.Set("traceparent", "00-"+traceID+"-"+spanID+"-01")
+		if b.TraceState != "" {
+			req.Header.Set("tracestate", b.TraceState)
+		}
+	case PropagationB3:
+		req.Header.Set("X-B3-TraceId", traceID)
+		req.Header.Set("X-B3-SpanId", spanID)
+		req.Header.Set("X-B3-Sampled", "1")
+	}
+	return traceID
+}
@@ -0,0 +1,102 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"sync"
+	"time"
+)
+
+// QUICReport describes the QUIC handshakes of HTTP/3 connections. Unlike
+// TCP and TLS, QUIC sets up the transport and TLS in a single handshake,
+// so their time is reported here apart from the phases of requests.
+type QUICReport struct {
+	// Handshakes is the number of connections set up, and Failed the
+	// number of handshakes that failed.
+	Handshakes int64
+	Failed     int64
+
+	// Average, Fastest and Slowest handshake times, in seconds.
+	Average float64
+	Fastest float64
+	Slowest float64
+}
+
+// quicStats collects the QUIC handshakes as connections are set up,
+// concurrently with the run.
+type quicStats struct {
+	mu         sync.Mutex
+	handshakes int64
+	failed     int64
+	total      time.Duration
+	fastest    time.Duration
+	slowest    time.Duration
+}
+
+func (q *quicStats) add(d time.Duration, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err != nil {
+		q.failed++
+		return
+	}
+	if q.handshakes == 0 || d < q.fastest {
+		q.fastest = d
+	}
+	q.slowest = max(q.slowest, d)
+	q.handshakes++
+	q.total += d
+}
+
+func (q *quicStats) snapshot() *QUICReport {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.handshakes == 0 && q.failed == 0 {
+		return nil
+	}
+	rep := &QUICReport{Handshakes: q.handshakes, Failed: q.failed}
+	if q.handshakes > 0 {
+		rep.Average = q.total.Seconds() / float64(q.handshakes)
+		rep.Fastest, rep.Slowest = q.fastest.Seconds(), q.slowest.Seconds()
+	}
+	return rep
+}
+
+// mergeQUIC combines the QUIC handshakes of parallel runs.
+func mergeQUIC(a, b *QUICReport) *QUICReport {
+	if b == nil {
+		return a
+	}
+	if a == nil {
+		c := *b
+		return &c
+	}
+	m := &QUICReport{
+		Handshakes: a.Handshakes + b.Handshakes,
+		Failed:     a.Failed + b.Failed,
+		Fastest:    a.Fastest,
+		Slowest:    max(a.Slowest, b.Slowest),
+	}
+	if a.Handshakes == 0 || b.Handshakes > 0 && b.Fastest < a.Fastest {
+		m.Fastest = b.Fastest
+	}
+	if m.Handshakes > 0 {
+		m.Average = (a.Average*float64(a.Handshakes) + b.Average*float64(b.Handshakes)) / float64(m.Handshakes)
+	}
+	return m
+}
@@ -0,0 +1,100 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+)
+
+// rangeBuckets is the number of buckets randomized ranges are grouped into
+// when reporting per-range latencies.
+const rangeBuckets = 10
+
+// RangeBucket holds latency statistics for range requests whose start
+// offset fell within [From, To).
+type RangeBucket struct {
+	Range   string
+	From    int64
+	To      int64
+	Count   int
+	Partial int
+	Average float64
+}
+
+// rangeHeader returns the Range header value for the next request and the
+// start offset of the range, or -1 if the offset is not known.
+func (b *Work) rangeHeader(rnd *rand.Rand) (string, int64) {
+	if b.RangeObjectSize > 0 {
+		length := b.RangeLength
+		if length <= 0 || length > b.RangeObjectSize {
+			length = b.RangeObjectSize
+		}
+		start := rnd.Int63n(b.RangeObjectSize - length + 1)
+		return fmt.Sprintf("bytes=%d-%d", start, start+length-1), start
+	}
+	return b.Range, -1
+}
+
+type rangeStats struct {
+	objectSize int64
+	fixed      string
+	buckets    []RangeBucket
+	total      []float64
+}
+
+func newRangeStats(w *Work) *rangeStats {
+	rs := &rangeStats{objectSize: w.RangeObjectSize, fixed: w.Range}
+	n := 1
+	if rs.objectSize > 0 {
+		n = rangeBuckets
+	}
+	rs.buckets = make([]RangeBucket, n)
+	rs.total = make([]float64, n)
+	for i := range rs.buckets {
+		if rs.objectSize > 0 {
+			rs.buckets[i].From = rs.objectSize * int64(i) / int64(n)
+			rs.buckets[i].To = rs.objectSize * int64(i+1) / int64(n)
+			rs.buckets[i].Range = fmt.Sprintf("%d-%d", rs.buckets[i].From, rs.buckets[i].To)
+		} else {
+			rs.buckets[i].Range = rs.fixed
+		}
+	}
+	return rs
+}
+
+func (rs *rangeStats) add(res *result) {
+	i := 0
+	if rs.objectSize > 0 && res.rangeStart >= 0 {
+		i = int(res.rangeStart * int64(len(rs.buckets)) / rs.objectSize)
+	}
+	rs.buckets[i].Count++
+	if res.statusCode == http.StatusPartialContent {
+		rs.buckets[i].Partial++
+	}
+	rs.total[i] += res.duration.Seconds()
+}
+
+func (rs *rangeStats) snapshot() []RangeBucket {
+	buckets := make([]RangeBucket, len(rs.buckets))
+	copy(buckets, rs.buckets)
+	for i := range buckets {
+		if buckets[i].Count > 0 {
+			buckets[i].Average = rs.total[i] / float64(buckets[i].Count)
+		}
+	}
+	return buckets
+}
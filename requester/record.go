@@ -0,0 +1,308 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Record is the raw result of a single request. The "ndjson" output
+// writes one Record per line as requests complete, which can later be
+// read back with ReadRecords to regenerate reports. Durations and
+// offsets are in seconds. Offsets are from the start of the run, on the
+// monotonic clock, so that they are unaffected by changes of the wall
+// clock, and Time is in UTC unless Work.LocalTime is set.
+type Record struct {
+	Offset   float64 `json:"offset"`
+	Duration float64 `json:"duration"`
+	Conn     float64 `json:"conn"`
+	DNS      float64 `json:"dns"`
+	Dial     float64 `json:"dial,omitempty"`
+	TLS      float64 `json:"tls,omitempty"`
+	ReqWrite float64 `json:"req_write"`
+	Delay    float64 `json:"delay"`
+	ResRead  float64 `json:"res_read"`
+	Status   int     `json:"status,omitempty"`
+	Size     int64   `json:"size,omitempty"`
+	Proto    string  `json:"proto,omitempty"`
+	NewConn  bool    `json:"new_conn,omitempty"`
+	Error    string  `json:"error,omitempty"`
+	Paced    bool    `json:"paced,omitempty"`
+	Lag      float64 `json:"lag,omitempty"`
+	Capped   bool    `json:"capped,omitempty"`
+	Hint     float64 `json:"hint,omitempty"`
+	Hints    int     `json:"hints,omitempty"`
+	Final    float64 `json:"final,omitempty"`
+	TraceID  string  `json:"trace_id,omitempty"`
+	Compared bool    `json:"compared,omitempty"`
+	Diff     string  `json:"diff,omitempty"`
+	URL      string  `json:"url,omitempty"`
+
+	// Worker numbers the worker that sent the request, from 1, and
+	// Iteration the iteration of the worker, with Work.Iterations.
+	Worker    int `json:"worker,omitempty"`
+	Iteration int `json:"iteration,omitempty"`
+
+	// Time is the wall clock time the request was started.
+	Time time.Time `json:"time"`
+
+	// Trailer holds the response trailers.
+	Trailer http.Header `json:"trailer,omitempty"`
+
+	// IdempotencyKey is the Idempotency-Key sent, with
+	// Work.IdempotencyKeys.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+
+	// Params holds the swept and fuzzed query parameters.
+	Params url.Values `json:"params,omitempty"`
+
+	// Capture is the file of Work.CaptureDir the request and response
+	// were saved to, if they were sampled by Work.CaptureSample.
+	Capture string `json:"capture,omitempty"`
+
+	// Body is the name of the body of Work.Bodies sent.
+	Body string `json:"body,omitempty"`
+
+	// Redirects are the redirects followed before the response.
+	Redirects []RedirectHop `json:"redirects,omitempty"`
+
+	// Family is the IP family of the connection opened, IPv4 or IPv6, and
+	// Fallback the family its dial fell back to, if it did.
+	Family   string `json:"family,omitempty"`
+	Fallback string `json:"fallback,omitempty"`
+
+	// Resumed is whether the TLS handshake of the connection opened
+	// resumed a session.
+	Resumed bool `json:"resumed,omitempty"`
+
+	// Date is the Date header of the response, in Unix seconds, if it
+	// had one.
+	Date int64 `json:"date,omitempty"`
+
+	// Probe is whether the request probed a target found down, with
+	// Work.PauseOnDown.
+	Probe bool `json:"probe,omitempty"`
+
+	// ConnID is the ID of the connection the request was sent on, see
+	// Connection.
+	ConnID int64 `json:"conn_id,omitempty"`
+
+	// Resolver is the resolver of the DNS lookup of the request, with
+	// Work.Resolvers or Work.FreshDNS, and DNSFailed whether it failed.
+	Resolver  string `json:"resolver,omitempty"`
+	DNSFailed bool   `json:"dns_failed,omitempty"`
+
+	// Mark, if set, makes this a mark of the run rather than the record of
+	// a request, with Offset and Time when it was made. See Work.Mark.
+	Mark string `json:"mark,omitempty"`
+
+	// Connection, if set, makes this the record of a connection rather
+	// than of a request, with Offset and Time when it was established.
+	Connection *ConnRecord `json:"connection,omitempty"`
+
+	// Schema is only set when a RecordsHeader is read as a record, and
+	// makes it the header of the stream rather than a request.
+	Schema *SchemaInfo `json:"schema,omitempty"`
+}
+
+func (res *result) record() Record {
+	rec := Record{
+		Offset:   res.offset.Seconds(),
+		Duration: res.duration.Seconds(),
+		Conn:     res.connDuration.Seconds(),
+		DNS:      res.dnsDuration.Seconds(),
+		Dial:     res.dialDuration.Seconds(),
+		TLS:      res.tlsDuration.Seconds(),
+		ReqWrite: res.reqDuration.Seconds(),
+		Delay:    res.delayDuration.Seconds(),
+		ResRead:  res.resDuration.Seconds(),
+		Status:   res.statusCode,
+		Size:     res.contentLength,
+		Proto:    res.proto,
+		NewConn:  res.newConn,
+		Paced:    res.paced,
+		Lag:      res.lag.Seconds(),
+		Capped:   res.capped,
+		Hint:     res.earlyHint.Seconds(),
+		Hints:    res.hintLinks,
+		Final:    res.final.Seconds(),
+		TraceID:  res.traceID,
+		Compared: res.compared,
+		Diff:     res.diff,
+		URL:      res.target,
+	}
+	rec.Worker, rec.Iteration = res.worker, res.iteration
+	rec.Time = res.sent
+	rec.Trailer = res.trailer
+	rec.IdempotencyKey = res.idemKey
+	rec.Params = res.params
+	rec.Capture = res.capture
+	rec.Body = res.body
+	rec.Family, rec.Fallback = res.family, res.fallback
+	rec.Resumed = res.resumed
+	rec.Date = res.date
+	rec.Probe = res.probe
+	rec.Mark = res.mark
+	rec.Resolver, rec.DNSFailed = res.resolver, res.dnsFailed
+	rec.ConnID, rec.Connection = res.connID, res.conn
+	for _, hop := range res.redirects {
+		rec.Redirects = append(rec.Redirects, RedirectHop{URL: hop.url, Duration: hop.duration.Seconds()})
+	}
+	if res.err != nil {
+		rec.Error = res.err.Error()
+	}
+	return rec
+}
+
+func (rec Record) result() *result {
+	res := &result{
+		offset:        seconds(rec.Offset),
+		duration:      seconds(rec.Duration),
+		connDuration:  seconds(rec.Conn),
+		dnsDuration:   seconds(rec.DNS),
+		dialDuration:  seconds(rec.Dial),
+		tlsDuration:   seconds(rec.TLS),
+		reqDuration:   seconds(rec.ReqWrite),
+		delayDuration: seconds(rec.Delay),
+		resDuration:   seconds(rec.ResRead),
+		statusCode:    rec.Status,
+		contentLength: rec.Size,
+		proto:         rec.Proto,
+		newConn:       rec.NewConn,
+		paced:         rec.Paced,
+		lag:           seconds(rec.Lag),
+		capped:        rec.Capped,
+		worker:        rec.Worker,
+		iteration:     rec.Iteration,
+		sent:          rec.Time,
+		earlyHint:     seconds(rec.Hint),
+		hintLinks:     rec.Hints,
+		final:         seconds(rec.Final),
+		trailer:       rec.Trailer,
+		traceID:       rec.TraceID,
+		idemKey:       rec.IdempotencyKey,
+		params:        rec.Params,
+		compared:      rec.Compared,
+		diff:          rec.Diff,
+		target:        rec.URL,
+		capture:       rec.Capture,
+		body:          rec.Body,
+		family:        rec.Family,
+		fallback:      rec.Fallback,
+		resumed:       rec.Resumed,
+		date:          rec.Date,
+		probe:         rec.Probe,
+		mark:          rec.Mark,
+		resolver:      rec.Resolver,
+		dnsFailed:     rec.DNSFailed,
+		connID:        rec.ConnID,
+		conn:          rec.Connection,
+	}
+	for _, hop := range rec.Redirects {
+		res.redirects = append(res.redirects, redirectHop{url: hop.URL, duration: seconds(hop.Duration)})
+	}
+	if rec.Error != "" {
+		res.err = errors.New(rec.Error)
+	}
+	return res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// ReadRecords reads newline-delimited JSON records, as written by the
+// "ndjson" output. Headers are checked against SchemaVersion and left
+// out.
+func ReadRecords(r io.Reader) ([]Record, error) {
+	var records []Record
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if h := rec.Schema; h != nil {
+			if h.Name != RecordsSchema || h.Version > SchemaVersion {
+				return nil, fmt.Errorf("line %d: unsupported schema %s version %d; want %s up to version %d", line, h.Name, h.Version, RecordsSchema, SchemaVersion)
+			}
+			continue
+		}
+		records = append(records, rec)
+	}
+	return records, sc.Err()
+}
+
+// ReportFromRecords computes a report out of previously saved records,
+// with the given latency percentiles (DefaultPercentiles if empty). The
+// total duration of the run is taken to be the time from the first
+// request being sent to the last one completing. Records of connections
+// are ignored.
+func ReportFromRecords(records []Record, percentiles []float64) Report {
+	results := make(chan *result, len(records))
+	var first, last time.Duration
+	var requests int
+	for _, rec := range records {
+		if rec.Connection != nil {
+			continue
+		}
+		res := rec.result()
+		if rec.Mark != "" {
+			results <- res
+			continue
+		}
+		if requests == 0 || res.offset < first {
+			first = res.offset
+		}
+		if end := res.offset + res.duration; end > last {
+			last = end
+		}
+		results <- res
+		requests++
+	}
+	close(results)
+
+	r := newReport(ioutil.Discard, results, "", len(records))
+	r.percentiles = percentiles
+	r.start = first
+	for _, rec := range records {
+		if rec.Paced {
+			// The rate limit is not recorded, only the lags.
+			r.pacing = &pacingStats{}
+			break
+		}
+	}
+	for _, rec := range records {
+		if rec.Compared {
+			r.golden = &GoldenReport{}
+			break
+		}
+	}
+	runReporter(r)
+	r.calculate(last - first)
+	return r.snapshot()
+}
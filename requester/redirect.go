@@ -0,0 +1,182 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// maxRedirects is the number of redirects followed, as by the default
+// policy of http.Client.
+const maxRedirects = 10
+
+// maxRedirectURLs caps the distinct URLs hops are reported by. Further
+// URLs are counted as otherRedirectURL.
+const maxRedirectURLs = 100
+
+const otherRedirectURL = "(other)"
+
+// redirectChain records the redirects followed by a request.
+type redirectChain struct {
+	last time.Duration // time the current hop was sent
+	hops []redirectHop
+}
+
+// redirectHop is a request that was redirected.
+type redirectHop struct {
+	url      string
+	duration time.Duration
+}
+
+type redirectChainKey struct{}
+
+// RedirectHop is a redirect followed by a request, as recorded.
+type RedirectHop struct {
+	URL      string  `json:"url"`      // redirected from, without its query
+	Duration float64 `json:"duration"` // until the redirect, in seconds
+}
+
+// checkRedirect follows redirects as http.Client does by default,
+// recording the hops of requests with a redirectChain.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return errors.New("stopped after 10 redirects")
+	}
+	if chain, ok := req.Context().Value(redirectChainKey{}).(*redirectChain); ok {
+		t := now()
+		chain.hops = append(chain.hops, redirectHop{
+			url:      targetOf(via[len(via)-1].URL),
+			duration: t - chain.last,
+		})
+		chain.last = t
+	}
+	return nil
+}
+
+// RedirectReport describes the redirects followed before final responses.
+type RedirectReport struct {
+	// Redirected counts the requests that were redirected, out of Total.
+	Redirected int64
+	Total      int64
+
+	// Overhead is the time spent on redirects before the final responses,
+	// in seconds, and AverageOverhead that per redirected request.
+	Overhead        float64
+	AverageOverhead float64
+
+	// ChainLengths counts redirected requests by the number of redirects
+	// they followed.
+	ChainLengths map[int]int64
+
+	// Hops summarizes the redirects by the URL redirected from, without
+	// its query. URLs beyond the first 100 are summarized as "(other)".
+	Hops map[string]RedirectURL
+}
+
+// RedirectURL summarizes the redirects from a URL.
+type RedirectURL struct {
+	Count int64
+	Total float64 // sum of latencies
+}
+
+// Average returns the average latency of the redirects.
+func (h RedirectURL) Average() float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Total / float64(h.Count)
+}
+
+type redirectStats struct {
+	total      int64
+	redirected int64
+	overhead   time.Duration
+	lengths    map[int]int64
+	hops       map[string]RedirectURL
+}
+
+func (r *redirectStats) add(res *result) {
+	if res.err != nil {
+		return
+	}
+	r.total++
+	if len(res.redirects) == 0 {
+		return
+	}
+	r.redirected++
+	if r.lengths == nil {
+		r.lengths = make(map[int]int64)
+		r.hops = make(map[string]RedirectURL)
+	}
+	r.lengths[len(res.redirects)]++
+	for _, hop := range res.redirects {
+		r.overhead += hop.duration
+		countRedirect(r.hops, hop.url, RedirectURL{Count: 1, Total: hop.duration.Seconds()})
+	}
+}
+
+func countRedirect(hops map[string]RedirectURL, url string, h RedirectURL) {
+	if _, ok := hops[url]; !ok && len(hops) >= maxRedirectURLs {
+		url = otherRedirectURL
+	}
+	sum := hops[url]
+	sum.Count += h.Count
+	sum.Total += h.Total
+	hops[url] = sum
+}
+
+func (r *redirectStats) snapshot() *RedirectReport {
+	if r.redirected == 0 {
+		return nil
+	}
+	rep := &RedirectReport{
+		Redirected:   r.redirected,
+		Total:        r.total,
+		Overhead:     r.overhead.Seconds(),
+		ChainLengths: make(map[int]int64, len(r.lengths)),
+		Hops:         make(map[string]RedirectURL, len(r.hops)),
+	}
+	rep.AverageOverhead = rep.Overhead / float64(rep.Redirected)
+	for n, count := range r.lengths {
+		rep.ChainLengths[n] = count
+	}
+	for url, h := range r.hops {
+		rep.Hops[url] = h
+	}
+	return rep
+}
+
+// mergeRedirects adds the redirects of b to a.
+func mergeRedirects(a, b *RedirectReport) *RedirectReport {
+	if b == nil {
+		return a
+	}
+	if a == nil {
+		a = &RedirectReport{ChainLengths: make(map[int]int64), Hops: make(map[string]RedirectURL)}
+	}
+	a.Redirected += b.Redirected
+	a.Total += b.Total
+	a.Overhead += b.Overhead
+	a.AverageOverhead = a.Overhead / float64(a.Redirected)
+	for n, count := range b.ChainLengths {
+		a.ChainLengths[n] += count
+	}
+	for url, h := range b.Hops {
+		countRedirect(a.Hops, url, h)
+	}
+	return a
+}
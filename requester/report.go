@@ -16,9 +16,12 @@ package requester
 
 import (
 	"bytes"
-	"fmt"
+	"context"
+	"encoding/json"
+	"errors"
 	"io"
 	"log"
+	"math"
 	"sort"
 	"time"
 )
@@ -30,6 +33,9 @@ const (
 // We report for max 1M results.
 const maxRes = 1000000
 
+// DefaultPercentiles are the latency percentiles reported by default.
+var DefaultPercentiles = []float64{10, 25, 50, 75, 90, 95, 99}
+
 type report struct {
 	avgTotal float64
 	fastest  float64
@@ -44,11 +50,14 @@ type report struct {
 	avgDelay    float64
 	connLats    []float64
 	dnsLats     []float64
+	dialLats    []float64
+	tlsLats     []float64
 	reqLats     []float64
 	resLats     []float64
 	delayLats   []float64
 	offsets     []float64
 	statusCodes []int
+	timestamps  []time.Time
 
 	results chan *result
 	done    chan bool
@@ -58,18 +67,132 @@ type report struct {
 	lats      []float64
 	sizeTotal int64
 	numRes    int64
+	responded int64 // results with a response, errors or not
 	output    string
 
-	w io.Writer
+	compressedTotal   int64
+	decompressedTotal int64
+	decompressTotal   float64
+	numDecoded        int64
+	numUndecoded      int64
+
+	protoDist  map[string]int
+	connProtos map[string]int
+
+	certs    []CertificateInfo
+	certWarn time.Duration
+	ocsp     *OCSPInfo
+
+	ranges *rangeStats
+	slo    *sloStats
+	pacing *pacingStats
+
+	// assertions are checked as the report is taken, asserted their
+	// results the last time it was.
+	assertions []Assertion
+	asserted   []AssertionResult
+
+	iterations  iterationStats
+	earlyHints  earlyHintStats
+	redirects   redirectStats
+	pings       *pingStats      // set with Work.PingInterval
+	keepalive   *keepAliveStats // set with Work.KeepAliveProbe
+	h2          *h2Stats        // set with Work.H2
+	quic        *quicStats      // set with Work.H3
+	dials       dialStats
+	dialRetries *DialRetryReport
+	resumption  resumptionStats
+	clock       clockStats
+	outages     outageStats
+	marks       []Mark
+	dns         dnsStats
+	outliers    outlierStats
+
+	// transport is the transport sharing strategy, transports the number
+	// of HTTP transports used, and conns the number of connections opened.
+	transport  string
+	transports int
+	conns      int64
+
+	// maxBytes is the data budget of the run, received the response body
+	// bytes received against it.
+	maxBytes int64
+	received int64
+
+	abortDist map[string]int
+	trailers  trailerStats
+	params    paramStats
+	golden    *GoldenReport
+	expect    *ExpectReport
+	headers   headerAssertStats
+	security  securityStats
+	targets   targetStats
+	drain     DrainPhase
+	series    statusSeries
+	inflight  inflightStats
+	workers   int           // configured concurrency, 0 if unknown
+	start     time.Duration // offset of the start of the run
+
+	// percentiles are reported in the latency distribution, in increasing
+	// order. If empty, DefaultPercentiles are used.
+	percentiles []float64
+	sketch      *Sketch
+
+	// records streams raw results for the "ndjson" output.
+	records *json.Encoder
+	// kept holds the records of requests with Work.JSONRecords, for the
+	// "json" output.
+	keepRecords bool
+	kept        []Record
+	sinks       *sinkWriter
+	spools      *sinkWriter
+
+	// trace streams raw results as trace events for the "trace" output.
+	trace *traceWriter
+
+	snapshots *snapshotter
+
+	w     io.Writer
+	quiet bool // whether the summary is left unprinted
+	local bool // whether times are in the local time zone rather than UTC
+
+	timeUnit string // unit latencies are printed in, see Work.TimeUnit
+	details  bool   // see Work.Details
+}
+
+// record returns the record of res, with its offset from the start of
+// the run and its time in the time zone of the outputs.
+func (r *report) record(res *result) Record {
+	rec := res.record()
+	rec.Offset = (res.offset - r.start).Seconds()
+	rec.Time = r.stamp(res.sent)
+	return rec
+}
+
+// stamp returns t in the time zone of the outputs, UTC unless
+// Work.LocalTime is set.
+func (r *report) stamp(t time.Time) time.Time {
+	if r.local {
+		return t.Local()
+	}
+	return t.UTC()
 }
 
 func newReport(w io.Writer, results chan *result, output string, n int) *report {
 	cap := min(n, maxRes)
-	return &report{
+	r := &report{
 		output:      output,
 		results:     results,
 		done:        make(chan bool, 1),
 		errorDist:   make(map[string]int),
+		dns:         make(dnsStats),
+		protoDist:   make(map[string]int),
+		connProtos:  make(map[string]int),
+		abortDist:   make(map[string]int),
+		trailers:    make(trailerStats),
+		params:      make(paramStats),
+		targets:     make(targetStats),
+		sketch:      NewSketch(DefaultSketchAccuracy),
 		w:           w,
 		connLats:    make([]float64, 0, cap),
 		dnsLats:     make([]float64, 0, cap),
@@ -79,16 +202,76 @@ func newReport(w io.Writer, results chan *result, output string, n int) *report
 		lats:        make([]float64, 0, cap),
 		statusCodes: make([]int, 0, cap),
 	}
+	if output == "ndjson" {
+		r.records = json.NewEncoder(w)
+		r.records.Encode(NewRecordsHeader())
+	}
+	if output == "trace" {
+		r.trace = newTraceWriter(w)
+	}
+	return r
 }
 
 func runReporter(r *report) {
 	// Loop will continue until channel is closed
 	for res := range r.results {
+		if r.records != nil {
+			r.records.Encode(r.record(res))
+		}
+		if r.trace != nil {
+			r.trace.write(r.record(res))
+		}
+		if r.sinks != nil {
+			r.sinks.write(r.record(res))
+		}
+		if r.spools != nil {
+			r.spools.write(r.record(res))
+		}
+		if res.mark != "" {
+			r.marks = append(r.marks, Mark{Offset: (res.offset - r.start).Seconds(), Time: r.stamp(res.sent), Text: res.mark})
+			continue
+		}
+		if res.conn != nil {
+			// Connections are only in the raw results.
+			continue
+		}
 		r.numRes++
-		if res.err != nil {
-			r.errorDist[res.err.Error()]++
+		if res.statusCode != 0 {
+			r.responded++
+		}
+		if r.keepRecords && len(r.kept) < maxRes {
+			r.kept = append(r.kept, r.record(res))
+		}
+		if r.snapshots != nil {
+			r.snapshots.add(res)
+		}
+		r.series.add(res, r.start)
+		r.inflight.add(res, r.start)
+		r.iterations.add(res)
+		r.earlyHints.add(res)
+		r.redirects.add(res)
+		r.dials.add(res)
+		r.dns.add(res)
+		r.resumption.add(res)
+		r.clock.add(res)
+		r.outages.add(res, r.start)
+		if r.pacing != nil {
+			r.pacing.add(res)
+		}
+		if res.drained {
+			r.drain.add(res)
+			continue
+		}
+		if r.slo != nil {
+			r.slo.add(res)
+		}
+		if res.aborted != "" {
+			r.abortDist[res.aborted]++
+		} else if res.err != nil {
+			r.errorDist[fingerprintError(res.err.Error())]++
 		} else {
 			r.avgTotal += res.duration.Seconds()
+			r.sketch.Add(res.duration.Seconds())
 			r.avgConn += res.connDuration.Seconds()
 			r.avgDelay += res.delayDuration.Seconds()
 			r.avgDNS += res.dnsDuration.Seconds()
@@ -98,22 +281,81 @@ func runReporter(r *report) {
 				r.lats = append(r.lats, res.duration.Seconds())
 				r.connLats = append(r.connLats, res.connDuration.Seconds())
 				r.dnsLats = append(r.dnsLats, res.dnsDuration.Seconds())
+				r.dialLats = append(r.dialLats, res.dialDuration.Seconds())
+				r.tlsLats = append(r.tlsLats, res.tlsDuration.Seconds())
 				r.reqLats = append(r.reqLats, res.reqDuration.Seconds())
 				r.delayLats = append(r.delayLats, res.delayDuration.Seconds())
 				r.resLats = append(r.resLats, res.resDuration.Seconds())
 				r.statusCodes = append(r.statusCodes, res.statusCode)
-				r.offsets = append(r.offsets, res.offset.Seconds())
+				r.timestamps = append(r.timestamps, r.stamp(res.sent))
+				r.offsets = append(r.offsets, (res.offset - r.start).Seconds())
+				r.outliers.add(res)
 			}
 			if res.contentLength > 0 {
 				r.sizeTotal += res.contentLength
 			}
+			if r.ranges != nil && res.ranged {
+				r.ranges.add(res)
+			}
+		}
+		if res.newConn {
+			r.conns++
+		}
+		r.trailers.add(res.trailer)
+		r.params.add(res)
+		r.golden.add(res)
+		r.expect.add(res)
+		r.headers.add(res)
+		r.security.add(res)
+		r.targets.add(res)
+		if res.proto != "" {
+			r.protoDist[res.proto]++
+			if res.newConn {
+				r.connProtos[res.proto]++
+			}
+		}
+		if eb := res.encoded; eb != nil {
+			r.compressedTotal += eb.compressed
+			if eb.decoded {
+				r.numDecoded++
+				r.decompressedTotal += eb.decompressed
+				r.decompressTotal += eb.duration.Seconds()
+			} else {
+				r.numUndecoded++
+			}
+		}
+	}
+	if r.trace != nil {
+		if err := r.trace.close(); err != nil {
+			log.Println("error:", err.Error())
 		}
 	}
+	if r.sinks != nil {
+		r.sinks.close()
+	}
+	if r.spools != nil {
+		r.spools.close()
+	}
+	if r.snapshots != nil {
+		r.snapshots.close()
+	}
 	// Signal reporter is done.
 	r.done <- true
 }
 
 func (r *report) finalize(total time.Duration) {
+	r.calculate(total)
+	if r.quiet {
+		return
+	}
+	if r.records == nil && r.trace == nil {
+		r.print()
+	} else if len(r.assertions) > 0 {
+		r.snapshot()
+	}
+}
+
+func (r *report) calculate(total time.Duration) {
 	r.total = total
 	r.rps = float64(r.numRes) / r.total.Seconds()
 	r.average = r.avgTotal / float64(len(r.lats))
@@ -122,22 +364,34 @@ func (r *report) finalize(total time.Duration) {
 	r.avgDNS = r.avgDNS / float64(len(r.lats))
 	r.avgReq = r.avgReq / float64(len(r.lats))
 	r.avgRes = r.avgRes / float64(len(r.lats))
-	r.print()
 }
 
 func (r *report) print() {
-	buf := &bytes.Buffer{}
-	if err := newTemplate(r.output).Execute(buf, r.snapshot()); err != nil {
+	if err := PrintReport(r.w, r.snapshot(), r.output); err != nil {
 		log.Println("error:", err.Error())
-		return
 	}
-	r.printf(buf.String())
-
-	r.printf("\n")
 }
 
-func (r *report) printf(s string, v ...interface{}) {
-	fmt.Fprintf(r.w, s, v...)
+// PrintReport writes rep to w in the given output format, as the run
+// summary would be.
+func PrintReport(w io.Writer, rep Report, output string) error {
+	switch output {
+	case "hdr":
+		return writeHDR(w, rep)
+	case "json":
+		return writeSummary(w, rep)
+	case "png":
+		return writeHeatmapPNG(w, rep)
+	}
+	buf := &bytes.Buffer{}
+	if err := newExecutor(output, rep.TimeUnit).Execute(buf, rep); err != nil {
+		return err
+	}
+	// The output is written as is; it may contain '%' from percentiles,
+	// SLO specs or error messages.
+	buf.WriteString("\n")
+	_, err := w.Write(buf.Bytes())
+	return err
 }
 
 func (r *report) snapshot() Report {
@@ -146,6 +400,8 @@ func (r *report) snapshot() Report {
 		Average:     r.average,
 		Rps:         r.rps,
 		SizeTotal:   r.sizeTotal,
+		MaxBytes:    r.maxBytes,
+		Received:    r.received,
 		AvgConn:     r.avgConn,
 		AvgDNS:      r.avgDNS,
 		AvgReq:      r.avgReq,
@@ -157,14 +413,87 @@ func (r *report) snapshot() Report {
 		Lats:        make([]float64, len(r.lats)),
 		ConnLats:    make([]float64, len(r.lats)),
 		DnsLats:     make([]float64, len(r.lats)),
+		DialLats:    make([]float64, len(r.lats)),
+		TLSLats:     make([]float64, len(r.lats)),
 		ReqLats:     make([]float64, len(r.lats)),
 		ResLats:     make([]float64, len(r.lats)),
 		DelayLats:   make([]float64, len(r.lats)),
 		Offsets:     make([]float64, len(r.lats)),
 		StatusCodes: make([]int, len(r.lats)),
+		Timestamps:  make([]time.Time, len(r.lats)),
+		TimeUnit:    r.timeUnit,
+		Details:     r.details,
+	}
+
+	if r.slo != nil {
+		snapshot.SLO = r.slo.snapshot()
+	}
+	if r.ranges != nil {
+		snapshot.RangeDist = r.ranges.snapshot()
+	}
+	snapshot.Sketch = r.sketch
+	snapshot.Drain = r.drain
+	if r.drain.Completed > 0 {
+		snapshot.Drain.Average /= float64(r.drain.Completed)
+	}
+	snapshot.AbortDist = r.abortDist
+	snapshot.TrailerDist = r.trailers
+	snapshot.ParamDist = r.params
+	snapshot.TargetDist = r.targets
+	for _, t := range r.targets {
+		if r.total > 0 {
+			t.Rps = float64(t.Requests) / r.total.Seconds()
+		}
+	}
+	if r.golden != nil {
+		g := *r.golden
+		snapshot.Golden = &g
+	}
+	if r.expect != nil {
+		snapshot.Expect = mergeExpect(nil, r.expect)
+	}
+	snapshot.HeaderAssertions = r.headers.snapshot()
+	snapshot.SecurityHeaders = r.security.snapshot()
+	snapshot.StatusSeries = r.series
+	snapshot.InFlight = r.inflight.snapshot(r.total, r.workers)
+	if r.pacing != nil {
+		snapshot.Pacing = r.pacing.snapshot(r.total)
+	}
+	snapshot.Iterations = r.iterations.snapshot()
+	snapshot.EarlyHints = r.earlyHints.snapshot()
+	snapshot.Redirects = r.redirects.snapshot()
+	snapshot.Pings = r.pings.snapshot()
+	snapshot.Records = r.kept
+	snapshot.KeepAlive = r.keepalive.snapshot()
+	snapshot.H2 = r.h2.snapshot()
+	snapshot.QUIC = r.quic.snapshot()
+	snapshot.Dials = r.dials.snapshot()
+	snapshot.DialRetries = r.dialRetries
+	snapshot.Resumption = r.resumption.snapshot()
+	snapshot.ClockSkew = r.clock.snapshot()
+	snapshot.Outages = r.outages.snapshot()
+	snapshot.Marks = r.marks
+	if r.sinks != nil {
+		snapshot.SinkDropped = r.sinks.dropped
+	}
+	snapshot.DNS = r.dns.snapshot()
+	snapshot.Transport = r.transport
+	snapshot.Transports = r.transports
+	snapshot.Connections = r.conns
+	snapshot.Certificates = r.certs
+	snapshot.OCSP = r.ocsp
+	snapshot.CertExpiryWarning = certExpiryWarning(r.certs, r.certWarn, time.Now())
+	snapshot.ProtoDist = r.protoDist
+	snapshot.ConnProtoDist = r.connProtos
+	snapshot.CompressedTotal = r.compressedTotal
+	snapshot.DecompressedTotal = r.decompressedTotal
+	snapshot.UndecodedRes = r.numUndecoded
+	if r.numDecoded > 0 {
+		snapshot.AvgDecompress = r.decompressTotal / float64(r.numDecoded)
 	}
 
 	if len(r.lats) == 0 {
+		r.assert(&snapshot)
 		return snapshot
 	}
 
@@ -173,11 +502,15 @@ func (r *report) snapshot() Report {
 	copy(snapshot.Lats, r.lats)
 	copy(snapshot.ConnLats, r.connLats)
 	copy(snapshot.DnsLats, r.dnsLats)
+	copy(snapshot.DialLats, r.dialLats)
+	copy(snapshot.TLSLats, r.tlsLats)
 	copy(snapshot.ReqLats, r.reqLats)
 	copy(snapshot.ResLats, r.resLats)
 	copy(snapshot.DelayLats, r.delayLats)
 	copy(snapshot.StatusCodes, r.statusCodes)
 	copy(snapshot.Offsets, r.offsets)
+	copy(snapshot.Timestamps, r.timestamps)
+	snapshot.Outliers = r.outliers.snapshot(&snapshot)
 
 	sort.Float64s(r.lats)
 	r.fastest = r.lats[0]
@@ -211,24 +544,44 @@ func (r *report) snapshot() Report {
 	}
 	snapshot.StatusCodeDist = statusCodeDist
 
+	r.assert(&snapshot)
 	return snapshot
 }
 
+// assert checks the assertions of the run against snapshot.
+func (r *report) assert(snapshot *Report) {
+	if len(r.assertions) == 0 {
+		return
+	}
+	snapshot.Assertions = checkAssertions(r.assertions, snapshot)
+	r.asserted = snapshot.Assertions
+}
+
 func (r *report) latencies() []LatencyDistribution {
-	pctls := []int{10, 25, 50, 75, 90, 95, 99}
+	pctls := r.percentiles
+	if len(pctls) == 0 {
+		pctls = DefaultPercentiles
+	}
 	data := make([]float64, len(pctls))
 	j := 0
 	for i := 0; i < len(r.lats) && j < len(pctls); i++ {
-		current := i * 100 / len(r.lats)
+		current := float64(i) * 100 / float64(len(r.lats))
 		if current >= pctls[j] {
 			data[j] = r.lats[i]
 			j++
 		}
 	}
+	// Percentiles too high for the samples are at most the slowest, and
+	// flagged as such by their confidence interval.
+	for ; j < len(pctls) && len(r.lats) > 0; j++ {
+		data[j] = r.lats[len(r.lats)-1]
+	}
 	res := make([]LatencyDistribution, len(pctls))
 	for i := 0; i < len(pctls); i++ {
 		if data[i] > 0 {
-			res[i] = LatencyDistribution{Percentage: pctls[i], Latency: data[i]}
+			res[i] = withConfidence(LatencyDistribution{Percentage: pctls[i], Latency: data[i]}, len(r.lats), func(rank int) float64 {
+				return r.lats[rank]
+			})
 		}
 	}
 	return res
@@ -290,14 +643,21 @@ type Report struct {
 	DelayMax float64
 	DelayMin float64
 
+	// Records are the raw results of the requests, in the order they
+	// completed; nil unless Work.JSONRecords was set.
+	Records []Record
+
 	Lats        []float64
 	ConnLats    []float64
 	DnsLats     []float64
+	DialLats    []float64 // TCP connect, part of ConnLats
+	TLSLats     []float64 // TLS handshake, part of ConnLats
 	ReqLats     []float64
 	ResLats     []float64
 	DelayLats   []float64
 	Offsets     []float64
 	StatusCodes []int
+	Timestamps  []time.Time // wall clock time requests were started, see Work.LocalTime
 
 	Total time.Duration
 
@@ -307,13 +667,218 @@ type Report struct {
 	SizeReq        int64
 	NumRes         int64
 
+	// CompressedTotal and DecompressedTotal are the response body sizes
+	// before and after decoding; they are only tracked when
+	// Work.AcceptEncoding is set. UndecodedRes counts responses with an
+	// unsupported Content-Encoding, which are not part of
+	// DecompressedTotal.
+	CompressedTotal   int64
+	DecompressedTotal int64
+	AvgDecompress     float64
+	UndecodedRes      int64
+
+	// MaxBytes is the data budget of the run, see Work.MaxBytes, and
+	// Received the response body bytes received against it. Both are
+	// zero without a budget.
+	MaxBytes int64
+	Received int64
+
+	// ProtoDist counts responses by the protocol they were served over,
+	// ConnProtoDist counts new connections by negotiated protocol.
+	ProtoDist     map[string]int
+	ConnProtoDist map[string]int
+
+	// SLO is the error budget burn of the run; nil if no SLO was given.
+	SLO *SLOReport
+
+	// Assertions are the results of Work.Assertions; nil if none were
+	// given. They are not merged across reports.
+	Assertions []AssertionResult
+
+	// RangeDist holds per-range latencies of range requests. Randomized
+	// ranges are grouped by their start offset.
+	RangeDist []RangeBucket
+
+	// Sketch holds the latencies of all successful requests, including
+	// those beyond the ones kept in Lats, in a mergeable form.
+	Sketch *Sketch
+
+	// Drain holds requests that completed, or were cancelled, after the
+	// run was stopped. They are not part of the other statistics.
+	Drain DrainPhase
+
+	// AbortDist counts requests deliberately aborted by chaos, by phase.
+	AbortDist map[string]int
+
+	// TrailerDist counts response trailers by name and value. Values of a
+	// trailer beyond the first 10 distinct ones are counted as "(other)".
+	TrailerDist map[string]map[string]int
+
+	// ParamDist tracks requests by the values of swept and fuzzed query
+	// parameters. Values of a parameter beyond the first 10000 distinct
+	// ones are tracked as "(other)".
+	ParamDist map[string]map[string]ParamValue
+
+	// Golden summarizes how responses compared to Work.Golden; nil if
+	// they were not compared.
+	Golden *GoldenReport
+
+	// Expect summarizes how requests matched Work.ExpectStatus; nil
+	// without expected status codes.
+	Expect *ExpectReport
+
+	// HeaderAssertions summarize how responses satisfied
+	// Work.HeaderAssertions; nil without header assertions.
+	HeaderAssertions []HeaderAssertionReport
+
+	// SecurityHeaders audit the security headers of the responses, with
+	// Work.SecurityHeaders; nil otherwise.
+	SecurityHeaders []*SecurityHeaderReport
+
+	// TargetDist summarizes requests by target URL, without its query,
+	// with Work.URLs or a Work.RequestFunc. Targets beyond the first 100
+	// are summarized as "(other)".
+	TargetDist map[string]*TargetStats
+
+	// StatusSeries counts responses by status code in one-second
+	// intervals of the time their request was sent.
+	StatusSeries []StatusBucket
+
+	// InFlight is the number of requests actually in flight over time.
+	InFlight *InFlight
+
+	// Pacing is how closely rate limited requests kept to their
+	// schedule; nil if there was no rate limit.
+	Pacing *PacingReport
+
+	// Iterations holds per-iteration results of runs where each worker
+	// made a set number of iterations; nil otherwise.
+	Iterations *IterationReport
+
+	// EarlyHints describes 103 Early Hints responses; nil if there were
+	// none.
+	EarlyHints *EarlyHintsReport
+
+	// Redirects describes the redirects followed; nil if there were none.
+	Redirects *RedirectReport
+
+	// Dials describes the IP families connections were opened over and
+	// the Happy Eyeballs fallbacks; nil if all were over IPv4 without a
+	// fallback.
+	Dials *DialReport
+
+	// DialRetries describes the failed dials retried in the grace period
+	// of Work.DialGrace; nil if none were.
+	DialRetries *DialRetryReport
+
+	// Resumption describes TLS session resumption; nil unless sessions
+	// were resumed or Work.TLSResume was set.
+	Resumption *ResumptionReport
+
+	// ClockSkew describes how far the clock of the server was from the
+	// local clock, by the Date headers of responses; nil if none had one.
+	ClockSkew *ClockSkewReport
+
+	// Outages are the windows the target was down in, with
+	// Work.PauseOnDown; nil if it never was.
+	Outages []Outage
+
+	// Marks are the annotations made with Work.Mark, in the order they
+	// were made.
+	Marks []Mark
+
+	// SinkDropped is the number of records not given to Work.Sinks, as
+	// they arrived while the sinks were too far behind.
+	SinkDropped int64
+
+	// DNS describes the DNS lookups by resolver, SystemResolver for the
+	// resolver of the system; nil unless Work.Resolvers or Work.FreshDNS
+	// was set.
+	DNS map[string]*LookupReport
+
+	// Pings describes the round trip times of HTTP/2 PING frames; nil
+	// unless Work.PingInterval was set.
+	Pings *PingReport
+
+	// KeepAlive describes the keep-alive probes sent in think time pauses;
+	// nil unless Work.KeepAliveProbe was set.
+	KeepAlive *KeepAliveReport
+
+	// H2 describes the lifecycle of HTTP/2 connections; nil unless
+	// Work.H2 was set and connections negotiated HTTP/2.
+	H2 *H2Report
+
+	// QUIC describes the QUIC handshakes of HTTP/3 connections; nil
+	// unless Work.H3 was set.
+	QUIC *QUICReport
+
+	// Transport is the transport sharing strategy, empty if unknown,
+	// Transports the number of HTTP transports used, and Connections the
+	// number of connections opened.
+	Transport   string
+	Transports  int
+	Connections int64
+
+	// Certificates is the chain presented on the first TLS connection.
+	// CertExpiryWarning is set if any of them is close to expiry.
+	Certificates      []CertificateInfo
+	CertExpiryWarning string
+
+	// OCSP describes the OCSP response stapled along with Certificates;
+	// nil if there were none.
+	OCSP *OCSPInfo
+
 	LatencyDistribution []LatencyDistribution
 	Histogram           []Bucket
+
+	// Outliers describes the responses much slower than the rest; nil if
+	// there were too few responses to tell.
+	Outliers *OutlierReport
+
+	// TimeUnit is the unit PrintReport prints latencies in, see
+	// Work.TimeUnit. Latencies are in seconds in the report itself.
+	TimeUnit string
+
+	// Details, if set, has PrintReport also print the confidence
+	// intervals of percentiles, outliers, clock skew, transports and
+	// requests in flight, see Work.Details.
+	Details bool
+}
+
+// DrainPhase summarizes requests that were in flight when the run was
+// stopped.
+type DrainPhase struct {
+	Completed int
+	Cancelled int
+	Failed    int
+	Average   float64
+	Slowest   float64
+}
+
+func (d *DrainPhase) add(res *result) {
+	switch {
+	case errors.Is(res.err, context.Canceled):
+		d.Cancelled++
+	case res.err != nil:
+		d.Failed++
+	default:
+		d.Completed++
+		d.Average += res.duration.Seconds()
+		d.Slowest = math.Max(d.Slowest, res.duration.Seconds())
+	}
 }
 
 type LatencyDistribution struct {
-	Percentage int
+	Percentage float64
 	Latency    float64
+
+	// Low and High bound the 95% confidence interval of the percentile of
+	// the latencies sampled, in the latency distribution of the run.
+	// TooFewSamples is set if too few requests were slower than it to
+	// bound it, e.g. for p99.9 of 200 requests, and High is the slowest.
+	Low           float64
+	High          float64
+	TooFewSamples bool
 }
 
 type Bucket struct {
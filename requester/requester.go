@@ -13,21 +13,30 @@
 // limitations under the License.
 
 // Package requester provides commands to run load tests and display results.
+// Programs embedding the load generator create a Work with NewWork and
+// run it with RunContext, which returns the Report of the run.
 package requester
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"net/http/httptrace"
+	"net/netip"
+	"net/textproto"
 	"net/url"
 	"os"
+	"regexp"
+	"runtime"
 	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
-
-	"golang.org/x/net/http2"
 )
 
 // Max size of the buffer of result channel.
@@ -35,28 +44,122 @@ const maxResult = 1000000
 const maxIdleConn = 500
 
 type result struct {
-	err           error
-	statusCode    int
-	offset        time.Duration
-	duration      time.Duration
-	connDuration  time.Duration // connection setup(DNS lookup + Dial up) duration
-	dnsDuration   time.Duration // dns lookup duration
-	reqDuration   time.Duration // request "write" duration
-	resDuration   time.Duration // response "read" duration
-	delayDuration time.Duration // delay between response and request
-	contentLength int64
+	err            error
+	statusCode     int
+	offset         time.Duration
+	duration       time.Duration
+	connDuration   time.Duration // connection setup(DNS lookup + Dial up) duration
+	dnsDuration    time.Duration // dns lookup duration
+	dialDuration   time.Duration // TCP connect duration
+	tlsDuration    time.Duration // TLS handshake duration
+	reqDuration    time.Duration // request "write" duration
+	resDuration    time.Duration // response "read" duration
+	delayDuration  time.Duration // delay between response and request
+	contentLength  int64
+	proto          string        // protocol of the response, e.g. "HTTP/2.0"
+	newConn        bool          // whether the request opened a new connection
+	encoded        *encodedBody  // set only when AcceptEncoding is used
+	ranged         bool          // whether a Range header was sent
+	rangeStart     int64         // start offset of a randomized range
+	aborted        string        // phase the request was aborted in by chaos
+	drained        bool          // whether the request completed after Stop
+	paced          bool          // whether the request was rate limited
+	lag            time.Duration // how late a rate limited request was sent
+	capped         bool          // whether the request waited for MaxInFlight
+	worker         int           // 1-based worker that sent the request
+	iteration      int           // 1-based iteration of the worker
+	sent           time.Time     // wall clock time the request was started
+	earlyHint      time.Duration // time to the first 103 Early Hints, if any
+	hintLinks      int           // resources hinted by 103 responses
+	final          time.Duration // time to the final response headers
+	trailer        http.Header   // response trailers
+	traceID        string        // ID of the trace started, with Propagation
+	idemKey        string        // Idempotency-Key sent, with IdempotencyKeys
+	params         url.Values    // swept and fuzzed query parameters
+	compared       bool          // whether the body was compared to Golden
+	diff           string        // first divergence from Golden, if any
+	headersChecked bool          // whether the headers were checked against HeaderAssertions
+	headerFailures []headerFailure
+	security       []string      // values of SecurityHeaderNames, with SecurityHeaders
+	backend        string        // backend that served the response, with SecurityHeaders
+	target         string        // URL requested without its query, see targetOf
+	capture        string        // file of CaptureDir the exchange was saved to
+	body           string        // name of the body of Bodies sent
+	redirects      []redirectHop // redirects followed before the response
+	family         string        // IP family of the new connection, if any
+	fallback       string        // IP family the dial fell back to, if any
+	resumed        bool          // whether the TLS handshake resumed a session
+	date           int64         // Date header of the response, in Unix seconds
+	probe          bool          // whether the request probed a target found down
+	mark           string        // set only for marks, see Work.Mark
+	resolver       string        // resolver of the DNS lookup, with Resolvers or FreshDNS
+	dnsFailed      bool          // whether the DNS lookup failed
+	connID         int64         // ID of the connection, if tracked
+	conn           *ConnRecord   // set only for the records of connections
 }
 
+// Transport sharing strategies.
+const (
+	// TransportShared has all workers share a transport.
+	TransportShared = "shared"
+	// TransportPerWorker gives each worker a transport of its own, as
+	// independent clients would have.
+	TransportPerWorker = "per-worker"
+	// TransportPerCPU shares a transport between the workers of each CPU,
+	// per GOMAXPROCS, which reduces contention on the connection pool.
+	TransportPerCPU = "per-cpu"
+	// TransportPerTarget gives each target host a transport of its own,
+	// shared by all workers, so that the connections to a slow host do
+	// not hold up those to the others.
+	TransportPerTarget = "per-target"
+)
+
+// Arrival processes of rate limited requests.
+const (
+	// ArrivalUniform spaces requests evenly.
+	ArrivalUniform = "uniform"
+	// ArrivalPoisson spaces requests by exponentially distributed gaps,
+	// as requests from many independent clients would be.
+	ArrivalPoisson = "poisson"
+)
+
 type Work struct {
 	// Request is the request to be made.
 	Request *http.Request
 
 	RequestBody []byte
 
+	// Bodies, if set, are sent instead of RequestBody, each request taking
+	// the next of them in turn, or a random one with RandomBodies. The
+	// body sent is named in the results.
+	Bodies       []Body
+	RandomBodies bool
+
+	// RandomBodyMax, if positive, makes every request send a body of a
+	// random size between RandomBodyMin and RandomBodyMax bytes, drawn so
+	// that Seed reproduces them. Bodies are random bytes, or repeated
+	// text if RandomBodyText is set.
+	RandomBodyMin  int64
+	RandomBodyMax  int64
+	RandomBodyText bool
+
+	// BodyTemplate, if set, is executed for every request to generate its
+	// body, see ParseBodyTemplate.
+	BodyTemplate *template.Template
+
 	// RequestFunc is a function to generate requests. If it is nil, then
 	// Request and RequestData are cloned for each request.
 	RequestFunc func() *http.Request
 
+	// URLs, if set, are requested in turn instead of the URL of Request,
+	// keeping its Host header if it was set apart from its URL. With URLs
+	// or a RequestFunc, requests are also reported per target URL.
+	URLs []*url.URL
+
+	// Trailer holds trailers to send after the request body, which is then
+	// sent chunked.
+	Trailer http.Header
+
 	// N is the total number of requests to make.
 	N int
 
@@ -66,18 +169,237 @@ type Work struct {
 	// H2 is an option to make HTTP/2 requests
 	H2 bool
 
+	// H3 makes HTTP/3 requests, over QUIC, and reports the QUIC
+	// handshakes. It requires https URLs, and cannot be combined with H2
+	// or ProxyAddr.
+	H3 bool
+
+	// PingInterval, if set with H2, is how often a PING frame is sent on
+	// every idle HTTP/2 connection. Their round trip times are reported
+	// apart from request latencies, as the network's share of them.
+	PingInterval time.Duration
+
 	// Timeout in seconds.
 	Timeout int
 
-	// Qps is the rate limit in queries per second.
+	// Qps is the rate limit in queries per second, per worker. The rate
+	// limit is enforced across all workers together, at QPS*C.
 	QPS float64
 
+	// Burst is how many requests may be sent back to back when the rate
+	// limit allows it, e.g. after workers were held up by slow responses.
+	// Defaults to 1, i.e. no bursting.
+	Burst int
+
+	// PrecisePacing busy-waits for the send time of rate limited requests
+	// on a dedicated goroutine instead of sleeping, for accurate pacing at
+	// high rates. It keeps a CPU core busy.
+	PrecisePacing bool
+
+	// Arrival is how rate limited requests are spaced, ArrivalUniform by
+	// default.
+	Arrival string
+
+	// ThinkTime, if set, is the distribution of the pause each worker
+	// makes after a request completes, before sending its next one.
+	ThinkTime Distribution
+
+	// KeepAliveProbe, if set with ThinkTime, is the method, HEAD or
+	// OPTIONS, of a probe each worker sends at the end of its pauses, on
+	// the connection its previous request left idle. How often servers
+	// had closed them silently is reported, to diagnose idle timeouts
+	// shorter than the client's.
+	KeepAliveProbe string
+
+	// MaxInFlight, if set, caps the number of requests in flight across
+	// all workers, independently of C.
+	MaxInFlight int
+
+	// Iterations, if set, is the number of requests each worker makes,
+	// like virtual users each running the same number of iterations. N
+	// is then ignored, and results are also reported per iteration.
+	Iterations int
+
+	// Transport is how workers share HTTP transports, and so connection
+	// pools, TransportShared by default.
+	Transport string
+
+	// Conns, if set, keeps the connections of the run open for the next
+	// run given the same Conns. It takes the shared transport, and cannot
+	// be combined with another Transport, H2, ClientCerts, NTLM,
+	// TLSKeyLogWriter or RecordConns.
+	Conns *Conns
+
 	// DisableCompression is an option to disable compression in response
 	DisableCompression bool
 
 	// DisableKeepAlives is an option to prevents re-use of TCP connections between different HTTP requests
 	DisableKeepAlives bool
 
+	// AcceptEncoding, if set, is sent as the Accept-Encoding header and
+	// disables the transport's transparent decompression. Responses are
+	// then decoded by the requester, so compressed and decompressed sizes
+	// and the decompression time can be reported.
+	AcceptEncoding string
+
+	// Range is sent as the Range header of every request, e.g.
+	// "bytes=0-65535". Optional.
+	Range string
+
+	// RangeObjectSize, if positive, makes every request ask for a random
+	// range of RangeLength bytes within an object of this size. It takes
+	// precedence over Range.
+	RangeObjectSize int64
+	RangeLength     int64
+
+	// UserAgents, if set, are sent as the User-Agent header of requests in
+	// turn, one per request, overriding the one of Request.
+	UserAgents []string
+
+	// Credentials, if set, are Authorization header values given to
+	// workers in turn, so that each sends its own, overriding the one of
+	// Request, e.g. to spread load over accounts.
+	Credentials []string
+
+	// ForwardedFor, if valid, makes every request send the next address
+	// of the prefix as its X-Forwarded-For header, as if it came from a
+	// different client behind a proxy.
+	ForwardedFor netip.Prefix
+
+	// Propagation, if set, makes every request start a new trace, sent
+	// in this trace context format, PropagationW3C or PropagationB3.
+	// TraceState is sent along with W3C trace contexts. Trace IDs are
+	// recorded in the results.
+	Propagation string
+	TraceState  string
+
+	// IdempotencyKeys, if set, sends a new Idempotency-Key header with
+	// every request, as a client would for every logical request. hey does
+	// not retry requests, so no key is sent twice. Keys are recorded in
+	// the results.
+	IdempotencyKeys bool
+
+	// SweepParam, if set, sets this query parameter of every request to
+	// each of SweepValues in turn. FuzzParam, if set, sets this query
+	// parameter to a random value instead, drawn so that Seed reproduces
+	// them. Requests are reported by the values of both parameters.
+	SweepParam  string
+	SweepValues []string
+	FuzzParam   string
+
+	// Golden, if set, is a decoded JSON body that responses are compared
+	// to, ignoring the paths matched by GoldenIgnore, see
+	// CompileIgnorePaths. GoldenSample is the fraction of responses to
+	// compare, all if zero. Divergences are counted in the report.
+	Golden       interface{}
+	GoldenIgnore []*regexp.Regexp
+	GoldenSample float64
+
+	// SOAPFaults, if set, makes responses that are SOAP envelopes with a
+	// Fault count as errors, whatever their status code, as services
+	// often report faults with 200 OK.
+	SOAPFaults bool
+
+	// CaptureSample is the fraction of requests, between 0 and 1, whose
+	// request and response, with headers and complete bodies, are saved
+	// to a file of CaptureDir each, to audit the traffic of a run. The
+	// files are named in the results.
+	CaptureSample float64
+	CaptureDir    string
+
+	// ExpectStatus, if set, are the status codes that are the correct
+	// outcome of requests, such as 429 when testing a rate limiter. The
+	// report counts them as successes, and any other outcome as failure.
+	ExpectStatus []int
+
+	// HeaderAssertions, if set, are checked against the headers of every
+	// response, after redirects. See FailedHeaderAssertions.
+	HeaderAssertions []HeaderAssertion
+
+	// SecurityHeaders, if set, audits the security headers of responses,
+	// see SecurityHeaderNames, by backend: the remote address of their
+	// connection, or the value of their BackendHeader if set, to find
+	// backends behind a load balancer that are configured differently.
+	SecurityHeaders bool
+	BackendHeader   string
+
+	// SLO, if set, makes the report include how much of the SLO's error
+	// budget the observed behavior would burn. SLOTrafficRate is the
+	// production traffic in requests per second used to express the
+	// budget in requests. Optional.
+	SLO            *SLO
+	SLOTrafficRate float64
+
+	// Assertions, if set, are checked against the latencies of the run,
+	// e.g. to enforce an SLA on TLS handshakes independently of the total
+	// latency. See FailedAssertions.
+	Assertions []Assertion
+
+	// ChaosAbortRate is the fraction of requests, between 0 and 1, whose
+	// connection is deliberately closed while the request is being sent or
+	// the response is being received. Aborted requests are reported
+	// separately from errors.
+	ChaosAbortRate float64
+
+	// Drain is the grace period in-flight requests are given to complete
+	// after Stop is called. Requests that are still running when it expires
+	// are cancelled. Requests completing after Stop are reported in a
+	// separate drain phase. If zero, in-flight requests are cancelled as
+	// soon as Stop is called.
+	Drain time.Duration
+
+	// PauseOnDown, if set, pauses sending once every request failed, with
+	// an error or a 5xx status, for this long, as when the target is down
+	// during a failover or a deploy. A single request is then sent every
+	// DownProbeInterval, or DefaultDownProbeInterval, until one succeeds,
+	// and sending resumes. The outages are reported.
+	PauseOnDown       time.Duration
+	DownProbeInterval time.Duration
+
+	// MaxBytes, if positive, stops the run once this many bytes of response
+	// bodies were received, as a data budget on top of N or a duration.
+	MaxBytes int64
+
+	// Percentiles are the latency percentiles to report, in increasing
+	// order. If empty, DefaultPercentiles are used.
+	Percentiles []float64
+
+	// OutlierK is how many median absolute deviations above the median
+	// latency responses are reported as outliers. If zero,
+	// DefaultOutlierK is used.
+	OutlierK float64
+
+	// Seed, if non-zero, seeds the random choices made for requests, such
+	// as randomized ranges and chaos aborts, so that runs with the same
+	// seed and settings make the same choices.
+	Seed int64
+
+	// Sinks receive the raw record of every request as it completes.
+	// They are closed when the run finishes. Optional.
+	Sinks []Sink
+
+	// Spools receive every record, like Sinks, but are written by the
+	// reporter itself, so that none are dropped: a slow one holds up the
+	// run instead. They are meant for local files. Optional.
+	Spools []Sink
+
+	// RecordConns, if set, adds the records of connections to the raw
+	// results, of the "ndjson" output and Sinks, as they close, see
+	// ConnRecord.
+	RecordConns bool
+
+	// SnapshotInterval, if set, is how often an interim summary of the
+	// results received since the previous one is written to
+	// SnapshotWriter, or to Writer if that is nil.
+	SnapshotInterval time.Duration
+	SnapshotWriter   io.Writer
+
+	// ErrorLog, if set, receives the status, headers and beginning of the
+	// body of the first ErrorLogLimit 4xx and 5xx responses.
+	// ErrorLogLimit defaults to DefaultErrorLogLimit.
+	ErrorLog      io.Writer
+	ErrorLogLimit int
+
 	// DisableRedirects is an option to prevent the following of HTTP redirects
 	DisableRedirects bool
 
@@ -85,22 +407,132 @@ type Work struct {
 	// output will be dumped as a csv stream.
 	Output string
 
+	// JSONRecords, with the "json" output, adds the raw result of every
+	// request to the summary.
+	JSONRecords bool
+
 	// ProxyAddr is the address of HTTP proxy server in the format on "host:port".
 	// Optional.
 	ProxyAddr *url.URL
 
+	// FallbackDelay is how long dials wait for a connection to the first
+	// IP family tried, usually IPv6, before racing one to the other, as in
+	// Happy Eyeballs (RFC 6555). If zero, the dialer's default of 300ms is
+	// used; if negative, the fallback is disabled. IPFamily, if set to
+	// IPv4 or IPv6, restricts dials to that family.
+	FallbackDelay time.Duration
+	IPFamily      string
+
+	// Resolvers, if set, are the DNS servers to resolve hosts with, as
+	// host or host:port, each new connection with the next in turn,
+	// rather than with the resolver of the system. FreshDNS, if positive,
+	// closes the connection of every FreshDNS-th request, so that the
+	// host is resolved afresh when the next connection is opened; 1
+	// resolves it for every request. With either, DNS lookups are
+	// reported by resolver.
+	Resolvers []string
+	FreshDNS  int
+
+	// DialGrace, if set, is how long from the start of the run failed
+	// dials are retried with jittered backoff, rather than failing their
+	// requests, e.g. when the SYNs of the initial connection storm are
+	// dropped. The retries are reported.
+	DialGrace time.Duration
+
+	// TLSResume, if set, has new connections resume the TLS sessions of
+	// earlier ones, and the report compare resumed handshakes to full
+	// ones. Early data is not sent, as crypto/tls does not support 0-RTT
+	// on clients, so resumed handshakes still take a round trip.
+	TLSResume bool
+
+	// NTLM, if set, authenticates connections with NTLMv2 when the server
+	// asks for it, with a handshake of two more requests on each new
+	// connection, which count towards the time of the request that made
+	// it. The handshake needs its requests to go over the same connection,
+	// so workers get transports of their own, TransportPerWorker whatever
+	// Transport is, each keeping to a single connection per host.
+	NTLM *NTLMCredentials
+
+	// Negotiate, if set, authenticates every request with HTTP Negotiate,
+	// with the Kerberos tickets of a credentials cache, see LoadKerberos.
+	Negotiate *Kerberos
+
+	// ClientCerts, if set, are client certificates for mutual TLS, given
+	// to workers in turn. Transports are not shared by workers with
+	// different certificates, so there are at least as many transports as
+	// certificates, if there are as many workers.
+	ClientCerts []tls.Certificate
+
+	// TLSKeyLogWriter, if set, receives TLS master secrets in NSS key log
+	// format for decrypting captured traffic. Optional.
+	TLSKeyLogWriter io.Writer
+
+	// CertExpiryWarning is how close to expiry the server certificate
+	// chain may be before the report warns about it. If zero,
+	// DefaultCertExpiryWarning is used.
+	CertExpiryWarning time.Duration
+
 	// Writer is where results will be written. If nil, results are written to stdout.
 	Writer io.Writer
 
-	initOnce sync.Once
-	results  chan *result
-	stopCh   chan struct{}
-	start    time.Duration
+	// LocalTime, if set, gives the wall clock times of requests and marks
+	// in the outputs in the local time zone rather than UTC.
+	LocalTime bool
+
+	// TimeUnit is the unit latencies are printed in by the summary, HTML
+	// and interim snapshots, one of TimeUnitSeconds, TimeUnitMillis and
+	// TimeUnitMicros. If empty, seconds are used. Other outputs are in
+	// seconds regardless.
+	TimeUnit string
 
+	// Details, if set, has the summary and HTML report also print the
+	// confidence intervals of latency percentiles, the outliers, the clock
+	// skew, the transports and the requests in flight. The JSON summary
+	// has them regardless.
+	Details bool
+
+	initOnce    sync.Once
+	stopOnce    sync.Once
+	stopAt      int64           // time Stop was called at, accessed atomically
+	ctx         context.Context // done once Stop is called
+	cancel      context.CancelFunc
+	drainCtx    context.Context // done once in-flight requests are to be cancelled
+	drainEnd    context.CancelFunc
+	certOnce    sync.Once
+	certs       []*x509.Certificate
+	staple      []byte // OCSP response stapled along with certs
+	ocsp        *OCSPInfo
+	errLog      *errorLog
+	limiter     atomic.Pointer[limiter]
+	auth        atomic.Pointer[string]
+	slots       chan struct{} // in-flight request slots, if MaxInFlight is set
+	uaNext      uint64        // index of the next of UserAgents, accessed atomically
+	xffNext     uint64        // index of the next ForwardedFor address, accessed atomically
+	received    int64         // response body bytes received, accessed atomically
+	swept       uint64        // index of the next of SweepValues, accessed atomically
+	urlNext     uint64        // index of the next of URLs, accessed atomically
+	dnsNext     uint64        // index of the next of Resolvers, accessed atomically
+	fresh       uint64        // requests counted towards FreshDNS, accessed atomically
+	dialRetries dialRetries
+	bodyNext    uint64   // index of the next of Bodies, accessed atomically
+	seqs        sync.Map // *int64 counters of BodyTemplate seq, by start
+	captured    int64    // number of exchanges captured, accessed atomically
+	results     chan *result
+	conns       *connTracker  // set with RecordConns
+	down        *downDetector // set with PauseOnDown
+	markMu      sync.Mutex    // guards sending marks against closing results
+	finished    bool
+	quiet       bool // set by RunContext without a Writer
+	start       time.Duration
+
+	pool   workerPool
 	report *report
 }
 
 func (b *Work) writer() io.Writer {
+	if b.quiet {
+		return io.Discard
+	}
 	if b.Writer == nil {
 		return os.Stdout
 	}
@@ -111,7 +543,8 @@ func (b *Work) writer() io.Writer {
 func (b *Work) Init() {
 	b.initOnce.Do(func() {
 		b.results = make(chan *result, min(b.C*1000, maxResult))
-		b.stopCh = make(chan struct{}, b.C)
+		b.ctx, b.cancel = context.WithCancel(context.Background())
+		b.drainCtx, b.drainEnd = context.WithCancel(context.Background())
 	})
 }
 
@@ -121,6 +554,79 @@ func (b *Work) Run() {
 	b.Init()
 	b.start = now()
 	b.report = newReport(b.writer(), b.results, b.Output, b.N)
+	b.report.percentiles = b.Percentiles
+	b.report.quiet = b.quiet
+	b.report.local = b.LocalTime
+	b.report.timeUnit = b.TimeUnit
+	b.report.details = b.Details
+	b.report.keepRecords = b.JSONRecords && b.Output == "json"
+	b.report.assertions = b.Assertions
+	b.report.outliers.k = b.OutlierK
+	b.report.start = b.start
+	b.report.workers = b.C
+	b.report.inflight.limit = b.MaxInFlight
+	b.report.iterations.perWorker = b.Iterations
+	b.report.transport = b.transport()
+	if b.QPS > 0 {
+		b.report.pacing = newPacingStats(b.QPS, b.C)
+	}
+	if b.Golden != nil {
+		b.report.golden = &GoldenReport{}
+	}
+	if len(b.ExpectStatus) > 0 {
+		b.report.expect = newExpectReport(b.ExpectStatus)
+	}
+	b.report.headers = newHeaderAssertStats(b.HeaderAssertions)
+	if b.SecurityHeaders {
+		b.report.security = newSecurityHeaderReports()
+	}
+	if len(b.Sinks) > 0 {
+		b.report.sinks = newSinkWriter(b.Sinks)
+	}
+	if len(b.Spools) > 0 {
+		b.report.spools = newSpoolWriter(b.Spools)
+	}
+	if b.ErrorLog != nil {
+		b.errLog = &errorLog{w: b.ErrorLog, limit: int64(b.ErrorLogLimit)}
+		if b.ErrorLogLimit == 0 {
+			b.errLog.limit = DefaultErrorLogLimit
+		}
+	}
+	if b.SnapshotInterval > 0 {
+		w := b.SnapshotWriter
+		if w == nil {
+			w = b.writer()
+		}
+		b.report.snapshots = newSnapshotter(w, b.SnapshotInterval, b.TimeUnit)
+	}
+	if b.Range != "" || b.RangeObjectSize > 0 {
+		b.report.ranges = newRangeStats(b)
+	}
+	if b.SLO != nil {
+		b.report.slo = &sloStats{slo: *b.SLO, rate: b.SLOTrafficRate}
+	}
+	b.report.resumption.enabled = b.TLSResume
+	if b.RecordConns {
+		b.conns = newConnTracker(b.results)
+	}
+	if b.PauseOnDown > 0 {
+		b.down = &downDetector{after: b.PauseOnDown, interval: b.DownProbeInterval}
+		if b.down.interval <= 0 {
+			b.down.interval = DefaultDownProbeInterval
+		}
+	}
+	if b.KeepAliveProbe != "" && b.ThinkTime != nil {
+		b.report.keepalive = &keepAliveStats{method: b.KeepAliveProbe}
+	}
+	if b.H3 {
+		b.report.quic = &quicStats{}
+	}
+	if b.H2 {
+		b.report.h2 = &h2Stats{}
+		if b.PingInterval > 0 {
+			b.report.pings = &pingStats{}
+		}
+	}
 	// Run the reporter first, it polls the result channel until it is closed.
 	go func() {
 		runReporter(b.report)
@@ -129,89 +635,396 @@ func (b *Work) Run() {
 	b.Finish()
 }
 
+// Stop stops the run: workers send no more requests, and in-flight
+// requests are cancelled, after the Drain period if it is set.
 func (b *Work) Stop() {
-	// Send stop signal so that workers can stop gracefully.
-	for i := 0; i < b.C; i++ {
-		b.stopCh <- struct{}{}
-	}
+	// Stop may be called before Run, e.g. by RunContext.
+	b.Init()
+	b.stopOnce.Do(func() {
+		atomic.StoreInt64(&b.stopAt, int64(now()))
+		b.cancel()
+		if b.Drain > 0 {
+			time.AfterFunc(b.Drain, b.drainEnd)
+		} else {
+			b.drainEnd()
+		}
+	})
 }
 
 func (b *Work) Finish() {
+	if b.conns != nil {
+		b.conns.finish()
+	}
+	b.markMu.Lock()
+	b.finished = true
 	close(b.results)
+	b.markMu.Unlock()
 	total := now() - b.start
 	// Wait until the reporter is done.
 	<-b.report.done
+	b.report.certs = certificateInfos(b.certs)
+	if len(b.certs) > 0 {
+		b.ocsp = checkOCSP(b.staple, b.certs, time.Now())
+		b.report.ocsp = b.ocsp
+	}
+	b.report.certWarn = b.CertExpiryWarning
+	if b.report.certWarn == 0 {
+		b.report.certWarn = DefaultCertExpiryWarning
+	}
+	b.report.maxBytes = b.MaxBytes
+	b.report.received = atomic.LoadInt64(&b.received)
+	b.report.dialRetries = b.dialRetries.snapshot(b.DialGrace)
 	b.report.finalize(total)
 }
 
-func (b *Work) makeRequest(c *http.Client) {
+// attempt describes how a request came to be sent.
+type attempt struct {
+	scheduled time.Duration // time a rate limited request was due, else 0
+	capped    bool          // whether the request waited for MaxInFlight
+	worker    int           // 1-based worker
+	iteration int           // 1-based iteration, with Iterations
+	probe     bool          // whether the request probes a target found down
+}
+
+// makeRequest sends a request and reports its result, making random
+// choices with rnd. tmpl is the worker's BodyTemplate.
+func (b *Work) makeRequest(c *http.Client, rnd *rand.Rand, tmpl *template.Template, at attempt) {
 	s := now()
+	sent := time.Now()
 	var size int64
 	var code int
-	var dnsStart, connStart, resStart, reqStart, delayStart time.Duration
-	var dnsDuration, connDuration, resDuration, reqDuration, delayDuration time.Duration
+	var dnsStart, connStart, dialStart, tlsStart, resStart, reqStart, delayStart time.Duration
+	var dnsDuration, connDuration, dialDuration, tlsDuration, resDuration, reqDuration, delayDuration time.Duration
+	var earlyHint time.Duration
+	var hintLinks int
+	var proto string
+	var newConn bool
+	var family string
+	var resumed bool
+	var connID int64
+	var dials dialTrace
+	var dnsFailed bool
+	var remote string
 	var req *http.Request
+	var bodyName string
 	if b.RequestFunc != nil {
 		req = b.RequestFunc()
+	} else if len(b.Bodies) > 0 {
+		payload := b.nextBody(rnd)
+		req = cloneRequest(b.Request, payload.Data)
+		req.ContentLength = int64(len(payload.Data))
+		if payload.ContentType != "" {
+			req.Header.Set("Content-Type", payload.ContentType)
+		}
+		bodyName = payload.Name
+	} else if b.RandomBodyMax > 0 {
+		data := b.randomBody(rnd)
+		req = cloneRequest(b.Request, data)
+		req.ContentLength = int64(len(data))
+	} else if tmpl != nil {
+		var body bytes.Buffer
+		if err := tmpl.Execute(&body, nil); err != nil {
+			b.results <- &result{offset: s, err: err, sent: sent, worker: at.worker, iteration: at.iteration}
+			return
+		}
+		req = cloneRequest(b.Request, body.Bytes())
+		req.ContentLength = int64(body.Len())
 	} else {
 		req = cloneRequest(b.Request, b.RequestBody)
 	}
+	if auth := b.auth.Load(); auth != nil {
+		req.Header.Set("Authorization", *auth)
+	}
+	if len(b.Credentials) > 0 {
+		req.Header.Set("Authorization", b.Credentials[(at.worker-1)%len(b.Credentials)])
+	}
+	if b.AcceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", b.AcceptEncoding)
+	}
+	if len(b.Trailer) > 0 {
+		withTrailer(req, b.Trailer)
+	}
+	if len(b.UserAgents) > 0 {
+		i := atomic.AddUint64(&b.uaNext, 1) - 1
+		req.Header.Set("User-Agent", b.UserAgents[i%uint64(len(b.UserAgents))])
+	}
+	if b.ForwardedFor.IsValid() {
+		addr := nthAddr(b.ForwardedFor, atomic.AddUint64(&b.xffNext, 1)-1)
+		req.Header.Set("X-Forwarded-For", addr.String())
+	}
+	var traceID string
+	if b.Propagation != "" {
+		traceID = b.propagate(req)
+	}
+	if len(b.URLs) > 0 {
+		b.setTarget(req)
+	}
+	var params url.Values
+	if b.SweepParam != "" || b.FuzzParam != "" {
+		params = b.setParams(req, rnd)
+	}
+	var target string
+	if len(b.URLs) > 0 || b.RequestFunc != nil {
+		target = targetOf(req.URL)
+	}
+	var idemKey string
+	if b.IdempotencyKeys {
+		idemKey = newIdempotencyKey()
+		req.Header.Set("Idempotency-Key", idemKey)
+	}
+	rangeStart := int64(-1)
+	if b.Range != "" || b.RangeObjectSize > 0 {
+		var rng string
+		rng, rangeStart = b.rangeHeader(rnd)
+		req.Header.Set("Range", rng)
+	}
+	var capt *capture
+	if b.CaptureSample > 0 && rnd.Float64() < b.CaptureSample {
+		capt = newCapture(req)
+	}
+	// The request is cancelled to abort it, or when the run is stopped,
+	// once the drain period is over.
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	defer context.AfterFunc(b.drainCtx, cancel)()
+	req = req.WithContext(ctx)
+	abort := b.chaosAbort(rnd)
 	trace := &httptrace.ClientTrace{
 		DNSStart: func(info httptrace.DNSStartInfo) {
 			dnsStart = now()
 		},
 		DNSDone: func(dnsInfo httptrace.DNSDoneInfo) {
 			dnsDuration = now() - dnsStart
+			dnsFailed = dnsInfo.Err != nil
 		},
 		GetConn: func(h string) {
 			connStart = now()
 		},
+		ConnectStart: func(network, addr string) {
+			dialStart = now()
+			dials.start(addr)
+		},
+		ConnectDone: func(network, addr string, err error) {
+			dialDuration = now() - dialStart
+		},
+		TLSHandshakeStart: func() {
+			tlsStart = now()
+		},
 		GotConn: func(connInfo httptrace.GotConnInfo) {
-			if !connInfo.Reused {
+			// HTTP/3 does not report requests that waited for the QUIC
+			// handshake of another as reused, though they did not dial.
+			if !connInfo.Reused && (!b.H3 || dialStart != 0) {
 				connDuration = now() - connStart
+				newConn = true
+				family = ipFamily(connInfo.Conn.RemoteAddr().String())
+			}
+			if b.SecurityHeaders {
+				remote = connInfo.Conn.RemoteAddr().String()
+			}
+			if b.conns != nil {
+				connID = b.conns.use(connInfo.Conn, !connInfo.Reused, tlsDuration)
 			}
 			reqStart = now()
 		},
+		WroteHeaders: func() {
+			if abort == abortRequest {
+				cancel()
+			}
+		},
 		WroteRequest: func(w httptrace.WroteRequestInfo) {
 			reqDuration = now() - reqStart
 			delayStart = now()
 		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			tlsDuration = now() - tlsStart
+			resumed = err == nil && state.DidResume
+			if err == nil && len(state.PeerCertificates) > 0 {
+				b.certOnce.Do(func() {
+					b.certs = state.PeerCertificates
+					b.staple = state.OCSPResponse
+				})
+			}
+		},
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if code == http.StatusEarlyHints {
+				if earlyHint == 0 {
+					earlyHint = now() - s
+				}
+				hintLinks += hintedResources(header)
+			}
+			return nil
+		},
 		GotFirstResponseByte: func() {
 			delayDuration = now() - delayStart
 			resStart = now()
+			if abort == abortResponse {
+				cancel()
+			}
 		},
 	}
+	var chain *redirectChain
+	if !b.DisableRedirects {
+		chain = &redirectChain{last: s}
+		req = req.WithContext(context.WithValue(req.Context(), redirectChainKey{}, chain))
+	}
+	var resolver *resolverChoice
+	if len(b.Resolvers) > 0 {
+		resolver = &resolverChoice{}
+		req = req.WithContext(context.WithValue(req.Context(), resolverKey{}, resolver))
+	}
 	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	if b.FreshDNS > 0 && atomic.AddUint64(&b.fresh, 1)%uint64(b.FreshDNS) == 0 {
+		req.Close = true
+	}
 	resp, err := c.Do(req)
+	final := now() - s
+	var redirects []redirectHop
+	if chain != nil {
+		redirects = chain.hops
+	}
+	var encoded *encodedBody
+	var trailer http.Header
+	var date int64
+	var received int64
+	var compared bool
+	var diff string
+	var headersChecked bool
+	var headerFailures []headerFailure
+	var security []string
+	var backend string
+	var decompress time.Duration
 	if err == nil {
+		if b.SecurityHeaders {
+			security = securityHeaders(resp.Header)
+			backend = remote
+			if b.BackendHeader != "" {
+				if backend = resp.Header.Get(b.BackendHeader); backend == "" {
+					backend = missingHeader
+				}
+			}
+		}
+		if len(b.HeaderAssertions) > 0 {
+			headersChecked = true
+			headerFailures = checkHeaders(b.HeaderAssertions, resp.Header)
+		}
 		size = resp.ContentLength
 		code = resp.StatusCode
-		io.Copy(ioutil.Discard, resp.Body)
+		proto = resp.Proto
+		if d, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+			date = d.Unix()
+		}
+		if capt != nil {
+			capt.captureResponse(resp)
+		}
+		var body *capturingBody
+		if b.errLog != nil && code >= 400 && b.errLog.claim() {
+			body = captureBody(resp)
+		}
+		if b.AcceptEncoding != "" {
+			var eb encodedBody
+			eb, err = readEncodedBody(resp)
+			encoded = &eb
+			received = eb.compressed
+			decompress = eb.duration
+		} else if compare := b.Golden != nil && (b.GoldenSample == 0 || rnd.Float64() < b.GoldenSample); compare || b.SOAPFaults {
+			var body []byte
+			body, err = ioutil.ReadAll(resp.Body)
+			received = int64(len(body))
+			if err == nil && compare {
+				compared, diff = true, b.diffGolden(body)
+			}
+			if err == nil && b.SOAPFaults {
+				err = soapFault(body)
+			}
+		} else {
+			received, _ = io.Copy(ioutil.Discard, resp.Body)
+		}
 		resp.Body.Close()
+		if b.MaxBytes > 0 && atomic.AddInt64(&b.received, received) >= b.MaxBytes {
+			b.Stop()
+		}
+		trailer = resp.Trailer
+		if body != nil {
+			b.errLog.write(s-b.start, req, resp, body)
+		}
 	}
-	t := now()
+	// Decompression is hey's own work, not the server's, so it is reported
+	// on its own rather than as part of the response time.
+	t := now() - decompress
+	stopAt := time.Duration(atomic.LoadInt64(&b.stopAt))
 	resDuration = t - resStart
 	finish := t - s
-	b.results <- &result{
-		offset:        s,
-		statusCode:    code,
-		duration:      finish,
-		err:           err,
-		contentLength: size,
-		connDuration:  connDuration,
-		dnsDuration:   dnsDuration,
-		reqDuration:   reqDuration,
-		resDuration:   resDuration,
-		delayDuration: delayDuration,
+	var captured string
+	if capt != nil {
+		captured = b.writeCapture(capt, s-b.start, req, resp, err)
 	}
-}
-
-func (b *Work) runWorker(client *http.Client, n int) {
-	var throttle <-chan time.Time
-	if b.QPS > 0 {
-		throttle = time.Tick(time.Duration(1e6/(b.QPS)) * time.Microsecond)
+	var resolverName string
+	if resolver != nil {
+		resolverName = resolver.get()
+	} else if b.FreshDNS > 0 && (dnsDuration > 0 || dnsFailed) {
+		resolverName = SystemResolver
+	}
+	res := &result{
+		offset:         s,
+		statusCode:     code,
+		duration:       finish,
+		err:            err,
+		contentLength:  size,
+		connDuration:   connDuration,
+		dnsDuration:    dnsDuration,
+		dialDuration:   dialDuration,
+		tlsDuration:    tlsDuration,
+		reqDuration:    reqDuration,
+		resDuration:    resDuration,
+		delayDuration:  delayDuration,
+		proto:          proto,
+		newConn:        newConn,
+		encoded:        encoded,
+		ranged:         rangeStart >= 0 || b.Range != "",
+		rangeStart:     rangeStart,
+		aborted:        abort,
+		drained:        stopAt > 0 && t > stopAt,
+		paced:          at.scheduled > 0,
+		lag:            max(s-at.scheduled, 0),
+		capped:         at.capped,
+		worker:         at.worker,
+		iteration:      at.iteration,
+		sent:           sent,
+		earlyHint:      earlyHint,
+		hintLinks:      hintLinks,
+		final:          final,
+		trailer:        trailer,
+		traceID:        traceID,
+		idemKey:        idemKey,
+		params:         params,
+		compared:       compared,
+		diff:           diff,
+		headersChecked: headersChecked,
+		headerFailures: headerFailures,
+		security:       security,
+		backend:        backend,
+		target:         target,
+		capture:        captured,
+		body:           bodyName,
+		redirects:      redirects,
+		family:         family,
+		fallback:       dials.fellBack(),
+		resumed:        resumed,
+		date:           date,
+		connID:         connID,
+		probe:          at.probe,
+		resolver:       resolverName,
+		dnsFailed:      dnsFailed,
+	}
+	if b.down != nil {
+		b.down.observe(res, t)
 	}
+	b.results <- res
+}
 
+// runWorker makes n requests, unless the run is stopped or quit is closed.
+func (b *Work) runWorker(client *http.Client, n, worker int, rnd *rand.Rand, quit <-chan struct{}) {
+	tmpl := b.workerTemplate(rnd, worker)
 	if b.DisableRedirects {
 		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
@@ -220,46 +1033,202 @@ func (b *Work) runWorker(client *http.Client, n int) {
 	for i := 0; i < n; i++ {
 		// Check if application is stopped. Do not send into a closed channel.
 		select {
-		case <-b.stopCh:
+		case <-b.ctx.Done():
+			return
+		case <-quit:
 			return
 		default:
-			if b.QPS > 0 {
-				<-throttle
+			at := attempt{worker: worker}
+			if b.Iterations > 0 {
+				at.iteration = i + 1
+			}
+			if b.down != nil {
+				probe, ok := b.down.wait(b.ctx.Done(), quit)
+				if !ok {
+					return
+				}
+				at.probe = probe
+			}
+			// The limiter is replaced when the rate is adjusted.
+			for l := b.limiter.Load(); l != nil; l = b.limiter.Load() {
+				if due, ok := l.wait(b.ctx.Done()); ok {
+					at.scheduled = due
+					break
+				}
+				if b.ctx.Err() != nil {
+					return
+				}
+			}
+			if b.slots != nil {
+				select {
+				case b.slots <- struct{}{}:
+				default:
+					at.capped = true
+					select {
+					case b.slots <- struct{}{}:
+					case <-b.ctx.Done():
+						return
+					case <-quit:
+						return
+					}
+				}
+			}
+			b.makeRequest(client, rnd, tmpl, at)
+			done := now()
+			if at.probe {
+				b.down.probed(now())
+			}
+			if b.slots != nil {
+				<-b.slots
+			}
+			if b.ThinkTime != nil {
+				select {
+				case <-time.After(b.ThinkTime.Sample(rnd)):
+				case <-b.ctx.Done():
+					return
+				case <-quit:
+					return
+				}
+				if b.report.keepalive != nil {
+					b.probeKeepAlive(client, now()-done)
+				}
 			}
-			b.makeRequest(client)
 		}
 	}
 }
 
-func (b *Work) runWorkers() {
-	var wg sync.WaitGroup
-	wg.Add(b.C)
+// clients returns the HTTP clients for workers to use, as many as there
+// are transports with the Transport strategy, and with H2 the pools of
+// their HTTP/2 connections.
+func (b *Work) clients() ([]*http.Client, []*h2Pool) {
+	n := 1
+	switch b.transport() {
+	case TransportPerWorker:
+		n = b.C
+	case TransportPerCPU:
+		n = min(runtime.GOMAXPROCS(0), b.C)
+	}
+	// Every client certificate has a transport of its own.
+	n = max(n, min(len(b.ClientCerts), b.C))
+	clients := make([]*http.Client, n)
+	var pools []*h2Pool
+	for i := range clients {
+		var rt http.RoundTripper
+		if b.transport() == TransportPerTarget {
+			rt, pools = b.targetTransport(i, n, pools)
+		} else if b.Conns != nil {
+			rt = b.Conns.transport(b)
+			b.report.transports++
+		} else {
+			var pool *h2Pool
+			rt, pool = b.newTransport(i, n, b.Request.Host)
+			if pool != nil {
+				pools = append(pools, pool)
+			}
+			b.report.transports++
+		}
+		clients[i] = &http.Client{Transport: rt, Timeout: time.Duration(b.Timeout) * time.Second}
+		if !b.DisableRedirects {
+			clients[i].CheckRedirect = checkRedirect
+		}
+	}
+	return clients, pools
+}
 
+// transport returns the Transport strategy, which is always
+// TransportPerWorker with NTLM.
+func (b *Work) transport() string {
+	switch {
+	case b.NTLM != nil:
+		return TransportPerWorker
+	case b.Transport == "":
+		return TransportShared
+	}
+	return b.Transport
+}
+
+// newTransport returns the i-th of n transports, which connects with
+// serverName as the TLS server name, and its HTTP/2 pool with H2.
+func (b *Work) newTransport(i, n int, serverName string) (http.RoundTripper, *h2Pool) {
 	tr := &http.Transport{
 		TLSClientConfig: &tls.Config{
 			InsecureSkipVerify: true,
-			ServerName:         b.Request.Host,
+			ServerName:         serverName,
+			KeyLogWriter:       b.TLSKeyLogWriter,
 		},
-		MaxIdleConnsPerHost: min(b.C, maxIdleConn),
-		DisableCompression:  b.DisableCompression,
+		MaxIdleConnsPerHost: min((b.C+n-1)/n, maxIdleConn),
+		DisableCompression:  b.DisableCompression || b.AcceptEncoding != "",
 		DisableKeepAlives:   b.DisableKeepAlives,
 		Proxy:               http.ProxyURL(b.ProxyAddr),
+		DialContext:         b.dialContext(),
+	}
+	if b.Conns != nil {
+		b.Conns.keep(tr)
+	}
+	if len(b.ClientCerts) > 0 {
+		tr.TLSClientConfig.Certificates = []tls.Certificate{b.ClientCerts[i%len(b.ClientCerts)]}
+	}
+	if b.TLSResume {
+		tr.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	if b.H3 {
+		// QUIC only takes the TLS configuration, the rest is for TCP.
+		var rt http.RoundTripper = b.newH3Transport(tr.TLSClientConfig)
+		if b.Negotiate != nil {
+			rt = &negotiateTransport{tr: rt, krb: b.Negotiate}
+		}
+		return rt, nil
 	}
+	var pool *h2Pool
 	if b.H2 {
-		http2.ConfigureTransport(tr)
+		pool = configureH2(tr, b.report.h2)
 	} else {
 		tr.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
 	}
-	client := &http.Client{Transport: tr, Timeout: time.Duration(b.Timeout) * time.Second}
+	if b.NTLM != nil {
+		// A second connection would start unauthenticated, and could
+		// take the second request of a handshake begun on the first.
+		tr.MaxConnsPerHost = 1
+		return &ntlmTransport{tr: tr, creds: b.NTLM}, pool
+	}
+	if b.Negotiate != nil {
+		return &negotiateTransport{tr: tr, krb: b.Negotiate}, pool
+	}
+	return tr, pool
+}
 
+func (b *Work) runWorkers() {
+	clients, pools := b.clients()
+	if b.report.pings != nil {
+		defer b.pingH2(pools)()
+	}
+
+	if b.MaxInFlight > 0 {
+		b.slots = make(chan struct{}, b.MaxInFlight)
+	}
+	seed := b.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
 	// Ignore the case where b.N % b.C != 0.
+	n := b.N / b.C
+	if b.Iterations > 0 {
+		n = b.Iterations
+	}
+	b.pool.mu.Lock()
+	b.pool.clients, b.pool.n, b.pool.seed = clients, n, seed
+	b.pool.qps = b.QPS
+	b.pool.done = make(chan struct{})
+	b.setRate(b.QPS * float64(b.C))
 	for i := 0; i < b.C; i++ {
-		go func() {
-			b.runWorker(client, b.N/b.C)
-			wg.Done()
-		}()
+		b.startWorker()
 	}
-	wg.Wait()
+	b.pool.mu.Unlock()
+	<-b.pool.done
+
+	b.pool.mu.Lock()
+	b.setRate(0)
+	b.pool.mu.Unlock()
 }
 
 // cloneRequest returns a clone of the provided *http.Request.
@@ -16,13 +16,54 @@ package requester
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
 	"io/ioutil"
+	"math"
+	"math/big"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
 )
 
 func TestN(t *testing.T) {
@@ -132,3 +173,3680 @@ func TestBody(t *testing.T) {
 		t.Errorf("Expected to work 10 times, found %v", count)
 	}
 }
+
+func TestAcceptEncoding(t *testing.T) {
+	var acceptEncoding string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		zw.Write(bytes.Repeat([]byte("a"), 1000))
+		zw.Close()
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:        req,
+		N:              2,
+		C:              1,
+		AcceptEncoding: "gzip, br",
+		Writer:         ioutil.Discard,
+	}
+	w.Run()
+	if acceptEncoding != "gzip, br" {
+		t.Errorf("Accept-Encoding is expected to be gzip, br, %v is found", acceptEncoding)
+	}
+	if got, want := w.report.decompressedTotal, int64(2000); got != want {
+		t.Errorf("Expected %v decompressed bytes, found %v", want, got)
+	}
+	if w.report.compressedTotal == 0 || w.report.compressedTotal >= 2000 {
+		t.Errorf("Unexpected compressed byte count %v", w.report.compressedTotal)
+	}
+}
+
+func TestReadEncodedBodyStacked(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(bytes.Repeat([]byte("a"), 1000))
+	zw.Close()
+	var body bytes.Buffer
+	bw := brotli.NewWriter(&body)
+	bw.Write(gz.Bytes())
+	bw.Close()
+
+	for _, header := range [][]string{{"gzip, br"}, {"gzip", "br"}} {
+		resp := &http.Response{Header: http.Header{"Content-Encoding": header}, Body: ioutil.NopCloser(bytes.NewReader(body.Bytes()))}
+		eb, err := readEncodedBody(resp)
+		if err != nil || !eb.decoded || eb.decompressed != 1000 {
+			t.Errorf("Content-Encoding %q: expected 1000 decoded bytes, found %v (decoded %v, err %v)", header, eb.decompressed, eb.decoded, err)
+		}
+	}
+	resp := &http.Response{Header: http.Header{"Content-Encoding": {"gzip, zstd"}}, Body: ioutil.NopCloser(bytes.NewReader(body.Bytes()))}
+	if eb, err := readEncodedBody(resp); err != nil || eb.decoded {
+		t.Errorf("Expected a body with an unsupported coding not to be decoded, found %+v (%v)", eb, err)
+	}
+}
+
+func TestAcceptEncodingBrotli(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "br")
+		bw := brotli.NewWriter(w)
+		bw.Write(bytes.Repeat([]byte("a"), 1000))
+		bw.Close()
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:        req,
+		N:              2,
+		C:              1,
+		AcceptEncoding: "br",
+		Writer:         ioutil.Discard,
+	}
+	w.Run()
+	if w.report.numDecoded != 2 || w.report.numUndecoded != 0 {
+		t.Errorf("Expected 2 decoded responses, found %v decoded and %v not", w.report.numDecoded, w.report.numUndecoded)
+	}
+	if got, want := w.report.decompressedTotal, int64(2000); got != want {
+		t.Errorf("Expected %v decompressed bytes, found %v", want, got)
+	}
+}
+
+func TestTLSKeyLog(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	var keyLog bytes.Buffer
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:         req,
+		N:               1,
+		C:               1,
+		TLSKeyLogWriter: &keyLog,
+		Writer:          ioutil.Discard,
+	}
+	w.Run()
+	if !bytes.Contains(keyLog.Bytes(), []byte("CLIENT_")) {
+		t.Errorf("Expected TLS secrets to be logged, found %q", keyLog.String())
+	}
+}
+
+// testChain returns a CA and a leaf certificate it issued for 127.0.0.1,
+// with the leaf's key.
+func testChain(t *testing.T) (ca, leaf *x509.Certificate, caKey, leafKey *ecdsa.PrivateKey) {
+	caKey, _ = ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	leafKey, _ = ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(crand.Reader, caTmpl, caTmpl, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ = x509.ParseCertificate(der)
+	leafTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err = x509.CreateCertificate(crand.Reader, leafTmpl, ca, leafKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ = x509.ParseCertificate(der)
+	return ca, leaf, caKey, leafKey
+}
+
+// testOCSPResponse returns an OCSP response about the certificate with
+// serial, signed by ca.
+func testOCSPResponse(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, serial *big.Int, revoked bool) []byte {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	asn1.Unmarshal(ca.RawSubjectPublicKeyInfo, &spki)
+	nameHash := sha256.Sum256(ca.RawSubject)
+	keyHash := sha256.Sum256(spki.PublicKey.RightAlign())
+	keyID, _ := asn1.Marshal(keyHash[:])
+	single := ocspSingleResponse{
+		CertID: ocspCertID{
+			HashAlgorithm:  pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}},
+			IssuerNameHash: nameHash[:],
+			IssuerKeyHash:  keyHash[:],
+			SerialNumber:   serial,
+		},
+		Good:       asn1.Flag(!revoked),
+		ThisUpdate: time.Now().Add(-time.Minute).UTC().Truncate(time.Second),
+		NextUpdate: time.Now().Add(time.Hour).UTC().Truncate(time.Second),
+	}
+	if revoked {
+		single.Revoked = ocspRevokedInfo{RevocationTime: time.Now().Add(-time.Minute).UTC().Truncate(time.Second)}
+	}
+	tbs, err := asn1.Marshal(ocspResponseData{
+		ResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: keyID},
+		ProducedAt:  time.Now().UTC().Truncate(time.Second),
+		Responses:   []ocspSingleResponse{single},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(tbs)
+	sig, _ := ecdsa.SignASN1(crand.Reader, caKey, digest[:])
+	basic, err := asn1.Marshal(ocspBasicResponse{
+		TBSResponseData:    asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+		Signature:          asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := asn1.Marshal(ocspResponse{Response: ocspResponseBytes{Type: oidOCSPBasic, Response: basic}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestOCSPStapling(t *testing.T) {
+	ca, leaf, caKey, leafKey := testChain(t)
+	chain := []*x509.Certificate{leaf, ca}
+	now := time.Now()
+
+	info := checkOCSP(testOCSPResponse(t, ca, caKey, leaf.SerialNumber, false), chain, now)
+	if !info.Stapled || !info.Verified || info.Status != OCSPGood || !info.Valid() {
+		t.Errorf("Expected a valid good response, found %+v", info)
+	}
+	if info := checkOCSP(testOCSPResponse(t, ca, caKey, leaf.SerialNumber, false), chain, now.Add(2*time.Hour)); info.Valid() {
+		t.Errorf("Expected an expired response not to be valid, found %+v", info)
+	}
+	info = checkOCSP(testOCSPResponse(t, ca, caKey, leaf.SerialNumber, true), chain, now)
+	if !info.Verified || info.Status != OCSPRevoked || info.RevokedAt.IsZero() || info.Valid() {
+		t.Errorf("Expected a verified revoked response, found %+v", info)
+	}
+	info = checkOCSP(testOCSPResponse(t, ca, caKey, big.NewInt(3), false), chain, now)
+	if info.Verified || !strings.Contains(info.Error, "not about the server certificate") {
+		t.Errorf("Expected a response about another certificate not to verify, found %+v", info)
+	}
+	_, _, otherKey, _ := testChain(t)
+	info = checkOCSP(testOCSPResponse(t, ca, otherKey, leaf.SerialNumber, false), chain, now)
+	if info.Verified || !strings.Contains(info.Error, "signature") {
+		t.Errorf("Expected a response signed by another key not to verify, found %+v", info)
+	}
+	if info := checkOCSP([]byte("garbage"), chain, now); info.Verified || info.Error == "" {
+		t.Errorf("Expected a malformed response not to verify, found %+v", info)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{{
+		Certificate: [][]byte{leaf.Raw, ca.Raw},
+		PrivateKey:  leafKey,
+		OCSPStaple:  testOCSPResponse(t, ca, caKey, leaf.SerialNumber, false),
+	}}}
+	server.StartTLS()
+	defer server.Close()
+
+	var out bytes.Buffer
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request: req,
+		N:       2,
+		C:       1,
+		Writer:  &out,
+	}
+	w.Run()
+	if info := w.OCSP(); info == nil || !info.Valid() {
+		t.Fatalf("Expected the stapled response to be valid, found %+v", info)
+	}
+	if !strings.Contains(out.String(), "OCSP stapling:") || !strings.Contains(out.String(), "good, verified") {
+		t.Errorf("Expected the summary to report stapling, found:\n%s", out.String())
+	}
+
+	plain := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plain.Close()
+	req, _ = http.NewRequest("GET", plain.URL, nil)
+	w = &Work{Request: req, N: 1, C: 1, Writer: ioutil.Discard}
+	w.Run()
+	if info := w.OCSP(); info == nil || info.Stapled || info.Valid() {
+		t.Errorf("Expected no stapled response, found %+v", info)
+	}
+}
+
+func TestProtoDist(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request: req,
+		N:       4,
+		C:       1,
+		H2:      true,
+		Writer:  ioutil.Discard,
+	}
+	w.Run()
+	if got := w.report.protoDist["HTTP/2.0"]; got != 4 {
+		t.Errorf("Expected 4 HTTP/2.0 responses, found %v", got)
+	}
+	if got := w.report.connProtos["HTTP/2.0"]; got != 1 {
+		t.Errorf("Expected 1 HTTP/2.0 connection, found %v", got)
+	}
+}
+
+func TestCertExpiryWarning(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	certs := []CertificateInfo{
+		{Subject: "CN=leaf", NotAfter: now.Add(24 * time.Hour)},
+		{Subject: "CN=root", NotAfter: now.Add(365 * 24 * time.Hour)},
+	}
+	if got := certExpiryWarning(certs, time.Hour, now); got != "" {
+		t.Errorf("Expected no warning, found %q", got)
+	}
+	if got, want := certExpiryWarning(certs, 48*time.Hour, now), `certificate "CN=leaf" expires in 24h0m0s`; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if got, want := certExpiryWarning(certs, time.Hour, now.Add(25*time.Hour)), `certificate "CN=leaf" expired 1h0m0s ago`; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestRandomRange(t *testing.T) {
+	var mu sync.Mutex
+	ranges := make(map[string]bool)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges[r.Header.Get("Range")] = true
+		mu.Unlock()
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(make([]byte, 1000)))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:         req,
+		N:               20,
+		C:               2,
+		RangeObjectSize: 1000,
+		RangeLength:     100,
+		Writer:          ioutil.Discard,
+	}
+	w.Run()
+	if len(ranges) < 2 {
+		t.Errorf("Expected randomized ranges, found %v", ranges)
+	}
+	var partial int
+	for _, b := range w.report.ranges.snapshot() {
+		partial += b.Partial
+	}
+	if partial != 20 {
+		t.Errorf("Expected 20 partial responses, found %v", partial)
+	}
+}
+
+func TestSeed(t *testing.T) {
+	run := func(seed int64) map[string]int {
+		var mu sync.Mutex
+		ranges := make(map[string]int)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			ranges[r.Header.Get("Range")]++
+			mu.Unlock()
+		}))
+		defer server.Close()
+
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		w := &Work{
+			Request:         req,
+			N:               20,
+			C:               2,
+			RangeObjectSize: 1000000,
+			RangeLength:     100,
+			Seed:            seed,
+			Writer:          ioutil.Discard,
+		}
+		w.Run()
+		return ranges
+	}
+	a, b, c := run(42), run(42), run(43)
+	if fmt.Sprint(a) != fmt.Sprint(b) {
+		t.Errorf("Expected runs with the same seed to request the same ranges, found %v and %v", a, b)
+	}
+	if fmt.Sprint(a) == fmt.Sprint(c) {
+		t.Errorf("Expected runs with different seeds to request different ranges, found %v", a)
+	}
+}
+
+func TestParseSLO(t *testing.T) {
+	slo, err := ParseSLO("99.9% < 300ms over 30d")
+	if err != nil {
+		t.Fatalf("ParseSLO errored: %v", err)
+	}
+	if math.Abs(slo.Target-0.999) > 1e-9 {
+		t.Errorf("Expected a target of 0.999, found %v", slo.Target)
+	}
+	if slo.Threshold != 300*time.Millisecond || slo.Window != 30*24*time.Hour {
+		t.Errorf("Expected 300ms over 720h, found %v over %v", slo.Threshold, slo.Window)
+	}
+	for _, in := range []string{"99%", "100% < 1s over 1d", "99% < 1x over 1d"} {
+		if _, err := ParseSLO(in); err == nil {
+			t.Errorf("ParseSLO(%q) did not error", in)
+		}
+	}
+}
+
+func TestSLOBurnRate(t *testing.T) {
+	s := &sloStats{slo: SLO{Target: 0.99, Threshold: time.Second, Window: 30 * 24 * time.Hour}, rate: 10}
+	for i := 0; i < 98; i++ {
+		s.add(&result{statusCode: 200, duration: time.Millisecond})
+	}
+	s.add(&result{statusCode: 503, duration: time.Millisecond})
+	s.add(&result{statusCode: 200, duration: 2 * time.Second})
+	rep := s.snapshot()
+	if rep.Bad != 2 || rep.Good != 98 {
+		t.Errorf("Expected 98 good and 2 bad requests, found %v and %v", rep.Good, rep.Bad)
+	}
+	if math.Abs(rep.BurnRate-2) > 1e-9 {
+		t.Errorf("Expected a burn rate of 2, found %v", rep.BurnRate)
+	}
+	if got, want := rep.Exhaustion, 15*24*time.Hour; got != want {
+		t.Errorf("Expected the budget to last %v, found %v", want, got)
+	}
+}
+
+func TestChaosAbort(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("a"), 1<<16))
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:        req,
+		N:              20,
+		C:              2,
+		ChaosAbortRate: 1,
+		Writer:         ioutil.Discard,
+	}
+	w.Run()
+	var aborted int
+	for _, n := range w.report.abortDist {
+		aborted += n
+	}
+	if aborted != 20 {
+		t.Errorf("Expected 20 aborted requests, found %v", aborted)
+	}
+	if len(w.report.errorDist) != 0 {
+		t.Errorf("Expected aborts not to be reported as errors, found %v", w.report.errorDist)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	release := make(chan struct{})
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+	defer close(release)
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request: req,
+		N:       10,
+		C:       2,
+		Drain:   50 * time.Millisecond,
+		Writer:  ioutil.Discard,
+	}
+	w.Init()
+	time.AfterFunc(50*time.Millisecond, w.Stop)
+	w.Run()
+	if got := w.report.drain.Cancelled; got != 2 {
+		t.Errorf("Expected 2 requests to be cancelled when draining, found %v", got)
+	}
+	if len(w.report.lats) != 0 {
+		t.Errorf("Expected drained requests to be reported separately, found %v", w.report.lats)
+	}
+}
+
+func TestPauseOnDown(t *testing.T) {
+	var mu sync.Mutex
+	var first time.Time
+	var paused int // requests received while the target was known down
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if first.IsZero() {
+			first = time.Now()
+		}
+		since := time.Since(first)
+		if since > 250*time.Millisecond && since < 400*time.Millisecond {
+			paused++
+		}
+		mu.Unlock()
+		if since < 400*time.Millisecond {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:           req,
+		N:                 math.MaxInt32,
+		C:                 4,
+		PauseOnDown:       100 * time.Millisecond,
+		DownProbeInterval: 50 * time.Millisecond,
+		Writer:            ioutil.Discard,
+	}
+	w.Init()
+	time.AfterFunc(700*time.Millisecond, w.Stop)
+	w.Run()
+	if paused > 4 {
+		t.Errorf("Expected only probes to be sent while the target was down, found %d requests", paused)
+	}
+	rep := w.report.snapshot()
+	if len(rep.Outages) != 1 {
+		t.Fatalf("Expected a single outage, found %+v", rep.Outages)
+	}
+	o := rep.Outages[0]
+	if o.Start > 0.05 || o.End < 0.4 || o.End > 0.6 || o.Probes < 2 {
+		t.Errorf("Unexpected outage %+v; want one from the start to about 0.4 secs", o)
+	}
+	var out bytes.Buffer
+	if err := PrintReport(&out, rep, ""); err != nil {
+		t.Fatalf("PrintReport errored: %v", err)
+	}
+	if !strings.Contains(out.String(), "Outages (sending paused while the target was down):") {
+		t.Errorf("Expected the outages in the summary, found:\n%s", out.String())
+	}
+
+	// Outages are found again from raw results, by their probes.
+	records := []Record{
+		{Offset: 1, Duration: 0.1, Status: 200},
+		{Offset: 2, Duration: 0.1, Error: "connection refused"},
+		{Offset: 2.5, Duration: 0.1, Status: 503},
+		{Offset: 4, Duration: 0.1, Error: "connection refused", Probe: true},
+		{Offset: 5, Duration: 0.1, Status: 200, Probe: true},
+		{Offset: 6, Duration: 0.1, Status: 200},
+		{Offset: 8, Duration: 0.1, Status: 502},
+		{Offset: 9, Duration: 0.1, Status: 502, Probe: true},
+	}
+	rep = ReportFromRecords(records, nil)
+	want := []Outage{{Start: 1, End: 4.1, Probes: 2}, {Start: 7, Probes: 1}}
+	if fmt.Sprint(rep.Outages) != fmt.Sprint(want) {
+		t.Errorf("Unexpected outages %+v; want %+v", rep.Outages, want)
+	}
+	merged, err := MergeReports([]Report{rep, rep}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merged.Outages) != 4 || merged.Outages[1].Start != 1 {
+		t.Errorf("Unexpected merged outages %+v", merged.Outages)
+	}
+}
+
+// blockingSink holds up every write until release is closed.
+type blockingSink struct {
+	release chan struct{}
+	written int
+}
+
+func (s *blockingSink) Write(rec Record) error {
+	<-s.release
+	s.written++
+	return nil
+}
+
+func (s *blockingSink) Close() error { return nil }
+
+func TestSinkDropsWhenBehind(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{})}
+	s := newSinkWriter([]Sink{sink})
+	total := sinkQueue + 10
+	for i := 0; i < total; i++ {
+		s.write(Record{Status: 200})
+	}
+	if s.dropped < 9 || s.dropped > 10 {
+		t.Errorf("Expected 9 or 10 records to be dropped, found %v", s.dropped)
+	}
+	close(sink.release)
+	s.close()
+	if got := sink.written + int(s.dropped); got != total {
+		t.Errorf("Expected %v records written or dropped, found %v", total, got)
+	}
+}
+
+func TestSpoolsDoNotDrop(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{})}
+	s := newSpoolWriter([]Sink{sink})
+	total := sinkQueue + 10
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < total; i++ {
+			s.write(Record{Status: 200})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Expected writes to wait for the spool")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(sink.release)
+	<-done
+	s.close()
+	if s.dropped != 0 || sink.written != total {
+		t.Errorf("Expected all %v records written, found %v and %v dropped", total, sink.written, s.dropped)
+	}
+}
+
+func TestMarks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	var out bytes.Buffer
+	w := &Work{
+		Request: req,
+		N:       math.MaxInt32,
+		C:       2,
+		Output:  "ndjson",
+		Writer:  &out,
+	}
+	if err := w.Mark("too early"); err == nil {
+		t.Error("Expected marking before Init to fail")
+	}
+	w.Init()
+	time.AfterFunc(100*time.Millisecond, func() {
+		if err := w.Mark(" deploy v2 "); err != nil {
+			t.Errorf("Mark errored: %v", err)
+		}
+	})
+	time.AfterFunc(200*time.Millisecond, w.Stop)
+	w.Run()
+	if err := w.Mark("too late"); err == nil {
+		t.Error("Expected marking after the run to fail")
+	}
+	rep := w.report.snapshot()
+	if len(rep.Marks) != 1 || rep.Marks[0].Text != "deploy v2" || rep.Marks[0].Offset < 0.09 || rep.Marks[0].Offset > 0.15 {
+		t.Fatalf("Unexpected marks %+v; want deploy v2 at 0.1 secs", rep.Marks)
+	}
+
+	records, err := ReadRecords(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var marks int
+	for _, rec := range records {
+		if rec.Mark != "" {
+			marks++
+		}
+	}
+	if marks != 1 {
+		t.Errorf("Expected a single mark among the raw results, found %d", marks)
+	}
+	fromRecords := ReportFromRecords(records, nil)
+	if len(fromRecords.Marks) != 1 || fromRecords.NumRes != int64(len(records)-1) {
+		t.Errorf("Unexpected marks %+v of %d responses from %d records", fromRecords.Marks, fromRecords.NumRes, len(records))
+	}
+
+	var text, html, trace bytes.Buffer
+	if err := PrintReport(&text, fromRecords, ""); err != nil {
+		t.Fatalf("PrintReport errored: %v", err)
+	}
+	if !strings.Contains(text.String(), "Marks:") || !strings.Contains(text.String(), "deploy v2") {
+		t.Errorf("Expected the marks in the summary, found:\n%s", text.String())
+	}
+	if err := PrintReport(&html, fromRecords, "html"); err != nil {
+		t.Fatalf("PrintReport errored: %v", err)
+	}
+	if n := strings.Count(html.String(), "s: deploy v2</title></line>"); n != 2 {
+		t.Errorf("Expected the mark on both charts over time, found it on %d", n)
+	}
+	if err := WriteTrace(&trace, records); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(trace.String(), `"name":"deploy v2","cat":"mark","ph":"i"`) {
+		t.Errorf("Expected the mark as an instant event of the trace, found:\n%s", trace.String())
+	}
+}
+
+// serveDNS answers A queries on a local UDP port with 127.0.0.1, and
+// other queries with no records, until the test ends.
+func serveDNS(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			q := buf[:n]
+			// The question follows the 12 byte header: a name, then its
+			// type and class.
+			end := 12
+			for end < len(q) && q[end] != 0 {
+				end += int(q[end]) + 1
+			}
+			end += 5
+			if end > len(q) {
+				continue
+			}
+			resp := append([]byte(nil), q[:end]...)
+			resp[2], resp[3] = 0x81, 0x80
+			binary.BigEndian.PutUint16(resp[6:], 0)
+			binary.BigEndian.PutUint16(resp[8:], 0)
+			binary.BigEndian.PutUint16(resp[10:], 0)
+			if binary.BigEndian.Uint16(q[end-4:]) == 1 {
+				binary.BigEndian.PutUint16(resp[6:], 1)
+				resp = append(resp, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 127, 0, 0, 1)
+			}
+			conn.WriteTo(resp, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestResolvers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	resolvers := []string{serveDNS(t), serveDNS(t)}
+
+	req, _ := http.NewRequest("GET", "http://hey.test:"+port+"/", nil)
+	var out bytes.Buffer
+	w := &Work{
+		Request:   req,
+		N:         10,
+		C:         1,
+		Resolvers: resolvers,
+		FreshDNS:  1,
+		Output:    "ndjson",
+		Writer:    &out,
+	}
+	w.Run()
+	rep := w.report.snapshot()
+	if len(rep.ErrorDist) > 0 {
+		t.Fatalf("Unexpected errors %v", rep.ErrorDist)
+	}
+	if len(rep.DNS) != 2 {
+		t.Fatalf("Expected lookups through both resolvers, found %v", rep.DNS)
+	}
+	for _, r := range resolvers {
+		l := rep.DNS[r]
+		if l == nil || l.Lookups != 5 || l.Failed != 0 || l.Fastest <= 0 || len(l.Distribution) != len(dnsPercentiles) {
+			t.Errorf("Unexpected lookups through %s: %+v; want 5", r, l)
+		}
+	}
+
+	records, err := ReadRecords(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromRecords := ReportFromRecords(records, nil)
+	if l := fromRecords.DNS[resolvers[0]]; l == nil || l.Lookups != 5 {
+		t.Errorf("Unexpected lookups from records %+v", fromRecords.DNS)
+	}
+	var text bytes.Buffer
+	if err := PrintReport(&text, fromRecords, ""); err != nil {
+		t.Fatalf("PrintReport errored: %v", err)
+	}
+	if !strings.Contains(text.String(), "DNS lookups by resolver:") || !strings.Contains(text.String(), "["+resolvers[1]+"]\t5 lookups") {
+		t.Errorf("Expected the lookups in the summary, found:\n%s", text.String())
+	}
+	merged, err := MergeReports([]Report{fromRecords, fromRecords}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := merged.DNS[resolvers[0]]; l == nil || l.Lookups != 10 || l.Distribution != nil {
+		t.Errorf("Unexpected merged lookups %+v", l)
+	}
+}
+
+func TestAssertions(t *testing.T) {
+	for _, s := range []string{"tls_p99<50ms", "ttfb_p95 <= 100ms", "total_avg<1s", "dns_max<5ms", "res_p99.9<1ms"} {
+		a, err := ParseAssertion(s)
+		if err != nil {
+			t.Errorf("ParseAssertion(%q) errored: %v", s, err)
+			continue
+		}
+		if b, err := ParseAssertion(a.String()); err != nil || b != a {
+			t.Errorf("ParseAssertion(%q) = %+v, %v; want %+v", a.String(), b, err, a)
+		}
+	}
+	for _, s := range []string{"", "tls<50ms", "body_p99<50ms", "tls_p0<50ms", "tls_p101<50ms", "tls_p99>50ms", "tls_p99<50"} {
+		if _, err := ParseAssertion(s); err == nil {
+			t.Errorf("Expected ParseAssertion(%q) to fail", s)
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	var assertions []Assertion
+	for _, s := range []string{"ttfb_p95<10ms", "ttfb_max<1s", "conn_p99<1s", "tls_p99<1s"} {
+		a, _ := ParseAssertion(s)
+		assertions = append(assertions, a)
+	}
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	var out bytes.Buffer
+	w := &Work{
+		Request:    req,
+		N:          10,
+		C:          2,
+		Assertions: assertions,
+		Writer:     &out,
+	}
+	w.Run()
+	rep := w.report.snapshot()
+	if len(rep.Assertions) != 4 {
+		t.Fatalf("Unexpected assertion results %+v", rep.Assertions)
+	}
+	if res := rep.Assertions[0]; res.Passed || res.Samples != 10 || res.Value < 0.02 {
+		t.Errorf("Unexpected ttfb_p95 result %+v; want a failure over 10 samples", res)
+	}
+	if res := rep.Assertions[1]; !res.Passed {
+		t.Errorf("Unexpected ttfb_max result %+v; want a pass", res)
+	}
+	if res := rep.Assertions[2]; !res.Passed || res.Samples < 1 || res.Samples > 2 {
+		t.Errorf("Unexpected conn_p99 result %+v; want a pass over the new connections", res)
+	}
+	if res := rep.Assertions[3]; res.Passed || res.Samples != 0 {
+		t.Errorf("Unexpected tls_p99 result %+v; want a failure without samples", res)
+	}
+	failed := w.FailedAssertions()
+	if len(failed) != 2 || failed[0].Assertion != "ttfb_p95<10ms" || failed[1].Assertion != "tls_p99<1s" {
+		t.Errorf("Unexpected failed assertions %+v", failed)
+	}
+	if !strings.Contains(out.String(), "Assertions:") || !strings.Contains(out.String(), "ttfb_p95<10ms\tFAILED") {
+		t.Errorf("Expected the report to show the assertions, got:\n%s", out.String())
+	}
+}
+
+func TestSOAPFaults(t *testing.T) {
+	var n int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&n, 1) % 3 {
+		case 0:
+			fmt.Fprint(w, `<?xml version="1.0"?><soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body><soap:Fault><faultcode>soap:Server</faultcode><faultstring>quote service unavailable</faultstring></soap:Fault></soap:Body></soap:Envelope>`)
+		case 1:
+			fmt.Fprint(w, `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body><GetQuoteResponse><Fault>not a fault</Fault></GetQuoteResponse></soap:Body></soap:Envelope>`)
+		default:
+			fmt.Fprint(w, `not XML`)
+		}
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("POST", server.URL, nil)
+	w := &Work{
+		Request:    req,
+		N:          9,
+		C:          1,
+		SOAPFaults: true,
+		Writer:     ioutil.Discard,
+	}
+	w.Run()
+	rep := w.report.snapshot()
+	if got := rep.ErrorDist["SOAP fault: soap:Server: quote service unavailable"]; got != 3 {
+		t.Errorf("Got %d SOAP faults, want 3; errors %v", got, rep.ErrorDist)
+	}
+	if len(rep.Lats) != 6 {
+		t.Errorf("Got %d successful responses, want 6", len(rep.Lats))
+	}
+}
+
+func TestHeaderAssertions(t *testing.T) {
+	var n int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if atomic.AddInt32(&n, 1)%2 == 0 {
+			w.Header().Set("X-Cache", "MISS")
+		} else {
+			w.Header().Set("X-Cache", "HIT")
+		}
+	}))
+	defer server.Close()
+
+	var assertions []HeaderAssertion
+	for _, s := range []struct {
+		v     string
+		regex bool
+	}{{"x-cache: HIT", false}, {"Content-Type: application/json.*", true}, {"Strict-Transport-Security: max-age=.*", true}} {
+		a, err := ParseHeaderAssertion(s.v, s.regex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertions = append(assertions, a)
+	}
+	if _, err := ParseHeaderAssertion("X-Cache", false); err == nil {
+		t.Error("Expected an assertion without a value to fail")
+	}
+	if _, err := ParseHeaderAssertion("X-Cache: (", true); err == nil {
+		t.Error("Expected an invalid regular expression to fail")
+	}
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:          req,
+		N:                10,
+		C:                1,
+		HeaderAssertions: assertions,
+		Writer:           ioutil.Discard,
+	}
+	w.Run()
+	want := []HeaderAssertionReport{
+		{Assertion: "X-Cache: HIT", Checked: 10, Failed: 5, Example: "MISS"},
+		{Assertion: "Content-Type ~ application/json.*", Checked: 10},
+		{Assertion: "Strict-Transport-Security ~ max-age=.*", Checked: 10, Failed: 10, Example: "(missing)"},
+	}
+	if got := w.report.snapshot().HeaderAssertions; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected header assertions\n got: %+v\nwant: %+v", got, want)
+	}
+	if failed := w.FailedHeaderAssertions(); len(failed) != 2 || failed[0].Assertion != "X-Cache: HIT" {
+		t.Errorf("Unexpected failed header assertions %+v", failed)
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	var n int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if atomic.AddInt32(&n, 1)%4 == 0 {
+			w.Header().Set("X-Served-By", "node-b")
+			return
+		}
+		w.Header().Set("X-Served-By", "node-a")
+		w.Header().Set("Strict-Transport-Security", "max-age=31536000")
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	var out bytes.Buffer
+	w := &Work{
+		Request:         req,
+		N:               8,
+		C:               1,
+		SecurityHeaders: true,
+		BackendHeader:   "X-Served-By",
+		Writer:          &out,
+	}
+	w.Run()
+	reps := w.report.snapshot().SecurityHeaders
+	if len(reps) != len(SecurityHeaderNames) {
+		t.Fatalf("Got %d security headers, want %d", len(reps), len(SecurityHeaderNames))
+	}
+	hsts, csp, xcto := reps[0], reps[1], reps[2]
+	if hsts.Consistent() || hsts.Present != 6 || hsts.Responses != 8 {
+		t.Errorf("Unexpected HSTS report %+v; want 6 of 8 responses", hsts)
+	}
+	want := []DivergentBackend{{Backend: "node-b", Values: map[string]int64{missingHeader: 2}}}
+	if got := hsts.Divergent(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected divergent backends %+v; want %+v", got, want)
+	}
+	if !csp.Consistent() || csp.Present != 0 {
+		t.Errorf("Unexpected CSP report %+v; want it missing", csp)
+	}
+	if !xcto.Consistent() || xcto.Present != 8 || len(xcto.Divergent()) != 0 {
+		t.Errorf("Unexpected X-Content-Type-Options report %+v; want it consistent", xcto)
+	}
+	if !strings.Contains(out.String(), "backend node-b:\t[2] (missing)") {
+		t.Errorf("Expected the report to name node-b, got:\n%s", out.String())
+	}
+
+	merged, err := MergeReports([]Report{w.report.snapshot(), w.report.snapshot()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hsts := merged.SecurityHeaders[0]; hsts.Present != 12 || hsts.Backends["node-b"][missingHeader] != 4 {
+		t.Errorf("Unexpected merged HSTS report %+v", hsts)
+	}
+}
+
+func TestKeepAliveProbe(t *testing.T) {
+	var heads int64
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			atomic.AddInt64(&heads, 1)
+		}
+	})
+	short := httptest.NewUnstartedServer(handler)
+	short.Config.IdleTimeout = 20 * time.Millisecond
+	short.Start()
+	defer short.Close()
+	long := httptest.NewServer(handler)
+	defer long.Close()
+
+	run := func(url, think string) *KeepAliveReport {
+		req, _ := http.NewRequest("GET", url, nil)
+		dist, err := ParseDistribution(think)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := &Work{
+			Request:        req,
+			N:              3,
+			C:              1,
+			ThinkTime:      dist,
+			KeepAliveProbe: http.MethodHead,
+			Writer:         ioutil.Discard,
+		}
+		w.Run()
+		return w.report.snapshot().KeepAlive
+	}
+	k := run(short.URL, "constant(200ms)")
+	if k == nil || k.Method != "HEAD" || k.Probes != 3 || k.Closed != 3 || k.Alive != 0 || k.ShortestClosed < 0.2 {
+		t.Errorf("Expected the connections closed by the server, found %+v", k)
+	}
+	k = run(long.URL, "constant(10ms)")
+	if k == nil || k.Probes != 3 || k.Alive != 3 || k.Closed != 0 || k.Failed != 0 || k.LongestAlive < 0.01 {
+		t.Errorf("Expected the connections kept alive, found %+v", k)
+	}
+	if n := atomic.LoadInt64(&heads); n != 6 {
+		t.Errorf("Expected 6 HEAD probes, got %d", n)
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := "http://" + l.Addr().String()
+	l.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	run := func(url string, qps float64) *Work {
+		req, _ := http.NewRequest("GET", url, nil)
+		w := &Work{Request: req, N: 10, C: 1, QPS: qps, Writer: ioutil.Discard}
+		w.Run()
+		return w
+	}
+	if w := run(closed, 0); !w.Unreachable() || w.Saturated() {
+		t.Errorf("Expected an unreachable target, got unreachable %v, saturated %v", w.Unreachable(), w.Saturated())
+	}
+	if w := run(slow.URL, 1000); w.Unreachable() || !w.Saturated() {
+		t.Errorf("Expected a saturated client, got unreachable %v, saturated %v", w.Unreachable(), w.Saturated())
+	}
+	if w := run(slow.URL, 10); w.Unreachable() || w.Saturated() {
+		t.Errorf("Expected a run on schedule, got unreachable %v, saturated %v", w.Unreachable(), w.Saturated())
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	var out bytes.Buffer
+	w := &Work{Request: req, N: 5, C: 1, Output: "json", JSONRecords: true, Writer: &out}
+	w.Run()
+
+	var s Summary
+	if err := json.Unmarshal(out.Bytes(), &s); err != nil {
+		t.Fatalf("Could not decode the JSON summary: %v\n%s", err, out.String())
+	}
+	if s.Requests != 5 || s.StatusCodes[200] != 5 || s.Percentiles["p50"] == 0 {
+		t.Errorf("Unexpected summary %+v", s)
+	}
+	var n int
+	for _, b := range s.Histogram {
+		n += b.Count
+	}
+	if len(s.Histogram) == 0 || n != 5 {
+		t.Errorf("Expected a histogram of 5 requests, found %+v", s.Histogram)
+	}
+	if len(s.Records) != 5 || s.Records[0].Status != 200 || s.Records[0].Worker != 1 {
+		t.Errorf("Expected a record per request, found %+v", s.Records)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	// Every field of the outputs is described.
+	var check func(path string, s map[string]interface{})
+	check = func(path string, s map[string]interface{}) {
+		if items, ok := s["items"].(map[string]interface{}); ok {
+			check(path+"[]", items)
+		}
+		props, _ := s["properties"].(map[string]interface{})
+		for name, p := range props {
+			p := p.(map[string]interface{})
+			if p["description"] == nil {
+				t.Errorf("%s.%s has no description", path, name)
+			}
+			check(path+"."+name, p)
+		}
+	}
+	for _, name := range []string{RecordsSchema, SummarySchema} {
+		s, err := JSONSchema(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s["version"] != SchemaVersion || s["type"] != "object" {
+			t.Errorf("Unexpected schema %v", s)
+		}
+		check(name, s)
+	}
+	if _, err := JSONSchema("hey.unknown"); err == nil {
+		t.Errorf("Expected an unknown schema to be refused")
+	}
+
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.Encode(NewRecordsHeader())
+	enc.Encode(Record{Duration: 0.1, Status: 200})
+	records, err := ReadRecords(bytes.NewReader(out.Bytes()))
+	if err != nil || len(records) != 1 || records[0].Status != 200 {
+		t.Errorf("Expected the header to be left out of %+v, err %v", records, err)
+	}
+	var header map[string]interface{}
+	json.Unmarshal(bytes.SplitN(out.Bytes(), []byte("\n"), 2)[0], &header)
+	if _, ok := header["status"]; ok || len(header) != 2 {
+		t.Errorf("Expected the header to have only its schema and time, found %v", header)
+	}
+	h := NewRecordsHeader()
+	h.Schema.Version = SchemaVersion + 1
+	out.Reset()
+	enc.Encode(h)
+	if _, err := ReadRecords(bytes.NewReader(out.Bytes())); err == nil {
+		t.Errorf("Expected records of a newer schema version to be refused")
+	}
+	if s := SummaryOf(Report{}); s.Schema == nil || s.Schema.Name != SummarySchema || s.Schema.Fields["rps"] == "" {
+		t.Errorf("Unexpected schema of the summary %+v", s.Schema)
+	}
+}
+
+func TestNewWork(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+	req, _ := http.NewRequest("GET", server.URL, nil)
+
+	if _, err := NewWork(nil); err == nil {
+		t.Errorf("Expected a nil request to be refused")
+	}
+	if _, err := NewWork(req, WithRequests(1), WithConcurrency(2)); err == nil {
+		t.Errorf("Expected fewer requests than workers to be refused")
+	}
+
+	// Nothing is printed without a Writer.
+	stdout := os.Stdout
+	r, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = pw
+	w, err := NewWork(req, WithRequests(10), WithConcurrency(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep, err := w.RunContext(context.Background())
+	os.Stdout = stdout
+	pw.Close()
+	printed, _ := ioutil.ReadAll(r)
+	if err != nil || rep.NumRes != 10 || rep.StatusCodeDist[200] != 10 {
+		t.Errorf("Expected 10 successful requests, got %+v, err %v", rep.StatusCodeDist, err)
+	}
+	if len(printed) > 0 {
+		t.Errorf("Expected nothing printed, got:\n%s", printed)
+	}
+
+	var out bytes.Buffer
+	slow, _ := http.NewRequest("GET", server.URL+"/slow", nil)
+	w, _ = NewWork(slow, WithRequests(1000), WithConcurrency(1), WithOutput(&out, ""))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	rep, err = w.RunContext(ctx)
+	if err != context.DeadlineExceeded || rep == nil || rep.NumRes == 0 || rep.NumRes >= 1000 {
+		t.Errorf("Expected the run stopped by its context, got err %v", err)
+	}
+	if !strings.Contains(out.String(), "Summary:") {
+		t.Errorf("Expected the summary written to the output, got:\n%s", out.String())
+	}
+}
+
+func TestRecordTimes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	run := func(local bool) []Record {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		var out bytes.Buffer
+		w := &Work{Request: req, N: 3, C: 1, Output: "ndjson", LocalTime: local, Writer: &out}
+		// Offsets are from the start of the run, not of the process.
+		time.Sleep(50 * time.Millisecond)
+		w.Run()
+		records, err := ReadRecords(&out)
+		if err != nil || len(records) != 3 {
+			t.Fatalf("Expected 3 records, got %d, err %v", len(records), err)
+		}
+		for _, rec := range records {
+			if rec.Offset < 0 || rec.Offset > 0.05 {
+				t.Errorf("Expected offsets from the start of the run, got %v", rec.Offset)
+			}
+		}
+		return records
+	}
+	if rec := run(false)[0]; rec.Time.Location() != time.UTC {
+		t.Errorf("Expected times in UTC, got %v", rec.Time)
+	}
+	local := time.Local
+	defer func() { time.Local = local }()
+	time.Local = time.FixedZone("UTC+1", 3600)
+	if rec := run(true)[0]; rec.Time.Format(time.RFC3339)[19:] != "+01:00" {
+		t.Errorf("Expected times in the local time zone, got %v", rec.Time)
+	}
+}
+
+func TestTimeUnit(t *testing.T) {
+	records := []Record{
+		{Offset: 0, Duration: 0.0012, Status: 200},
+		{Offset: 0.001, Duration: 0.0034, Status: 200},
+	}
+	printed := func(unit, output string) string {
+		rep := ReportFromRecords(records, nil)
+		rep.TimeUnit = unit
+		var out bytes.Buffer
+		if err := PrintReport(&out, rep, output); err != nil {
+			t.Fatalf("PrintReport errored: %v", err)
+		}
+		return out.String()
+	}
+	if out := printed("", ""); !strings.Contains(out, "Slowest:\t0.0034 secs") || !strings.Contains(out, "  0.003 [1]") {
+		t.Errorf("Expected latencies in seconds by default, got:\n%s", out)
+	}
+	if out := printed(TimeUnitMillis, ""); !strings.Contains(out, "Slowest:\t3.400 ms") || !strings.Contains(out, "  3.400 [1]") {
+		t.Errorf("Expected latencies in milliseconds, got:\n%s", out)
+	}
+	if out := printed(TimeUnitMicros, "html"); !strings.Contains(out, "<td>3400.0 us</td>") {
+		t.Errorf("Expected latencies in microseconds, got:\n%s", out)
+	}
+}
+
+func TestStopCancelsInFlight(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w, err := NewWork(req, WithRequests(10), WithConcurrency(2))
+	if err != nil {
+		t.Fatalf("NewWork errored: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	rep, err := w.RunContext(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected the error of the context, got %v", err)
+	}
+	// The requests would otherwise run into the 20 seconds timeout.
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("Expected in-flight requests to be cancelled, the run took %v", took)
+	}
+	if rep.Drain.Cancelled != 2 {
+		t.Errorf("Expected 2 requests to be cancelled, found %v", rep.Drain.Cancelled)
+	}
+	if len(rep.ErrorDist) != 0 {
+		t.Errorf("Expected cancelled requests not to be reported as errors, found %v", rep.ErrorDist)
+	}
+}
+
+func TestForeignFormats(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	records := []Record{
+		{Offset: 0, Duration: 0.25, Conn: 0.05, ResRead: 0.05, Status: 200, Size: 10, Worker: 1, Time: at},
+		{Mark: "deploy", Time: at},
+		{Offset: 0.1, Duration: 0.5, Status: 503, Worker: 2, Time: at.Add(100 * time.Millisecond)},
+		{Offset: 0.2, Duration: 0.1, Error: "dial tcp: connection refused", Worker: 2, Time: at.Add(200 * time.Millisecond)},
+	}
+	var jtl bytes.Buffer
+	if err := WriteJTL(&jtl, records); err != nil {
+		t.Fatalf("WriteJTL errored: %v", err)
+	}
+	rows, err := csv.NewReader(&jtl).ReadAll()
+	if err != nil || len(rows) != 4 {
+		t.Fatalf("Expected a header and 3 rows, got %d, err %v", len(rows), err)
+	}
+	want := []string{"1714564800000", "250", "hey", "200", "OK", "hey 1-1", "text", "true", "", "10", "0", "2", "2", "", "200", "0", "50"}
+	if !reflect.DeepEqual(rows[1], want) {
+		t.Errorf("Expected row %q, got %q", want, rows[1])
+	}
+	if rows[2][7] != "false" || rows[3][7] != "false" || !strings.HasPrefix(rows[3][3], "Non HTTP response code: ") {
+		t.Errorf("Expected the 503 and the error to fail, got %q and %q", rows[2], rows[3])
+	}
+
+	var k6 bytes.Buffer
+	if err := WriteK6Summary(&k6, ReportFromRecords(records, nil)); err != nil {
+		t.Fatalf("WriteK6Summary errored: %v", err)
+	}
+	var s struct {
+		Metrics map[string]struct {
+			Type   string
+			Values map[string]float64
+		}
+	}
+	if err := json.Unmarshal(k6.Bytes(), &s); err != nil {
+		t.Fatalf("Invalid k6 summary: %v", err)
+	}
+	if v := s.Metrics["http_reqs"].Values; v["count"] != 3 {
+		t.Errorf("Expected 3 requests, got %v", v)
+	}
+	if v := s.Metrics["http_req_failed"].Values; v["passes"] != 2 || v["fails"] != 1 {
+		t.Errorf("Expected 2 failed requests of 3, got %v", v)
+	}
+	if m := s.Metrics["http_req_duration"]; m.Type != "trend" || m.Values["min"] != 250 || m.Values["max"] != 500 {
+		t.Errorf("Expected durations from 250 to 500ms, got %v", m)
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	var inflight, peak int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&inflight, 1)
+		for {
+			p := atomic.LoadInt64(&peak)
+			if n <= p || atomic.CompareAndSwapInt64(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt64(&inflight, -1)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:     req,
+		N:           40,
+		C:           10,
+		MaxInFlight: 3,
+		Writer:      ioutil.Discard,
+	}
+	w.Run()
+	if peak > 3 {
+		t.Errorf("Expected at most 3 requests in flight, found %v", peak)
+	}
+	in := w.report.snapshot().InFlight
+	if in.Limit != 3 || in.Capped == 0 {
+		t.Errorf("Expected a limit of 3 holding up requests, found %v and %v", in.Limit, in.Capped)
+	}
+}
+
+func TestIterations(t *testing.T) {
+	var count int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&count, 1)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:    req,
+		N:          15,
+		C:          3,
+		Iterations: 5,
+		Writer:     ioutil.Discard,
+	}
+	w.Run()
+	if count != 15 {
+		t.Errorf("Expected 15 requests, found %v", count)
+	}
+	it := w.report.snapshot().Iterations
+	if it == nil || it.PerWorker != 5 || it.Workers != 3 || it.Finished != 3 {
+		t.Fatalf("Expected 3 workers to finish 5 iterations, found %+v", it)
+	}
+	if len(it.Buckets) != 5 || it.Buckets[0].Count != 3 || it.Buckets[0].Iterations != "1" {
+		t.Errorf("Expected a bucket of 3 requests per iteration, found %+v", it.Buckets)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:   req,
+		N:         20,
+		C:         4,
+		Transport: TransportPerWorker,
+		Writer:    ioutil.Discard,
+	}
+	w.Run()
+	rep := w.report.snapshot()
+	// Workers make requests one after another, so each opens a single
+	// connection on its own transport.
+	if rep.Transport != TransportPerWorker || rep.Transports != 4 || rep.Connections != 4 {
+		t.Errorf("Expected 4 transports opening 4 connections, found %v %v opening %v", rep.Transports, rep.Transport, rep.Connections)
+	}
+}
+
+func TestTransportPerTarget(t *testing.T) {
+	var urls []*url.URL
+	for i := 0; i < 2; i++ {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer server.Close()
+		u, _ := url.Parse(server.URL + "/")
+		urls = append(urls, u, u)
+	}
+
+	req, _ := http.NewRequest("GET", urls[0].String(), nil)
+	w := &Work{
+		Request:   req,
+		URLs:      urls,
+		N:         20,
+		C:         1,
+		Transport: TransportPerTarget,
+		Writer:    ioutil.Discard,
+	}
+	w.Run()
+	rep := w.report.snapshot()
+	if rep.Transports != 2 || rep.Connections != 2 {
+		t.Errorf("Expected 2 transports opening 2 connections, found %v opening %v", rep.Transports, rep.Connections)
+	}
+	for target, ts := range rep.TargetDist {
+		if ts.Requests != 10 || ts.NewConns != 1 || ts.AvgConn() <= 0 {
+			t.Errorf("Unexpected stats of %s: %+v; want 10 requests over a new connection", target, ts)
+		}
+	}
+}
+
+func TestDialGrace(t *testing.T) {
+	// The server only listens once the run started, so the first dials
+	// are refused.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	time.AfterFunc(100*time.Millisecond, func() {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		server.Listener = l
+		server.Start()
+	})
+
+	req, _ := http.NewRequest("GET", "http://"+addr+"/", nil)
+	w := &Work{
+		Request:   req,
+		N:         4,
+		C:         2,
+		DialGrace: 5 * time.Second,
+		Writer:    ioutil.Discard,
+	}
+	w.Run()
+	rep := w.report.snapshot()
+	if len(rep.ErrorDist) != 0 || len(rep.Lats) != 4 {
+		t.Errorf("Expected 4 successful requests, got errors %v", rep.ErrorDist)
+	}
+	r := rep.DialRetries
+	if r == nil || r.Retries == 0 || r.Recovered == 0 || r.Failed != 0 || r.Grace != 5*time.Second {
+		t.Errorf("Unexpected dial retries %+v; want the dials recovered", r)
+	}
+}
+
+func TestCSVPhases(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request: req,
+		N:       1,
+		C:       1,
+		Output:  "csv",
+		Writer:  &out,
+	}
+	w.Run()
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], ",offset,Connect,TLS-handshake,timestamp") {
+		t.Fatalf("Unexpected CSV output %q", out.String())
+	}
+	fields := strings.Split(lines[1], ",")
+	if tls, err := strconv.ParseFloat(strings.TrimSpace(fields[9]), 64); err != nil || tls <= 0 {
+		t.Errorf("Expected the TLS handshake to be timed, found %q", fields[9])
+	}
+	if _, err := time.Parse(time.RFC3339Nano, fields[10]); err != nil {
+		t.Errorf("Expected an RFC 3339 timestamp, found %q", fields[10])
+	}
+}
+
+func TestEarlyHints(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/hinted" {
+			w.Header().Add("Link", "</style.css>; rel=preload; as=style, </app.js>; rel=preload; as=script")
+			w.WriteHeader(http.StatusEarlyHints)
+			time.Sleep(20 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	run := func(path string) Report {
+		req, _ := http.NewRequest("GET", server.URL+path, nil)
+		w := &Work{Request: req, N: 4, C: 2, Writer: ioutil.Discard}
+		w.Run()
+		return w.report.snapshot()
+	}
+	if hints := run("/").EarlyHints; hints != nil {
+		t.Errorf("Expected no Early Hints, found %+v", hints)
+	}
+	rep := run("/hinted")
+	var out bytes.Buffer
+	if err := PrintReport(&out, rep, ""); err != nil || !strings.Contains(out.String(), "Early Hints (4/4 responses hinted)") {
+		t.Errorf("Expected Early Hints in the summary, found %q (%v)", out.String(), err)
+	}
+	hints := rep.EarlyHints
+	if hints == nil || hints.Hinted != 4 || hints.Total != 4 || hints.Resources != 8 {
+		t.Fatalf("Expected 4 hinted responses with 2 resources each, found %+v", hints)
+	}
+	if hints.AverageFinal-hints.AverageHint < 0.02 {
+		t.Errorf("Expected final responses 20ms after the hints, found %v and %v", hints.AverageHint, hints.AverageFinal)
+	}
+}
+
+func TestTrailers(t *testing.T) {
+	var seq, sent int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		if r.Trailer.Get("X-Checksum") == "abc" {
+			atomic.AddInt64(&sent, 1)
+		}
+		w.Header().Set("Trailer", "Grpc-Status, X-Seq")
+		w.Write([]byte("ok"))
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set("X-Seq", strconv.FormatInt(atomic.AddInt64(&seq, 1), 10))
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request: req,
+		N:       15,
+		C:       1,
+		Trailer: http.Header{"X-Checksum": {"abc"}},
+		Writer:  ioutil.Discard,
+	}
+	w.Run()
+	if sent != 15 {
+		t.Errorf("Expected 15 requests with trailers, found %v", sent)
+	}
+	dist := w.report.snapshot().TrailerDist
+	if dist["Grpc-Status"]["0"] != 15 {
+		t.Errorf("Expected 15 Grpc-Status trailers, found %v", dist["Grpc-Status"])
+	}
+	if len(dist["X-Seq"]) != maxTrailerValues+1 || dist["X-Seq"][otherTrailerValue] != 5 {
+		t.Errorf("Expected distinct trailer values to be capped, found %v", dist["X-Seq"])
+	}
+}
+
+func TestTraceContext(t *testing.T) {
+	var mu sync.Mutex
+	traces := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(r.Header.Get("traceparent"), "-")
+		if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || parts[3] != "01" {
+			t.Errorf("Unexpected traceparent %q", r.Header.Get("traceparent"))
+			return
+		}
+		if got := r.Header.Get("tracestate"); got != "hey=1" {
+			t.Errorf("Unexpected tracestate %q", got)
+		}
+		mu.Lock()
+		traces[parts[1]] = true
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:     req,
+		N:           10,
+		C:           2,
+		Propagation: PropagationW3C,
+		TraceState:  "hey=1",
+		Output:      "ndjson",
+		Writer:      &out,
+	}
+	w.Run()
+	records, err := ReadRecords(&out)
+	if err != nil {
+		t.Fatalf("ReadRecords errored: %v", err)
+	}
+	if len(traces) != 10 {
+		t.Errorf("Expected 10 distinct traces, found %v", len(traces))
+	}
+	for _, rec := range records {
+		if !traces[rec.TraceID] {
+			t.Errorf("Recorded trace ID %q was not sent", rec.TraceID)
+		}
+	}
+}
+
+func TestB3Propagation(t *testing.T) {
+	var sampled int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.Header.Get("X-B3-TraceId")) == 32 && len(r.Header.Get("X-B3-SpanId")) == 16 && r.Header.Get("X-B3-Sampled") == "1" {
+			atomic.AddInt64(&sampled, 1)
+		}
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:     req,
+		N:           5,
+		C:           1,
+		Propagation: PropagationB3,
+		Writer:      ioutil.Discard,
+	}
+	w.Run()
+	if sampled != 5 {
+		t.Errorf("Expected 5 requests with B3 headers, found %v", sampled)
+	}
+}
+
+func TestUserAgents(t *testing.T) {
+	var mu sync.Mutex
+	uas := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		uas[r.UserAgent()]++
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("User-Agent", "hey")
+	w := &Work{
+		Request:    req,
+		N:          9,
+		C:          3,
+		UserAgents: []string{"a", "b", "c"},
+		Writer:     ioutil.Discard,
+	}
+	w.Run()
+	if len(uas) != 3 || uas["a"] != 3 || uas["b"] != 3 || uas["c"] != 3 {
+		t.Errorf("Expected each User-Agent to be sent 3 times, found %v", uas)
+	}
+}
+
+func TestNthAddr(t *testing.T) {
+	tests := []struct {
+		prefix string
+		n      uint64
+		want   string
+	}{
+		{"10.0.0.0/16", 0, "10.0.0.0"},
+		{"10.0.0.0/16", 300, "10.0.1.44"},
+		{"10.0.0.0/16", 65536 + 1, "10.0.0.1"},
+		{"192.168.1.77/30", 6, "192.168.1.78"},
+		{"2001:db8::/32", 1<<16 + 1, "2001:db8::1:1"},
+	}
+	for _, tt := range tests {
+		if got := nthAddr(netip.MustParsePrefix(tt.prefix), tt.n); got.String() != tt.want {
+			t.Errorf("nthAddr(%v, %v) = %v; want %v", tt.prefix, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestNDJSONRecords(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request: req,
+		N:       10,
+		C:       2,
+		Output:  "ndjson",
+		Writer:  &out,
+	}
+	w.Run()
+	records, err := ReadRecords(&out)
+	if err != nil {
+		t.Fatalf("ReadRecords errored: %v", err)
+	}
+	if len(records) != 10 {
+		t.Fatalf("Expected 10 records, found %v", len(records))
+	}
+	rep := ReportFromRecords(records, nil)
+	if rep.NumRes != 10 || rep.StatusCodeDist[200] != 10 {
+		t.Errorf("Expected 10 responses with status 200, found %v: %v", rep.NumRes, rep.StatusCodeDist)
+	}
+	if rep.Slowest <= 0 || rep.Total <= 0 {
+		t.Errorf("Expected latencies to be recomputed, found slowest %v and total %v", rep.Slowest, rep.Total)
+	}
+}
+
+// runConnRecords runs w against server with RecordConns, returning the
+// records of connections and of requests.
+func runConnRecords(t *testing.T, server *httptest.Server, w *Work) (conns []*ConnRecord, reqs []Record) {
+	var out bytes.Buffer
+	w.Request, _ = http.NewRequest("GET", server.URL, nil)
+	w.C, w.Output, w.Writer, w.RecordConns = 1, "ndjson", &out, true
+	w.Run()
+	records, err := ReadRecords(&out)
+	if err != nil {
+		t.Fatalf("ReadRecords errored: %v", err)
+	}
+	for _, rec := range records {
+		if rec.Connection != nil {
+			conns = append(conns, rec.Connection)
+		} else {
+			reqs = append(reqs, rec)
+		}
+	}
+	if rep := ReportFromRecords(records, nil); rep.NumRes != int64(w.N) {
+		t.Errorf("Expected connections not to count as responses, found %v", rep.NumRes)
+	}
+	return conns, reqs
+}
+
+func TestConnRecords(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+	conns, reqs := runConnRecords(t, server, &Work{N: 4})
+	if len(conns) != 1 {
+		t.Fatalf("Expected 1 connection, found %v", len(conns))
+	}
+	c := conns[0]
+	if c.ID != 1 || c.Requests != 4 || c.CloseReason != ConnOpen || c.TLS <= 0 || c.Lifetime <= 0 {
+		t.Errorf("Unexpected connection record %+v", c)
+	}
+	if c.BytesRead <= 0 || c.BytesWritten <= 0 || c.Addr != server.Listener.Addr().String() {
+		t.Errorf("Unexpected connection record %+v", c)
+	}
+	for _, rec := range reqs {
+		if rec.ConnID != c.ID {
+			t.Errorf("Expected requests on connection %v, found %v", c.ID, rec.ConnID)
+		}
+	}
+}
+
+func TestConnRecordsSingleUse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	conns, reqs := runConnRecords(t, server, &Work{N: 3, DisableKeepAlives: true})
+	if len(conns) != 3 {
+		t.Fatalf("Expected 3 connections, found %v", len(conns))
+	}
+	ids := make(map[int64]bool)
+	for _, c := range conns {
+		if c.Requests != 1 || c.CloseReason != ConnClosedByClient || c.TLS != 0 {
+			t.Errorf("Unexpected connection record %+v", c)
+		}
+		ids[c.ID] = true
+	}
+	for _, rec := range reqs {
+		if !ids[rec.ConnID] {
+			t.Errorf("Request on unrecorded connection %v", rec.ConnID)
+		}
+	}
+}
+
+func TestConnRecordsServerClosed(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Config.IdleTimeout = 10 * time.Millisecond
+	server.Start()
+	defer server.Close()
+	conns, _ := runConnRecords(t, server, &Work{N: 3, QPS: 10})
+	if len(conns) != 3 {
+		t.Fatalf("Expected 3 connections, found %v", len(conns))
+	}
+	for _, c := range conns[:2] {
+		if c.Requests != 1 || c.CloseReason != ConnClosedByServer {
+			t.Errorf("Expected idle connections to be closed by the server, found %+v", c)
+		}
+	}
+}
+
+func TestConnRecordsH2(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	conns, reqs := runConnRecords(t, server, &Work{N: 4, H2: true})
+	if len(conns) != 1 || conns[0].Requests != 4 {
+		t.Fatalf("Expected 1 connection serving 4 requests, found %+v", conns)
+	}
+	for _, rec := range reqs {
+		if rec.Proto != "HTTP/2.0" || rec.ConnID != conns[0].ID {
+			t.Errorf("Expected HTTP/2 requests on connection %v, found %v on %v", conns[0].ID, rec.Proto, rec.ConnID)
+		}
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	var snapshots bytes.Buffer
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:          req,
+		N:                30,
+		C:                1,
+		QPS:              100,
+		SnapshotInterval: 100 * time.Millisecond,
+		SnapshotWriter:   &snapshots,
+		Writer:           ioutil.Discard,
+	}
+	w.Run()
+	lines := strings.Split(strings.TrimSpace(snapshots.String()), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("Expected at least 2 snapshots, found %q", snapshots.String())
+	}
+	for _, l := range lines {
+		if !strings.HasSuffix(l, "errors 100.00%") {
+			t.Errorf("Expected 5xx responses to be counted as errors, found %q", l)
+		}
+	}
+}
+
+func TestErrorLog(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Reason", "overloaded")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write(bytes.Repeat([]byte("a"), maxErrorBody+10))
+	}))
+	defer server.Close()
+
+	var log bytes.Buffer
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:       req,
+		N:             10,
+		C:             2,
+		ErrorLog:      &log,
+		ErrorLogLimit: 3,
+		Writer:        ioutil.Discard,
+	}
+	w.Run()
+	out := log.String()
+	if n := strings.Count(out, "503 Service Unavailable"); n != 3 {
+		t.Errorf("Expected 3 logged responses, found %v", n)
+	}
+	if !strings.Contains(out, "X-Reason: overloaded") {
+		t.Errorf("Expected response headers to be logged, found %q", out)
+	}
+	if !strings.Contains(out, fmt.Sprintf("[truncated, %d of %d bytes shown]", maxErrorBody, maxErrorBody+10)) {
+		t.Errorf("Expected bodies to be truncated, found %q", out)
+	}
+}
+
+func TestFingerprintError(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{
+			`read tcp 127.0.0.1:54321->127.0.0.1:8080: read: connection reset by peer`,
+			`read tcp <addr>-><addr>: read: connection reset by peer`,
+		},
+		{
+			`Get "http://10.0.0.7:8080/users/123?id=9": dial tcp 10.0.0.7:8080: connect: connection refused`,
+			`Get "http://<addr>": dial tcp <addr>: connect: connection refused`,
+		},
+		{
+			`dial tcp [::1]:443: connect: connection refused`,
+			`dial tcp <addr>: connect: connection refused`,
+		},
+		{
+			`request 5f0c6e1a-8e2b-4c57-9d3e-2a1b7c9d0e4f failed after 1234567 bytes (trace 4bf92f3577b34da6a3ce929d0e0e4736)`,
+			`request <id> failed after <n> bytes (trace <id>)`,
+		},
+		{
+			`net/http: request canceled (Client.Timeout exceeded while awaiting headers)`,
+			`net/http: request canceled (Client.Timeout exceeded while awaiting headers)`,
+		},
+	}
+	for _, tt := range tests {
+		if got := fingerprintError(tt.in); got != tt.want {
+			t.Errorf("fingerprintError(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestStatusSeries(t *testing.T) {
+	records := []Record{
+		{Offset: 10, Duration: 0.1, Status: 200},
+		{Offset: 11.5, Duration: 0.1, Status: 200},
+		{Offset: 11.7, Duration: 0.1, Status: 503},
+		{Offset: 12.1, Duration: 0.1, Error: "timeout"},
+	}
+	rep := ReportFromRecords(records, nil)
+	var out bytes.Buffer
+	if err := PrintReport(&out, rep, "series"); err != nil {
+		t.Fatalf("PrintReport errored: %v", err)
+	}
+	want := "offset,200,503,errors,in-flight\n0,1,0,0,0.10\n1,1,1,0,0.20\n2,0,0,1,0.50\n"
+	if got := out.String(); got != want {
+		t.Errorf("Unexpected series output %q; want %q", got, want)
+	}
+}
+
+func TestHeatmap(t *testing.T) {
+	// Bimodal latencies, with a spike of slow responses in the middle.
+	var records []Record
+	for i := 0; i < 60; i++ {
+		d := 0.01
+		if i%2 == 1 || i >= 30 && i < 33 {
+			d = 1
+		}
+		records = append(records, Record{Offset: float64(i), Duration: d, Status: 200})
+	}
+	rep := ReportFromRecords(records, nil)
+	h := newHeatmap(rep.Lats, rep.Offsets)
+	var fast, slow int
+	for _, counts := range h.counts {
+		fast += counts[0]
+		slow += counts[heatmapRows-1]
+	}
+	if fast != 28 || slow != 32 {
+		t.Errorf("Expected 28 fast and 32 slow responses in the extreme rows, found %v and %v", fast, slow)
+	}
+	if h.counts[30][heatmapRows-1] != 1 || h.counts[0][0] != 1 {
+		t.Errorf("Expected responses in the column of their offset, found %v", h.counts)
+	}
+
+	var out bytes.Buffer
+	if err := PrintReport(&out, rep, "html"); err != nil {
+		t.Fatalf("PrintReport errored: %v", err)
+	}
+	if !strings.Contains(out.String(), "Latency over time") || !strings.Contains(out.String(), "0.98s-1.97s: 1 responses in 0.7943-1.0000 secs</title>") {
+		t.Errorf("Expected a latency heatmap in the HTML output, found:\n%s", out.String())
+	}
+	out.Reset()
+	if err := PrintReport(&out, rep, "png"); err != nil {
+		t.Fatalf("PrintReport errored: %v", err)
+	}
+	img, err := png.Decode(&out)
+	if err != nil {
+		t.Fatalf("Decoding the PNG output errored: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != heatmapColumns*heatmapCell || b.Dy() != heatmapRows*heatmapCell {
+		t.Errorf("Unexpected heatmap size %v", b)
+	}
+	if err := PrintReport(&out, Report{}, "png"); err == nil {
+		t.Error("Expected a PNG output without responses to error")
+	}
+}
+
+func TestPercentileConfidence(t *testing.T) {
+	if lo, hi, tooFew := percentileRanks(1000, 99); lo != 982 || hi != 996 || tooFew {
+		t.Errorf("Unexpected ranks %v-%v (too few %v) of p99 of 1000 samples; want 982-996", lo, hi, tooFew)
+	}
+	if _, hi, tooFew := percentileRanks(200, 99.9); hi != 199 || !tooFew {
+		t.Errorf("Expected too few samples for p99.9 of 200, found rank %v", hi)
+	}
+
+	var records []Record
+	for i := 1; i <= 200; i++ {
+		records = append(records, Record{Offset: float64(i), Duration: float64(i) / 1000, Status: 200})
+	}
+	rep := ReportFromRecords(records, []float64{50, 99, 99.9})
+	for _, d := range rep.LatencyDistribution {
+		if d.Low > d.Latency || d.High < d.Latency {
+			t.Errorf("Expected p%v %v within its interval %v-%v", d.Percentage, d.Latency, d.Low, d.High)
+		}
+		if d.TooFewSamples != (d.Percentage > 90) {
+			t.Errorf("Unexpected too few samples %v for p%v of 200 requests", d.TooFewSamples, d.Percentage)
+		}
+	}
+	if d := rep.LatencyDistribution[0]; d.Low < 0.08 || d.High > 0.12 {
+		t.Errorf("Expected the interval of p50 of 200 requests to be narrow, found %v-%v", d.Low, d.High)
+	}
+
+	var out bytes.Buffer
+	if err := PrintReport(&out, rep, ""); err != nil {
+		t.Fatalf("PrintReport errored: %v", err)
+	}
+	if !strings.Contains(out.String(), "\n  50% in 0.1010 secs\n") || strings.Contains(out.String(), "95% CI") || strings.Contains(out.String(), "WARNING") {
+		t.Errorf("Expected plain percentiles in the summary without Details, found:\n%s", out.String())
+	}
+	out.Reset()
+	rep.Details = true
+	if err := PrintReport(&out, rep, ""); err != nil {
+		t.Fatalf("PrintReport errored: %v", err)
+	}
+	if !strings.Contains(out.String(), "(95% CI ") || !strings.Contains(out.String(), "WARNING: too few requests (200) to tell p99, p99.9 from noise") {
+		t.Errorf("Expected confidence intervals and a warning in the summary, found:\n%s", out.String())
+	}
+	if s := SummaryOf(rep); len(s.Intervals) != 3 || len(s.TooFewSamples) != 2 {
+		t.Errorf("Unexpected intervals %v and too few samples %v in the JSON summary", s.Intervals, s.TooFewSamples)
+	}
+
+	merged, err := MergeReports([]Report{rep, rep}, []float64{50, 99})
+	if err != nil {
+		t.Fatalf("MergeReports errored: %v", err)
+	}
+	if d := merged.LatencyDistribution[1]; d.High == 0 || d.TooFewSamples {
+		t.Errorf("Expected p99 of 400 merged requests to be bounded, found %+v", d)
+	}
+}
+
+func TestMannWhitneyU(t *testing.T) {
+	a := []float64{1, 2, 3, 4, 5}
+	b := []float64{6, 7, 8, 9, 10}
+	greater, p := MannWhitneyU(a, b)
+	if greater != 1 || math.Abs(p-0.0122) > 0.0001 {
+		t.Errorf("Unexpected P(b > a) %v and p-value %v; want 1 and 0.0122", greater, p)
+	}
+	if greater, p := MannWhitneyU(b, a); greater != 0 || math.Abs(p-0.0122) > 0.0001 {
+		t.Errorf("Unexpected P(a > b) %v and p-value %v; want 0 and 0.0122", greater, p)
+	}
+	if greater, p := MannWhitneyU(a, a); greater != 0.5 || p != 1 {
+		t.Errorf("Expected identical samples not to differ, found %v and p-value %v", greater, p)
+	}
+	if greater, p := MannWhitneyU([]float64{1, 1}, []float64{1, 1}); greater != 0.5 || p != 1 {
+		t.Errorf("Expected tied samples not to differ, found %v and p-value %v", greater, p)
+	}
+}
+
+func TestKolmogorovSmirnov(t *testing.T) {
+	d, p := KolmogorovSmirnov([]float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10})
+	if d != 1 || p > 0.01 {
+		t.Errorf("Unexpected distance %v and p-value %v of disjoint samples", d, p)
+	}
+	// Same median, different shapes: bimodal against unimodal.
+	var uni, bi []float64
+	for i := 0; i < 200; i++ {
+		uni = append(uni, 0.5)
+		bi = append(bi, float64(i%2))
+	}
+	if _, p := MannWhitneyU(uni, bi); p < 0.05 {
+		t.Errorf("Expected no shift between samples of the same median, found p-value %v", p)
+	}
+	if d, p := KolmogorovSmirnov(uni, bi); d != 0.5 || p > 0.001 {
+		t.Errorf("Expected the shapes to differ, found distance %v and p-value %v", d, p)
+	}
+	if d, p := KolmogorovSmirnov(uni, uni); d != 0 || p != 1 {
+		t.Errorf("Expected identical samples not to differ, found distance %v and p-value %v", d, p)
+	}
+}
+
+func TestOutliers(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var records []Record
+	for i := 0; i < 100; i++ {
+		rec := Record{
+			Offset:   1 + float64(i)/10,
+			Duration: 0.01 + float64(i%5)/1000,
+			Status:   200,
+			NewConn:  i == 0,
+			Time:     start.Add(time.Duration(i) * 100 * time.Millisecond),
+		}
+		rec.Delay = rec.Duration
+		switch i {
+		case 20:
+			rec.Duration, rec.Delay = 1, 0.9
+			rec.Redirects = []RedirectHop{{URL: "http://example.com/", Duration: 0.1}}
+		case 50:
+			rec.Duration, rec.Conn = 3, 2.9
+			rec.NewConn = true
+		case 80:
+			rec.Duration, rec.Delay, rec.Status = 2, 2, 503
+		}
+		records = append(records, rec)
+	}
+	rep := ReportFromRecords(records, nil)
+	o := rep.Outliers
+	if o == nil {
+		t.Fatal("Expected outliers to be reported")
+	}
+	if o.Count != 3 || o.Responses != 100 || o.K != DefaultOutlierK {
+		t.Errorf("Unexpected %v outliers of %v responses at k %v; want 3 of 100 at k %v", o.Count, o.Responses, o.K, DefaultOutlierK)
+	}
+	if o.NewConns != 1 || o.AllNewConns != 2 || o.Redirected != 1 || o.AllRedirected != 1 {
+		t.Errorf("Unexpected new connections %v/%v and redirects %v/%v; want 1/2 and 1/1", o.NewConns, o.AllNewConns, o.Redirected, o.AllRedirected)
+	}
+	if o.StatusCodes[200] != 2 || o.StatusCodes[503] != 1 {
+		t.Errorf("Unexpected outlier status codes %v", o.StatusCodes)
+	}
+	if o.Phases[PhaseConn] != 1 || o.Phases[PhaseDelay] != 2 {
+		t.Errorf("Unexpected outlier phases %v", o.Phases)
+	}
+	if len(o.Slowest) != 3 || o.Slowest[0].Latency != 3 || o.Slowest[2].Latency != 1 {
+		t.Fatalf("Expected the outliers slowest first, found %+v", o.Slowest)
+	}
+	if s := o.Slowest[0]; s.Offset != 5 || !s.Time.Equal(start.Add(5*time.Second)) || !s.NewConn || s.Phase != PhaseConn {
+		t.Errorf("Unexpected slowest outlier %+v", s)
+	}
+
+	var out bytes.Buffer
+	if err := PrintReport(&out, rep, ""); err != nil {
+		t.Fatalf("PrintReport errored: %v", err)
+	}
+	if strings.Contains(out.String(), "Outliers") {
+		t.Errorf("Expected no outliers in the summary without Details, found:\n%s", out.String())
+	}
+	out.Reset()
+	rep.Details = true
+	if err := PrintReport(&out, rep, ""); err != nil {
+		t.Fatalf("PrintReport errored: %v", err)
+	}
+	if !strings.Contains(out.String(), "Outliers (3/100 responses over ") || !strings.Contains(out.String(), "[503] 1") {
+		t.Errorf("Expected outliers in the summary, found:\n%s", out.String())
+	}
+	out.Reset()
+	if err := PrintReport(&out, rep, "html"); err != nil {
+		t.Fatalf("PrintReport errored: %v", err)
+	}
+	if !strings.Contains(out.String(), "<h2>Outliers</h2>") {
+		t.Errorf("Expected outliers in the HTML summary, found:\n%s", out.String())
+	}
+
+	merged, err := MergeReports([]Report{rep, rep}, nil)
+	if err != nil {
+		t.Fatalf("MergeReports errored: %v", err)
+	}
+	if m := merged.Outliers; m == nil || m.Count != 6 || m.Responses != 200 || len(m.Slowest) != 6 {
+		t.Errorf("Unexpected merged outliers %+v", m)
+	}
+
+	if rep := ReportFromRecords(records[:minOutlierSamples-1], nil); rep.Outliers != nil {
+		t.Errorf("Expected no outliers of %v responses, found %+v", minOutlierSamples-1, rep.Outliers)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request: req,
+		N:       10,
+		C:       2,
+		Output:  "trace",
+		Writer:  &out,
+	}
+	w.Run()
+	var events []traceEvent
+	if err := json.Unmarshal(out.Bytes(), &events); err != nil {
+		t.Fatalf("Trace is not a JSON array of events: %v\n%s", err, out.String())
+	}
+	tracks := make(map[int]string)
+	var spans []traceEvent
+	for _, e := range events {
+		switch {
+		case e.Name == "thread_name":
+			tracks[e.Tid] = e.Args["name"].(string)
+		case e.Cat == "request":
+			spans = append(spans, e)
+		}
+	}
+	if len(spans) != 10 {
+		t.Fatalf("Expected 10 request spans, found %v", len(spans))
+	}
+	for _, s := range spans {
+		if s.Ph != "X" || s.Name != "200" || s.Dur <= 0 {
+			t.Errorf("Unexpected request span %+v", s)
+		}
+		if tracks[s.Tid] != fmt.Sprintf("worker %d", s.Tid) {
+			t.Errorf("Expected span on a named worker track, found tid %v in %v", s.Tid, tracks)
+		}
+	}
+
+	out.Reset()
+	records := []Record{
+		{Offset: 1, Duration: 0.5, Conn: 0.1, Delay: 0.3, ResRead: 0.1, Status: 503},
+		{Offset: 1, Connection: &ConnRecord{ID: 1}},
+		{Offset: 2, Error: "dial tcp: connection refused", Worker: 3},
+	}
+	if err := WriteTrace(&out, records); err != nil {
+		t.Fatalf("WriteTrace errored: %v", err)
+	}
+	events = nil
+	if err := json.Unmarshal(out.Bytes(), &events); err != nil {
+		t.Fatalf("Trace is not a JSON array of events: %v", err)
+	}
+	var names []string
+	for _, e := range events {
+		if e.Ph == "X" {
+			names = append(names, fmt.Sprintf("%v@%v+%v/%v", e.Name, e.Ts, e.Dur, e.Tid))
+		}
+	}
+	want := "503@1e+06+500000/0 DNS+dialup@1e+06+100000/0 resp wait@1.1e+06+300000/0 resp read@1.4e+06+100000/0 error@2e+06+0/3"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("Unexpected spans %v; want %v", got, want)
+	}
+}
+
+func TestClientCerts(t *testing.T) {
+	clientCert := func(name string) tls.Certificate {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		der, err := x509.CreateCertificate(crand.Reader, tmpl, tmpl, key.Public(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	}
+
+	var mu sync.Mutex
+	clients := make(map[string]int)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		clients[r.TLS.PeerCertificates[0].Subject.CommonName]++
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:     req,
+		N:           8,
+		C:           4,
+		ClientCerts: []tls.Certificate{clientCert("a"), clientCert("b")},
+		Writer:      ioutil.Discard,
+	}
+	w.Run()
+	if len(clients) != 2 || clients["a"] != 4 || clients["b"] != 4 {
+		t.Errorf("Expected 4 requests with each certificate, found %v", clients)
+	}
+	if rep := w.report.snapshot(); rep.Transports != 2 {
+		t.Errorf("Expected a transport per certificate, found %v", rep.Transports)
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	var mu sync.Mutex
+	auths := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		auths[r.Header.Get("Authorization")]++
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.SetBasicAuth("shared", "secret")
+	w := &Work{
+		Request:     req,
+		N:           12,
+		C:           3,
+		Credentials: []string{"Bearer a", "Bearer b"},
+		Writer:      ioutil.Discard,
+	}
+	w.Run()
+	// Workers 1 and 3 share the first credentials.
+	if len(auths) != 2 || auths["Bearer a"] != 8 || auths["Bearer b"] != 4 {
+		t.Errorf("Unexpected credentials sent %v; want 8 of the first and 4 of the second", auths)
+	}
+}
+
+func TestNTLM(t *testing.T) {
+	creds := &NTLMCredentials{Domain: "CORP", User: "alice", Password: "s3cret"}
+	serverChallenge := []byte("\x01\x23\x45\x67\x89\xab\xcd\xef")
+	targetInfo := binary.LittleEndian.AppendUint16(nil, ntlmAvTimestamp)
+	targetInfo = binary.LittleEndian.AppendUint16(targetInfo, 8)
+	targetInfo = binary.LittleEndian.AppendUint64(targetInfo, ntlmFiletime(time.Now()))
+	targetInfo = append(targetInfo, 0, 0, 0, 0)
+	challenge := make([]byte, 48)
+	copy(challenge, ntlmSignature)
+	binary.LittleEndian.PutUint32(challenge[8:], 2)
+	binary.LittleEndian.PutUint32(challenge[16:], 48)
+	binary.LittleEndian.PutUint32(challenge[20:], ntlmFlags)
+	copy(challenge[24:], serverChallenge)
+	binary.LittleEndian.PutUint16(challenge[40:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint16(challenge[42:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint32(challenge[44:], 48)
+	challenge = append(challenge, targetInfo...)
+
+	var mu sync.Mutex
+	negotiated := make(map[string]bool)
+	authenticated := make(map[string]bool)
+	var handshakes int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if authenticated[r.RemoteAddr] {
+			return
+		}
+		auth, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(r.Header.Get("Authorization"), "NTLM "))
+		switch {
+		case len(auth) >= 12 && binary.LittleEndian.Uint32(auth[8:]) == 1:
+			negotiated[r.RemoteAddr] = true
+			w.Header().Set("WWW-Authenticate", "NTLM "+base64.StdEncoding.EncodeToString(challenge))
+			w.WriteHeader(http.StatusUnauthorized)
+		case len(auth) >= 64 && binary.LittleEndian.Uint32(auth[8:]) == 3 && negotiated[r.RemoteAddr]:
+			field := func(i int) []byte {
+				n, off := binary.LittleEndian.Uint16(auth[12+8*i:]), binary.LittleEndian.Uint32(auth[16+8*i:])
+				return auth[off : off+uint32(n)]
+			}
+			nt := field(1)
+			if !bytes.Equal(nt[:16], hmacMD5(ntowfv2(creds), serverChallenge, nt[16:])) ||
+				!bytes.Equal(field(2), utf16le("CORP")) || !bytes.Equal(field(3), utf16le("alice")) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			authenticated[r.RemoteAddr] = true
+			handshakes++
+		default:
+			w.Header().Set("WWW-Authenticate", "NTLM")
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	// Handshakes of concurrent workers must not mix, whatever the
+	// transport strategy.
+	for _, transport := range []string{"", TransportShared, TransportPerCPU, TransportPerWorker} {
+		mu.Lock()
+		handshakes = 0
+		mu.Unlock()
+		req, _ := http.NewRequest("POST", server.URL, nil)
+		w := &Work{
+			Request:     req,
+			RequestBody: []byte("payload"),
+			N:           200,
+			C:           8,
+			Transport:   transport,
+			NTLM:        creds,
+			Writer:      ioutil.Discard,
+		}
+		w.Run()
+		rep := w.report.snapshot()
+		if rep.StatusCodeDist[200] != 200 || len(rep.ErrorDist) > 0 {
+			t.Errorf("%q: expected 200 authenticated responses, found %v and errors %v", transport, rep.StatusCodeDist, rep.ErrorDist)
+		}
+		if handshakes != 8 || rep.Connections != 8 {
+			t.Errorf("%q: expected a handshake on each of 8 connections, found %v on %v", transport, handshakes, rep.Connections)
+		}
+		if rep.Transport != TransportPerWorker {
+			t.Errorf("%q: expected transports per worker, found %q", transport, rep.Transport)
+		}
+	}
+
+	if _, err := ntlmAuthenticate(challenge[:40], creds, time.Now()); err == nil {
+		t.Error("Expected a truncated challenge to be rejected")
+	}
+}
+
+func TestKeepConns(t *testing.T) {
+	var opened int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&opened, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	// The second run starts on the connections of the first, and the
+	// third, with more workers, opens the ones it lacks.
+	conns := &Conns{}
+	for i, c := range []int{4, 1, 16} {
+		before := atomic.LoadInt64(&opened)
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		w := &Work{Request: req, N: 160, C: c, Conns: conns, Writer: ioutil.Discard}
+		w.Run()
+		rep := w.report.snapshot()
+		if rep.StatusCodeDist[200] != 160 {
+			t.Fatalf("-c %d: expected 160 responses, found %v", c, rep.StatusCodeDist)
+		}
+		n := atomic.LoadInt64(&opened) - before
+		if i == 1 && (n != 0 || rep.Connections != 0) {
+			t.Errorf("Expected the second run to open no connection, found %d (%d reported)", n, rep.Connections)
+		}
+		if i == 2 && (n == 0 || rep.Connections == 0) {
+			t.Errorf("Expected the third run to open connections, found %d (%d reported)", n, rep.Connections)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	const realm = "EXAMPLE.COM"
+	now := time.Now()
+	service := keytab.New()
+	if err := service.AddEntry("HTTP/127.0.0.1", realm, "service", now, 1, etypeID.AES256_CTS_HMAC_SHA1_96); err != nil {
+		t.Fatal(err)
+	}
+	kdc := keytab.New()
+	if err := kdc.AddEntry("krbtgt/"+realm, realm, "kdc", now, 1, etypeID.AES256_CTS_HMAC_SHA1_96); err != nil {
+		t.Fatal(err)
+	}
+
+	// A version 4 credentials cache, as kinit writes, with a ticket
+	// granting ticket and a ticket for the server, so that no KDC is
+	// needed.
+	data := func(b, d []byte) []byte {
+		return append(binary.BigEndian.AppendUint32(b, uint32(len(d))), d...)
+	}
+	principal := func(b []byte, p types.PrincipalName) []byte {
+		b = binary.BigEndian.AppendUint32(b, uint32(p.NameType))
+		b = binary.BigEndian.AppendUint32(b, uint32(len(p.NameString)))
+		b = data(b, []byte(realm))
+		for _, s := range p.NameString {
+			b = data(b, []byte(s))
+		}
+		return b
+	}
+	alice := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "alice")
+	ccache := principal([]byte{5, 4, 0, 0}, alice)
+	for spn, kt := range map[string]*keytab.Keytab{"krbtgt/" + realm: kdc, "HTTP/127.0.0.1": service} {
+		sname := types.NewPrincipalName(nametype.KRB_NT_SRV_INST, spn)
+		tkt, key, err := messages.NewTicket(alice, realm, sname, realm, types.NewKrbFlags(), kt, etypeID.AES256_CTS_HMAC_SHA1_96, 1, now, now, now.Add(time.Hour), now.Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := tkt.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ccache = principal(principal(ccache, alice), sname)
+		ccache = data(binary.BigEndian.AppendUint16(ccache, uint16(key.KeyType)), key.KeyValue)
+		for _, at := range []time.Time{now, now, now.Add(time.Hour), now.Add(time.Hour)} {
+			ccache = binary.BigEndian.AppendUint32(ccache, uint32(at.Unix()))
+		}
+		// No session key flag, ticket flags, addresses nor authorization
+		// data, then the ticket without a second one.
+		ccache = append(ccache, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+		ccache = data(data(ccache, b), nil)
+	}
+	path := filepath.Join(t.TempDir(), "krb5cc")
+	if err := os.WriteFile(path, ccache, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadKerberos(path+".missing", ""); err == nil || !strings.Contains(err.Error(), "kinit") {
+		t.Errorf("Expected a missing credentials cache to ask for kinit, found %v", err)
+	}
+	krb, err := LoadKerberos(path, "")
+	if err != nil {
+		t.Fatalf("LoadKerberos errored: %v", err)
+	}
+
+	server := httptest.NewServer(spnego.SPNEGOKRB5Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), service))
+	defer server.Close()
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:   req,
+		N:         20,
+		C:         2,
+		Negotiate: krb,
+		Writer:    ioutil.Discard,
+	}
+	w.Run()
+	if rep := w.report.snapshot(); rep.StatusCodeDist[200] != 20 {
+		t.Errorf("Expected 20 authenticated responses, found %v and errors %v", rep.StatusCodeDist, rep.ErrorDist)
+	}
+}
+
+func TestMD4(t *testing.T) {
+	for in, want := range map[string]string{
+		"":    "31d6cfe0d16ae931b73c59d7e0c089c0",
+		"abc": "a448017aaf21d8525fc10ae87aa6729d",
+		"12345678901234567890123456789012345678901234567890123456789012345678901234567890": "e33b4ddc9c38f2199c3e7b164fcc0536",
+	} {
+		if got := hex.EncodeToString(md4([]byte(in))); got != want {
+			t.Errorf("md4(%q) = %v; want %v", in, got, want)
+		}
+	}
+	// From the NTLMv2 example of MS-NLMP.
+	key := ntowfv2(&NTLMCredentials{Domain: "Domain", User: "User", Password: "Password"})
+	if got := hex.EncodeToString(key); got != "0c868a403bfd7a93a3001ef22ef02e3f" {
+		t.Errorf("Unexpected NTOWFv2 %v", got)
+	}
+}
+
+func TestClockSkew(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := func(skew func(offset float64) float64) []Record {
+		var recs []Record
+		for i := 0; i < 120; i++ {
+			offset := float64(i) + float64(i%7)/7
+			sent := start.Add(seconds(offset))
+			mid := float64(sent.UnixNano())/1e9 + 0.025
+			recs = append(recs, Record{
+				Offset:   offset,
+				Duration: 0.05,
+				Final:    0.05,
+				Status:   200,
+				Time:     sent,
+				Date:     int64(math.Floor(mid + skew(offset))),
+			})
+		}
+		return recs
+	}
+
+	rep := ReportFromRecords(records(func(float64) float64 { return 2.5 }), nil)
+	c := rep.ClockSkew
+	if c == nil {
+		t.Fatal("Expected the clock skew to be reported")
+	}
+	if c.Samples != 120 || math.Abs(c.Skew-2.5) > 0.1 || !c.Consistent() || c.Low > 2.5 || c.High < 2.5 {
+		t.Errorf("Unexpected clock skew %+v; want 2.5 secs", c)
+	}
+	if math.Abs(c.Drift) > 5 {
+		t.Errorf("Unexpected drift %v secs per hour of a steady skew", c.Drift)
+	}
+	var out bytes.Buffer
+	rep.Details = true
+	if err := PrintReport(&out, rep, ""); err != nil {
+		t.Fatalf("PrintReport errored: %v", err)
+	}
+	if !strings.Contains(out.String(), "Clock skew (120 Date headers, server minus local clock):") {
+		t.Errorf("Expected the clock skew in the summary, found:\n%s", out.String())
+	}
+
+	rep = ReportFromRecords(records(func(offset float64) float64 { return -offset / 60 }), nil)
+	if c := rep.ClockSkew; math.Abs(c.Drift+60) > 5 || c.Consistent() {
+		t.Errorf("Unexpected clock skew %+v; want a drift of -60 secs per hour", c)
+	}
+
+	merged, err := MergeReports([]Report{rep, rep}, nil)
+	if err != nil {
+		t.Fatalf("MergeReports errored: %v", err)
+	}
+	if c := merged.ClockSkew; c == nil || c.Samples != 240 || c.Drift != rep.ClockSkew.Drift {
+		t.Errorf("Unexpected merged clock skew %+v", c)
+	}
+	if rep := ReportFromRecords([]Record{{Offset: 1, Duration: 0.1, Status: 200}}, nil); rep.ClockSkew != nil {
+		t.Errorf("Expected no clock skew without Date headers, found %+v", rep.ClockSkew)
+	}
+}
+
+func TestInFlight(t *testing.T) {
+	records := []Record{
+		{Offset: 5, Duration: 2, Status: 200},
+		{Offset: 5, Duration: 1, Status: 200},
+	}
+	in := ReportFromRecords(records, nil).InFlight
+	if in == nil {
+		t.Fatal("Expected in-flight requests to be reported")
+	}
+	if len(in.Series) != 2 || in.Series[0] != 2 || in.Series[1] != 1 {
+		t.Errorf("Unexpected in-flight series %v; want [2 1]", in.Series)
+	}
+	if in.Average != 1.5 {
+		t.Errorf("Expected 1.5 requests in flight on average, found %v", in.Average)
+	}
+
+	merged, err := MergeReports([]Report{ReportFromRecords(records, nil), ReportFromRecords(records, nil)}, nil)
+	if err != nil {
+		t.Fatalf("MergeReports errored: %v", err)
+	}
+	if merged.InFlight.Average != 3 {
+		t.Errorf("Expected 3 requests in flight on average across runs, found %v", merged.InFlight.Average)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(1000, 1, nil)
+	start := time.Now()
+	for i := 0; i < 200; i++ {
+		l.wait(nil)
+	}
+	if elapsed := time.Since(start); elapsed < 190*time.Millisecond || elapsed > 250*time.Millisecond {
+		t.Errorf("Expected 200 requests at 1000 QPS to take about 200ms, took %v", elapsed)
+	}
+
+	l = newLimiter(10, 5, nil)
+	start = time.Now()
+	for i := 0; i < 5; i++ {
+		l.wait(nil)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("Expected a burst of 5 requests to be sent at once, took %v", elapsed)
+	}
+
+	l = newPreciseLimiter(10000, 1, nil)
+	defer l.stop()
+	start = time.Now()
+	for i := 0; i < 2000; i++ {
+		l.wait(nil)
+	}
+	if elapsed := time.Since(start); elapsed < 190*time.Millisecond || elapsed > 300*time.Millisecond {
+		t.Errorf("Expected 2000 requests at 10000 QPS to take about 200ms, took %v", elapsed)
+	}
+}
+
+func TestPoissonArrival(t *testing.T) {
+	l := newLimiter(1000, 1, rand.New(rand.NewSource(1)))
+	const n = 10000
+	var sum, sumSq float64
+	for i := 0; i < n; i++ {
+		g := l.gap().Seconds()
+		sum += g
+		sumSq += g * g
+	}
+	mean := sum / n
+	stddev := math.Sqrt(sumSq/n - mean*mean)
+	// Exponentially distributed gaps have a standard deviation equal to
+	// their mean.
+	if math.Abs(mean-0.001) > 0.0001 || math.Abs(stddev-mean) > 0.0001 {
+		t.Errorf("Expected gaps with a mean and stddev of 1ms, found %v and %v", mean, stddev)
+	}
+}
+
+func TestParseDistribution(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "constant(100ms)", want: "constant(100ms)"},
+		{in: "uniform(100ms, 1s)", want: "uniform(100ms,1s)"},
+		{in: "exponential(200ms)", want: "exponential(200ms)"},
+		{in: "normal(200ms,50ms)", want: "normal(200ms,50ms)"},
+		{in: "lognormal(200ms,0.5)", want: "lognormal(200ms,0.5)"},
+		{in: "uniform(1s,100ms)", wantErr: true},
+		{in: "normal(200ms)", wantErr: true},
+		{in: "pareto(1s)", wantErr: true},
+		{in: "100ms", wantErr: true},
+	}
+	for _, tt := range tests {
+		d, err := ParseDistribution(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseDistribution(%q) error = %v; want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err == nil && d.String() != tt.want {
+			t.Errorf("ParseDistribution(%q) = %v; want %v", tt.in, d, tt.want)
+		}
+	}
+}
+
+func TestDistributionSample(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	d, _ := ParseDistribution("lognormal(200ms,0.5)")
+	samples := make([]float64, 10001)
+	for i := range samples {
+		samples[i] = d.Sample(rnd).Seconds()
+	}
+	sort.Float64s(samples)
+	if median := samples[len(samples)/2]; math.Abs(median-0.2) > 0.01 {
+		t.Errorf("Expected a median of 200ms, found %vs", median)
+	}
+	d, _ = ParseDistribution("uniform(100ms,200ms)")
+	for i := 0; i < 1000; i++ {
+		if v := d.Sample(rnd); v < 100*time.Millisecond || v > 200*time.Millisecond {
+			t.Fatalf("Sample %v out of range", v)
+		}
+	}
+}
+
+func TestPacing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request: req,
+		N:       5,
+		C:       1,
+		QPS:     100,
+		Writer:  ioutil.Discard,
+	}
+	w.Run()
+	pacing := w.report.snapshot().Pacing
+	if pacing == nil {
+		t.Fatal("Expected pacing to be reported")
+	}
+	if pacing.TargetRate != 100 {
+		t.Errorf("Expected a target rate of 100, found %v", pacing.TargetRate)
+	}
+	// Requests take 5 intervals, so the schedule slips by at least 4 of
+	// them per request after the first.
+	if pacing.Late != 4 || pacing.MaxLag < 0.15 {
+		t.Errorf("Expected 4 late requests and a lag of at least 0.15s, found %v and %v", pacing.Late, pacing.MaxLag)
+	}
+}
+
+func TestSketch(t *testing.T) {
+	a, b := NewSketch(DefaultSketchAccuracy), NewSketch(DefaultSketchAccuracy)
+	for i := 1; i <= 500; i++ {
+		a.Add(float64(i) / 1000)
+		b.Add(float64(i+500) / 1000)
+	}
+	if err := a.Merge(b); err != nil {
+		t.Fatalf("Merge errored: %v", err)
+	}
+	for _, q := range []float64{0.5, 0.9, 0.99} {
+		want := q
+		if got := a.Quantile(q); math.Abs(got-want)/want > 2*DefaultSketchAccuracy {
+			t.Errorf("Quantile(%v) = %v; want %v within %v", q, got, want, DefaultSketchAccuracy)
+		}
+	}
+	if err := a.Merge(NewSketch(0.05)); err == nil {
+		t.Errorf("Merging sketches with different accuracies did not error")
+	}
+}
+
+func TestMergeReports(t *testing.T) {
+	records := func(status int, lat float64) []Record {
+		var recs []Record
+		for i := 0; i < 100; i++ {
+			recs = append(recs, Record{Offset: float64(i) / 100, Duration: lat, Status: status})
+		}
+		return recs
+	}
+	a := ReportFromRecords(records(200, 0.01), nil)
+	b := ReportFromRecords(records(503, 0.03), nil)
+	m, err := MergeReports([]Report{a, b}, []float64{25, 75})
+	if err != nil {
+		t.Fatalf("MergeReports errored: %v", err)
+	}
+	if m.NumRes != 200 || m.StatusCodeDist[200] != 100 || m.StatusCodeDist[503] != 100 {
+		t.Errorf("Unexpected merged counts: %v, %v", m.NumRes, m.StatusCodeDist)
+	}
+	if math.Abs(m.Rps-(a.Rps+b.Rps)) > 1e-9 {
+		t.Errorf("Expected throughputs to be summed, found %v", m.Rps)
+	}
+	if math.Abs(m.Average-0.02) > 1e-9 || m.Fastest != 0.01 || m.Slowest != 0.03 {
+		t.Errorf("Unexpected merged latencies: %v, %v, %v", m.Average, m.Fastest, m.Slowest)
+	}
+	p25, p75 := m.LatencyDistribution[0].Latency, m.LatencyDistribution[1].Latency
+	if math.Abs(p25-0.01) > 0.0005 || math.Abs(p75-0.03) > 0.0005 {
+		t.Errorf("Unexpected merged percentiles: p25 %v, p75 %v", p25, p75)
+	}
+}
+
+func TestAdjust(t *testing.T) {
+	var inflight, peak int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inflight, 1)
+		for p := atomic.LoadInt32(&peak); n > p && !atomic.CompareAndSwapInt32(&peak, p, n); p = atomic.LoadInt32(&peak) {
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&inflight, -1)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request: req,
+		N:       math.MaxInt32,
+		C:       1,
+		QPS:     10,
+		Writer:  ioutil.Discard,
+	}
+	done := make(chan struct{})
+	go func() {
+		w.Run()
+		close(done)
+	}()
+	for {
+		if c, _ := w.Load(); c == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := w.SetConcurrency(4); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetQPS(0); err != nil {
+		t.Fatal(err)
+	}
+	if c, qps := w.Load(); c != 4 || qps != 0 {
+		t.Errorf("Expected 4 workers without rate limit, found %v at %v QPS", c, qps)
+	}
+	for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt32(&peak) < 4 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := w.SetConcurrency(2); err != nil {
+		t.Fatal(err)
+	}
+	w.Stop()
+	<-done
+	if p := atomic.LoadInt32(&peak); p != 4 {
+		t.Errorf("Expected 4 concurrent requests at most, found %v", p)
+	}
+	if err := w.SetConcurrency(2); err != errNotRunning {
+		t.Errorf("Expected %v adjusting a finished run, found %v", errNotRunning, err)
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 1000))
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:  req,
+		N:        1000,
+		C:        1,
+		MaxBytes: 5000,
+		Writer:   ioutil.Discard,
+	}
+	w.Run()
+	rep := w.report.snapshot()
+	if rep.NumRes != 5 || rep.Received != 5000 || rep.MaxBytes != 5000 {
+		t.Errorf("Expected 5 responses of 5000 bytes, found %v of %v bytes", rep.NumRes, rep.Received)
+	}
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	var mu sync.Mutex
+	keys := make(map[string]bool)
+	keyRegexp := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if !keyRegexp.MatchString(key) {
+			t.Errorf("Unexpected Idempotency-Key %q", key)
+		}
+		mu.Lock()
+		keys[key] = true
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:         req,
+		N:               10,
+		C:               2,
+		IdempotencyKeys: true,
+		Output:          "ndjson",
+		Writer:          &out,
+	}
+	w.Run()
+	records, err := ReadRecords(&out)
+	if err != nil {
+		t.Fatalf("ReadRecords errored: %v", err)
+	}
+	if len(keys) != 10 {
+		t.Errorf("Expected 10 distinct keys, found %v", len(keys))
+	}
+	for _, rec := range records {
+		if !keys[rec.IdempotencyKey] {
+			t.Errorf("Recorded key %q was not sent", rec.IdempotencyKey)
+		}
+	}
+}
+
+func TestParamSweep(t *testing.T) {
+	var mu sync.Mutex
+	pages := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("lang") != "en" || len(q.Get("q")) != 8 {
+			t.Errorf("Unexpected query %q", r.URL.RawQuery)
+		}
+		mu.Lock()
+		pages[q.Get("page")]++
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"?lang=en", nil)
+	w := &Work{
+		Request:     req,
+		N:           10,
+		C:           2,
+		SweepParam:  "page",
+		SweepValues: []string{"1", "2", "3", "4", "5"},
+		FuzzParam:   "q",
+		Writer:      ioutil.Discard,
+	}
+	w.Run()
+	for _, page := range w.SweepValues {
+		if pages[page] != 2 {
+			t.Errorf("Expected 2 requests of page %v, found %v", page, pages[page])
+		}
+	}
+	rep := w.report.snapshot()
+	if dist := rep.ParamDist["page"]; len(dist) != 5 || dist["3"].Count != 2 {
+		t.Errorf("Unexpected page distribution %v", dist)
+	}
+	if dist := rep.ParamDist["q"]; len(dist) != 10 {
+		t.Errorf("Expected 10 fuzzed values, found %v", len(dist))
+	}
+}
+
+func TestGolden(t *testing.T) {
+	var n int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := atomic.AddInt64(&n, 1)
+		price := 10
+		if i%5 == 0 {
+			price = 12
+		}
+		fmt.Fprintf(w, `{"id": %d, "items": [{"price": %d, "at": "%d"}]}`, i, price, i)
+	}))
+	defer server.Close()
+
+	var golden interface{}
+	json.Unmarshal([]byte(`{"id": 0, "items": [{"price": 10, "at": "0"}]}`), &golden)
+	ignore, err := CompileIgnorePaths([]string{"$.id", "$.items[*].at"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:      req,
+		N:            20,
+		C:            2,
+		Golden:       golden,
+		GoldenIgnore: ignore,
+		Writer:       ioutil.Discard,
+	}
+	w.Run()
+	g := w.report.snapshot().Golden
+	if g == nil || g.Compared != 20 || g.Diverged != 4 {
+		t.Fatalf("Expected 4 of 20 responses to diverge, found %+v", g)
+	}
+	if d := g.Paths["$.items[0].price"]; d.Count != 4 || d.Example != "$.items[0].price: 12 instead of 10" {
+		t.Errorf("Unexpected divergences %+v", g.Paths)
+	}
+}
+
+func TestURLs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/a", nil)
+	var urls []*url.URL
+	for _, path := range []string{"/a", "/missing"} {
+		u, _ := url.Parse(server.URL + path)
+		urls = append(urls, u)
+	}
+	w := &Work{
+		Request: req,
+		URLs:    urls,
+		N:       10,
+		C:       2,
+		Writer:  ioutil.Discard,
+	}
+	w.Run()
+	dist := w.report.snapshot().TargetDist
+	if len(dist) != 2 {
+		t.Fatalf("Expected 2 targets, found %v", len(dist))
+	}
+	if a := dist[server.URL+"/a"]; a == nil || a.Requests != 5 || a.StatusCodes[200] != 5 {
+		t.Errorf("Unexpected stats of /a: %+v", a)
+	}
+	if m := dist[server.URL+"/missing"]; m == nil || m.Requests != 5 || m.StatusCodes[404] != 5 {
+		t.Errorf("Unexpected stats of /missing: %+v", m)
+	}
+}
+
+func TestCaptureSample(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Echo", "yes")
+		w.Write(bytes.ToUpper(body))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	var out bytes.Buffer
+	req, _ := http.NewRequest("POST", server.URL, nil)
+	w := &Work{
+		Request:       req,
+		RequestBody:   []byte("hello"),
+		N:             20,
+		C:             2,
+		CaptureSample: 0.5,
+		CaptureDir:    dir,
+		Seed:          1,
+		Output:        "ndjson",
+		Writer:        &out,
+	}
+	w.Run()
+	records, err := ReadRecords(&out)
+	if err != nil {
+		t.Fatalf("ReadRecords errored: %v", err)
+	}
+	var captured int
+	for _, rec := range records {
+		if rec.Capture == "" {
+			continue
+		}
+		captured++
+		data, err := os.ReadFile(filepath.Join(dir, rec.Capture))
+		if err != nil {
+			t.Fatalf("Reading capture errored: %v", err)
+		}
+		for _, want := range []string{"POST " + server.URL, "\nhello\n", "200 OK", "X-Echo: yes", "\nHELLO"} {
+			if !strings.Contains(string(data), want) {
+				t.Errorf("Capture %s does not contain %q:\n%s", rec.Capture, want, data)
+			}
+		}
+	}
+	if captured == 0 || captured == 20 {
+		t.Errorf("Expected a sample of the requests to be captured, captured %v", captured)
+	}
+	files, _ := os.ReadDir(dir)
+	if len(files) != captured {
+		t.Errorf("Expected %v capture files, found %v", captured, len(files))
+	}
+}
+
+func TestConvertFormats(t *testing.T) {
+	var records []Record
+	for i := 1; i <= 100; i++ {
+		records = append(records, Record{Offset: float64(i) / 100, Duration: float64(i) / 1000, Status: 200})
+	}
+	records = append(records, Record{Offset: 1, Error: "connection refused"})
+	rep := ReportFromRecords(records, []float64{50, 99})
+
+	var hdr bytes.Buffer
+	if err := PrintReport(&hdr, rep, "hdr"); err != nil {
+		t.Fatalf("PrintReport errored: %v", err)
+	}
+	for _, want := range []string{
+		"      50.000 0.500000000000         50           2.00\n",
+		"     100.000 1.000000000000        100\n",
+		"#[Max     =      100.000, Total count    =          100]\n",
+	} {
+		if !strings.Contains(hdr.String(), want) {
+			t.Errorf("HdrHistogram output does not contain %q:\n%s", want, hdr.String())
+		}
+	}
+
+	var out bytes.Buffer
+	if err := PrintReport(&out, rep, "json"); err != nil {
+		t.Fatalf("PrintReport errored: %v", err)
+	}
+	var s Summary
+	if err := json.Unmarshal(out.Bytes(), &s); err != nil {
+		t.Fatalf("Summary is not JSON: %v\n%s", err, out.String())
+	}
+	if s.Requests != 101 || s.Errors != 1 || s.StatusCodes[200] != 100 {
+		t.Errorf("Unexpected counts in summary: %+v", s)
+	}
+	if s.Percentiles["p50"] != rep.LatencyDistribution[0].Latency || s.Percentiles["p99"] != rep.LatencyDistribution[1].Latency {
+		t.Errorf("Unexpected percentiles in summary: %v", s.Percentiles)
+	}
+}
+
+func TestBodies(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		got = append(got, r.Header.Get("Content-Type")+" "+string(body))
+		mu.Unlock()
+		if r.ContentLength != int64(len(body)) {
+			t.Errorf("Expected Content-Length %v, found %v", len(body), r.ContentLength)
+		}
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	req, _ := http.NewRequest("POST", server.URL, nil)
+	req.Header.Set("Content-Type", "text/html")
+	w := &Work{
+		Request: req,
+		Bodies: []Body{
+			{Name: "a.json", Data: []byte(`{"a":1}`), ContentType: "application/json"},
+			{Name: "b.txt", Data: []byte("bb")},
+		},
+		N:      4,
+		C:      1,
+		Output: "ndjson",
+		Writer: &out,
+	}
+	w.Run()
+	if want := `["application/json {\"a\":1}" "text/html bb" "application/json {\"a\":1}" "text/html bb"]`; fmt.Sprintf("%q", got) != want {
+		t.Errorf("got %q; want %v", got, want)
+	}
+	records, err := ReadRecords(&out)
+	if err != nil {
+		t.Fatalf("ReadRecords errored: %v", err)
+	}
+	for i, rec := range records {
+		if want := w.Bodies[i%2].Name; rec.Body != want {
+			t.Errorf("Expected record %d to name body %q, found %q", i, want, rec.Body)
+		}
+	}
+}
+
+func TestRandomBody(t *testing.T) {
+	var mu sync.Mutex
+	var bodies [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, body)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	for _, text := range []bool{false, true} {
+		bodies = nil
+		req, _ := http.NewRequest("POST", server.URL, nil)
+		w := &Work{
+			Request:        req,
+			RandomBodyMin:  2000,
+			RandomBodyMax:  4000,
+			RandomBodyText: text,
+			N:              20,
+			C:              2,
+			Writer:         ioutil.Discard,
+		}
+		w.Run()
+		sizes := make(map[int]bool)
+		for _, body := range bodies {
+			if len(body) < 2000 || len(body) > 4000 {
+				t.Errorf("Body size %v is out of range", len(body))
+			}
+			sizes[len(body)] = true
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			zw.Write(body)
+			zw.Close()
+			if compressed := buf.Len() < len(body)/2; compressed != text {
+				t.Errorf("Expected compressible %v, body of %v bytes compressed to %v", text, len(body), buf.Len())
+			}
+		}
+		if len(sizes) < 2 {
+			t.Errorf("Expected bodies of different sizes, found %v", sizes)
+		}
+	}
+}
+
+func TestBodyTemplate(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Body is not JSON: %v", err)
+		}
+		mu.Lock()
+		bodies = append(bodies, body)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	tmpl, err := ParseBodyTemplate(`{"name": "{{fake.Name}}", "email": "{{fake.Email}}", "ip": "{{fake.IPv4}}", "card": "{{fake.CreditCard}}"}`)
+	if err != nil {
+		t.Fatalf("ParseBodyTemplate errored: %v", err)
+	}
+	req, _ := http.NewRequest("POST", server.URL, nil)
+	w := &Work{
+		Request:      req,
+		BodyTemplate: tmpl,
+		N:            20,
+		C:            2,
+		Writer:       ioutil.Discard,
+	}
+	w.Run()
+	if len(bodies) != 20 {
+		t.Fatalf("Expected 20 bodies, found %v", len(bodies))
+	}
+	emails := make(map[string]bool)
+	for _, body := range bodies {
+		emails[body["email"]] = true
+		if !regexp.MustCompile(`^\S+ \S+$`).MatchString(body["name"]) {
+			t.Errorf("Unexpected name %q", body["name"])
+		}
+		if !regexp.MustCompile(`@example\.(com|org|net)$`).MatchString(body["email"]) {
+			t.Errorf("Unexpected email %q", body["email"])
+		}
+		if _, err := netip.ParseAddr(body["ip"]); err != nil {
+			t.Errorf("Unexpected IPv4 %q", body["ip"])
+		}
+		if !luhnValid(body["card"]) {
+			t.Errorf("Card number %q fails the Luhn check", body["card"])
+		}
+	}
+	if len(emails) < 10 {
+		t.Errorf("Expected varied emails, found %v", emails)
+	}
+
+	if _, err := ParseBodyTemplate(`{{fake.Unknown}}`); err == nil {
+		t.Errorf("ParseBodyTemplate with an unknown faker did not error")
+	}
+}
+
+func luhnValid(number string) bool {
+	sum := 0
+	for i := range number {
+		d := int(number[len(number)-1-i] - '0')
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return len(number) == 16 && sum%10 == 0
+}
+
+func TestBodyTemplateSequences(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		for _, v := range strings.Fields(string(body)) {
+			if seen[v] {
+				t.Errorf("%s was generated twice", v)
+			}
+			seen[v] = true
+		}
+	}))
+	defer server.Close()
+
+	tmpl, err := ParseBodyTemplate(`seq:{{seq 1000}} worker:{{workerseq}}`)
+	if err != nil {
+		t.Fatalf("ParseBodyTemplate errored: %v", err)
+	}
+	req, _ := http.NewRequest("POST", server.URL, nil)
+	w := &Work{
+		Request:      req,
+		BodyTemplate: tmpl,
+		N:            40,
+		C:            4,
+		Writer:       ioutil.Discard,
+	}
+	w.Run()
+	for i := 1000; i < 1040; i++ {
+		if !seen["seq:"+strconv.Itoa(i)] {
+			t.Errorf("seq %d was not generated", i)
+		}
+	}
+	for worker := 0; worker < 4; worker++ {
+		for i := 1; i <= 10; i++ {
+			if v := "worker:" + strconv.Itoa(worker*workerSeqBlock+i); !seen[v] {
+				t.Errorf("%s was not generated", v)
+			}
+		}
+	}
+
+	if _, err := ParseBodyTemplate(`{{seq 1 2}}`); err == nil {
+		t.Errorf("ParseBodyTemplate with two starts did not error")
+	}
+}
+
+func TestExpectStatus(t *testing.T) {
+	var n int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&n, 1)%4 == 0 {
+			return
+		}
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:      req,
+		N:            20,
+		C:            1,
+		ExpectStatus: []int{429, 401},
+		Writer:       &out,
+	}
+	w.Run()
+	e := w.report.snapshot().Expect
+	if e == nil || e.Expected != 15 || e.Unexpected != 5 || e.UnexpectedDist[200] != 5 {
+		t.Fatalf("Expected 15 expected and 5 unexpected 200 responses, found %+v", e)
+	}
+	if !strings.Contains(out.String(), "Success rate:	0.7500") {
+		t.Errorf("Summary does not report the success rate:\n%s", out.String())
+	}
+	merged, _ := MergeReports([]Report{w.report.snapshot(), w.report.snapshot()}, nil)
+	if merged.Expect.Expected != 30 || merged.Expect.UnexpectedDist[200] != 10 {
+		t.Errorf("Unexpected merged outcomes %+v", merged.Expect)
+	}
+}
+
+func TestRedirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/http":
+			http.Redirect(w, r, "/www", http.StatusMovedPermanently)
+		case "/www":
+			http.Redirect(w, r, "/canonical", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	req, _ := http.NewRequest("GET", server.URL+"/http", nil)
+	w := &Work{
+		Request: req,
+		N:       10,
+		C:       2,
+		Output:  "ndjson",
+		Writer:  &out,
+	}
+	w.Run()
+	records, err := ReadRecords(&out)
+	if err != nil {
+		t.Fatalf("ReadRecords errored: %v", err)
+	}
+	for _, rec := range records {
+		if len(rec.Redirects) != 2 || rec.Redirects[0].URL != server.URL+"/http" || rec.Redirects[1].URL != server.URL+"/www" {
+			t.Fatalf("Unexpected redirects %+v", rec.Redirects)
+		}
+	}
+	rep := ReportFromRecords(records, nil)
+	r := rep.Redirects
+	if r == nil || r.Redirected != 10 || r.ChainLengths[2] != 10 || r.Hops[server.URL+"/www"].Count != 10 {
+		t.Fatalf("Unexpected redirect report %+v", r)
+	}
+	if r.Overhead <= 0 || r.Overhead > rep.AvgTotal {
+		t.Errorf("Expected the redirect overhead to be part of the total latency, found %v of %v", r.Overhead, rep.AvgTotal)
+	}
+	for _, output := range []string{"", "html"} {
+		var buf bytes.Buffer
+		if err := PrintReport(&buf, rep, output); err != nil {
+			t.Fatalf("PrintReport errored: %v", err)
+		}
+		if !strings.Contains(buf.String(), "Redirects") {
+			t.Errorf("%q output does not report redirects:\n%s", output, buf.String())
+		}
+	}
+
+	out.Reset()
+	w = &Work{
+		Request:          req,
+		N:                2,
+		C:                1,
+		DisableRedirects: true,
+		Output:           "ndjson",
+		Writer:           &out,
+	}
+	w.Run()
+	records, _ = ReadRecords(&out)
+	if rep := ReportFromRecords(records, nil); rep.Redirects != nil || rep.StatusCodeDist[301] != 2 {
+		t.Errorf("Expected redirects not to be followed, found %+v and %v", rep.Redirects, rep.StatusCodeDist)
+	}
+}
+
+func TestDials(t *testing.T) {
+	var d dialTrace
+	d.start("[2001:db8::1]:443")
+	d.start("[2001:db8::2]:443")
+	if got := d.fellBack(); got != "" {
+		t.Errorf("Expected no fallback dialing one family, found %q", got)
+	}
+	d.start("192.0.2.1:443")
+	if got := d.fellBack(); got != IPv4 {
+		t.Errorf("Expected a fallback to IPv4, found %q", got)
+	}
+
+	var stats dialStats
+	for _, res := range []*result{
+		{newConn: true, family: IPv6, connDuration: time.Millisecond},
+		{newConn: true, family: IPv6, connDuration: 3 * time.Millisecond},
+		{newConn: true, family: IPv4, fallback: IPv4, connDuration: 300 * time.Millisecond},
+		{newConn: true, family: IPv6, fallback: IPv4, connDuration: 100 * time.Millisecond},
+		{family: IPv6},
+	} {
+		stats.add(res)
+	}
+	rep := stats.snapshot()
+	if rep == nil || rep.Families[IPv6] != 3 || rep.Families[IPv4] != 1 || rep.Fallbacks != 2 || rep.FallbackConnections != 1 {
+		t.Fatalf("Unexpected dial report %+v", rep)
+	}
+	if math.Abs(rep.ConnTime-0.002) > 1e-9 || math.Abs(rep.FallbackConnTime-0.2) > 1e-9 {
+		t.Errorf("Unexpected conn times %v and %v", rep.ConnTime, rep.FallbackConnTime)
+	}
+	other := &DialReport{Families: map[string]int64{IPv6: 2}, ConnTime: 0.005}
+	merged := mergeDials(mergeDials(nil, rep), other)
+	if merged.Families[IPv6] != 5 || merged.Fallbacks != 2 || math.Abs(merged.ConnTime-0.0035) > 1e-9 || math.Abs(merged.FallbackConnTime-0.2) > 1e-9 {
+		t.Errorf("Unexpected merged dial report %+v", merged)
+	}
+
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 is not available: %v", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Listener = ln
+	server.Start()
+	defer server.Close()
+
+	for _, family := range []string{"", IPv6, IPv4} {
+		var out bytes.Buffer
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		w := &Work{
+			Request:  req,
+			N:        4,
+			C:        1,
+			IPFamily: family,
+			Output:   "ndjson",
+			Writer:   &out,
+		}
+		w.Run()
+		records, _ := ReadRecords(&out)
+		rep := ReportFromRecords(records, nil)
+		if family == IPv4 {
+			if len(rep.ErrorDist) == 0 || rep.Dials != nil {
+				t.Errorf("Expected IPv4 dials of an IPv6 address to fail, found %v and %+v", rep.ErrorDist, rep.Dials)
+			}
+			continue
+		}
+		if rep.Dials == nil || rep.Dials.Families[IPv6] != 1 || rep.Dials.Fallbacks != 0 {
+			t.Errorf("Unexpected dial report %+v with IP family %q", rep.Dials, family)
+		}
+		if records[0].Family != IPv6 {
+			t.Errorf("Expected the first record to be of an IPv6 connection, found %q", records[0].Family)
+		}
+	}
+}
+
+func TestH2Pings(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request:      req,
+		N:            10,
+		C:            1,
+		QPS:          20,
+		H2:           true,
+		PingInterval: 10 * time.Millisecond,
+		Writer:       ioutil.Discard,
+	}
+	w.Run()
+	if got := w.report.protoDist["HTTP/2.0"]; got != 10 {
+		t.Errorf("Expected 10 HTTP/2.0 responses, found %v", got)
+	}
+	rep := w.report.snapshot()
+	p := rep.Pings
+	if p == nil || p.Count == 0 || p.Failed != 0 {
+		t.Fatalf("Unexpected ping report %+v", p)
+	}
+	if p.Fastest <= 0 || p.Fastest > p.Average || p.Average > p.Slowest || len(p.Distribution) != len(pingPercentiles) {
+		t.Errorf("Inconsistent ping round trip times %+v", p)
+	}
+	for _, output := range []string{"", "html"} {
+		var buf bytes.Buffer
+		if err := PrintReport(&buf, rep, output); err != nil {
+			t.Fatalf("PrintReport errored: %v", err)
+		}
+		if !strings.Contains(buf.String(), "PING RTT") {
+			t.Errorf("%q output does not report pings:\n%s", output, buf.String())
+		}
+	}
+	if merged := mergePings(mergePings(nil, p), p); merged.Count != 2*p.Count || math.Abs(merged.Average-p.Average) > 1e-9 || merged.Fastest != p.Fastest {
+		t.Errorf("Unexpected merged ping report %+v", merged)
+	}
+
+	w = &Work{
+		Request:           req,
+		N:                 3,
+		C:                 1,
+		H2:                true,
+		DisableKeepAlives: true,
+		Writer:            ioutil.Discard,
+	}
+	w.Run()
+	if got := w.report.connProtos["HTTP/2.0"]; got != 3 {
+		t.Errorf("Expected a HTTP/2.0 connection per request without keep-alives, found %v", got)
+	}
+}
+
+func TestH2Lifecycle(t *testing.T) {
+	var frames bytes.Buffer
+	fr := http2.NewFramer(&frames, nil)
+	fr.WriteSettings()
+	fr.WriteData(1, false, make([]byte, 100))
+	fr.WriteRSTStream(3, http2.ErrCodeRefusedStream)
+	fr.WriteRSTStream(5, http2.ErrCodeCancel)
+	fr.WriteGoAway(5, http2.ErrCodeNo, []byte("recycling"))
+	fr.WritePing(true, [8]byte{})
+	stats := &h2Stats{opened: 1}
+	tap := &h2TapConn{stats: stats}
+	// Feed the frames in chunks that split headers and payloads.
+	for b := frames.Bytes(); len(b) > 0; b = b[min(len(b), 7):] {
+		tap.tap(b[:min(len(b), 7)])
+	}
+	rep := stats.snapshot()
+	if rep.GoAways != 1 || rep.GoAwayCodes["NO_ERROR"] != 1 || rep.RefusedStreams != 1 || rep.ResetStreams["CANCEL"] != 1 || len(rep.ResetStreams) != 1 {
+		t.Fatalf("Unexpected HTTP/2 report %+v", rep)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.EnableHTTP2 = true
+	// The server sends a GOAWAY after every request without keep-alives.
+	server.Config.SetKeepAlivesEnabled(false)
+	server.StartTLS()
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request: req,
+		N:       4,
+		C:       1,
+		H2:      true,
+		Writer:  ioutil.Discard,
+	}
+	w.Run()
+	rep = w.report.snapshot().H2
+	if rep == nil || rep.Opened < 2 || rep.GoAways < 1 || rep.GoAwayCodes["NO_ERROR"] != rep.GoAways {
+		t.Fatalf("Unexpected HTTP/2 report %+v", rep)
+	}
+	if rep.Closed > 0 && (rep.ShortestLifetime <= 0 || rep.ShortestLifetime > rep.LongestLifetime || rep.AverageRequests < 1) {
+		t.Errorf("Inconsistent connection lifetimes %+v", rep)
+	}
+	for _, output := range []string{"", "html"} {
+		var buf bytes.Buffer
+		if err := PrintReport(&buf, Report{H2: rep}, output); err != nil {
+			t.Fatalf("PrintReport errored: %v", err)
+		}
+		if !strings.Contains(buf.String(), "HTTP/2 connections") {
+			t.Errorf("%q output does not report HTTP/2 connections:\n%s", output, buf.String())
+		}
+	}
+	merged := mergeH2(mergeH2(nil, rep), rep)
+	if merged.Opened != 2*rep.Opened || merged.GoAways != 2*rep.GoAways || merged.GoAwayCodes["NO_ERROR"] != 2*rep.GoAways || math.Abs(merged.AverageLifetime-rep.AverageLifetime) > 1e-9 {
+		t.Errorf("Unexpected merged HTTP/2 report %+v", merged)
+	}
+}
+
+func TestH3(t *testing.T) {
+	q := &quicStats{}
+	q.add(30*time.Millisecond, nil)
+	q.add(10*time.Millisecond, nil)
+	q.add(0, errors.New("timeout: no recent network activity"))
+	rep := q.snapshot()
+	if rep.Handshakes != 2 || rep.Failed != 1 || math.Abs(rep.Average-0.02) > 1e-9 || rep.Fastest != 0.01 || rep.Slowest != 0.03 {
+		t.Errorf("Unexpected QUIC report %+v", rep)
+	}
+	m := mergeQUIC(mergeQUIC(nil, rep), &QUICReport{Handshakes: 2, Average: 0.005, Fastest: 0.004, Slowest: 0.006})
+	if m.Handshakes != 4 || m.Failed != 1 || math.Abs(m.Average-0.0125) > 1e-9 || m.Fastest != 0.004 || m.Slowest != 0.03 {
+		t.Errorf("Unexpected merged QUIC report %+v", m)
+	}
+
+	ca, leaf, _, leafKey := testChain(t)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var protos sync.Map
+	server := &http3.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			protos.Store(r.Proto, true)
+		}),
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: []tls.Certificate{{
+			Certificate: [][]byte{leaf.Raw, ca.Raw},
+			PrivateKey:  leafKey,
+		}}}),
+	}
+	go server.Serve(conn)
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", "https://"+conn.LocalAddr().String(), nil)
+	w := &Work{
+		Request: req,
+		N:       20,
+		C:       2,
+		H3:      true,
+		Writer:  ioutil.Discard,
+	}
+	w.Run()
+	res := w.report.snapshot()
+	if res.StatusCodeDist[200] != 20 || len(res.ErrorDist) > 0 {
+		t.Fatalf("Expected 20 responses over HTTP/3, found %v and errors %v", res.StatusCodeDist, res.ErrorDist)
+	}
+	if _, ok := protos.Load("HTTP/3.0"); !ok {
+		t.Error("Expected the requests to be made over HTTP/3")
+	}
+	rep = res.QUIC
+	if rep == nil || rep.Handshakes < 1 || rep.Handshakes != res.Connections || rep.Failed != 0 || rep.Fastest <= 0 {
+		t.Fatalf("Unexpected QUIC report %+v, %d connections", rep, res.Connections)
+	}
+	for _, output := range []string{"", "html"} {
+		var buf bytes.Buffer
+		if err := PrintReport(&buf, Report{QUIC: rep}, output); err != nil {
+			t.Fatalf("PrintReport errored: %v", err)
+		}
+		if !strings.Contains(buf.String(), "QUIC handshakes") {
+			t.Errorf("%q output does not report QUIC handshakes:\n%s", output, buf.String())
+		}
+	}
+}
+
+func TestTLSResume(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	for _, resume := range []bool{false, true} {
+		var out bytes.Buffer
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		w := &Work{
+			Request:           req,
+			N:                 4,
+			C:                 1,
+			DisableKeepAlives: true,
+			TLSResume:         resume,
+			Output:            "ndjson",
+			Writer:            &out,
+		}
+		w.Run()
+		rep := w.report.snapshot().Resumption
+		if !resume {
+			if rep != nil {
+				t.Errorf("Expected no resumption report without TLSResume, found %+v", rep)
+			}
+			continue
+		}
+		// The first connection has no session to resume.
+		if rep == nil || rep.Handshakes != 4 || rep.Resumed != 3 {
+			t.Fatalf("Unexpected resumption report %+v", rep)
+		}
+		if rep.FullHandshake <= 0 || rep.ResumedHandshake <= 0 {
+			t.Errorf("Expected handshake times, found %+v", rep)
+		}
+		records, _ := ReadRecords(&out)
+		if got := ReportFromRecords(records, nil).Resumption; got == nil || got.Resumed != 3 {
+			t.Errorf("Expected resumptions to be recorded, found %+v", got)
+		}
+		for _, output := range []string{"", "html"} {
+			var buf bytes.Buffer
+			if err := PrintReport(&buf, Report{Resumption: rep}, output); err != nil {
+				t.Fatalf("PrintReport errored: %v", err)
+			}
+			if !strings.Contains(buf.String(), "TLS resumption") {
+				t.Errorf("%q output does not report TLS resumption:\n%s", output, buf.String())
+			}
+		}
+		if merged := mergeResumption(mergeResumption(nil, rep), rep); merged.Resumed != 6 || math.Abs(merged.ResumedConn-rep.ResumedConn) > 1e-9 {
+			t.Errorf("Unexpected merged resumption report %+v", merged)
+		}
+	}
+}
+
+func TestSetAuthorization(t *testing.T) {
+	var mu sync.Mutex
+	auths := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		auths[r.Header.Get("Authorization")]++
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.SetBasicAuth("user", "pass")
+	w := &Work{
+		Request: req,
+		N:       20,
+		C:       1,
+		QPS:     200,
+		Writer:  ioutil.Discard,
+	}
+	w.Init()
+	go func() {
+		time.Sleep(40 * time.Millisecond)
+		w.SetAuthorization("Bearer new")
+	}()
+	w.Run()
+	if auths["Bearer new"] == 0 || auths["Basic dXNlcjpwYXNz"] == 0 || len(auths) != 2 {
+		t.Errorf("Expected the Authorization header to be swapped mid-run, found %v", auths)
+	}
+}
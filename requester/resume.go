@@ -0,0 +1,112 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import "time"
+
+// ResumptionReport describes the TLS handshakes of new connections with
+// Work.TLSResume, and how much resuming sessions saved over full
+// handshakes.
+type ResumptionReport struct {
+	// Handshakes counts the TLS handshakes, of which Resumed resumed a
+	// session of an earlier connection.
+	Handshakes int64
+	Resumed    int64
+
+	// FullHandshake and ResumedHandshake are the average times of full
+	// and resumed TLS handshakes, and FullConn and ResumedConn those of
+	// the connection setups including them, in seconds.
+	FullHandshake    float64
+	ResumedHandshake float64
+	FullConn         float64
+	ResumedConn      float64
+}
+
+// Saved returns how much faster resumed connection setups were than full
+// ones on average, in seconds, or 0 if either did not occur.
+func (r *ResumptionReport) Saved() float64 {
+	if r.Resumed == 0 || r.Resumed == r.Handshakes {
+		return 0
+	}
+	return r.FullConn - r.ResumedConn
+}
+
+type resumptionStats struct {
+	enabled          bool // whether sessions were resumed, see Work.TLSResume
+	handshakes       int64
+	resumed          int64
+	fullHandshake    time.Duration
+	resumedHandshake time.Duration
+	fullConn         time.Duration
+	resumedConn      time.Duration
+}
+
+func (r *resumptionStats) add(res *result) {
+	if !res.newConn || res.tlsDuration == 0 {
+		return
+	}
+	r.handshakes++
+	if !res.resumed {
+		r.fullHandshake += res.tlsDuration
+		r.fullConn += res.connDuration
+		return
+	}
+	r.resumed++
+	r.resumedHandshake += res.tlsDuration
+	r.resumedConn += res.connDuration
+}
+
+// snapshot returns the report of the handshakes, or nil if sessions were
+// not resumed, nor meant to be.
+func (r *resumptionStats) snapshot() *ResumptionReport {
+	if r.handshakes == 0 || !r.enabled && r.resumed == 0 {
+		return nil
+	}
+	rep := &ResumptionReport{Handshakes: r.handshakes, Resumed: r.resumed}
+	if full := r.handshakes - r.resumed; full > 0 {
+		rep.FullHandshake = r.fullHandshake.Seconds() / float64(full)
+		rep.FullConn = r.fullConn.Seconds() / float64(full)
+	}
+	if r.resumed > 0 {
+		rep.ResumedHandshake = r.resumedHandshake.Seconds() / float64(r.resumed)
+		rep.ResumedConn = r.resumedConn.Seconds() / float64(r.resumed)
+	}
+	return rep
+}
+
+// mergeResumption adds the handshakes of b to a.
+func mergeResumption(a, b *ResumptionReport) *ResumptionReport {
+	if b == nil {
+		return a
+	}
+	if a == nil {
+		a = &ResumptionReport{}
+	}
+	weigh := func(x float64, nx int64, y float64, ny int64) float64 {
+		if nx+ny == 0 {
+			return 0
+		}
+		return (x*float64(nx) + y*float64(ny)) / float64(nx+ny)
+	}
+	aFull, bFull := a.Handshakes-a.Resumed, b.Handshakes-b.Resumed
+	return &ResumptionReport{
+		Handshakes:       a.Handshakes + b.Handshakes,
+		Resumed:          a.Resumed + b.Resumed,
+		FullHandshake:    weigh(a.FullHandshake, aFull, b.FullHandshake, bFull),
+		ResumedHandshake: weigh(a.ResumedHandshake, a.Resumed, b.ResumedHandshake, b.Resumed),
+		FullConn:         weigh(a.FullConn, aFull, b.FullConn, bFull),
+		ResumedConn:      weigh(a.ResumedConn, a.Resumed, b.ResumedConn, b.Resumed),
+	}
+}
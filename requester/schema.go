@@ -0,0 +1,228 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// SchemaVersion is the version of the schemas of the "ndjson" and "json"
+// outputs. Fields may be added within a version; it is bumped when one
+// is removed or changes meaning, so that parsers can tell.
+const SchemaVersion = 1
+
+// Names of the schemas, see JSONSchema.
+const (
+	RecordsSchema = "hey.records" // the lines of the "ndjson" output
+	SummarySchema = "hey.summary" // the "json" output
+)
+
+// SchemaInfo describes the schema of an output, with the descriptions of
+// its top-level fields.
+type SchemaInfo struct {
+	Name    string            `json:"name"`
+	Version int               `json:"version"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// schemas are the types of the outputs by schema name.
+var schemas = map[string]reflect.Type{
+	RecordsSchema: reflect.TypeOf(Record{}),
+	SummarySchema: reflect.TypeOf(Summary{}),
+}
+
+// fieldDocs describes the JSON fields of the types of the outputs, by
+// type and field name.
+var fieldDocs = map[string]map[string]string{
+	"Record": {
+		"schema":          "Set only on the first line of a stream, which describes the schema of the records rather than a request.",
+		"offset":          "Time the request was started, in seconds since the start of the run.",
+		"duration":        "Total duration of the request, in seconds.",
+		"conn":            "Time to set up the connection, DNS lookup and dial included, in seconds.",
+		"dns":             "Time of the DNS lookup, in seconds.",
+		"dial":            "Time of the TCP connect, in seconds.",
+		"tls":             "Time of the TLS handshake, in seconds.",
+		"req_write":       "Time to write the request, in seconds.",
+		"delay":           "Time from the request written to the first response byte, in seconds.",
+		"res_read":        "Time to read the response, in seconds.",
+		"status":          "HTTP status code of the response.",
+		"size":            "Size of the response body, in bytes.",
+		"proto":           "Protocol of the response, e.g. HTTP/2.0.",
+		"new_conn":        "Whether the request opened a new connection.",
+		"error":           "Error of the request, if it failed.",
+		"paced":           "Whether the request was rate limited.",
+		"lag":             "How late the rate limited request was sent, in seconds.",
+		"capped":          "Whether the request waited for the in-flight cap.",
+		"hint":            "Time to the first 103 Early Hints response, in seconds.",
+		"hints":           "Number of resources hinted by 103 responses.",
+		"final":           "Time to the final response headers, in seconds.",
+		"trace_id":        "ID of the trace started by the request.",
+		"compared":        "Whether the body was compared to the golden response.",
+		"diff":            "First divergence of the body from the golden response.",
+		"url":             "URL requested, when several are.",
+		"worker":          "Worker that sent the request, from 1.",
+		"iteration":       "Iteration of the worker, from 1.",
+		"time":            "Wall clock time the request, mark or connection was started, or the header written.",
+		"trailer":         "Response trailers.",
+		"idempotency_key": "Idempotency-Key header sent.",
+		"params":          "Swept and fuzzed query parameters.",
+		"capture":         "File the request and response were captured to.",
+		"body":            "Name of the request body sent.",
+		"redirects":       "Redirects followed before the response.",
+		"family":          "IP family of the connection opened, IPv4 or IPv6.",
+		"fallback":        "IP family the dial fell back to.",
+		"resumed":         "Whether the TLS handshake of the connection opened resumed a session.",
+		"date":            "Date header of the response, in Unix seconds.",
+		"probe":           "Whether the request probed a target found down.",
+		"conn_id":         "ID of the connection the request was sent on.",
+		"resolver":        "Resolver of the DNS lookup.",
+		"dns_failed":      "Whether the DNS lookup failed.",
+		"mark":            "Set only for marks of the run, the text of the mark.",
+		"connection":      "Set only for the records of connections rather than requests.",
+	},
+	"ConnRecord": {
+		"id":            "ID of the connection, from 1.",
+		"addr":          "Remote address of the connection.",
+		"dial":          "Time to connect, in seconds.",
+		"tls":           "Time of the TLS handshake, in seconds.",
+		"lifetime":      "How long the connection was open, in seconds.",
+		"requests":      "Number of requests the connection served.",
+		"bytes_read":    "Bytes read, TLS records and HTTP framing included.",
+		"bytes_written": "Bytes written, TLS records and HTTP framing included.",
+		"close_reason":  "Why the connection was closed: server, client, failed or open.",
+		"error":         "Error reading or writing the failed connection.",
+	},
+	"RedirectHop": {
+		"url":      "URL redirected from, without its query.",
+		"duration": "Time until the redirect, in seconds.",
+	},
+	"Summary": {
+		"schema":               "Schema of the summary.",
+		"requests":             "Number of requests made.",
+		"errors":               "Number of requests that failed.",
+		"duration":             "Duration of the run, in seconds.",
+		"rps":                  "Requests per second.",
+		"average":              "Average latency of successful requests, in seconds.",
+		"fastest":              "Fastest latency, in seconds.",
+		"slowest":              "Slowest latency, in seconds.",
+		"percentiles":          "Latency percentiles in seconds, by name, e.g. p99.",
+		"status_codes":         "Number of responses by status code.",
+		"errors_by_type":       "Number of failed requests by error.",
+		"size_total":           "Total size of the response bodies, in bytes.",
+		"percentile_intervals": "95% confidence intervals of the percentiles, low and high, in seconds.",
+		"too_few_samples":      "Percentiles too few requests were slower than to tell from noise.",
+		"histogram":            "Latency histogram.",
+		"records":              "Records of the requests, if asked for.",
+	},
+	"SummaryBucket": {
+		"mark":      "Upper bound of the bucket, in seconds.",
+		"count":     "Number of requests in the bucket.",
+		"frequency": "Fraction of the requests in the bucket.",
+	},
+	"SchemaInfo": {
+		"name":    "Name of the schema.",
+		"version": "Version of the schema.",
+		"fields":  "Descriptions of the top-level fields, by name.",
+	},
+}
+
+func schemaInfo(name string) *SchemaInfo {
+	return &SchemaInfo{Name: name, Version: SchemaVersion, Fields: maps.Clone(fieldDocs[schemas[name].Name()])}
+}
+
+// RecordsHeader starts a stream of records, describing their schema. It
+// has none of the fields of a request, so that readers that do not know
+// it cannot take it for one.
+type RecordsHeader struct {
+	Schema *SchemaInfo `json:"schema"`
+	Time   time.Time   `json:"time"`
+}
+
+// NewRecordsHeader returns the header of a stream of records, at the time
+// it is written.
+func NewRecordsHeader() RecordsHeader {
+	return RecordsHeader{Schema: schemaInfo(RecordsSchema), Time: time.Now()}
+}
+
+// JSONSchema returns the JSON Schema of the named schema, RecordsSchema
+// or SummarySchema, with the descriptions of all its fields.
+func JSONSchema(name string) (map[string]interface{}, error) {
+	t, ok := schemas[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown schema %q; want %s or %s", name, RecordsSchema, SummarySchema)
+	}
+	s := jsonSchemaOf(t)
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["title"] = name
+	s["version"] = SchemaVersion
+	return s, nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// jsonSchemaOf returns the JSON Schema of the values of t, as encoded by
+// encoding/json.
+func jsonSchemaOf(t reflect.Type) map[string]interface{} {
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonSchemaOf(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": jsonSchemaOf(t.Elem())}
+	case reflect.Array:
+		return map[string]interface{}{"type": "array", "items": jsonSchemaOf(t.Elem()), "minItems": t.Len(), "maxItems": t.Len()}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchemaOf(t.Elem())}
+	case reflect.Struct:
+		props := make(map[string]interface{})
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.IsExported() || name == "" || name == "-" {
+				continue
+			}
+			p := jsonSchemaOf(f.Type)
+			if doc := fieldDocs[t.Name()][name]; doc != "" {
+				p["description"] = doc
+			}
+			props[name] = p
+			if opts != "omitempty" {
+				required = append(required, name)
+			}
+		}
+		s := map[string]interface{}{"type": "object", "properties": props}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	}
+	return map[string]interface{}{}
+}
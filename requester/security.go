@@ -0,0 +1,175 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"net/http"
+	"sort"
+)
+
+// SecurityHeaderNames are the headers audited with Work.SecurityHeaders.
+var SecurityHeaderNames = []string{
+	"Strict-Transport-Security",
+	"Content-Security-Policy",
+	"X-Content-Type-Options",
+	"X-Frame-Options",
+	"Referrer-Policy",
+	"Permissions-Policy",
+}
+
+// Caps of the distinct values and backends a security header is reported
+// on, as values may vary by response, e.g. CSP nonces. Further ones are
+// counted as otherTarget.
+const (
+	maxHeaderValues   = 10
+	maxHeaderBackends = 100
+)
+
+// missingHeader is the value of a header responses did not have.
+const missingHeader = "(missing)"
+
+// securityHeaders returns the values of SecurityHeaderNames in h, in
+// that order, missingHeader for those h does not have.
+func securityHeaders(h http.Header) []string {
+	values := make([]string, len(SecurityHeaderNames))
+	for i, name := range SecurityHeaderNames {
+		values[i] = missingHeader
+		if v := h.Values(name); len(v) > 0 {
+			values[i] = v[0]
+			for _, more := range v[1:] {
+				values[i] += ", " + more
+			}
+		}
+	}
+	return values
+}
+
+// SecurityHeaderReport describes the values a security header had
+// across the responses of a run, and on which backends.
+type SecurityHeaderReport struct {
+	Name      string
+	Responses int64
+	Present   int64
+
+	// Values counts responses by the value of the header, "(missing)"
+	// for the responses without it, and Backends by backend and value.
+	Values   map[string]int64
+	Backends map[string]map[string]int64
+}
+
+// Consistent reports whether every response had the same value of the
+// header, or none had it.
+func (s *SecurityHeaderReport) Consistent() bool {
+	return len(s.Values) <= 1
+}
+
+// DivergentBackend is a backend whose responses had other values of a
+// security header than most responses.
+type DivergentBackend struct {
+	Backend string
+	Values  map[string]int64
+}
+
+// Divergent returns the backends whose responses had other values than
+// the most common one, in the order of their names.
+func (s *SecurityHeaderReport) Divergent() []DivergentBackend {
+	common, most := "", int64(-1)
+	for v, n := range s.Values {
+		if n > most || n == most && v < common {
+			common, most = v, n
+		}
+	}
+	var divergent []DivergentBackend
+	for backend, values := range s.Backends {
+		if len(values) > 1 || values[common] == 0 {
+			divergent = append(divergent, DivergentBackend{Backend: backend, Values: values})
+		}
+	}
+	sort.Slice(divergent, func(i, j int) bool { return divergent[i].Backend < divergent[j].Backend })
+	return divergent
+}
+
+func (s *SecurityHeaderReport) add(backend, value string, n int64) {
+	s.Responses += n
+	if value != missingHeader {
+		s.Present += n
+	}
+	if _, ok := s.Values[value]; !ok && len(s.Values) >= maxHeaderValues {
+		value = otherTarget
+	}
+	s.Values[value] += n
+	if _, ok := s.Backends[backend]; !ok && len(s.Backends) >= maxHeaderBackends {
+		backend = otherTarget
+	}
+	values := s.Backends[backend]
+	if values == nil {
+		values = make(map[string]int64)
+		s.Backends[backend] = values
+	}
+	values[value] += n
+}
+
+func newSecurityHeaderReports() []*SecurityHeaderReport {
+	reps := make([]*SecurityHeaderReport, len(SecurityHeaderNames))
+	for i, name := range SecurityHeaderNames {
+		reps[i] = &SecurityHeaderReport{Name: name, Values: make(map[string]int64), Backends: make(map[string]map[string]int64)}
+	}
+	return reps
+}
+
+// securityStats audits the security headers of responses, with
+// Work.SecurityHeaders.
+type securityStats []*SecurityHeaderReport
+
+func (s securityStats) add(res *result) {
+	if res.security == nil {
+		return
+	}
+	for i, v := range res.security {
+		s[i].add(res.backend, v, 1)
+	}
+}
+
+// snapshot returns the reports of the security headers, nil if they were
+// not audited.
+func (s securityStats) snapshot() []*SecurityHeaderReport {
+	if s == nil {
+		return nil
+	}
+	return mergeSecurityHeaders(nil, s)
+}
+
+// mergeSecurityHeaders adds the reports of b to a, by header.
+func mergeSecurityHeaders(a, b []*SecurityHeaderReport) []*SecurityHeaderReport {
+	if len(b) == 0 {
+		return a
+	}
+	if a == nil {
+		a = newSecurityHeaderReports()
+	}
+	for _, rep := range b {
+		for _, m := range a {
+			if m.Name != rep.Name {
+				continue
+			}
+			for backend, values := range rep.Backends {
+				for v, n := range values {
+					m.add(backend, v, n)
+				}
+			}
+		}
+	}
+	return a
+}
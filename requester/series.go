@@ -0,0 +1,143 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"sort"
+	"strings"
+	"time"
+)
+
+// seriesInterval is the width of the buckets of the status code time
+// series.
+const seriesInterval = time.Second
+
+// StatusBucket counts the responses to the requests sent within one
+// interval of the run, by status code.
+type StatusBucket struct {
+	Offset      float64 // start of the interval, in seconds
+	StatusCodes map[int]int
+	Errors      int
+}
+
+// statusSeries buckets results by the time their request was sent.
+type statusSeries []StatusBucket
+
+func (s *statusSeries) add(res *result, start time.Duration) {
+	i := int(max(res.offset-start, 0) / seriesInterval)
+	for len(*s) <= i {
+		*s = append(*s, StatusBucket{
+			Offset:      (time.Duration(len(*s)) * seriesInterval).Seconds(),
+			StatusCodes: make(map[int]int),
+		})
+	}
+	b := &(*s)[i]
+	if res.err != nil || res.aborted != "" {
+		b.Errors++
+		return
+	}
+	b.StatusCodes[res.statusCode]++
+}
+
+// mergeSeries adds the buckets of b to a, matching them by offset.
+func mergeSeries(a, b []StatusBucket) []StatusBucket {
+	for i, bucket := range b {
+		if i >= len(a) {
+			a = append(a, StatusBucket{Offset: bucket.Offset, StatusCodes: make(map[int]int)})
+		}
+		for code, n := range bucket.StatusCodes {
+			a[i].StatusCodes[code] += n
+		}
+		a[i].Errors += bucket.Errors
+	}
+	return a
+}
+
+// seriesCodes returns the status codes seen in series, in order.
+func seriesCodes(series []StatusBucket) []int {
+	seen := make(map[int]bool)
+	var codes []int
+	for _, b := range series {
+		for code := range b.StatusCodes {
+			if !seen[code] {
+				seen[code] = true
+				codes = append(codes, code)
+			}
+		}
+	}
+	sort.Ints(codes)
+	return codes
+}
+
+// statusColor returns the chart color of a status code class.
+func statusColor(code int) string {
+	switch code / 100 {
+	case 2:
+		return "#5cb85c"
+	case 3:
+		return "#4a90d9"
+	case 4:
+		return "#f0ad4e"
+	case 5:
+		return "#d9534f"
+	}
+	return "#999999"
+}
+
+// seriesChart renders series as an SVG stacked bar chart, with one bar per
+// interval, and marks as vertical lines.
+func seriesChart(series []StatusBucket, marks []Mark) htmltemplate.HTML {
+	const width, height = 800, 200
+	codes := seriesCodes(series)
+	max := 0
+	for _, b := range series {
+		n := b.Errors
+		for _, v := range b.StatusCodes {
+			n += v
+		}
+		if n > max {
+			max = n
+		}
+	}
+	if max == 0 {
+		return ""
+	}
+	bw := float64(width) / float64(len(series))
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg width="%d" height="%d" viewBox="0 0 %d %d">`, width, height, width, height)
+	for i, b := range series {
+		y := float64(height)
+		bar := func(n int, color, label string) {
+			if n == 0 {
+				return
+			}
+			h := float64(n) * height / float64(max)
+			y -= h
+			fmt.Fprintf(&sb, `<rect x="%.2f" y="%.2f" width="%.2f" height="%.2f" fill="%s"><title>%vs: %d %s</title></rect>`,
+				float64(i)*bw, y, bw, h, color, b.Offset, n, label)
+		}
+		for _, code := range codes {
+			bar(b.StatusCodes[code], statusColor(code), fmt.Sprint(code))
+		}
+		bar(b.Errors, statusColor(0), "errors")
+	}
+	for _, m := range marks {
+		markLine(&sb, m.Offset/seriesInterval.Seconds()*bw, 0, height, m)
+	}
+	sb.WriteString(`</svg>`)
+	return htmltemplate.HTML(sb.String())
+}
@@ -0,0 +1,118 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"math"
+	"sort"
+)
+
+// MannWhitneyU tests whether latencies b tend to be higher or lower than
+// latencies a, as those of two runs being compared, without assuming how
+// they are distributed. It returns the probability that a value of b is
+// greater than one of a, counting ties as half, which is 0.5 if neither
+// tends to be greater, and the two-sided p-value of the difference, from
+// the normal approximation with tie and continuity corrections.
+func MannWhitneyU(a, b []float64) (greater, p float64) {
+	n, m := float64(len(a)), float64(len(b))
+	if n == 0 || m == 0 {
+		return 0.5, 1
+	}
+	type sample struct {
+		v     float64
+		fromB bool
+	}
+	all := make([]sample, 0, len(a)+len(b))
+	for _, v := range a {
+		all = append(all, sample{v: v})
+	}
+	for _, v := range b {
+		all = append(all, sample{v: v, fromB: true})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+
+	// Rank the samples from 1, tied ones sharing their average rank.
+	var rankSum, ties float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for _, s := range all[i:j] {
+			if s.fromB {
+				rankSum += rank
+			}
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+	u := rankSum - m*(m+1)/2
+	total := n + m
+	mean := n * m / 2
+	sigma := math.Sqrt(n * m / 12 * ((total + 1) - ties/(total*(total-1))))
+	if sigma == 0 {
+		return u / (n * m), 1
+	}
+	z := math.Max(math.Abs(u-mean)-0.5, 0) / sigma
+	return u / (n * m), math.Erfc(z / math.Sqrt2)
+}
+
+// KolmogorovSmirnov tests whether latencies a and b are distributed
+// differently, in shape as well as location, e.g. if one run is bimodal.
+// It returns the largest distance between their cumulative distributions
+// and the p-value of it, from the asymptotic Kolmogorov distribution.
+func KolmogorovSmirnov(a, b []float64) (d, p float64) {
+	if len(a) == 0 || len(b) == 0 {
+		return 0, 1
+	}
+	a = append([]float64(nil), a...)
+	b = append([]float64(nil), b...)
+	sort.Float64s(a)
+	sort.Float64s(b)
+	n, m := float64(len(a)), float64(len(b))
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		v := math.Min(a[i], b[j])
+		for i < len(a) && a[i] == v {
+			i++
+		}
+		for j < len(b) && b[j] == v {
+			j++
+		}
+		d = math.Max(d, math.Abs(float64(i)/n-float64(j)/m))
+	}
+	en := math.Sqrt(n * m / (n + m))
+	return d, kolmogorovQ((en + 0.12 + 0.11/en) * d)
+}
+
+// kolmogorovQ returns the probability that the Kolmogorov distribution
+// exceeds lambda.
+func kolmogorovQ(lambda float64) float64 {
+	if lambda < 0.2 {
+		return 1
+	}
+	var q float64
+	sign := 1.0
+	for j := 1.0; j <= 100; j++ {
+		term := sign * 2 * math.Exp(-2*j*j*lambda*lambda)
+		q += term
+		if math.Abs(term) < 1e-12 {
+			break
+		}
+		sign = -sign
+	}
+	return math.Min(math.Max(q, 0), 1)
+}
@@ -0,0 +1,119 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import "log"
+
+// Sink receives the raw record of every request as the run progresses,
+// and of every connection with Work.RecordConns, e.g. to forward them to
+// an external system. Write is called from a single goroutine, apart from
+// the reporter, and records are dropped if it falls too far behind. Close
+// is called once all records have been written.
+type Sink interface {
+	Write(rec Record) error
+	Close() error
+}
+
+// sinkQueue is how many records may wait for the sinks before further
+// records are dropped. Sinks may do network I/O, and must not hold up the
+// reporter, and with it the workers, when they fall behind.
+const sinkQueue = 10000
+
+// sinkWriter fans records out to sinks from its own goroutine, logging
+// the first error of each and counting the rest. Records that arrive
+// while its queue is full are dropped and counted. A sinkWriter without a
+// queue writes every record from the caller instead, for Work.Spools.
+type sinkWriter struct {
+	sinks   []Sink
+	errors  []int
+	queue   chan Record
+	done    chan struct{}
+	dropped int64
+}
+
+func newSinkWriter(sinks []Sink) *sinkWriter {
+	s := &sinkWriter{
+		sinks:  sinks,
+		errors: make([]int, len(sinks)),
+		queue:  make(chan Record, sinkQueue),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// newSpoolWriter returns a sinkWriter that writes every record to sinks
+// before write returns, so none are dropped.
+func newSpoolWriter(sinks []Sink) *sinkWriter {
+	return &sinkWriter{
+		sinks:  sinks,
+		errors: make([]int, len(sinks)),
+	}
+}
+
+func (s *sinkWriter) run() {
+	for rec := range s.queue {
+		s.writeAll(rec)
+	}
+	close(s.done)
+}
+
+func (s *sinkWriter) writeAll(rec Record) {
+	for i, sink := range s.sinks {
+		if err := sink.Write(rec); err != nil {
+			s.fail(i, err)
+		}
+	}
+}
+
+// write queues rec for the sinks, or drops it if the queue is full. Without
+// a queue, it writes rec itself.
+func (s *sinkWriter) write(rec Record) {
+	if s.queue == nil {
+		s.writeAll(rec)
+		return
+	}
+	select {
+	case s.queue <- rec:
+	default:
+		s.dropped++
+	}
+}
+
+// close waits for the queued records to be written and closes the sinks.
+func (s *sinkWriter) close() {
+	if s.queue != nil {
+		close(s.queue)
+		<-s.done
+	}
+	for i, sink := range s.sinks {
+		if err := sink.Close(); err != nil {
+			s.fail(i, err)
+		}
+		if s.errors[i] > 1 {
+			log.Printf("sink: %d more errors", s.errors[i]-1)
+		}
+	}
+	if s.dropped > 0 {
+		log.Printf("sink: %d records dropped, sinks could not keep up", s.dropped)
+	}
+}
+
+func (s *sinkWriter) fail(i int, err error) {
+	if s.errors[i] == 0 {
+		log.Println("sink error:", err)
+	}
+	s.errors[i]++
+}
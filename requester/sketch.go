@@ -0,0 +1,111 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// DefaultSketchAccuracy is the relative accuracy of the latency sketches
+// kept by reports.
+const DefaultSketchAccuracy = 0.01
+
+// minSketchValue is the smallest value tracked by logarithmic bins;
+// smaller values are counted as zeros.
+const minSketchValue = 1e-9
+
+// Sketch is a mergeable quantile sketch with logarithmic bins, in the
+// manner of DDSketch. Quantiles estimated from it are within Alpha
+// relative error of the true values. Sketches with the same Alpha can be
+// merged losslessly, which allows combining the latency distributions of
+// runs made on different machines.
+type Sketch struct {
+	Alpha float64       `json:"alpha"`
+	Bins  map[int]int64 `json:"bins"`
+	Zeros int64         `json:"zeros"`
+	Count int64         `json:"count"`
+}
+
+// NewSketch returns an empty sketch with the given relative accuracy.
+func NewSketch(alpha float64) *Sketch {
+	return &Sketch{Alpha: alpha, Bins: make(map[int]int64)}
+}
+
+func (s *Sketch) gamma() float64 {
+	return (1 + s.Alpha) / (1 - s.Alpha)
+}
+
+// Add records the value v.
+func (s *Sketch) Add(v float64) {
+	s.Count++
+	if v < minSketchValue {
+		s.Zeros++
+		return
+	}
+	s.Bins[int(math.Ceil(math.Log(v)/math.Log(s.gamma())))]++
+}
+
+// Merge adds all values recorded by o to s.
+func (s *Sketch) Merge(o *Sketch) error {
+	if o == nil {
+		return nil
+	}
+	if s.Alpha != o.Alpha {
+		return fmt.Errorf("cannot merge sketches with accuracies %v and %v", s.Alpha, o.Alpha)
+	}
+	for i, n := range o.Bins {
+		s.Bins[i] += n
+	}
+	s.Zeros += o.Zeros
+	s.Count += o.Count
+	return nil
+}
+
+// Quantile returns an estimate of the q-quantile, for q between 0 and 1.
+// It returns 0 for an empty sketch.
+func (s *Sketch) Quantile(q float64) float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	rank := int64(q * float64(s.Count-1))
+	if rank < s.Zeros {
+		return 0
+	}
+	seen := s.Zeros
+	for _, i := range s.indexes() {
+		seen += s.Bins[i]
+		if seen > rank {
+			return s.value(i)
+		}
+	}
+	return s.value(s.indexes()[len(s.Bins)-1])
+}
+
+// value returns the representative value of bin i.
+func (s *Sketch) value(i int) float64 {
+	g := s.gamma()
+	return 2 * math.Pow(g, float64(i)) / (g + 1)
+}
+
+func (s *Sketch) indexes() []int {
+	idx := make([]int, 0, len(s.Bins))
+	for i := range s.Bins {
+		idx = append(idx, i)
+	}
+	sort.Ints(idx)
+	return idx
+}
@@ -0,0 +1,137 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var sloRegexp = regexp.MustCompile(`^\s*([\d.]+)%\s*<\s*(\S+)\s+over\s+(\S+)\s*$`)
+
+// SLO is a latency service level objective, such as "99% < 300ms over 30d":
+// Target percent of requests over Window must succeed faster than
+// Threshold.
+type SLO struct {
+	Target    float64 // fraction of good requests, e.g. 0.99
+	Threshold time.Duration
+	Window    time.Duration
+}
+
+// ParseSLO parses an SLO of the form "99.9% < 300ms over 30d". Durations
+// are in time.ParseDuration format, with the addition of the "d" (day)
+// and "w" (week) units.
+func ParseSLO(s string) (SLO, error) {
+	m := sloRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return SLO{}, fmt.Errorf("could not parse the provided SLO; input = %v", s)
+	}
+	pct, err := strconv.ParseFloat(m[1], 64)
+	if err != nil || pct <= 0 || pct >= 100 {
+		return SLO{}, fmt.Errorf("SLO target must be between 0%% and 100%%; input = %v", s)
+	}
+	threshold, err := parseLongDuration(m[2])
+	if err != nil {
+		return SLO{}, err
+	}
+	window, err := parseLongDuration(m[3])
+	if err != nil {
+		return SLO{}, err
+	}
+	return SLO{Target: pct / 100, Threshold: threshold, Window: window}, nil
+}
+
+func (s SLO) String() string {
+	return fmt.Sprintf("%v%% < %v over %v", s.Target*100, s.Threshold, s.Window)
+}
+
+// parseLongDuration is like time.ParseDuration but also accepts whole
+// days and weeks, e.g. "30d" or "4w".
+func parseLongDuration(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			v, err := strconv.ParseFloat(n, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(v * float64(unit)), nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+// SLOReport translates the results of a run into error budget terms.
+type SLOReport struct {
+	SLO  string
+	Good int64
+	Bad  int64
+
+	// Compliance is the fraction of good requests.
+	Compliance float64
+
+	// BurnRate is how fast the error budget would be consumed relative to
+	// the rate that exactly exhausts it at the end of the window.
+	BurnRate float64
+
+	// Exhaustion is how long a full error budget would last at this burn
+	// rate; zero if no budget is being burned.
+	Exhaustion time.Duration
+
+	// BudgetRequests is the number of bad requests the SLO allows over its
+	// window at TrafficRate requests per second. Zero if no production
+	// traffic rate was given.
+	TrafficRate    float64
+	BudgetRequests float64
+}
+
+type sloStats struct {
+	slo       SLO
+	rate      float64
+	good, bad int64
+}
+
+func (s *sloStats) add(res *result) {
+	if res.err != nil || res.statusCode >= 500 || res.duration >= s.slo.Threshold {
+		s.bad++
+	} else {
+		s.good++
+	}
+}
+
+func (s *sloStats) snapshot() *SLOReport {
+	rep := &SLOReport{
+		SLO:         s.slo.String(),
+		Good:        s.good,
+		Bad:         s.bad,
+		TrafficRate: s.rate,
+	}
+	total := s.good + s.bad
+	if total == 0 {
+		return rep
+	}
+	rep.Compliance = float64(s.good) / float64(total)
+	budget := 1 - s.slo.Target
+	rep.BurnRate = (float64(s.bad) / float64(total)) / budget
+	if rep.BurnRate > 0 {
+		rep.Exhaustion = time.Duration(float64(s.slo.Window) / rep.BurnRate).Round(time.Second)
+	}
+	if s.rate > 0 {
+		rep.BudgetRequests = budget * s.rate * s.slo.Window.Seconds()
+	}
+	return rep
+}
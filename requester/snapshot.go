@@ -0,0 +1,126 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// snapshotter periodically writes an interim summary of the results
+// received since the previous snapshot.
+type snapshotter struct {
+	w        io.Writer
+	interval time.Duration
+	unit     timeUnit
+	start    time.Time
+	stop     chan struct{}
+	done     chan struct{}
+
+	mu     sync.Mutex
+	last   time.Time
+	lats   []float64
+	errors int
+	count  int
+}
+
+func newSnapshotter(w io.Writer, interval time.Duration, unit string) *snapshotter {
+	s := &snapshotter{
+		w:        w,
+		interval: interval,
+		unit:     timeUnitOf(unit),
+		start:    time.Now(),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	s.last = s.start
+	go s.run()
+	return s
+}
+
+func (s *snapshotter) run() {
+	defer close(s.done)
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			s.flush()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// add records a result. Errors and 5xx responses count as failed.
+func (s *snapshotter) add(res *result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.count++
+	if res.err != nil || res.aborted != "" || res.statusCode >= 500 {
+		s.errors++
+		return
+	}
+	s.lats = append(s.lats, res.duration.Seconds())
+}
+
+// close stops the ticker and writes a final snapshot for the results
+// received since the last one. Tails shorter than a tenth of the interval
+// are left to the final summary, as their rates would be mostly noise.
+func (s *snapshotter) close() {
+	close(s.stop)
+	<-s.done
+	s.mu.Lock()
+	partial := s.count > 0 && time.Since(s.last) >= s.interval/10
+	s.mu.Unlock()
+	if partial {
+		s.flush()
+	}
+}
+
+func (s *snapshotter) flush() {
+	s.mu.Lock()
+	now := time.Now()
+	elapsed, interval := now.Sub(s.start), now.Sub(s.last)
+	lats, errors, count := s.lats, s.errors, s.count
+	s.last, s.lats, s.errors, s.count = now, nil, 0, 0
+	s.mu.Unlock()
+
+	sort.Float64s(lats)
+	var errRate float64
+	if count > 0 {
+		errRate = float64(errors) / float64(count) * 100
+	}
+	fmt.Fprintf(s.w, "[%v] %d requests, %4.4f req/s, p50 %s, p95 %s, p99 %s, errors %.2f%%\n",
+		elapsed.Round(time.Second), count, float64(count)/interval.Seconds(),
+		s.unit.format(quantile(lats, 50)), s.unit.format(quantile(lats, 95)), s.unit.format(quantile(lats, 99)), errRate)
+}
+
+// quantile returns the pct percentile of sorted, picked the same way as
+// in the latency distribution, or 0 if sorted is empty.
+func quantile(sorted []float64, pct float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(pct / 100 * float64(len(sorted))))
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
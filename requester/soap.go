@@ -0,0 +1,68 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+)
+
+// soapFault returns an error describing the Fault of a SOAP 1.1 or 1.2
+// response body, or nil if it has none or is not a SOAP envelope.
+func soapFault(body []byte) error {
+	d := xml.NewDecoder(bytes.NewReader(body))
+	var path []string // local names of the open elements
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "Fault" && len(path) == 2 && path[0] == "Envelope" && path[1] == "Body" {
+				var f struct {
+					Code   string `xml:"faultcode"`   // SOAP 1.1
+					String string `xml:"faultstring"` // SOAP 1.1
+					Value  string `xml:"Code>Value"`  // SOAP 1.2
+					Reason string `xml:"Reason>Text"` // SOAP 1.2
+				}
+				if err := d.DecodeElement(&f, &t); err != nil {
+					return errors.New("SOAP fault")
+				}
+				code, reason := f.Code+f.Value, f.String+f.Reason
+				switch {
+				case code != "" && reason != "":
+					return errors.New("SOAP fault: " + code + ": " + reason)
+				case code+reason != "":
+					return errors.New("SOAP fault: " + code + reason)
+				}
+				return errors.New("SOAP fault")
+			}
+			path = append(path, t.Name.Local)
+			if len(path) > 2 {
+				// Faults are children of the Body.
+				if err := d.Skip(); err != nil {
+					return nil
+				}
+				path = path[:len(path)-1]
+			}
+		case xml.EndElement:
+			if len(path) > 0 {
+				path = path[:len(path)-1]
+			}
+		}
+	}
+}
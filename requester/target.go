@@ -0,0 +1,215 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"net/http"
+	"net/url"
+	"sync/atomic"
+)
+
+// maxTargets caps the distinct targets reported on, so that generated
+// URLs do not grow the report without bound. Further targets are counted
+// as otherTarget.
+const maxTargets = 100
+
+const otherTarget = "(other)"
+
+// setTarget points req at the next of URLs.
+func (b *Work) setTarget(req *http.Request) {
+	i := atomic.AddUint64(&b.urlNext, 1) - 1
+	u := b.URLs[i%uint64(len(b.URLs))]
+	// Unless a Host header was set, requests go to the host of their URL.
+	if req.Host == b.Request.URL.Host {
+		req.Host = u.Host
+	}
+	req.URL = u
+}
+
+// targetTransport routes requests to the transport of their target
+// host, with TransportPerTarget. Other hosts, such as those redirected
+// to or requested by a RequestFunc, share a transport.
+type targetTransport struct {
+	byHost map[string]http.RoundTripper
+	other  http.RoundTripper
+}
+
+func (t *targetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rt := t.byHost[req.URL.Host]; rt != nil {
+		return rt.RoundTrip(req)
+	}
+	return t.other.RoundTrip(req)
+}
+
+// targetTransport returns the i-th of n transports that route requests
+// by target host, adding the HTTP/2 pools of their transports to pools.
+func (b *Work) targetTransport(i, n int, pools []*h2Pool) (http.RoundTripper, []*h2Pool) {
+	urls := b.URLs
+	if len(urls) == 0 {
+		urls = []*url.URL{b.Request.URL}
+	}
+	t := &targetTransport{byHost: make(map[string]http.RoundTripper)}
+	for _, u := range urls {
+		if t.byHost[u.Host] != nil {
+			continue
+		}
+		serverName := u.Host
+		if b.Request.Host != b.Request.URL.Host {
+			// A Host header was set, which all targets are sent.
+			serverName = b.Request.Host
+		}
+		rt, pool := b.newTransport(i, n, serverName)
+		if pool != nil {
+			pools = append(pools, pool)
+		}
+		t.byHost[u.Host] = rt
+		b.report.transports++
+	}
+	var pool *h2Pool
+	t.other, pool = b.newTransport(i, n, b.Request.Host)
+	if pool != nil {
+		pools = append(pools, pool)
+	}
+	return t, pools
+}
+
+// targetOf returns the target requests to u are reported under, the URL
+// without its query, which may vary from request to request.
+func targetOf(u *url.URL) string {
+	return u.Scheme + "://" + u.Host + u.EscapedPath()
+}
+
+// TargetStats summarizes the requests made to a target.
+type TargetStats struct {
+	Requests    int
+	Errors      int
+	Total       float64 // sum of latencies of successful requests
+	Slowest     float64
+	Rps         float64
+	StatusCodes map[int]int
+	Sketch      *Sketch // latencies of successful requests
+
+	// NewConns counts the connections opened, ConnTotal and DNSTotal sum
+	// the time they took to set up and to resolve their host.
+	NewConns  int
+	ConnTotal float64
+	DNSTotal  float64
+}
+
+// AvgConn returns the average time new connections took to set up,
+// including DNS lookups and TLS handshakes.
+func (t *TargetStats) AvgConn() float64 {
+	if t.NewConns == 0 {
+		return 0
+	}
+	return t.ConnTotal / float64(t.NewConns)
+}
+
+// AvgDNS returns the average time the DNS lookups of new connections
+// took.
+func (t *TargetStats) AvgDNS() float64 {
+	if t.NewConns == 0 {
+		return 0
+	}
+	return t.DNSTotal / float64(t.NewConns)
+}
+
+// Average returns the average latency of the successful requests.
+func (t *TargetStats) Average() float64 {
+	if t.Sketch.Count == 0 {
+		return 0
+	}
+	return t.Total / float64(t.Sketch.Count)
+}
+
+// Percentile returns the p-th percentile latency of the successful
+// requests.
+func (t *TargetStats) Percentile(p float64) float64 {
+	return t.Sketch.Quantile(p / 100)
+}
+
+func (t *TargetStats) merge(o *TargetStats) {
+	t.Requests += o.Requests
+	t.NewConns += o.NewConns
+	t.ConnTotal += o.ConnTotal
+	t.DNSTotal += o.DNSTotal
+	t.Errors += o.Errors
+	t.Total += o.Total
+	t.Slowest = max(t.Slowest, o.Slowest)
+	t.Rps += o.Rps
+	for code, n := range o.StatusCodes {
+		t.StatusCodes[code] += n
+	}
+	t.Sketch.Merge(o.Sketch)
+}
+
+func newTargetStats() *TargetStats {
+	return &TargetStats{
+		StatusCodes: make(map[int]int),
+		Sketch:      NewSketch(DefaultSketchAccuracy),
+	}
+}
+
+// targetStats tracks requests by target.
+type targetStats map[string]*TargetStats
+
+func (ts targetStats) add(res *result) {
+	if res.target == "" {
+		return
+	}
+	t := ts.get(res.target)
+	t.Requests++
+	if res.newConn {
+		t.NewConns++
+		t.ConnTotal += res.connDuration.Seconds()
+		t.DNSTotal += res.dnsDuration.Seconds()
+	}
+	if res.err != nil || res.aborted != "" {
+		t.Errors++
+		return
+	}
+	d := res.duration.Seconds()
+	t.Total += d
+	t.Slowest = max(t.Slowest, d)
+	t.StatusCodes[res.statusCode]++
+	t.Sketch.Add(d)
+}
+
+func (ts targetStats) get(target string) *TargetStats {
+	if _, ok := ts[target]; !ok && len(ts) >= maxTargets {
+		target = otherTarget
+	}
+	t := ts[target]
+	if t == nil {
+		t = newTargetStats()
+		ts[target] = t
+	}
+	return t
+}
+
+// mergeTargets adds the targets tracked in b to a.
+func mergeTargets(a, b map[string]*TargetStats) map[string]*TargetStats {
+	if len(b) == 0 {
+		return a
+	}
+	ts := targetStats(a)
+	if ts == nil {
+		ts = make(targetStats)
+	}
+	for target, t := range b {
+		ts.get(target).merge(t)
+	}
+	return ts
+}
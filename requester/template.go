@@ -0,0 +1,188 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"text/template"
+)
+
+// workerSeqBlock is the size of the block of numbers each worker's
+// workerseq sequences are drawn from, so that workers do not collide.
+const workerSeqBlock = 1000000
+
+// ParseBodyTemplate parses a request body template, a text/template
+// executed for every request. Besides the builtin functions, it can use:
+//
+//   - fake, whose methods generate plausible data such as {{fake.Email}},
+//     see Faker.
+//   - seq, which returns the next number of a counter shared by all
+//     workers, from 1 or the given start, as {{seq 1000}} does.
+//   - workerseq, which returns the next number of a sequence of the
+//     worker, from 1 or the given start, offset by workerSeqBlock per
+//     worker so that the sequences of workers are disjoint.
+//
+// Counters are distinct per start.
+func ParseBodyTemplate(text string) (*template.Template, error) {
+	w := &templateWorker{b: &Work{}, rnd: rand.New(rand.NewSource(1)), worker: 1}
+	tmpl, err := template.New("body").Funcs(w.funcs()).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	// Catch execution errors, such as calls of unknown methods, up front.
+	// Templates cannot be cloned once executed, so a clone is probed.
+	probe, err := tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	if err := probe.Execute(&bytes.Buffer{}, nil); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// templateWorker holds the state of the body template of a worker.
+type templateWorker struct {
+	b      *Work
+	rnd    *rand.Rand
+	worker int
+	seqs   map[int64]int64 // next of the worker's sequences, by start
+}
+
+func (w *templateWorker) funcs() template.FuncMap {
+	return template.FuncMap{
+		"fake":      w.fake,
+		"seq":       w.seq,
+		"workerseq": w.workerSeq,
+	}
+}
+
+func (w *templateWorker) fake() Faker {
+	return Faker{w.rnd}
+}
+
+func (w *templateWorker) seq(start ...int64) (int64, error) {
+	from, err := seqStart(start)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := w.b.seqs.LoadOrStore(from, new(int64))
+	return from + atomic.AddInt64(n.(*int64), 1) - 1, nil
+}
+
+func (w *templateWorker) workerSeq(start ...int64) (int64, error) {
+	from, err := seqStart(start)
+	if err != nil {
+		return 0, err
+	}
+	if w.seqs == nil {
+		w.seqs = make(map[int64]int64)
+	}
+	i := w.seqs[from]
+	w.seqs[from]++
+	return from + int64(w.worker-1)*workerSeqBlock + i, nil
+}
+
+func seqStart(start []int64) (int64, error) {
+	switch len(start) {
+	case 0:
+		return 1, nil
+	case 1:
+		return start[0], nil
+	}
+	return 0, fmt.Errorf("sequences take at most one start, got %d", len(start))
+}
+
+// workerTemplate returns the BodyTemplate of a worker, or nil without a
+// BodyTemplate.
+func (b *Work) workerTemplate(rnd *rand.Rand, worker int) *template.Template {
+	if b.BodyTemplate == nil {
+		return nil
+	}
+	tmpl, err := b.BodyTemplate.Clone()
+	if err != nil {
+		// BodyTemplate is never executed, so it can always be cloned.
+		panic(err)
+	}
+	w := &templateWorker{b: b, rnd: rnd, worker: worker}
+	return tmpl.Funcs(w.funcs())
+}
+
+var (
+	fakeFirstNames = []string{"James", "Mary", "Robert", "Patricia", "John", "Jennifer", "Michael", "Linda", "David", "Elizabeth", "Wei", "Aisha", "Carlos", "Yuki", "Olga", "Thabo"}
+	fakeLastNames  = []string{"Smith", "Johnson", "Williams", "Brown", "Jones", "Garcia", "Miller", "Davis", "Martinez", "Nguyen", "Chen", "Okafor", "Kowalski", "Tanaka", "Ivanova", "Dlamini"}
+	// Domains reserved for examples, so no real mailbox is targeted.
+	fakeDomains = []string{"example.com", "example.org", "example.net"}
+)
+
+// Faker generates plausible random data for body templates.
+type Faker struct {
+	rnd *rand.Rand
+}
+
+// FirstName returns a first name.
+func (f Faker) FirstName() string {
+	return fakeFirstNames[f.rnd.Intn(len(fakeFirstNames))]
+}
+
+// LastName returns a last name.
+func (f Faker) LastName() string {
+	return fakeLastNames[f.rnd.Intn(len(fakeLastNames))]
+}
+
+// Name returns a full name.
+func (f Faker) Name() string {
+	return f.FirstName() + " " + f.LastName()
+}
+
+// Email returns an email address at a domain reserved for examples.
+func (f Faker) Email() string {
+	return fmt.Sprintf("%s.%s%d@%s", f.FirstName(), f.LastName(), f.rnd.Intn(1000), fakeDomains[f.rnd.Intn(len(fakeDomains))])
+}
+
+// IPv4 returns a unicast IPv4 address.
+func (f Faker) IPv4() string {
+	return fmt.Sprintf("%d.%d.%d.%d", 1+f.rnd.Intn(223), f.rnd.Intn(256), f.rnd.Intn(256), 1+f.rnd.Intn(254))
+}
+
+// CreditCard returns a 16-digit card number starting with 4, as Visa
+// numbers do, with a valid Luhn check digit.
+func (f Faker) CreditCard() string {
+	digits := make([]byte, 16)
+	digits[0] = 4
+	for i := 1; i < 15; i++ {
+		digits[i] = byte(f.rnd.Intn(10))
+	}
+	// Double every second digit from the right, starting left of the
+	// check digit.
+	sum := 0
+	for i := 14; i >= 0; i-- {
+		d := int(digits[i])
+		if (15-i)%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	digits[15] = byte((10 - sum%10) % 10)
+	for i := range digits {
+		digits[i] += '0'
+	}
+	return string(digits)
+}
@@ -0,0 +1,128 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var distRegexp = regexp.MustCompile(`^\s*(\w+)\s*\(([^)]*)\)\s*$`)
+
+// Distribution draws random durations, e.g. think times.
+type Distribution interface {
+	Sample(rnd *rand.Rand) time.Duration
+	String() string
+}
+
+// ParseDistribution parses a distribution of durations written as a
+// function of its parameters:
+//
+//	constant(d)              always d
+//	uniform(min,max)         uniformly between min and max
+//	exponential(mean)        exponentially with the given mean
+//	normal(mean,stddev)      normally, negative samples read as 0
+//	lognormal(median,sigma)  log-normally; sigma is the standard deviation
+//	                         of the logarithm, e.g. 0.5 for a heavy tail
+//
+// Durations are in time.ParseDuration format.
+func ParseDistribution(s string) (Distribution, error) {
+	m := distRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("could not parse the provided distribution; input = %v", s)
+	}
+	args := strings.Split(m[2], ",")
+	for i := range args {
+		args[i] = strings.TrimSpace(args[i])
+	}
+	want := map[string]int{"constant": 1, "uniform": 2, "exponential": 1, "normal": 2, "lognormal": 2}
+	n, ok := want[m[1]]
+	if !ok {
+		return nil, fmt.Errorf("unknown distribution %q; want constant, uniform, exponential, normal or lognormal", m[1])
+	}
+	if len(args) != n {
+		return nil, fmt.Errorf("%s takes %d parameters; input = %v", m[1], n, s)
+	}
+	durs := make([]time.Duration, n)
+	for i, a := range args {
+		if m[1] == "lognormal" && i == 1 {
+			break
+		}
+		d, err := time.ParseDuration(a)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid duration %q in %v", a, s)
+		}
+		durs[i] = d
+	}
+	switch m[1] {
+	case "constant":
+		return constantDist{durs[0]}, nil
+	case "uniform":
+		if durs[1] < durs[0] {
+			return nil, fmt.Errorf("uniform maximum is less than its minimum; input = %v", s)
+		}
+		return uniformDist{durs[0], durs[1]}, nil
+	case "exponential":
+		return expDist{durs[0]}, nil
+	case "normal":
+		return normalDist{durs[0], durs[1]}, nil
+	}
+	sigma, err := strconv.ParseFloat(args[1], 64)
+	if err != nil || sigma < 0 {
+		return nil, fmt.Errorf("invalid lognormal sigma %q; input = %v", args[1], s)
+	}
+	return lognormalDist{durs[0], sigma}, nil
+}
+
+type constantDist struct{ d time.Duration }
+
+func (c constantDist) Sample(*rand.Rand) time.Duration { return c.d }
+func (c constantDist) String() string                  { return fmt.Sprintf("constant(%v)", c.d) }
+
+type uniformDist struct{ min, max time.Duration }
+
+func (u uniformDist) Sample(rnd *rand.Rand) time.Duration {
+	return u.min + time.Duration(rnd.Float64()*float64(u.max-u.min))
+}
+func (u uniformDist) String() string { return fmt.Sprintf("uniform(%v,%v)", u.min, u.max) }
+
+type expDist struct{ mean time.Duration }
+
+func (e expDist) Sample(rnd *rand.Rand) time.Duration {
+	return time.Duration(rnd.ExpFloat64() * float64(e.mean))
+}
+func (e expDist) String() string { return fmt.Sprintf("exponential(%v)", e.mean) }
+
+type normalDist struct{ mean, stddev time.Duration }
+
+func (n normalDist) Sample(rnd *rand.Rand) time.Duration {
+	return max(time.Duration(rnd.NormFloat64()*float64(n.stddev))+n.mean, 0)
+}
+func (n normalDist) String() string { return fmt.Sprintf("normal(%v,%v)", n.mean, n.stddev) }
+
+type lognormalDist struct {
+	median time.Duration
+	sigma  float64
+}
+
+func (l lognormalDist) Sample(rnd *rand.Rand) time.Duration {
+	return time.Duration(float64(l.median) * math.Exp(rnd.NormFloat64()*l.sigma))
+}
+func (l lognormalDist) String() string { return fmt.Sprintf("lognormal(%v,%v)", l.median, l.sigma) }
@@ -0,0 +1,56 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import "fmt"
+
+// Units latencies can be printed in, see Work.TimeUnit.
+const (
+	TimeUnitSeconds = "s"
+	TimeUnitMillis  = "ms"
+	TimeUnitMicros  = "us"
+)
+
+// timeUnit is a unit latencies are printed in, with the number of
+// decimals that keeps them readable.
+type timeUnit struct {
+	scale  float64 // units per second
+	suffix string
+	prec   int
+}
+
+var timeUnits = map[string]timeUnit{
+	TimeUnitSeconds: {1, "secs", 4},
+	TimeUnitMillis:  {1e3, "ms", 3},
+	TimeUnitMicros:  {1e6, "us", 1},
+}
+
+// timeUnitOf returns the named unit, seconds if it is unknown.
+func timeUnitOf(name string) timeUnit {
+	if u, ok := timeUnits[name]; ok {
+		return u
+	}
+	return timeUnits[TimeUnitSeconds]
+}
+
+// value formats secs in the unit, without the unit.
+func (u timeUnit) value(secs float64) string {
+	return fmt.Sprintf("%4.*f", u.prec, secs*u.scale)
+}
+
+// format formats secs in the unit, followed by the unit.
+func (u timeUnit) format(secs float64) string {
+	return u.value(secs) + " " + u.suffix
+}
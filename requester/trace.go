@@ -0,0 +1,146 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// traceEvent is an event of the Chrome trace event format, which is also
+// read by Perfetto. Times are in microseconds.
+type traceEvent struct {
+	Name string                 `json:"name"`
+	Cat  string                 `json:"cat,omitempty"`
+	Ph   string                 `json:"ph"`
+	Ts   float64                `json:"ts"`
+	Dur  float64                `json:"dur,omitempty"`
+	Pid  int                    `json:"pid"`
+	Tid  int                    `json:"tid"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+// traceWriter streams records as trace events, each request a span on
+// the track of its worker, with its phases nested in it. The events are
+// a JSON array, which trace viewers also read if it is not closed, e.g.
+// if the run was killed.
+type traceWriter struct {
+	w       io.Writer
+	workers map[int]bool // workers whose tracks were named
+	n       int          // events written
+	err     error
+}
+
+func newTraceWriter(w io.Writer) *traceWriter {
+	t := &traceWriter{w: w, workers: make(map[int]bool)}
+	t.event(traceEvent{Name: "process_name", Ph: "M", Args: map[string]interface{}{"name": "hey"}})
+	return t
+}
+
+func (t *traceWriter) event(e traceEvent) {
+	if t.err != nil {
+		return
+	}
+	sep := ",\n"
+	if t.n == 0 {
+		sep = "[\n"
+	}
+	d, err := json.Marshal(e)
+	if err != nil {
+		t.err = err
+		return
+	}
+	_, t.err = io.WriteString(t.w, sep+string(d))
+	t.n++
+}
+
+// write writes the events of rec, marks as instant events across all
+// tracks. Records of connections are skipped.
+func (t *traceWriter) write(rec Record) {
+	const us = 1e6
+	if rec.Connection != nil {
+		return
+	}
+	if rec.Mark != "" {
+		t.event(traceEvent{Name: rec.Mark, Cat: "mark", Ph: "i", Ts: rec.Offset * us, Args: map[string]interface{}{"s": "g"}})
+		return
+	}
+	if !t.workers[rec.Worker] {
+		t.workers[rec.Worker] = true
+		name := "worker " + strconv.Itoa(rec.Worker)
+		if rec.Worker == 0 {
+			// Records of older versions have no worker.
+			name = "requests"
+		}
+		t.event(traceEvent{Name: "thread_name", Ph: "M", Tid: rec.Worker, Args: map[string]interface{}{"name": name}})
+	}
+	name := strconv.Itoa(rec.Status)
+	args := map[string]interface{}{"status": rec.Status, "offset": rec.Offset}
+	if rec.Error != "" {
+		name = "error"
+		args["error"] = rec.Error
+	}
+	if rec.URL != "" {
+		args["url"] = rec.URL
+	}
+	if rec.NewConn {
+		args["new_conn"] = true
+	}
+	if rec.ConnID != 0 {
+		args["conn_id"] = rec.ConnID
+	}
+	if rec.Iteration != 0 {
+		args["iteration"] = rec.Iteration
+	}
+	if rec.TraceID != "" {
+		args["trace_id"] = rec.TraceID
+	}
+	ts := rec.Offset * us
+	t.event(traceEvent{Name: name, Cat: "request", Ph: "X", Ts: ts, Dur: rec.Duration * us, Tid: rec.Worker, Args: args})
+	for _, p := range []struct {
+		name string
+		d    float64
+	}{{PhaseConn, rec.Conn}, {PhaseReq, rec.ReqWrite}, {PhaseDelay, rec.Delay}, {PhaseRes, rec.ResRead}} {
+		if p.d <= 0 {
+			continue
+		}
+		t.event(traceEvent{Name: p.name, Cat: "phase", Ph: "X", Ts: ts, Dur: p.d * us, Tid: rec.Worker})
+		ts += p.d * us
+	}
+}
+
+// close ends the array of events.
+func (t *traceWriter) close() error {
+	if t.err == nil {
+		_, t.err = io.WriteString(t.w, "\n]\n")
+	}
+	if t.err != nil {
+		return fmt.Errorf("writing trace: %v", t.err)
+	}
+	return nil
+}
+
+// WriteTrace writes records as a Chrome trace event file, which Perfetto
+// and chrome://tracing open, each request a span on the track of the
+// worker that sent it, with its phases nested in it.
+func WriteTrace(w io.Writer, records []Record) error {
+	t := newTraceWriter(w)
+	for _, rec := range records {
+		t.write(rec)
+	}
+	return t.close()
+}
@@ -0,0 +1,78 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// maxTrailerValues caps the distinct values counted per trailer, so that
+// values unique to every response, like checksums, do not grow the
+// report without bound. Further values are counted as otherTrailerValue.
+const maxTrailerValues = 10
+
+const otherTrailerValue = "(other)"
+
+// withTrailer makes req send trailer after its body, which requires the
+// body to be sent chunked.
+func withTrailer(req *http.Request, trailer http.Header) {
+	req.Trailer = trailer.Clone()
+	req.TransferEncoding = []string{"chunked"}
+	req.ContentLength = -1
+	if req.Body == nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(nil))
+	}
+}
+
+// trailerStats counts response trailers by name and value.
+type trailerStats map[string]map[string]int
+
+func (t trailerStats) add(trailer http.Header) {
+	for name, values := range trailer {
+		t.count(name, strings.Join(values, ", "), 1)
+	}
+}
+
+func (t trailerStats) count(name, value string, n int) {
+	dist := t[name]
+	if dist == nil {
+		dist = make(map[string]int)
+		t[name] = dist
+	}
+	if _, ok := dist[value]; !ok && len(dist) >= maxTrailerValues {
+		value = otherTrailerValue
+	}
+	dist[value] += n
+}
+
+// mergeTrailers adds the trailers counted in b to a.
+func mergeTrailers(a, b map[string]map[string]int) map[string]map[string]int {
+	if len(b) == 0 {
+		return a
+	}
+	t := trailerStats(a)
+	if t == nil {
+		t = make(trailerStats)
+	}
+	for name, dist := range b {
+		for value, n := range dist {
+			t.count(name, value, n)
+		}
+	}
+	return t
+}
@@ -0,0 +1,120 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// Defaults of NewWork, those of the hey command.
+const (
+	DefaultRequests    = 200
+	DefaultConcurrency = 50
+	DefaultTimeout     = 20
+)
+
+// Option configures the Work returned by NewWork.
+type Option func(*Work)
+
+// WithRequests sets the number of requests to make, Work.N.
+func WithRequests(n int) Option {
+	return func(b *Work) { b.N = n }
+}
+
+// WithConcurrency sets the number of workers, Work.C.
+func WithConcurrency(c int) Option {
+	return func(b *Work) { b.C = c }
+}
+
+// WithQPS sets the rate limit per worker, Work.QPS.
+func WithQPS(qps float64) Option {
+	return func(b *Work) { b.QPS = qps }
+}
+
+// WithTimeout sets the timeout of requests in seconds, Work.Timeout; 0
+// means no timeout.
+func WithTimeout(seconds int) Option {
+	return func(b *Work) { b.Timeout = seconds }
+}
+
+// WithBody sets the body sent with every request, Work.RequestBody.
+func WithBody(body []byte) Option {
+	return func(b *Work) { b.RequestBody = body }
+}
+
+// WithH2 makes HTTP/2 requests, Work.H2.
+func WithH2() Option {
+	return func(b *Work) { b.H2 = true }
+}
+
+// WithOutput prints the summary to w in the given output format, as
+// Work.Writer and Work.Output. Without it, RunContext prints nothing.
+func WithOutput(w io.Writer, output string) Option {
+	return func(b *Work) {
+		b.Writer = w
+		b.Output = output
+	}
+}
+
+// NewWork returns the Work of sending req with the defaults of the hey
+// command, DefaultRequests requests by DefaultConcurrency workers with a
+// timeout of DefaultTimeout seconds, changed by opts. Fields without an
+// Option can be set on the Work returned before it is run.
+func NewWork(req *http.Request, opts ...Option) (*Work, error) {
+	if req == nil {
+		return nil, errors.New("requester: nil request")
+	}
+	b := &Work{
+		Request: req,
+		N:       DefaultRequests,
+		C:       DefaultConcurrency,
+		Timeout: DefaultTimeout,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	switch {
+	case b.C <= 0:
+		return nil, errors.New("requester: concurrency must be positive")
+	case b.N < b.C:
+		return nil, errors.New("requester: number of requests cannot be less than concurrency")
+	case b.QPS < 0:
+		return nil, errors.New("requester: QPS cannot be negative")
+	case b.Timeout < 0:
+		return nil, errors.New("requester: timeout cannot be negative")
+	}
+	return b, nil
+}
+
+// RunContext makes the requests like Run and returns the report of the
+// run, for programs embedding the load generator. Unlike Run, it prints
+// the summary only if Writer is set. The run is stopped if ctx is done,
+// cancelling in-flight requests as Stop does, in which case the report of
+// the requests made is returned along with the error of ctx. A Work can be
+// run only once.
+func (b *Work) RunContext(ctx context.Context) (*Report, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	b.quiet = b.Writer == nil
+	stop := context.AfterFunc(ctx, b.Stop)
+	defer stop()
+	b.Run()
+	rep := b.report.snapshot()
+	return &rep, ctx.Err()
+}
@@ -0,0 +1,148 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// secretRef matches the references to secrets in the files hey reads
+// headers and credentials from: ${env:NAME}, ${file:path} and
+// ${vault:path#field}.
+var secretRef = regexp.MustCompile(`\$\{((?:env|file|vault):[^}]*)\}`)
+
+// defaultVaultAddr is the address of Vault if VAULT_ADDR is not set, as
+// for the vault command.
+const defaultVaultAddr = "https://127.0.0.1:8200"
+
+// secrets resolves references to secrets, each once.
+type secrets struct {
+	resolved map[string]string
+	client   *http.Client
+}
+
+func newSecrets() *secrets {
+	return &secrets{resolved: make(map[string]string), client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// expand replaces the references to secrets in v with the secrets.
+func (s *secrets) expand(v string) (string, error) {
+	var err error
+	v = secretRef.ReplaceAllStringFunc(v, func(ref string) string {
+		if err != nil {
+			return ""
+		}
+		var secret string
+		secret, err = s.resolve(ref[2 : len(ref)-1])
+		return secret
+	})
+	return v, err
+}
+
+// resolve returns the secret uri refers to. Errors name the reference,
+// never the secret.
+func (s *secrets) resolve(uri string) (string, error) {
+	if secret, ok := s.resolved[uri]; ok {
+		return secret, nil
+	}
+	scheme, ref, _ := strings.Cut(uri, ":")
+	var secret string
+	switch scheme {
+	case "env":
+		v, ok := os.LookupEnv(ref)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s of ${%s} is not set", ref, uri)
+		}
+		secret = v
+	case "file":
+		data, err := os.ReadFile(ref)
+		if err != nil {
+			return "", fmt.Errorf("${%s}: %v", uri, err)
+		}
+		secret = strings.TrimRight(string(data), "\r\n")
+	case "vault":
+		v, err := s.vault(ref)
+		if err != nil {
+			return "", fmt.Errorf("${%s}: %v", uri, err)
+		}
+		secret = v
+	}
+	s.resolved[uri] = secret
+	return secret, nil
+}
+
+// vault reads the field of a secret of HashiCorp Vault, referred to as
+// path#field, e.g. secret/data/api#token. The address of Vault and the
+// token to read with are taken from VAULT_ADDR and VAULT_TOKEN, or
+// ~/.vault-token, as for the vault command. Secrets of KV version 2
+// engines are read from their nested data.
+func (s *secrets) vault(ref string) (string, error) {
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || field == "" {
+		return "", fmt.Errorf("not a path#field reference")
+	}
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		addr = defaultVaultAddr
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			data, _ := os.ReadFile(filepath.Join(home, ".vault-token"))
+			token = strings.TrimSpace(string(data))
+		}
+	}
+	if token == "" {
+		return "", fmt.Errorf("no Vault token; set VAULT_TOKEN or log in with vault login")
+	}
+	req, err := http.NewRequest("GET", strings.TrimRight(addr, "/")+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault responded %s", resp.Status)
+	}
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	data := body.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+	v, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("no field %s", field)
+	}
+	return v, nil
+}
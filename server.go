@@ -0,0 +1,555 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rakyll/hey/requester"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+var serverUsage = `Usage: hey server [options...]
+
+Serves an HTTP API to start, monitor and stop load tests, and to fetch
+their reports. One run is active at a time, and the last runs are kept.
+
+Endpoints:
+  POST /runs               Starts a run configured by the JSON body, e.g.
+                           {"url": "http://host/", "n": 1000, "c": 10}.
+  GET  /runs               Lists runs.
+  GET  /runs/<id>          Returns a run, with live statistics.
+  DELETE /runs/<id>        Deletes a finished run and its results.
+  POST /runs/<id>/stop     Stops a run.
+  POST /runs/<id>/mark     Marks the results of a running run, e.g. when
+                           a deploy starts, with the text of the JSON
+                           body, e.g. {"text": "deploy v2"}.
+  GET  /runs/<id>/report   Returns the report of a finished run. The o
+                           query parameter selects the output type, one
+                           of "csv", "html", "png", "series", "ndjson"
+                           or "trace".
+
+The same address serves the Control service of api/control.proto over
+HTTP/2, with or without TLS, for gRPC clients: StartRun, StopRun, and
+StreamStats to stream the live statistics of a run.
+
+Request bodies must be sent as application/json, and requests that
+change runs are refused if they come from web pages of other origins.
+
+Run configurations have the fields url, method, headers, body, n, c, q,
+z (a duration, e.g. "30s"), t and percentiles, with the defaults of
+"hey run".
+
+Options:
+  -addr   Address to listen on. Default is "localhost:8080". Addresses
+          other than loopback ones require -token.
+  -token  Token clients must send as "Authorization: Bearer <token>", the
+          authorization metadata of gRPC calls.
+          It may be a reference to a secret, e.g. ${env:HEY_TOKEN}.
+  -keep   Number of runs to keep; older finished runs are deleted.
+          Default is 100.
+`
+
+// maxServerRecords is the number of raw results kept per run to
+// generate its report from.
+const maxServerRecords = 1000000
+
+// defaultKeepRuns is the number of runs a server keeps by default.
+const defaultKeepRuns = 100
+
+func serverMain(args []string) {
+	fs := newCommandFlags("server", serverUsage)
+	addr := fs.String("addr", "localhost:8080", "")
+	token := fs.String("token", "", "")
+	keep := fs.Int("keep", defaultKeepRuns, "")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		usageAndExit("")
+	}
+	if *keep < 1 {
+		usageAndExit("-keep cannot be smaller than 1.")
+	}
+	s := newServer()
+	s.keep = *keep
+	if *token != "" {
+		var err error
+		if s.token, err = newSecrets().expand(*token); err != nil {
+			errAndExit(err.Error())
+		}
+	}
+	if s.token == "" && !isLoopback(*addr) {
+		usageAndExit(fmt.Sprintf("-addr %s is not a loopback address; set -token to serve the API on it.", *addr))
+	}
+	log.Printf("Serving the hey API on %s", *addr)
+	// h2c serves HTTP/2 without TLS, for gRPC clients.
+	if err := http.ListenAndServe(*addr, h2c.NewHandler(s, &http2.Server{})); err != nil {
+		errAndExit(err.Error())
+	}
+}
+
+// isLoopback reports whether addr only listens on loopback interfaces.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// runConfig configures a run started through the API, like the options
+// of the same names of "hey run".
+type runConfig struct {
+	URL         string            `json:"url"`
+	Method      string            `json:"method,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Body        string            `json:"body,omitempty"`
+	N           int               `json:"n,omitempty"`
+	C           int               `json:"c,omitempty"`
+	Q           float64           `json:"q,omitempty"`
+	Z           string            `json:"z,omitempty"`
+	T           int               `json:"t,omitempty"`
+	Percentiles []float64         `json:"percentiles,omitempty"`
+}
+
+// work returns the load test configured by c, and its duration if any.
+func (c *runConfig) work() (*requester.Work, time.Duration, error) {
+	if c.Method == "" {
+		c.Method = "GET"
+	}
+	if c.N == 0 {
+		c.N = 200
+	}
+	if c.C == 0 {
+		c.C = 50
+	}
+	if c.T == 0 {
+		c.T = 20
+	}
+	var dur time.Duration
+	if c.Z != "" {
+		var err error
+		if dur, err = time.ParseDuration(c.Z); err != nil {
+			return nil, 0, err
+		}
+	}
+	num := c.N
+	if dur > 0 {
+		num = math.MaxInt32
+	} else if c.N < c.C {
+		return nil, 0, errors.New("n cannot be less than c")
+	}
+	if c.C < 1 {
+		return nil, 0, errors.New("c cannot be smaller than 1")
+	}
+	for _, p := range c.Percentiles {
+		if p <= 0 || p >= 100 {
+			return nil, 0, fmt.Errorf("percentile %v out of range", p)
+		}
+	}
+	sort.Float64s(c.Percentiles)
+
+	req, err := http.NewRequest(strings.ToUpper(c.Method), c.URL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, 0, fmt.Errorf("unsupported URL %q", c.URL)
+	}
+	for k, v := range c.Headers {
+		req.Header.Set(k, v)
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", heyUA)
+	}
+	req.ContentLength = int64(len(c.Body))
+	return &requester.Work{
+		Request:     req,
+		RequestBody: []byte(c.Body),
+		N:           num,
+		C:           c.C,
+		QPS:         c.Q,
+		Timeout:     c.T,
+		Percentiles: c.Percentiles,
+		Writer:      io.Discard,
+	}, dur, nil
+}
+
+// serverRun is a run started through the API.
+type serverRun struct {
+	ID       string     `json:"id"`
+	Config   runConfig  `json:"config"`
+	State    string     `json:"state"` // "running" or "done"
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Stats    runStats   `json:"stats"`
+
+	mu      sync.Mutex
+	work    *requester.Work
+	records []requester.Record
+}
+
+// runStats are the live statistics of a run.
+type runStats struct {
+	Requests    int64         `json:"requests"`
+	Errors      int64         `json:"errors"`
+	StatusCodes map[int]int64 `json:"status_codes"`
+	Rps         float64       `json:"rps"`
+	Average     float64       `json:"average"`
+	Elapsed     float64       `json:"elapsed"`
+	total       float64       // sum of durations
+}
+
+// Write implements requester.Sink to collect the results of the run.
+func (r *serverRun) Write(rec requester.Record) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rec.Connection == nil && rec.Mark == "" {
+		r.Stats.Requests++
+		if rec.Error != "" {
+			r.Stats.Errors++
+		} else {
+			r.Stats.StatusCodes[rec.Status]++
+			r.Stats.total += rec.Duration
+		}
+	}
+	if len(r.records) < maxServerRecords {
+		r.records = append(r.records, rec)
+	}
+	return nil
+}
+
+func (r *serverRun) Close() error {
+	return nil
+}
+
+// snapshot returns a copy of the run to serve, with up to date stats.
+func (r *serverRun) snapshot() *serverRun {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := &serverRun{ID: r.ID, Config: r.Config, State: r.State, Started: r.Started.UTC(), Stats: r.Stats}
+	if r.Finished != nil {
+		finished := r.Finished.UTC()
+		s.Finished = &finished
+	}
+	s.Stats.StatusCodes = make(map[int]int64, len(r.Stats.StatusCodes))
+	for code, n := range r.Stats.StatusCodes {
+		s.Stats.StatusCodes[code] = n
+	}
+	end := time.Now()
+	if r.Finished != nil {
+		end = *r.Finished
+	}
+	s.Stats.Elapsed = end.Sub(r.Started).Seconds()
+	if s.Stats.Elapsed > 0 {
+		s.Stats.Rps = float64(r.Stats.Requests) / s.Stats.Elapsed
+	}
+	if ok := r.Stats.Requests - r.Stats.Errors; ok > 0 {
+		s.Stats.Average = r.Stats.total / float64(ok)
+	}
+	return s
+}
+
+type server struct {
+	token string // required bearer token, if set
+	keep  int    // number of runs kept
+
+	mu     sync.Mutex
+	runs   []*serverRun
+	lastID int
+	active *serverRun
+	mux    *http.ServeMux
+}
+
+func newServer() *server {
+	s := &server{keep: defaultKeepRuns, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/runs", s.handleRuns)
+	s.mux.HandleFunc("/runs/", s.handleRun)
+	return s
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+			return
+		}
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && crossOrigin(r) {
+		writeError(w, http.StatusForbidden, errors.New("cross-origin requests are not allowed"))
+		return
+	}
+	if isGRPC(r) {
+		s.serveGRPC(w, r)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// crossOrigin reports whether r was made by a web page of another origin,
+// which must not start runs from the machine of whoever visits it.
+func crossOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || u.Host != r.Host
+}
+
+// isJSON reports whether the body of r is declared to be JSON. Web pages
+// can only send other content types across origins without a preflight.
+func isJSON(r *http.Request) bool {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mt == "application/json"
+}
+
+func (s *server) handleRuns(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.Lock()
+		runs := make([]*serverRun, len(s.runs))
+		for i, run := range s.runs {
+			runs[i] = run.snapshot()
+		}
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, runs)
+	case http.MethodPost:
+		if !isJSON(r) {
+			writeError(w, http.StatusUnsupportedMediaType, errors.New("want an application/json body"))
+			return
+		}
+		var c runConfig
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		run, err := s.start(c)
+		if err != nil {
+			code := http.StatusBadRequest
+			if errors.Is(err, errRunActive) {
+				code = http.StatusConflict
+			}
+			writeError(w, code, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, run.snapshot())
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+func (s *server) handleRun(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/runs/"), "/")
+	run := s.run(id)
+	if run == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no run %q", id))
+		return
+	}
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, run.snapshot())
+	case action == "" && r.Method == http.MethodDelete:
+		if err := s.delete(run); err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case action == "stop" && r.Method == http.MethodPost:
+		run.work.Stop()
+		writeJSON(w, http.StatusOK, run.snapshot())
+	case action == "mark" && r.Method == http.MethodPost:
+		if !isJSON(r) {
+			writeError(w, http.StatusUnsupportedMediaType, errors.New("want an application/json body"))
+			return
+		}
+		var m struct {
+			Text string `json:"text"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil || strings.TrimSpace(m.Text) == "" {
+			writeError(w, http.StatusBadRequest, errors.New(`want a JSON body with a "text" field`))
+			return
+		}
+		if err := run.work.Mark(m.Text); err != nil {
+			writeError(w, http.StatusConflict, fmt.Errorf("run %s is not running", run.ID))
+			return
+		}
+		writeJSON(w, http.StatusOK, run.snapshot())
+	case action == "report" && r.Method == http.MethodGet:
+		s.report(w, r, run)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown endpoint %s %s", r.Method, r.URL.Path))
+	}
+}
+
+func (s *server) report(w http.ResponseWriter, r *http.Request, run *serverRun) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if run.State != "done" {
+		writeError(w, http.StatusConflict, fmt.Errorf("run %s is still running", run.ID))
+		return
+	}
+	output := r.URL.Query().Get("o")
+	var buf bytes.Buffer
+	switch output {
+	case "", "csv", "series", "html", "png":
+		rep := requester.ReportFromRecords(run.records, run.Config.Percentiles)
+		if err := requester.PrintReport(&buf, rep, output); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	case "ndjson":
+		enc := json.NewEncoder(&buf)
+		enc.Encode(requester.NewRecordsHeader())
+		for _, rec := range run.records {
+			enc.Encode(rec)
+		}
+	case "trace":
+		if err := requester.WriteTrace(&buf, run.records); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unsupported output %q", output))
+		return
+	}
+	contentType := map[string]string{
+		"":       "text/plain; charset=utf-8",
+		"csv":    "text/csv; charset=utf-8",
+		"series": "text/csv; charset=utf-8",
+		"html":   "text/html; charset=utf-8",
+		"png":    "image/png",
+		"ndjson": "application/x-ndjson",
+		"trace":  "application/json",
+	}[output]
+	w.Header().Set("Content-Type", contentType)
+	w.Write(buf.Bytes())
+}
+
+var errRunActive = errors.New("a run is already active")
+
+// start starts a run configured by c, unless one is already active.
+func (s *server) start(c runConfig) (*serverRun, error) {
+	work, dur, err := c.work()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active != nil {
+		return nil, fmt.Errorf("%w: %s", errRunActive, s.active.ID)
+	}
+	s.lastID++
+	run := &serverRun{
+		ID:      strconv.Itoa(s.lastID),
+		Config:  c,
+		State:   "running",
+		Started: time.Now(),
+		Stats:   runStats{StatusCodes: make(map[int]int64)},
+		work:    work,
+	}
+	work.Sinks = []requester.Sink{run}
+	work.Init()
+	s.runs = append(s.runs, run)
+	if n := len(s.runs) - s.keep; n > 0 {
+		// Only the new run is active, so the oldest are finished.
+		s.runs = append([]*serverRun(nil), s.runs[n:]...)
+	}
+	s.active = run
+
+	var timer *time.Timer
+	if dur > 0 {
+		timer = time.AfterFunc(dur, work.Stop)
+	}
+	go func() {
+		work.Run()
+		if timer != nil {
+			timer.Stop()
+		}
+		s.mu.Lock()
+		run.mu.Lock()
+		finished := time.Now()
+		run.State, run.Finished = "done", &finished
+		s.active = nil
+		run.mu.Unlock()
+		s.mu.Unlock()
+	}()
+	return run, nil
+}
+
+// delete deletes run, unless it is still running.
+func (s *server) delete(run *serverRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active == run {
+		return fmt.Errorf("run %s is still running", run.ID)
+	}
+	for i, r := range s.runs {
+		if r == run {
+			s.runs = append(s.runs[:i:i], s.runs[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (s *server) run(id string) *serverRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, run := range s.runs {
+		if run.ID == id {
+			return run
+		}
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
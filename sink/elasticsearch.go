@@ -0,0 +1,131 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rakyll/hey/requester"
+)
+
+const (
+	esBatchSize = 500
+	esLinger    = time.Second
+	esTimeout   = 30 * time.Second
+)
+
+var esDatePattern = regexp.MustCompile(`\{([^}]+)\}`)
+
+// Elasticsearch bulk-indexes records as documents into Elasticsearch or
+// OpenSearch. Every document is a requester.Record with an added
+// "@timestamp" field holding the time its request was sent.
+type Elasticsearch struct {
+	url    string
+	index  string
+	client *http.Client
+	buf    bytes.Buffer
+	n      int
+	first  time.Time
+}
+
+// NewElasticsearch returns a sink indexing into the cluster at url. The
+// index name may contain a time layout in braces, formatted with the time
+// each request was sent, e.g. "hey-{2006.01.02}" for daily indices.
+// Credentials can be given in the URL.
+func NewElasticsearch(url, index string) *Elasticsearch {
+	return &Elasticsearch{
+		url:    strings.TrimSuffix(url, "/") + "/_bulk",
+		index:  index,
+		client: &http.Client{Timeout: esTimeout},
+	}
+}
+
+type esDocument struct {
+	Timestamp time.Time `json:"@timestamp"`
+	requester.Record
+}
+
+// Write implements requester.Sink.
+func (e *Elasticsearch) Write(rec requester.Record) error {
+	ts := rec.Time.UTC()
+	action := map[string]map[string]string{"index": {"_index": e.indexName(ts)}}
+	enc := json.NewEncoder(&e.buf)
+	if err := enc.Encode(action); err != nil {
+		return err
+	}
+	if err := enc.Encode(esDocument{Timestamp: ts, Record: rec}); err != nil {
+		return err
+	}
+	if e.n == 0 {
+		e.first = time.Now()
+	}
+	e.n++
+	if e.n >= esBatchSize || time.Since(e.first) >= esLinger {
+		return e.flush()
+	}
+	return nil
+}
+
+// Close implements requester.Sink.
+func (e *Elasticsearch) Close() error {
+	return e.flush()
+}
+
+func (e *Elasticsearch) indexName(ts time.Time) string {
+	return esDatePattern.ReplaceAllStringFunc(e.index, func(m string) string {
+		return ts.Format(m[1 : len(m)-1])
+	})
+}
+
+func (e *Elasticsearch) flush() error {
+	if e.n == 0 {
+		return nil
+	}
+	defer func() {
+		e.buf.Reset()
+		e.n = 0
+	}()
+	req, err := http.NewRequest("POST", e.url, bytes.NewReader(e.buf.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("elasticsearch: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("elasticsearch: bulk request failed with status %d: %s", resp.StatusCode, body)
+	}
+	var result struct {
+		Errors bool `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("elasticsearch: %v", err)
+	}
+	if result.Errors {
+		return fmt.Errorf("elasticsearch: some documents failed to index")
+	}
+	return nil
+}
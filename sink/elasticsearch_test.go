@@ -0,0 +1,61 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rakyll/hey/requester"
+)
+
+func TestElasticsearch(t *testing.T) {
+	var lines []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" {
+			t.Errorf("Expected a bulk request, found %v", r.URL.Path)
+		}
+		sc := bufio.NewScanner(r.Body)
+		for sc.Scan() {
+			lines = append(lines, sc.Text())
+		}
+		w.Write([]byte(`{"errors":false}`))
+	}))
+	defer server.Close()
+
+	es := NewElasticsearch(server.URL+"/", "hey-{2006.01.02}")
+	start := time.Date(2020, 5, 4, 23, 59, 59, 0, time.UTC)
+	es.Write(requester.Record{Offset: 0.5, Status: 200, Time: start.Add(500 * time.Millisecond)})
+	es.Write(requester.Record{Offset: 1.5, Status: 503, Time: start.Add(1500 * time.Millisecond)})
+	if err := es.Close(); err != nil {
+		t.Fatalf("Close errored: %v", err)
+	}
+	if len(lines) != 4 {
+		t.Fatalf("Expected 4 bulk lines, found %v", lines)
+	}
+	if !strings.Contains(lines[0], `"hey-2020.05.04"`) || !strings.Contains(lines[2], `"hey-2020.05.05"`) {
+		t.Errorf("Unexpected index names: %v, %v", lines[0], lines[2])
+	}
+	var doc map[string]interface{}
+	json.Unmarshal([]byte(lines[3]), &doc)
+	if doc["@timestamp"] != "2020-05-05T00:00:00.5Z" || doc["status"] != float64(503) {
+		t.Errorf("Unexpected document: %v", lines[3])
+	}
+}
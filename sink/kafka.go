@@ -0,0 +1,377 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sink provides requester.Sink implementations that forward the
+// raw record of every request to external systems.
+package sink
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/rakyll/hey/requester"
+)
+
+// Kafka API keys and the versions used. Produce v3 and Metadata v4 are the
+// oldest versions still supported by current brokers, and the first ones
+// using the v2 record batch format.
+const (
+	kafkaProduce         = 0
+	kafkaProduceVersion  = 3
+	kafkaMetadata        = 3
+	kafkaMetadataVersion = 4
+)
+
+const (
+	kafkaClientID  = "hey"
+	kafkaBatchSize = 500
+	kafkaLinger    = time.Second
+	kafkaTimeout   = 10 * time.Second
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// Kafka produces records as JSON messages to a Kafka topic. Records are
+// batched and sent with acks=1, spreading batches round-robin over the
+// topic's partitions.
+type Kafka struct {
+	topic      string
+	partitions []int32          // partition indexes
+	leaders    map[int32]string // partition index to leader address
+	conns      map[string]net.Conn
+	correlate  int32
+	next       int // next partition to send to
+	batch      [][]byte
+	batchStart time.Time
+}
+
+// NewKafka connects to the given bootstrap brokers, as host:port
+// addresses, and looks up the partitions of topic.
+func NewKafka(brokers []string, topic string) (*Kafka, error) {
+	k := &Kafka{topic: topic, conns: make(map[string]net.Conn)}
+	var err error
+	for _, b := range brokers {
+		if err = k.loadMetadata(b); err == nil {
+			return k, nil
+		}
+	}
+	if err == nil {
+		err = errors.New("no brokers given")
+	}
+	return nil, fmt.Errorf("kafka: %v", err)
+}
+
+// Write implements requester.Sink.
+func (k *Kafka) Write(rec requester.Record) error {
+	v, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if len(k.batch) == 0 {
+		k.batchStart = time.Now()
+	}
+	k.batch = append(k.batch, v)
+	if len(k.batch) >= kafkaBatchSize || time.Since(k.batchStart) >= kafkaLinger {
+		return k.flush()
+	}
+	return nil
+}
+
+// Close implements requester.Sink.
+func (k *Kafka) Close() error {
+	err := k.flush()
+	for _, c := range k.conns {
+		c.Close()
+	}
+	return err
+}
+
+func (k *Kafka) flush() error {
+	if len(k.batch) == 0 {
+		return nil
+	}
+	partition := k.partitions[k.next%len(k.partitions)]
+	k.next++
+	batch := encodeRecordBatch(k.batch, k.batchStart)
+	k.batch = k.batch[:0]
+
+	var req kafkaEncoder
+	req.nullableString(nil) // transactional_id
+	req.int16(1)            // acks
+	req.int32(int32(kafkaTimeout / time.Millisecond))
+	req.int32(1)
+	req.string(k.topic)
+	req.int32(1)
+	req.int32(partition)
+	req.bytes(batch)
+
+	resp, err := k.roundTrip(k.leaders[partition], kafkaProduce, kafkaProduceVersion, req.b)
+	if err != nil {
+		return fmt.Errorf("kafka: %v", err)
+	}
+	d := kafkaDecoder{b: resp}
+	for topics := d.int32(); topics > 0; topics-- {
+		d.string()
+		for parts := d.int32(); parts > 0; parts-- {
+			d.int32()
+			if code := d.int16(); code != 0 {
+				return fmt.Errorf("kafka: produce to partition %d failed with error code %d", partition, code)
+			}
+			d.int64()
+			d.int64()
+		}
+	}
+	return d.err
+}
+
+func (k *Kafka) loadMetadata(bootstrap string) error {
+	var req kafkaEncoder
+	req.int32(1)
+	req.string(k.topic)
+	req.int8(0) // allow_auto_topic_creation
+	resp, err := k.roundTrip(bootstrap, kafkaMetadata, kafkaMetadataVersion, req.b)
+	if err != nil {
+		return err
+	}
+
+	d := kafkaDecoder{b: resp}
+	d.int32() // throttle_time_ms
+	brokers := make(map[int32]string)
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // rack
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.string() // cluster_id
+	d.int32()  // controller_id
+	k.leaders = make(map[int32]string)
+	k.partitions = nil
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		if code := d.int16(); code != 0 {
+			return fmt.Errorf("metadata for topic %q failed with error code %d", k.topic, code)
+		}
+		d.string() // name
+		d.int8()   // is_internal
+		for p := d.int32(); p > 0 && d.err == nil; p-- {
+			d.int16() // error_code
+			index := d.int32()
+			leader := d.int32()
+			d.int32Array() // replica_nodes
+			d.int32Array() // isr_nodes
+			if addr, ok := brokers[leader]; ok {
+				k.leaders[index] = addr
+				k.partitions = append(k.partitions, index)
+			}
+		}
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if len(k.partitions) == 0 {
+		return fmt.Errorf("topic %q has no available partitions", k.topic)
+	}
+	return nil
+}
+
+// roundTrip sends a request to the broker at addr and returns the body of
+// its response.
+func (k *Kafka) roundTrip(addr string, apiKey, version int16, body []byte) ([]byte, error) {
+	c, ok := k.conns[addr]
+	if !ok {
+		var err error
+		if c, err = net.DialTimeout("tcp", addr, kafkaTimeout); err != nil {
+			return nil, err
+		}
+		k.conns[addr] = c
+	}
+	k.correlate++
+
+	var req kafkaEncoder
+	req.int32(0) // size, filled in below
+	req.int16(apiKey)
+	req.int16(version)
+	req.int32(k.correlate)
+	req.string(kafkaClientID)
+	req.b = append(req.b, body...)
+	binary.BigEndian.PutUint32(req.b, uint32(len(req.b)-4))
+
+	c.SetDeadline(time.Now().Add(kafkaTimeout))
+	if _, err := c.Write(req.b); err != nil {
+		k.drop(addr)
+		return nil, err
+	}
+	var size [4]byte
+	if _, err := io.ReadFull(c, size[:]); err != nil {
+		k.drop(addr)
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(c, resp); err != nil {
+		k.drop(addr)
+		return nil, err
+	}
+	if len(resp) < 4 || int32(binary.BigEndian.Uint32(resp)) != k.correlate {
+		k.drop(addr)
+		return nil, errors.New("unexpected response correlation id")
+	}
+	return resp[4:], nil
+}
+
+func (k *Kafka) drop(addr string) {
+	if c, ok := k.conns[addr]; ok {
+		c.Close()
+		delete(k.conns, addr)
+	}
+}
+
+// encodeRecordBatch encodes values as a v2 record batch without keys or
+// headers.
+func encodeRecordBatch(values [][]byte, ts time.Time) []byte {
+	var records kafkaEncoder
+	for i, v := range values {
+		var rec kafkaEncoder
+		rec.int8(0)               // attributes
+		rec.varint(0)             // timestamp_delta
+		rec.varint(int64(i))      // offset_delta
+		rec.varint(-1)            // key length, null
+		rec.varint(int64(len(v))) // value length
+		rec.b = append(rec.b, v...)
+		rec.varint(0) // headers count
+		records.varint(int64(len(rec.b)))
+		records.b = append(records.b, rec.b...)
+	}
+
+	millis := ts.UnixNano() / int64(time.Millisecond)
+	var tail kafkaEncoder // everything covered by the CRC
+	tail.int16(0)         // attributes
+	tail.int32(int32(len(values) - 1))
+	tail.int64(millis) // base_timestamp
+	tail.int64(millis) // max_timestamp
+	tail.int64(-1)     // producer_id
+	tail.int16(-1)     // producer_epoch
+	tail.int32(-1)     // base_sequence
+	tail.int32(int32(len(values)))
+	tail.b = append(tail.b, records.b...)
+
+	var batch kafkaEncoder
+	batch.int64(0)                              // base_offset
+	batch.int32(int32(4 + 1 + 4 + len(tail.b))) // batch_length
+	batch.int32(-1)                             // partition_leader_epoch
+	batch.int8(2)                               // magic
+	batch.int32(int32(crc32.Checksum(tail.b, crc32c)))
+	batch.b = append(batch.b, tail.b...)
+	return batch.b
+}
+
+type kafkaEncoder struct {
+	b []byte
+}
+
+func (e *kafkaEncoder) int8(v int8)   { e.b = append(e.b, byte(v)) }
+func (e *kafkaEncoder) int16(v int16) { e.b = binary.BigEndian.AppendUint16(e.b, uint16(v)) }
+func (e *kafkaEncoder) int32(v int32) { e.b = binary.BigEndian.AppendUint32(e.b, uint32(v)) }
+func (e *kafkaEncoder) int64(v int64) { e.b = binary.BigEndian.AppendUint64(e.b, uint64(v)) }
+
+// varint appends v zigzag encoded, as Kafka records expect.
+func (e *kafkaEncoder) varint(v int64) { e.b = binary.AppendVarint(e.b, v) }
+
+func (e *kafkaEncoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.b = append(e.b, s...)
+}
+
+func (e *kafkaEncoder) nullableString(s *string) {
+	if s == nil {
+		e.int16(-1)
+		return
+	}
+	e.string(*s)
+}
+
+func (e *kafkaEncoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.b = append(e.b, b...)
+}
+
+// kafkaDecoder reads big-endian fields, recording the first error and
+// returning zero values after it.
+type kafkaDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *kafkaDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b) < n {
+		d.err = errors.New("short kafka response")
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *kafkaDecoder) int8() int8 {
+	if b := d.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// string reads a nullable or non-nullable string; null reads as empty.
+func (d *kafkaDecoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+func (d *kafkaDecoder) int32Array() {
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		d.int32()
+	}
+}
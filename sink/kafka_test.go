@@ -0,0 +1,154 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/rakyll/hey/requester"
+)
+
+// fakeBroker serves a single-partition topic and collects the values of
+// produced records.
+func fakeBroker(t *testing.T, topic string, values chan<- []byte) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			var size [4]byte
+			if _, err := io.ReadFull(c, size[:]); err != nil {
+				return
+			}
+			req := make([]byte, binary.BigEndian.Uint32(size[:]))
+			io.ReadFull(c, req)
+			d := kafkaDecoder{b: req}
+			apiKey, _, correlation := d.int16(), d.int16(), d.int32()
+			d.string() // client_id
+
+			var resp kafkaEncoder
+			resp.int32(0)
+			resp.int32(correlation)
+			switch apiKey {
+			case kafkaMetadata:
+				resp.int32(0) // throttle_time_ms
+				resp.int32(1)
+				resp.int32(1)
+				resp.string(host)
+				resp.int32(int32(portNum))
+				resp.nullableString(nil)
+				resp.nullableString(nil)
+				resp.int32(1) // controller_id
+				resp.int32(1)
+				resp.int16(0)
+				resp.string(topic)
+				resp.int8(0)
+				resp.int32(1)
+				resp.int16(0)
+				resp.int32(0) // partition_index
+				resp.int32(1) // leader_id
+				resp.int32(0)
+				resp.int32(0)
+			case kafkaProduce:
+				d.string()
+				d.int16()
+				d.int32()
+				d.int32()
+				d.string()
+				d.int32()
+				d.int32()
+				batch := d.next(int(d.int32()))
+				b := kafkaDecoder{b: batch}
+				b.int64()
+				b.int32()
+				b.int32()
+				b.int8()
+				crc := uint32(b.int32())
+				if crc32.Checksum(b.b, crc32c) != crc {
+					t.Errorf("record batch has an invalid CRC")
+				}
+				b.next(2 + 4 + 8 + 8 + 8 + 2 + 4)
+				for n := b.int32(); n > 0; n-- {
+					rec := b.varint()
+					r := kafkaDecoder{b: b.next(int(rec))}
+					r.int8()
+					r.varint()
+					r.varint()
+					r.varint()
+					values <- r.next(int(r.varint()))
+				}
+				resp.int32(1)
+				resp.string(topic)
+				resp.int32(1)
+				resp.int32(0)
+				resp.int16(0)
+				resp.int64(0)
+				resp.int64(-1)
+				resp.int32(0)
+			}
+			binary.BigEndian.PutUint32(resp.b, uint32(len(resp.b)-4))
+			c.Write(resp.b)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func (d *kafkaDecoder) varint() int64 {
+	v, n := binary.Varint(d.b)
+	d.next(n)
+	return v
+}
+
+func TestKafka(t *testing.T) {
+	values := make(chan []byte, 10)
+	addr := fakeBroker(t, "results", values)
+	k, err := NewKafka([]string{addr}, "results")
+	if err != nil {
+		t.Fatalf("NewKafka errored: %v", err)
+	}
+	for i := 1; i <= 3; i++ {
+		if err := k.Write(requester.Record{Status: 200, Duration: float64(i)}); err != nil {
+			t.Fatalf("Write errored: %v", err)
+		}
+	}
+	if err := k.Close(); err != nil {
+		t.Fatalf("Close errored: %v", err)
+	}
+	for i := 1; i <= 3; i++ {
+		var rec requester.Record
+		if err := json.Unmarshal(<-values, &rec); err != nil {
+			t.Fatalf("Unexpected record value: %v", err)
+		}
+		if rec.Duration != float64(i) {
+			t.Errorf("Expected record %v, found duration %v", i, rec.Duration)
+		}
+	}
+}
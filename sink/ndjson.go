@@ -0,0 +1,49 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+
+	"github.com/rakyll/hey/requester"
+)
+
+// NDJSON writes records as JSON lines, in the format read back by
+// requester.ReadRecords.
+type NDJSON struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+// NewNDJSON returns a sink writing to w, starting with the header of the
+// records. Close flushes buffered records but does not close w.
+func NewNDJSON(w io.Writer) *NDJSON {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.Encode(requester.NewRecordsHeader())
+	return &NDJSON{w: bw, enc: enc}
+}
+
+// Write implements requester.Sink.
+func (n *NDJSON) Write(rec requester.Record) error {
+	return n.enc.Encode(rec)
+}
+
+// Close implements requester.Sink.
+func (n *NDJSON) Close() error {
+	return n.w.Flush()
+}
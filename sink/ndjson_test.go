@@ -0,0 +1,52 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/rakyll/hey/requester"
+)
+
+func TestNDJSON(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewNDJSON(&buf)
+	want := []requester.Record{
+		{Offset: 0.5, Duration: 0.1, Status: 200, Size: 10},
+		{Offset: 1, Duration: 0.2, Error: "timeout"},
+	}
+	for _, rec := range want {
+		if err := s.Write(rec); err != nil {
+			t.Fatalf("Write errored: %v", err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close errored: %v", err)
+	}
+	got, err := requester.ReadRecords(&buf)
+	if err != nil {
+		t.Fatalf("ReadRecords errored: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Read %d records; want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("Record %d = %+v; want %+v", i, got[i], want[i])
+		}
+	}
+}
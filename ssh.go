@@ -0,0 +1,287 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rakyll/hey/requester"
+)
+
+var sshUsage = `Usage: hey ssh -hosts <hosts.txt> [options...] -- [run options...] <url>
+
+Runs the same load test on every host in parallel over SSH, with the ssh
+command, and merges their results into a single report. Each host runs
+"hey run -o ndjson" with the run options, e.g. -n and -c, which apply
+per host. Raw results are streamed back as requests complete.
+
+Hosts whose runs complete but exit with an outcome other than success,
+e.g. a saturated client, keep their results in the report. The exit code
+is 1 if a host failed, or else the lowest exit code of the hosts.
+
+Options:
+  -hosts  File of hosts to run on, one ssh destination per line, e.g.
+      user@host. Blank lines and lines starting with # are skipped.
+  -copy  Copy this hey binary to every host with scp before running,
+      instead of running the one installed there. Hosts must have the
+      same OS and architecture.
+  -hey  Path of hey on the hosts. Default is "hey".
+  -save  Directory to save the raw results of every host in, as
+      <host>.ndjson. Optional.
+  -o  Output type. If none provided, a summary is printed.
+      "csv" dumps the response metrics in comma-separated values format,
+      with the time of every request phase (DNS, connect, TLS, request
+      write, time to first byte, response read) and a timestamp.
+      "html" renders the summary as a web page, with a chart of status
+      codes over time and a heatmap of latencies over time.
+      "png" draws the heatmap of latencies over time as an image.
+      "series" dumps status code counts per second of the run as CSV.
+  -percentiles  Comma-separated latency percentiles to report, e.g.
+      "50,90,99,99.9". Default is "10,25,50,75,90,95,99". Each is
+      reported with its 95% confidence interval, and a warning if too few
+      requests were slower than it to tell it from noise.
+`
+
+func sshMain(args []string) {
+	fs := newCommandFlags("ssh", sshUsage)
+	hostsFile := fs.String("hosts", "", "")
+	copyBinary := fs.Bool("copy", false, "")
+	heyPath := fs.String("hey", "hey", "")
+	saveDir := fs.String("save", "", "")
+	output := fs.String("o", "", "")
+	pctls := fs.String("percentiles", "", "")
+	fs.Parse(args)
+	if *hostsFile == "" || fs.NArg() < 1 {
+		usageAndExit("")
+	}
+	if *output == "ndjson" || *output == "trace" {
+		usageAndExit(fmt.Sprintf("-o %s is not supported; use -save.", *output))
+	}
+	for _, arg := range fs.Args() {
+		if arg == "-o" || strings.HasPrefix(arg, "-o=") {
+			usageAndExit("-o must be given before --, hosts always send raw results.")
+		}
+	}
+	percentiles, err := parsePercentiles(*pctls)
+	if err != nil {
+		usageAndExit(err.Error())
+	}
+	hosts, err := readHosts(*hostsFile)
+	if err != nil {
+		errAndExit(err.Error())
+	}
+	if *saveDir != "" {
+		if err := os.MkdirAll(*saveDir, 0755); err != nil {
+			errAndExit(err.Error())
+		}
+	}
+
+	var exe string
+	if *copyBinary {
+		if exe, err = os.Executable(); err != nil {
+			errAndExit(err.Error())
+		}
+	}
+
+	runs := make([]*sshRun, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		runs[i] = &sshRun{host: host, hey: *heyPath, exe: exe, saveDir: *saveDir}
+		wg.Add(1)
+		go func(r *sshRun) {
+			defer wg.Done()
+			r.run(fs.Args())
+		}(runs[i])
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	printProgress(os.Stderr, runs, done)
+
+	var reps []requester.Report
+	code := 0
+	for _, r := range runs {
+		if r.err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", r.host, r.err)
+			continue
+		}
+		if r.code != 0 {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", r.host, outcomes[r.code])
+			if code == 0 || r.code < code {
+				code = r.code
+			}
+		}
+		reps = append(reps, requester.ReportFromRecords(r.records, nil))
+	}
+	if len(reps) == 0 {
+		errAndExit("no host completed the run.")
+	}
+	merged, err := requester.MergeReports(reps, percentiles)
+	if err != nil {
+		errAndExit(err.Error())
+	}
+	if err := requester.PrintReport(os.Stdout, merged, *output); err != nil {
+		errAndExit(err.Error())
+	}
+	if len(reps) < len(runs) {
+		os.Exit(exitUsage)
+	}
+	os.Exit(code)
+}
+
+// sshRun is a run on a single host.
+type sshRun struct {
+	host    string
+	hey     string // path of hey on the host
+	exe     string // local binary to copy to the host, if any
+	saveDir string
+
+	n       int64 // records received so far, accessed atomically
+	running int32 // accessed atomically
+	records []requester.Record
+	code    int   // exit code of a completed run, see outcomes
+	err     error // set if the run failed
+}
+
+func (r *sshRun) run(args []string) {
+	atomic.StoreInt32(&r.running, 1)
+	defer atomic.StoreInt32(&r.running, 0)
+	hey := r.hey
+	var cleanup string
+	if r.exe != "" {
+		hey = fmt.Sprintf("/tmp/hey.%d", os.Getpid())
+		scp := exec.Command("scp", "-q", "-o", "BatchMode=yes", r.exe, r.host+":"+hey)
+		if out, err := scp.CombinedOutput(); err != nil {
+			r.err = fmt.Errorf("copying hey: %v: %s", err, bytes.TrimSpace(out))
+			return
+		}
+		cleanup = "; s=$?; rm -f " + shellQuote(hey) + "; exit $s"
+	}
+	remote := append([]string{hey, "run", "-o", "ndjson"}, args...)
+	for i, arg := range remote {
+		remote[i] = shellQuote(arg)
+	}
+	cmd := exec.Command("ssh", "-o", "BatchMode=yes", r.host, strings.Join(remote, " ")+cleanup)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		r.err = err
+		return
+	}
+	var in io.Reader = &lineCounter{r: stdout, n: &r.n}
+	if r.saveDir != "" {
+		f, err := os.Create(filepath.Join(r.saveDir, sanitizeHost(r.host)+".ndjson"))
+		if err != nil {
+			r.err = err
+			return
+		}
+		defer f.Close()
+		in = io.TeeReader(in, f)
+	}
+	if err := cmd.Start(); err != nil {
+		r.err = err
+		return
+	}
+	records, readErr := requester.ReadRecords(in)
+	err = cmd.Wait()
+	code, completed := runOutcome(err)
+	if !completed {
+		r.err = fmt.Errorf("%v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+		return
+	}
+	r.records, r.code, r.err = records, code, readErr
+}
+
+// printProgress writes the number of requests completed on all hosts
+// every second until done is closed.
+func printProgress(w io.Writer, runs []*sshRun, done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			var n int64
+			var running int
+			for _, r := range runs {
+				n += atomic.LoadInt64(&r.n)
+				running += int(atomic.LoadInt32(&r.running))
+			}
+			fmt.Fprintf(w, "%d requests, %d/%d hosts running\n", n, running, len(runs))
+		}
+	}
+}
+
+// lineCounter counts the lines read from r, one per record.
+type lineCounter struct {
+	r io.Reader
+	n *int64
+}
+
+func (c *lineCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.n, int64(bytes.Count(p[:n], []byte{'\n'})))
+	return n, err
+}
+
+// readHosts reads the ssh destinations in the named file, one per line,
+// skipping blank lines and comments.
+func readHosts(name string) ([]string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var hosts []string
+	for _, line := range strings.Split(string(data), "\n") {
+		host := strings.TrimSpace(line)
+		if host == "" || strings.HasPrefix(host, "#") {
+			continue
+		}
+		hosts = append(hosts, host)
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no hosts in %s", name)
+	}
+	return hosts, nil
+}
+
+// shellQuote quotes s for a POSIX shell, as ssh runs remote commands with
+// the user's shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// sanitizeHost makes a host usable as a file name.
+func sanitizeHost(host string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == ':' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, host)
+}
@@ -0,0 +1,152 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package upload
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type s3 struct {
+	bucket, prefix string
+	region         string
+	endpoint       string // path-style endpoint; empty for AWS
+	accessKey      string
+	secretKey      string
+	sessionToken   string
+	client         *http.Client
+	now            func() time.Time
+}
+
+func newS3(bucket, prefix string, client *http.Client) (*s3, error) {
+	s := &s3{
+		bucket:       bucket,
+		prefix:       prefix,
+		region:       os.Getenv("AWS_REGION"),
+		endpoint:     strings.TrimSuffix(os.Getenv("AWS_ENDPOINT_URL"), "/"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		client:       client,
+		now:          time.Now,
+	}
+	if s.region == "" {
+		s.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, errors.New("upload: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set for s3:// destinations")
+	}
+	return s, nil
+}
+
+func (s *s3) Upload(name string, data []byte, contentType string) error {
+	path := "/" + escapePath(objectKey(s.prefix, name))
+	url := fmt.Sprintf("https://%s.s3.%s.amazonaws.com%s", s.bucket, s.region, path)
+	if s.endpoint != "" {
+		url = s.endpoint + "/" + s.bucket + path
+	}
+	req, err := http.NewRequest("PUT", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	s.sign(req, data)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("upload: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("upload: PUT %s failed with status %d: %s", url, resp.StatusCode, body)
+	}
+	return nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req.
+func (s *s3) sign(req *http.Request, payload []byte) {
+	t := s.now().UTC()
+	amzDate := t.Format("20060102T150405Z")
+	day := t.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	var names []string
+	for k := range req.Header {
+		names = append(names, strings.ToLower(k))
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + strings.TrimSpace(req.Header.Get(k)) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+	req.Header.Del("Host")
+}
+
+func sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
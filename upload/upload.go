@@ -0,0 +1,90 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package upload stores the artifacts of a run, such as reports and raw
+// results, in cloud object storage.
+package upload
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const timeout = 5 * time.Minute
+
+// Uploader stores objects under a destination prefix.
+type Uploader interface {
+	// Upload stores data as the object name, relative to the prefix.
+	Upload(name string, data []byte, contentType string) error
+}
+
+// New returns an uploader for dest, which is either an
+// s3://bucket/prefix/ or a gs://bucket/prefix/ URL.
+//
+// S3 credentials are read from the AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables, and
+// the region from AWS_REGION or AWS_DEFAULT_REGION. AWS_ENDPOINT_URL
+// selects an S3-compatible endpoint other than AWS.
+//
+// GCS credentials are an access token in GOOGLE_OAUTH_ACCESS_TOKEN or a
+// service account key file in GOOGLE_APPLICATION_CREDENTIALS.
+func New(dest string) (Uploader, error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("upload: no bucket in %q", dest)
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+	client := &http.Client{Timeout: timeout}
+	switch u.Scheme {
+	case "s3":
+		return newS3(u.Host, prefix, client)
+	case "gs":
+		return newGCS(u.Host, prefix, client)
+	}
+	return nil, fmt.Errorf("upload: unsupported destination %q; want s3:// or gs://", dest)
+}
+
+// objectKey joins a prefix and an object name.
+func objectKey(prefix, name string) string {
+	if prefix == "" || strings.HasSuffix(prefix, "/") {
+		return prefix + name
+	}
+	return prefix + "/" + name
+}
+
+// escapePath escapes an object key for use in a URL path, keeping its
+// slashes. Every byte but the unreserved characters of RFC 3986 is
+// percent-encoded, as the UriEncode of AWS Signature Version 4 requires.
+func escapePath(key string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		switch c := key[i]; {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&15])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,87 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rakyll/hey/requester"
+)
+
+var validateUsage = `Usage: hey validate [run options...] <url>...
+
+Checks a load test of "hey run" without sending any request: its options
+and how they combine, its URLs, the files it reads, such as -D,
+-headers-file, -body-dir, -user-agent-file, -credentials-file and
+-golden, its -template body, which is executed once, and its assertions.
+The first problem found is printed, with exit status 1. Otherwise, the
+requests the run would send are summarized.
+
+Files the run would write, such as -error-log and -tls-keylog, are not
+created, sinks such as -kafka-brokers are not connected to, and
+-auth-refresh-cmd is not run.
+
+Run "hey run -h" for the run options.
+`
+
+func validateMain(args []string) {
+	if code := run(args, runValidate); code != 0 {
+		os.Exit(code)
+	}
+}
+
+// printValidated summarizes the requests w would send, for a duration of
+// dur if non-zero.
+func printValidated(out io.Writer, w *requester.Work, dur time.Duration) {
+	fmt.Fprintf(out, "%s %s is valid:\n", w.Request.Method, w.Request.URL)
+	tw := tabwriter.NewWriter(out, 0, 8, 1, ' ', 0)
+	load := fmt.Sprintf("%d requests", w.N)
+	switch {
+	case dur > 0:
+		load = fmt.Sprintf("for %v", dur)
+	case w.Iterations > 0:
+		load = fmt.Sprintf("%d requests per worker", w.Iterations)
+	}
+	fmt.Fprintf(tw, "  Load:\t%s, %d workers", load, w.C)
+	if w.QPS > 0 {
+		fmt.Fprintf(tw, ", %v req/s each", w.QPS)
+	}
+	fmt.Fprintln(tw)
+	if len(w.URLs) > 1 {
+		fmt.Fprintf(tw, "  URLs:\t%d, in turn\n", len(w.URLs))
+	}
+	switch {
+	case w.BodyTemplate != nil:
+		fmt.Fprintf(tw, "  Body:\ttemplate of %d bytes\n", len(w.RequestBody))
+	case len(w.Bodies) > 0:
+		fmt.Fprintf(tw, "  Body:\t%d bodies\n", len(w.Bodies))
+	case len(w.RequestBody) > 0:
+		fmt.Fprintf(tw, "  Body:\t%d bytes\n", len(w.RequestBody))
+	}
+	if len(w.UserAgents) > 0 {
+		fmt.Fprintf(tw, "  User-Agents:\t%d\n", len(w.UserAgents))
+	}
+	if len(w.Credentials) > 0 {
+		fmt.Fprintf(tw, "  Credentials:\t%d\n", len(w.Credentials))
+	}
+	if n := len(w.Assertions) + len(w.HeaderAssertions); n > 0 {
+		fmt.Fprintf(tw, "  Assertions:\t%d\n", n)
+	}
+	tw.Flush()
+}
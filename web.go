@@ -0,0 +1,185 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+)
+
+var webUsage = `Usage: hey web [options...]
+
+Serves a web page to compose and launch load tests, and to watch their
+progress live. It is backed by the API of "hey server", which it serves
+as well.
+
+Options:
+  -addr  Address to listen on. Default is "localhost:8080".
+`
+
+func webMain(args []string) {
+	fs := newCommandFlags("web", webUsage)
+	addr := fs.String("addr", "localhost:8080", "")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		usageAndExit("")
+	}
+	log.Printf("Serving the hey web UI on http://%s/", *addr)
+	if err := http.ListenAndServe(*addr, newWebHandler()); err != nil {
+		errAndExit(err.Error())
+	}
+}
+
+// newWebHandler serves the web page at the root, and the API of hey
+// server it uses.
+func newWebHandler() http.Handler {
+	api := newServer()
+	mux := http.NewServeMux()
+	mux.Handle("/runs", api)
+	mux.Handle("/runs/", api)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, webPage)
+	})
+	return mux
+}
+
+// webPage composes runs as the run configurations of the API, and polls
+// the run it launched for its statistics every second.
+const webPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>hey</title>
+<style>
+body { font-family: sans-serif; margin: 2em; max-width: 60em; }
+label { display: block; margin: 0.5em 0; }
+input, select, textarea { font-family: monospace; }
+input[type=text] { width: 30em; }
+input[type=number] { width: 7em; }
+textarea { width: 40em; height: 4em; }
+table { border-collapse: collapse; }
+td, th { text-align: left; padding: 2px 12px 2px 0; }
+#error { color: #c0392b; }
+</style>
+</head>
+<body>
+<h1>hey</h1>
+<form id="form">
+<label>URL <input type="text" name="url" required placeholder="http://localhost:8000/"></label>
+<label>Method <select name="method"><option>GET</option><option>POST</option><option>PUT</option><option>DELETE</option><option>HEAD</option><option>OPTIONS</option></select></label>
+<label>Headers, one "Name: value" per line<br><textarea name="headers"></textarea></label>
+<label>Body<br><textarea name="body"></textarea></label>
+<label>Requests <input type="number" name="n" value="200" min="1">
+Workers <input type="number" name="c" value="50" min="1">
+QPS per worker <input type="number" name="q" value="0" min="0" step="any">
+Duration <input type="text" name="z" placeholder="e.g. 30s" style="width: 6em">
+Timeout (s) <input type="number" name="t" value="20" min="0"></label>
+<button type="submit" id="start">Start</button>
+<button type="button" id="stop" disabled>Stop</button>
+<span id="error"></span>
+</form>
+<div id="run" hidden>
+<h2>Run <span id="id"></span>: <span id="state"></span></h2>
+<table>
+<tr><th>Requests</th><td id="requests"></td></tr>
+<tr><th>Errors</th><td id="errors"></td></tr>
+<tr><th>Requests/sec</th><td id="rps"></td></tr>
+<tr><th>Average</th><td id="average"></td></tr>
+<tr><th>Elapsed</th><td id="elapsed"></td></tr>
+<tr><th>Status codes</th><td id="codes"></td></tr>
+</table>
+<h3>Requests/sec over time</h3>
+<svg id="chart" width="640" height="200" style="border: 1px solid #ddd"><polyline fill="none" stroke="#2980b9" stroke-width="2"/></svg>
+<p id="report" hidden>Report: <a target="_blank">summary</a> | <a target="_blank">HTML</a> | <a target="_blank">CSV</a> | <a target="_blank">NDJSON</a></p>
+</div>
+<script>
+const form = document.getElementById("form");
+const $ = id => document.getElementById(id);
+let run, timer, points;
+
+form.onsubmit = async e => {
+  e.preventDefault();
+  const f = new FormData(form);
+  const headers = {};
+  for (const line of f.get("headers").split("\n")) {
+    const i = line.indexOf(":");
+    if (i > 0) headers[line.slice(0, i).trim()] = line.slice(i + 1).trim();
+  }
+  const config = {
+    url: f.get("url"), method: f.get("method"), headers: headers, body: f.get("body"),
+    n: +f.get("n"), c: +f.get("c"), q: +f.get("q"), z: f.get("z"), t: +f.get("t"),
+  };
+  const resp = await fetch("/runs", {method: "POST", headers: {"Content-Type": "application/json"}, body: JSON.stringify(config)});
+  const body = await resp.json();
+  if (!resp.ok) {
+    $("error").textContent = body.error;
+    return;
+  }
+  $("error").textContent = "";
+  run = body;
+  points = [];
+  $("start").disabled = true;
+  $("stop").disabled = false;
+  $("run").hidden = false;
+  $("report").hidden = true;
+  show(run);
+  timer = setInterval(poll, 1000);
+};
+
+$("stop").onclick = () => fetch("/runs/" + run.id + "/stop", {method: "POST"});
+
+async function poll() {
+  const resp = await fetch("/runs/" + run.id);
+  const body = await resp.json();
+  const prev = run;
+  run = body;
+  const dt = run.stats.elapsed - prev.stats.elapsed;
+  if (dt > 0) points.push((run.stats.requests - prev.stats.requests) / dt);
+  show(run);
+  if (run.state === "done") {
+    clearInterval(timer);
+    $("start").disabled = false;
+    $("stop").disabled = true;
+    const links = $("report").querySelectorAll("a");
+    ["", "html", "csv", "ndjson"].forEach((o, i) => links[i].href = "/runs/" + run.id + "/report?o=" + o);
+    $("report").hidden = false;
+  }
+}
+
+function show(run) {
+  const s = run.stats;
+  $("id").textContent = run.id;
+  $("state").textContent = run.state;
+  $("requests").textContent = s.requests;
+  $("errors").textContent = s.errors;
+  $("rps").textContent = s.rps.toFixed(2);
+  $("average").textContent = s.average.toFixed(4) + " secs";
+  $("elapsed").textContent = s.elapsed.toFixed(1) + " secs";
+  $("codes").textContent = Object.entries(s.status_codes).map(([c, n]) => "[" + c + "] " + n).join(", ");
+  const max = Math.max(1, ...points);
+  const step = points.length > 1 ? 640 / (points.length - 1) : 0;
+  $("chart").firstElementChild.setAttribute("points",
+    points.map((p, i) => (i * step) + "," + (195 - p / max * 190)).join(" "));
+}
+</script>
+</body>
+</html>
+`